@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/keyer"
+	"fiatjaf.com/nostr/nip11"
+	"fiatjaf.com/nostr/nip13"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/urfave/cli/v3"
+)
+
+var dkg = &cli.Command{
+	Name:  "dkg",
+	Usage: "asks the chosen signers to generate a brand new key together, so nobody ever knows the full secret, then registers it with the coordinator",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "coordinator",
+			Usage: "relay we chose to act as our coordinator",
+		},
+		&cli.StringSliceFlag{
+			Name:  "signer",
+			Usage: "permanent pubkeys of the signers we've chosen",
+		},
+		&cli.UintFlag{
+			Name:  "threshold",
+			Usage: "minimum number of signers required (must be lower than or equal to the total number of signers)",
+		},
+		&cli.UintFlag{
+			Name:  "derived",
+			Usage: "how many child accounts derived from the same key should also be served, each with its own bunker url",
+		},
+		&cli.BoolFlag{
+			Name:  "nip04",
+			Usage: "also let the bunker url encrypt and decrypt with the legacy NIP-04 scheme",
+		},
+		&cli.BoolFlag{
+			Name:  "musig2",
+			Usage: "aggregate keys of the signers with MuSig2 instead of doing a DKG, in which case all of them must sign (--threshold is ignored)",
+		},
+		recoveryFlag(),
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		fmt.Fprintf(os.Stderr, ". preparing stuff\n")
+
+		recovery, err := recoveryKey(c)
+		if err != nil {
+			return err
+		}
+
+		signerPubkeys := make([]nostr.PubKey, 0, 6)
+		for _, pkh := range c.StringSlice("signer") {
+			pk, err := nostr.PubKeyFromHex(pkh)
+			if err != nil {
+				return fmt.Errorf("invalid pubkey '%s': %w", pkh, err)
+			}
+			signerPubkeys = append(signerPubkeys, pk)
+		}
+		threshold := int(c.Uint("threshold"))
+		method := frost.MethodFROST
+		if c.Bool("musig2") {
+			method = frost.MethodMuSig2
+			threshold = len(signerPubkeys)
+		}
+		coordinator := nostr.NormalizeURL(c.String("coordinator"))
+
+		if threshold == 0 || threshold > len(signerPubkeys) {
+			return fmt.Errorf("invalid threshold")
+		}
+
+		if !nostr.IsValidRelayURL(coordinator) {
+			return fmt.Errorf("coordinator URL '%s' is invalid", coordinator)
+		}
+
+		// there is no user key yet, so we talk to everybody using a throwaway key
+		sec := nostr.Generate()
+		kr := keyer.NewPlainKeySigner(sec)
+		requester := sec.Public()
+		authedPool := nostr.NewPool(nostr.PoolOptions{
+			AuthHandler: kr.SignEvent,
+		})
+
+		info, err := nip11.Fetch(ctx, coordinator)
+		if err != nil || info.PubKey == nil {
+			return fmt.Errorf("failed to get coordinator pubkey: %w", err)
+		}
+
+		// the parts of the account registration only the coordinator should see
+		secretRand := make([]byte, 10)
+		if _, err := rand.Read(secretRand); err != nil {
+			panic(err)
+		}
+		ar := common.AccountRegistration{
+			HandlerSecret: nostr.Generate(),
+			Profiles: []common.AccountProfile{
+				{
+					Name:         "__root__",
+					Restrictions: nil, // full authorization
+					NIP04:        c.Bool("nip04"),
+					Secret:       strings.ToLower(base32.StdEncoding.EncodeToString(secretRand)),
+				},
+			},
+		}
+		for i := range uint32(c.Uint("derived")) {
+			ar.Derived = append(ar.Derived, common.DerivedAccount{Index: i, HandlerSecret: nostr.Generate()})
+		}
+		template, _ := json.Marshal(ar.EncodeTemplate())
+		ciphertext, err := kr.Encrypt(ctx, string(template), *info.PubKey)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt to coordinator: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, ". grabbing their inbox relays\n")
+
+		inboxCtx, cancel := context.WithTimeout(ctx, time.Second*4)
+		defer cancel()
+		inboxes := make(map[nostr.PubKey][]string, len(signerPubkeys))
+		for evt := range pool.FetchMany(inboxCtx, common.IndexRelays, nostr.Filter{
+			Kinds:   []nostr.Kind{10002},
+			Authors: signerPubkeys,
+		}, nostr.SubscriptionOptions{}) {
+			inbox := make([]string, 0, len(evt.Tags))
+			for tag := range evt.Tags.FindAll("r") {
+				if len(tag) == 2 || tag[2] == "read" {
+					inbox = append(inbox, tag[1])
+				}
+			}
+			inboxes[evt.PubKey] = inbox
+		}
+
+		invite := common.DKGInvite{
+			Coordinator:       coordinator,
+			Threshold:         threshold,
+			Method:            method,
+			Signers:           signerPubkeys,
+			Recovery:          recovery,
+			EncryptedTemplate: ciphertext,
+		}
+		inviteEvt := invite.Encode()
+		inviteEvt.PubKey = requester
+		fmt.Fprintf(os.Stderr, ". doing work\n")
+		tag, err := nip13.DoWork(ctx, inviteEvt, 22)
+		if err != nil {
+			return fmt.Errorf("failed to add work to invite event: %w", err)
+		}
+		inviteEvt.Tags = append(inviteEvt.Tags, tag)
+		inviteEvt.Sign(sec)
+
+		// listen for the coordinator telling us it's done
+		fmt.Fprintf(os.Stderr, ". listening for the result\n")
+		ack := make(chan nostr.PubKey)
+		go func() {
+			for evt := range authedPool.SubscribeMany(ctx, []string{coordinator}, nostr.Filter{
+				Kinds: []nostr.Kind{common.KindShardACK},
+				Tags: nostr.TagMap{
+					"p": []string{requester.Hex()},
+				},
+			}, nostr.SubscriptionOptions{}) {
+				if evt.PubKey != *info.PubKey {
+					continue
+				}
+				if eTag := evt.Tags.Find("e"); eTag == nil || eTag[1] != inviteEvt.ID.Hex() {
+					continue
+				}
+				if pTag := evt.Tags.Find("P"); pTag != nil {
+					if pk, err := nostr.PubKeyFromHex(pTag[1]); err == nil {
+						ack <- pk
+						return
+					}
+				}
+			}
+		}()
+
+		// the coordinator must know about it before the signers start talking to it
+		fmt.Fprintf(os.Stderr, ". sending invite to coordinator %s\n", coordinator)
+		for res := range authedPool.PublishMany(ctx, []string{coordinator}, inviteEvt) {
+			if res.Error != nil {
+				return fmt.Errorf("failed to send invite to the coordinator: %w", res.Error)
+			}
+		}
+
+		for _, signer := range signerPubkeys {
+			fmt.Fprintf(os.Stderr, ". sending invite to %s\n", signer)
+
+			relays, _ := inboxes[signer]
+			if len(relays) == 0 {
+				return fmt.Errorf("signer %s doesn't have inbox relays", signer)
+			}
+
+			ok := false
+			errs := make([]error, len(relays))
+			for res := range pool.PublishMany(ctx, relays, inviteEvt) {
+				if res.Error == nil {
+					ok = true
+				} else {
+					errs[slices.Index(relays, res.RelayURL)] = res.Error
+				}
+			}
+			if !ok {
+				return fmt.Errorf("failed to send invite to %s: %v", signer, errs)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, ". waiting for the signers to generate the key\n")
+		var pubkey nostr.PubKey
+		select {
+		case pubkey = <-ack:
+		case <-time.After(time.Minute * 4):
+			return fmt.Errorf("timed out waiting for the key generation")
+		}
+		fmt.Fprintf(os.Stderr, ". done, generated %s\n", pubkey.Hex())
+
+		fmt.Printf("bunker://%s?relay=%s&secret=%s\n",
+			ar.HandlerSecret.Public().Hex(), coordinator, ar.Profiles[0].Secret)
+		for _, derived := range ar.Derived {
+			fmt.Fprintf(os.Stderr, ". derived account %d is %s\n", derived.Index, common.DerivePubKey(pubkey, derived.Index).Hex())
+			fmt.Printf("bunker://%s?relay=%s&secret=%s\n",
+				derived.HandlerSecret.Public().Hex(), coordinator, ar.Profiles[0].Secret)
+		}
+
+		return nil
+	},
+}
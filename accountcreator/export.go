@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/keyer"
+	"fiatjaf.com/nostr/nip13"
+	"fiatjaf.com/nostr/nip19"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/urfave/cli/v3"
+)
+
+var export = &cli.Command{
+	Name:  "export",
+	Usage: "gets the shards back from the signers of an account and rebuilds its secret key locally, for leaving promenade",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "account",
+			Usage:    "pubkey of the account being exported",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "recovery-sec",
+			Usage:    "secret key of the recovery key given to 'create', 'dkg' or 'reshare', used to sign the export request",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:  "signer",
+			Usage: "permanent pubkeys of the signers of the account",
+		},
+		&cli.UintFlag{
+			Name:  "threshold",
+			Usage: "number of shards needed, only necessary if the shards don't carry their vss commitments",
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		fmt.Fprintf(os.Stderr, ". preparing stuff\n")
+
+		pub, err := nostr.PubKeyFromHex(c.String("account"))
+		if err != nil {
+			return fmt.Errorf("invalid account pubkey: %w", err)
+		}
+		recoverySec, err := nostr.SecretKeyFromHex(c.String("recovery-sec"))
+		if err != nil {
+			return fmt.Errorf("invalid recovery-sec")
+		}
+		recovery := recoverySec.Public()
+
+		signerPubkeys := make([]nostr.PubKey, 0, 6)
+		for _, pkh := range c.StringSlice("signer") {
+			pk, err := nostr.PubKeyFromHex(pkh)
+			if err != nil {
+				return fmt.Errorf("invalid pubkey '%s': %w", pkh, err)
+			}
+			signerPubkeys = append(signerPubkeys, pk)
+		}
+		if len(signerPubkeys) == 0 {
+			return fmt.Errorf("no signers given")
+		}
+
+		// the shards will be encrypted to this key, which only lives here
+		exportSec := nostr.Generate()
+		exportKr := keyer.NewPlainKeySigner(exportSec)
+
+		fmt.Fprintf(os.Stderr, ". grabbing their inbox relays\n")
+
+		inboxCtx, cancel := context.WithTimeout(ctx, time.Second*4)
+		defer cancel()
+		inboxes := make(map[nostr.PubKey][]string, len(signerPubkeys))
+		for evt := range pool.FetchMany(inboxCtx, common.IndexRelays, nostr.Filter{
+			Kinds:   []nostr.Kind{10002},
+			Authors: append(slices.Clone(signerPubkeys), recovery),
+		}, nostr.SubscriptionOptions{}) {
+			inbox := make([]string, 0, len(evt.Tags))
+			for tag := range evt.Tags.FindAll("r") {
+				if len(tag) == 2 || tag[2] == "read" {
+					inbox = append(inbox, tag[1])
+				}
+			}
+			inboxes[evt.PubKey] = inbox
+		}
+
+		request := common.ExportRequest{
+			Account: pub,
+			To:      exportSec.Public(),
+			Reply:   hardcodedAckReadRelays,
+		}
+		requestEvt := request.Encode(signerPubkeys)
+		requestEvt.PubKey = recovery
+		fmt.Fprintf(os.Stderr, ". doing work\n")
+		tag, err := nip13.DoWork(ctx, requestEvt, 22)
+		if err != nil {
+			return fmt.Errorf("failed to add work to export request: %w", err)
+		}
+		requestEvt.Tags = append(requestEvt.Tags, tag)
+
+		requestEvt.Sign(recoverySec)
+
+		// gather the shards as they arrive
+		fmt.Fprintf(os.Stderr, ". listening for shards\n")
+		subCtx, cancelSub := context.WithTimeout(ctx, time.Minute*3)
+		defer cancelSub()
+		shardEvts := pool.SubscribeMany(subCtx, append(inboxes[recovery], hardcodedAckReadRelays...), nostr.Filter{
+			Kinds: []nostr.Kind{common.KindExportShard},
+			Tags: nostr.TagMap{
+				"p": []string{request.To.Hex()},
+			},
+		}, nostr.SubscriptionOptions{})
+
+		for _, signer := range signerPubkeys {
+			fmt.Fprintf(os.Stderr, ". sending request to %s\n", signer)
+
+			relays, _ := inboxes[signer]
+			if len(relays) == 0 {
+				fmt.Fprintf(os.Stderr, ". signer %s doesn't have inbox relays\n", signer)
+				continue
+			}
+
+			ok := false
+			for res := range pool.PublishMany(ctx, relays, requestEvt) {
+				if res.Error == nil {
+					ok = true
+				}
+			}
+			if !ok {
+				fmt.Fprintf(os.Stderr, ". failed to send request to %s\n", signer)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, ". waiting for the signers to send their shards back\n")
+		threshold := int(c.Uint("threshold"))
+		shards := make([]frost.KeyShard, 0, len(signerPubkeys))
+		for ie := range shardEvts {
+			evt := ie.Event
+			if !slices.Contains(signerPubkeys, evt.PubKey) {
+				continue
+			}
+			if eTag := evt.Tags.Find("e"); eTag == nil || eTag[1] != requestEvt.ID.Hex() {
+				continue
+			}
+
+			plaintext, err := exportKr.Decrypt(ctx, evt.Content, evt.PubKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, ". failed to decrypt shard from %s: %s\n", evt.PubKey, err)
+				continue
+			}
+			// weighted signers send all their shards at once
+			received, err := common.DecodeShards(plaintext)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, ". got broken shard from %s: %s\n", evt.PubKey, err)
+				continue
+			}
+			if *received[0].PublicKey.X.Bytes() != pub {
+				fmt.Fprintf(os.Stderr, ". got shard for a different key from %s\n", evt.PubKey)
+				continue
+			}
+			for _, shard := range received {
+				if slices.ContainsFunc(shards, func(sh frost.KeyShard) bool { return sh.ID == shard.ID }) {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, ". got shard %d from %s\n", shard.ID, evt.PubKey)
+				shards = append(shards, shard)
+			}
+
+			if threshold == 0 {
+				threshold = len(shards[0].VssCommitment)
+			}
+			if threshold == 0 || len(shards) < threshold {
+				continue
+			}
+
+			secret, err := frost.ReconstructSecret(threshold, shards)
+			if err != nil {
+				return fmt.Errorf("failed to rebuild the key: %w", err)
+			}
+			fmt.Println(nip19.EncodeNsec(secret.Bytes()))
+			return nil
+		}
+
+		return fmt.Errorf("timed out with only %d shards", len(shards))
+	},
+}
+
+func recoveryFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name: "recovery",
+		Usage: "pubkey of a key kept apart from the bunker, which will be the only one able to ask the signers for " +
+			"their shards back with 'export' (it must be given again on every 'reshare')",
+	}
+}
+
+func recoveryKey(c *cli.Command) (nostr.PubKey, error) {
+	if c.String("recovery") == "" {
+		return nostr.ZeroPK, nil
+	}
+	pk, err := nostr.PubKeyFromHex(c.String("recovery"))
+	if err != nil {
+		return nostr.ZeroPK, fmt.Errorf("invalid recovery pubkey '%s': %w", c.String("recovery"), err)
+	}
+	return pk, nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,6 +39,9 @@ var app = &cli.Command{
 	Description: "debugging tool for creating accounts in the frost coordinator",
 	Commands: []*cli.Command{
 		create,
+		dkg,
+		reshare,
+		export,
 	},
 }
 
@@ -55,28 +59,89 @@ var create = &cli.Command{
 		},
 		&cli.StringSliceFlag{
 			Name:  "signer",
-			Usage: "permanent pubkeys of the signers we've chosen",
+			Usage: "permanent pubkeys of the signers we've chosen, each optionally followed by ':<weight>' to give it more than one shard",
+		},
+		&cli.StringSliceFlag{
+			Name:  "committee",
+			Usage: "a sub-committee that counts as one signer, given as '<threshold>:<pubkey>,<pubkey>,...', whose members get shares of its shard so any threshold of them can sign for it",
 		},
 		&cli.UintFlag{
 			Name:  "threshold",
-			Usage: "minimum number of signers required (must be lower than or equal to the total number of signers)",
+			Usage: "minimum number of signers required, counting each by its weight (must be lower than or equal to the total weight)",
+		},
+		&cli.UintFlag{
+			Name:  "derived",
+			Usage: "how many child accounts derived from the same key should also be served, each with its own bunker url",
+		},
+		&cli.BoolFlag{
+			Name:  "nip04",
+			Usage: "also let the bunker url encrypt and decrypt with the legacy NIP-04 scheme",
 		},
+		recoveryFlag(),
 	},
 	Action: func(ctx context.Context, c *cli.Command) error {
 		fmt.Fprintf(os.Stderr, ". preparing stuff\n")
 
+		recovery, err := recoveryKey(c)
+		if err != nil {
+			return err
+		}
+
 		signerPubkeys := make([]nostr.PubKey, 0, 6)
-		for _, pkh := range c.StringSlice("signer") {
+		weights := make([]int, 0, 6)
+		totalWeight := 0
+		for _, arg := range c.StringSlice("signer") {
+			pkh, weightStr, hasWeight := strings.Cut(arg, ":")
 			pk, err := nostr.PubKeyFromHex(pkh)
 			if err != nil {
 				return fmt.Errorf("invalid pubkey '%s': %w", pkh, err)
 			}
+			if slices.Contains(signerPubkeys, pk) {
+				return fmt.Errorf("signer %s given twice", pk)
+			}
+			weight := 1
+			if hasWeight {
+				if weight, err = strconv.Atoi(weightStr); err != nil || weight < 1 {
+					return fmt.Errorf("invalid weight '%s' for %s", weightStr, pk)
+				}
+			}
 			signerPubkeys = append(signerPubkeys, pk)
+			weights = append(weights, weight)
+			totalWeight += weight
+		}
+
+		// each sub-committee takes one shard, which is then split among its members
+		committees := make([]common.Committee, 0, len(c.StringSlice("committee")))
+		recipients := slices.Clone(signerPubkeys)
+		for _, arg := range c.StringSlice("committee") {
+			thresholdStr, membersStr, _ := strings.Cut(arg, ":")
+			committee := common.Committee{}
+			var err error
+			if committee.Threshold, err = strconv.Atoi(thresholdStr); err != nil || committee.Threshold < 1 {
+				return fmt.Errorf("invalid committee threshold '%s'", thresholdStr)
+			}
+			for _, pkh := range strings.Split(membersStr, ",") {
+				pk, err := nostr.PubKeyFromHex(pkh)
+				if err != nil {
+					return fmt.Errorf("invalid pubkey '%s': %w", pkh, err)
+				}
+				if slices.Contains(recipients, pk) {
+					return fmt.Errorf("signer %s given twice", pk)
+				}
+				recipients = append(recipients, pk)
+				committee.Members = append(committee.Members, common.CommitteeMember{PeerPubKey: pk})
+			}
+			if committee.Threshold > len(committee.Members) {
+				return fmt.Errorf("committee threshold %d is higher than its %d members",
+					committee.Threshold, len(committee.Members))
+			}
+			committees = append(committees, committee)
+			totalWeight++
 		}
 		threshold := int(c.Uint("threshold"))
 		coordinator := nostr.NormalizeURL(c.String("coordinator"))
 
-		if threshold == 0 || threshold > len(signerPubkeys) {
+		if threshold == 0 || threshold > totalWeight {
 			return fmt.Errorf("invalid threshold")
 		}
 
@@ -86,7 +151,7 @@ var create = &cli.Command{
 
 		ar := common.AccountRegistration{
 			Threshold:     threshold,
-			Signers:       make([]common.Signer, len(signerPubkeys)),
+			Signers:       make([]common.Signer, len(signerPubkeys), len(signerPubkeys)+len(committees)),
 			HandlerSecret: nostr.Generate(),
 		}
 
@@ -103,10 +168,10 @@ var create = &cli.Command{
 
 		inboxCtx, cancel := context.WithTimeout(ctx, time.Second*4)
 		defer cancel()
-		inboxes := make(map[nostr.PubKey][]string, len(signerPubkeys)+1)
+		inboxes := make(map[nostr.PubKey][]string, len(recipients)+1)
 		for evt := range pool.FetchMany(inboxCtx, common.IndexRelays, nostr.Filter{
 			Kinds:   []nostr.Kind{10002},
-			Authors: append(signerPubkeys, pub),
+			Authors: append(slices.Clone(recipients), pub),
 		}, nostr.SubscriptionOptions{}) {
 			inbox := make([]string, 0, len(evt.Tags))
 			for tag := range evt.Tags.FindAll("r") {
@@ -119,14 +184,20 @@ var create = &cli.Command{
 
 		fmt.Fprintf(os.Stderr, ". sharding key\n")
 
-		shards, agg, _ := frost.TrustedKeyDeal(secret, ar.Threshold, len(ar.Signers))
+		shards, agg, _ := frost.TrustedKeyDeal(secret, ar.Threshold, totalWeight)
+		defer func() {
+			for s := range shards {
+				shards[s].Zero()
+			}
+			secret.Zero()
+		}()
 		if *agg.X.Bytes() != pub {
 			return fmt.Errorf("the split went wrong")
 		}
 
 		// gather replies from the shards we're sending right now
 		fmt.Fprintf(os.Stderr, ". listening for responses\n")
-		acks := make([]nostr.PubKey, 0, len(signerPubkeys))
+		acks := make([]nostr.PubKey, 0, len(recipients))
 		ourReadRelays, _ := inboxes[pub]
 		if len(ourReadRelays) == 0 {
 			return fmt.Errorf("we need some read relays first")
@@ -155,43 +226,47 @@ var create = &cli.Command{
 					continue
 				}
 
-				if slices.Contains(signerPubkeys, evt.PubKey) && !slices.Contains(acks, evt.PubKey) {
+				if slices.Contains(recipients, evt.PubKey) && !slices.Contains(acks, evt.PubKey) {
 					acks = append(acks, evt.PubKey)
-					if len(acks) == len(signerPubkeys) {
+					if len(acks) == len(recipients) {
 						ack <- struct{}{}
 					}
 				}
 			}
 		}()
 
-		// send one shard to each signer
-		for s, shard := range shards {
-			signer := signerPubkeys[s]
-			fmt.Fprintf(os.Stderr, ". sending shard to %s\n", signer)
-
-			ar.Signers[s].PeerPubKey = signer
-			ar.Signers[s].Shard = shard.PublicKeyShard
+		// every signer keeps the list of everybody holding a part of the account, so they won't do ecdh with them
+		signersTag := nostr.Tag{"signers"}
+		for _, recipient := range recipients {
+			signersTag = append(signersTag, recipient.Hex())
+		}
 
-			relays, _ := inboxes[signer]
+		// sends the plaintext of a shard event (or of a sub-shard, to a member of a sub-committee) to recipient
+		sendShard := func(recipient nostr.PubKey, plaintext string) error {
+			relays, _ := inboxes[recipient]
 			if len(relays) == 0 {
-				return fmt.Errorf("signer %s doesn't have inbox relays", signer)
+				return fmt.Errorf("signer %s doesn't have inbox relays", recipient)
 			}
 
-			ciphertext, err := kr.Encrypt(ctx, shard.Hex(), signer)
+			ciphertext, err := kr.Encrypt(ctx, plaintext, recipient)
 			if err != nil {
-				return fmt.Errorf("failed to encrypt to %s: %w", signer, err)
+				return fmt.Errorf("failed to encrypt to %s: %w", recipient, err)
 			}
 			shardEvt := nostr.Event{
 				CreatedAt: nostr.Now(),
 				Kind:      common.KindShard,
 				Content:   ciphertext,
 				Tags: nostr.Tags{
-					{"p", signer.Hex()},
+					{"p", recipient.Hex()},
 					{"coordinator", coordinator},
 					append(nostr.Tag{"reply"}, hardcodedAckReadRelays...),
+					signersTag,
 				},
 				PubKey: pub,
 			}
+			if recovery != nostr.ZeroPK {
+				shardEvt.Tags = append(shardEvt.Tags, nostr.Tag{"recovery", recovery.Hex()})
+			}
 			fmt.Fprintf(os.Stderr, ". doing work\n")
 			tag, err := nip13.DoWork(ctx, shardEvt, 22)
 			if err != nil {
@@ -210,8 +285,54 @@ var create = &cli.Command{
 				}
 			}
 			if !ok {
-				return fmt.Errorf("failed to send shard to %s: %v", signer, errs)
+				return fmt.Errorf("failed to send shard to %s: %v", recipient, errs)
 			}
+			return nil
+		}
+
+		// send one shard to each signer, or as many as its weight, all in the same event
+		next := 0
+		for s, signer := range signerPubkeys {
+			signerShards := shards[next : next+weights[s]]
+			next += weights[s]
+			fmt.Fprintf(os.Stderr, ". sending %d shard(s) to %s\n", len(signerShards), signer)
+
+			ar.Signers[s].PeerPubKey = signer
+			ar.Signers[s].Shard = signerShards[0].PublicKeyShard
+			for _, shard := range signerShards[1:] {
+				ar.Signers[s].ExtraShards = append(ar.Signers[s].ExtraShards, shard.PublicKeyShard)
+			}
+
+			if err := sendShard(signer, common.EncodeShards(signerShards)); err != nil {
+				return err
+			}
+		}
+
+		// and split the shard of each sub-committee among its members
+		for _, committee := range committees {
+			shard := shards[next]
+			next++
+			subShards, err := frost.SplitKeyShard(shard, committee.Threshold, len(committee.Members))
+			if err != nil {
+				return fmt.Errorf("failed to split shard for committee: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, ". sending %d sub-shards of shard %d to a %d-of-%d committee\n",
+				len(subShards), shard.ID, committee.Threshold, len(committee.Members))
+
+			for m := range committee.Members {
+				committee.Members[m].Shard = subShards[m].PublicKeyShard
+				err := sendShard(committee.Members[m].PeerPubKey, subShards[m].Hex())
+				subShards[m].Zero()
+				if err != nil {
+					return err
+				}
+			}
+
+			ar.Signers = append(ar.Signers, common.Signer{
+				PeerPubKey: nostr.PubKey(*shard.PublicKeyShard.PublicKey.X.Bytes()),
+				Shard:      shard.PublicKeyShard,
+				Committee:  &committee,
+			})
 		}
 
 		// in the meantime create the root profile
@@ -223,8 +344,12 @@ var create = &cli.Command{
 		ar.Profiles = append(ar.Profiles, common.AccountProfile{
 			Name:         "__root__",
 			Restrictions: nil, // full authorization
+			NIP04:        c.Bool("nip04"),
 			Secret:       strings.ToLower(base32.StdEncoding.EncodeToString(secretRand)),
 		})
+		for i := range uint32(c.Uint("derived")) {
+			ar.Derived = append(ar.Derived, common.DerivedAccount{Index: i, HandlerSecret: nostr.Generate()})
+		}
 
 		// wait until all the signers have answered
 		fmt.Fprintf(os.Stderr, ". waiting for acks from all signers\n")
@@ -244,6 +369,11 @@ var create = &cli.Command{
 
 		fmt.Printf("bunker://%s?relay=%s&secret=%s\n",
 			ar.HandlerSecret.Public().Hex(), coordinator, ar.Profiles[0].Secret)
+		for _, derived := range ar.Derived {
+			fmt.Fprintf(os.Stderr, ". derived account %d is %s\n", derived.Index, common.DerivePubKey(pub, derived.Index).Hex())
+			fmt.Printf("bunker://%s?relay=%s&secret=%s\n",
+				derived.HandlerSecret.Public().Hex(), coordinator, ar.Profiles[0].Secret)
+		}
 
 		return nil
 	},
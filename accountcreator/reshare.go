@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/keyer"
+	"fiatjaf.com/nostr/nip11"
+	"fiatjaf.com/nostr/nip13"
+	"fiatjaf.com/promenade/common"
+	"github.com/urfave/cli/v3"
+)
+
+var reshare = &cli.Command{
+	Name:  "reshare",
+	Usage: "moves an account that is registered on a coordinator to a new set of signers and/or a new threshold, keeping the same key",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "sec",
+			Usage: "the account secret key, which is only used to sign the request and is never split",
+		},
+		&cli.StringFlag{
+			Name:  "coordinator",
+			Usage: "relay where the account is registered",
+		},
+		&cli.StringSliceFlag{
+			Name:  "signer",
+			Usage: "permanent pubkeys of the new signers we've chosen (may include current signers)",
+		},
+		&cli.UintFlag{
+			Name:  "threshold",
+			Usage: "new minimum number of signers required (must be lower than or equal to the total number of signers)",
+		},
+		recoveryFlag(),
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		fmt.Fprintf(os.Stderr, ". preparing stuff\n")
+
+		recovery, err := recoveryKey(c)
+		if err != nil {
+			return err
+		}
+
+		signerPubkeys := make([]nostr.PubKey, 0, 6)
+		for _, pkh := range c.StringSlice("signer") {
+			pk, err := nostr.PubKeyFromHex(pkh)
+			if err != nil {
+				return fmt.Errorf("invalid pubkey '%s': %w", pkh, err)
+			}
+			signerPubkeys = append(signerPubkeys, pk)
+		}
+		threshold := int(c.Uint("threshold"))
+		coordinator := nostr.NormalizeURL(c.String("coordinator"))
+
+		if threshold == 0 || threshold > len(signerPubkeys) {
+			return fmt.Errorf("invalid threshold")
+		}
+
+		if !nostr.IsValidRelayURL(coordinator) {
+			return fmt.Errorf("coordinator URL '%s' is invalid", coordinator)
+		}
+
+		sec, err := nostr.SecretKeyFromHex(c.String("sec"))
+		if err != nil {
+			return fmt.Errorf("invalid sec")
+		}
+		kr := keyer.NewPlainKeySigner(sec)
+		pub := sec.Public()
+		authedPool := nostr.NewPool(nostr.PoolOptions{
+			AuthHandler: kr.SignEvent,
+		})
+
+		info, err := nip11.Fetch(ctx, coordinator)
+		if err != nil || info.PubKey == nil {
+			return fmt.Errorf("failed to get coordinator pubkey: %w", err)
+		}
+
+		fmt.Fprintf(os.Stderr, ". grabbing their inbox relays\n")
+
+		inboxCtx, cancel := context.WithTimeout(ctx, time.Second*4)
+		defer cancel()
+		inboxes := make(map[nostr.PubKey][]string, len(signerPubkeys))
+		for evt := range pool.FetchMany(inboxCtx, common.IndexRelays, nostr.Filter{
+			Kinds:   []nostr.Kind{10002},
+			Authors: signerPubkeys,
+		}, nostr.SubscriptionOptions{}) {
+			inbox := make([]string, 0, len(evt.Tags))
+			for tag := range evt.Tags.FindAll("r") {
+				if len(tag) == 2 || tag[2] == "read" {
+					inbox = append(inbox, tag[1])
+				}
+			}
+			inboxes[evt.PubKey] = inbox
+		}
+
+		request := common.ReshareRequest{
+			Coordinator: coordinator,
+			Threshold:   threshold,
+			Signers:     signerPubkeys,
+			Recovery:    recovery,
+		}
+		requestEvt := request.Encode()
+		requestEvt.PubKey = pub
+		fmt.Fprintf(os.Stderr, ". doing work\n")
+		tag, err := nip13.DoWork(ctx, requestEvt, 22)
+		if err != nil {
+			return fmt.Errorf("failed to add work to reshare request: %w", err)
+		}
+		requestEvt.Tags = append(requestEvt.Tags, tag)
+		requestEvt.Sign(sec)
+
+		// listen for the coordinator telling us it's done
+		fmt.Fprintf(os.Stderr, ". listening for the result\n")
+		ack := make(chan struct{})
+		go func() {
+			for evt := range authedPool.SubscribeMany(ctx, []string{coordinator}, nostr.Filter{
+				Kinds: []nostr.Kind{common.KindShardACK},
+				Tags: nostr.TagMap{
+					"p": []string{pub.Hex()},
+				},
+			}, nostr.SubscriptionOptions{}) {
+				if evt.PubKey != *info.PubKey {
+					continue
+				}
+				if eTag := evt.Tags.Find("e"); eTag != nil && eTag[1] == requestEvt.ID.Hex() {
+					ack <- struct{}{}
+					return
+				}
+			}
+		}()
+
+		// the coordinator must know about it before the signers start talking to it
+		fmt.Fprintf(os.Stderr, ". sending request to coordinator %s\n", coordinator)
+		for res := range authedPool.PublishMany(ctx, []string{coordinator}, requestEvt) {
+			if res.Error != nil {
+				return fmt.Errorf("failed to send request to the coordinator: %w", res.Error)
+			}
+		}
+
+		for _, signer := range signerPubkeys {
+			fmt.Fprintf(os.Stderr, ". sending request to %s\n", signer)
+
+			relays, _ := inboxes[signer]
+			if len(relays) == 0 {
+				return fmt.Errorf("signer %s doesn't have inbox relays", signer)
+			}
+
+			ok := false
+			errs := make([]error, len(relays))
+			for res := range pool.PublishMany(ctx, relays, requestEvt) {
+				if res.Error == nil {
+					ok = true
+				} else {
+					errs[slices.Index(relays, res.RelayURL)] = res.Error
+				}
+			}
+			if !ok {
+				return fmt.Errorf("failed to send request to %s: %v", signer, errs)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, ". waiting for the signers to reshare the key\n")
+		select {
+		case <-ack:
+		case <-time.After(time.Minute * 4):
+			return fmt.Errorf("timed out waiting for the resharing")
+		}
+		fmt.Fprintf(os.Stderr, ". done, %s is now %d-of-%d\n", pub.Hex(), threshold, len(signerPubkeys))
+
+		return nil
+	},
+}
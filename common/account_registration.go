@@ -1,12 +1,15 @@
 package common
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strconv"
 
 	"fiatjaf.com/nostr"
 	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
 )
 
 // this is the type represented by the event kind 16430
@@ -19,13 +22,32 @@ type AccountRegistration struct {
 	HandlerSecret nostr.SecretKey
 
 	Threshold int
-	Signers   []Signer // len() == MaxSigners
+	Signers   []Signer // the sum of their weights is MaxSigners()
+
+	// how the key came to be and how the signers sign for it, given by the "method" tag, frost if there is none.
+	// with musig2 the key is the aggregate of the signers' own keys and the threshold is the number of signers
+	Method frost.Method
 
 	Profiles []AccountProfile
 
+	// other identities the same signers can sign for, each served under its own handler
+	Derived []DerivedAccount
+
 	Event *nostr.Event
 }
 
+// DerivedAccount is a child of the account, its key is the account key tweaked by frost.DeriveTweak(index), so
+// anyone that knows the account pubkey can tell the child pubkey, but only the signers can sign for it.
+type DerivedAccount struct {
+	Index uint32
+
+	// the keypair the coordinator will use to handle signing requests for this child account
+	HandlerSecret nostr.SecretKey
+
+	// computed from the account pubkey and the index, empty in templates as there is no key yet
+	PubKey nostr.PubKey
+}
+
 // this represents a different profile inside an account -- each profile has a unique "secret" and policies
 type AccountProfile struct {
 	Name string
@@ -35,6 +57,9 @@ type AccountProfile struct {
 
 	// given by base64encode(sha256(handlersecret + this_profile_name + encoded_restrictions))
 	Secret string
+
+	// legacy NIP-04 encryption must be allowed explicitly, it's included as "nip04" after the restrictions
+	NIP04 bool
 }
 
 type Signer struct {
@@ -42,6 +67,118 @@ type Signer struct {
 	PeerPubKey nostr.PubKey
 
 	Shard frost.PublicKeyShard
+
+	// only with weighted signers, the shards this signer holds besides Shard, each with its own identifier, so it
+	// counts as 1 + len(ExtraShards) signers towards the threshold
+	ExtraShards []frost.PublicKeyShard
+
+	// only with musig2, the signer's own key, which is what goes in the registration: Shard comes from the
+	// aggregation of all of them
+	Key *btcec.JacobianPoint
+
+	// only when the signer is a sub-committee, whose members sign for Shard together, each with a frost.SubShard of
+	// it. a sub-committee has no key of its own, so its PeerPubKey is just the x coordinate of Shard
+	Committee *Committee
+}
+
+// Committee is a signer that is itself a group, in which any Threshold of the Members can sign for it.
+type Committee struct {
+	Threshold int
+	Members   []CommitteeMember
+}
+
+type CommitteeMember struct {
+	// Permanent public key of the member, unrelated to FROST
+	PeerPubKey nostr.PubKey
+
+	// the public side of the member's frost.SubShard
+	Shard frost.PublicKeyShard
+}
+
+// Member gives the member of the committee with the given pubkey, if any.
+func (c Committee) Member(pubkey nostr.PubKey) (CommitteeMember, bool) {
+	for _, member := range c.Members {
+		if member.PeerPubKey == pubkey {
+			return member, true
+		}
+	}
+	return CommitteeMember{}, false
+}
+
+// Configuration is what the members of the sub-committee s use to sign for its shard, when the members with the
+// given identifiers take part (see frost.SubShard.Committee).
+func (s Signer) Configuration(members []int) *frost.Configuration {
+	return &frost.Configuration{
+		PublicKey:    s.Shard.PublicKey,
+		Threshold:    s.Committee.Threshold,
+		MaxSigners:   len(s.Committee.Members),
+		Participants: members,
+	}
+}
+
+// Weight is how many shards the signer holds.
+func (s Signer) Weight() int { return 1 + len(s.ExtraShards) }
+
+// Shards gives Shard followed by ExtraShards.
+func (s Signer) Shards() []frost.PublicKeyShard {
+	return append([]frost.PublicKeyShard{s.Shard}, s.ExtraShards...)
+}
+
+// ShardByID gives the shard the signer holds with identifier id, if any.
+func (s Signer) ShardByID(id int) (frost.PublicKeyShard, bool) {
+	for _, shard := range s.Shards() {
+		if shard.ID == id {
+			return shard, true
+		}
+	}
+	return frost.PublicKeyShard{}, false
+}
+
+// MaxSigners is the total number of shards, which is the number of signers unless some are weighted.
+func (a AccountRegistration) MaxSigners() int {
+	total := 0
+	for _, signer := range a.Signers {
+		total += signer.Weight()
+	}
+	return total
+}
+
+// Weighted says if any signer holds more than one shard, which only signing and ecdh support.
+func (a AccountRegistration) Weighted() bool {
+	return slices.ContainsFunc(a.Signers, func(signer Signer) bool { return signer.Weight() > 1 })
+}
+
+// HasCommittees says if any signer is a sub-committee, which only signing supports.
+func (a AccountRegistration) HasCommittees() bool {
+	return slices.ContainsFunc(a.Signers, func(signer Signer) bool { return signer.Committee != nil })
+}
+
+// Peers gives the pubkeys of everybody holding a part of the key, which are the signers and the members of their
+// sub-committees.
+func (a AccountRegistration) Peers() []nostr.PubKey {
+	peers := make([]nostr.PubKey, 0, len(a.Signers))
+	for _, signer := range a.Signers {
+		if signer.Committee != nil {
+			for _, member := range signer.Committee.Members {
+				peers = append(peers, member.PeerPubKey)
+			}
+		} else {
+			peers = append(peers, signer.PeerPubKey)
+		}
+	}
+	return peers
+}
+
+// CommitteeOf gives the sub-committee the given pubkey is a member of, if any.
+func (a AccountRegistration) CommitteeOf(pubkey nostr.PubKey) (Signer, bool) {
+	for _, signer := range a.Signers {
+		if signer.Committee != nil {
+			if _, ok := signer.Committee.Member(pubkey); ok {
+				return signer, true
+			}
+		}
+	}
+	return Signer{}, false
 }
 
 func (a *AccountRegistration) Decode(evt nostr.Event) error {
@@ -54,21 +191,8 @@ func (a *AccountRegistration) Decode(evt nostr.Event) error {
 
 	// the handler secret key is also created by the client and the coordinator is
 	//   merely informed about it
-	if tag := evt.Tags.Find("handlersecret"); tag == nil {
-		return fmt.Errorf("missing 'handlersecret' tag")
-	} else {
-		var err error
-		a.HandlerSecret, err = nostr.SecretKeyFromHex(tag[1])
-		if err != nil {
-			return fmt.Errorf("invalid 'handlersecret': %w", err)
-		}
-
-		handlerPubKey := nostr.GetPublicKey(a.HandlerSecret)
-		if tag := evt.Tags.Find("h"); tag == nil {
-			return fmt.Errorf("missing 'h' tag")
-		} else if handlerPubKey.Hex() != tag[1] {
-			return fmt.Errorf("'h' tag pubkey doesn't match 'handlersecret'")
-		}
+	if err := a.decodeHandler(evt.Tags); err != nil {
+		return err
 	}
 
 	if tag := evt.Tags.Find("threshold"); tag == nil {
@@ -81,34 +205,313 @@ func (a *AccountRegistration) Decode(evt nostr.Event) error {
 		}
 	}
 
-	// each signer is a different 'p' tag
+	a.Method = frost.MethodFROST
+	if tag := evt.Tags.Find("method"); tag != nil {
+		var err error
+		if a.Method, err = frost.ParseMethod(tag[1]); err != nil {
+			return err
+		}
+	}
+
+	// each signer is a different 'p' tag, with its shard (or shards, if it's weighted) or, with musig2, its own key
 	a.Signers = make([]Signer, 0, a.Threshold*2)
 	for tag := range evt.Tags.FindAll("p") {
-		if len(tag) != 3 {
+		if len(tag) < 3 || (a.Method == frost.MethodMuSig2 && len(tag) != 3) {
 			return fmt.Errorf("invalid signer tag length: 3 expected, got %d", len(tag))
 		}
 		pk, err := nostr.PubKeyFromHex(tag[1])
 		if err != nil {
 			return fmt.Errorf("invalid tag: %v", tag)
 		}
+		if slices.ContainsFunc(a.Signers, func(signer Signer) bool { return signer.PeerPubKey == pk }) {
+			return fmt.Errorf("signer %s appears twice", pk)
+		}
 
 		signer := Signer{
 			PeerPubKey: pk,
 		}
-		if err := signer.Shard.DecodeHex(tag[2]); err != nil {
+		if a.Method == frost.MethodMuSig2 {
+			if signer.Key, err = decodeSignerKey(tag[2]); err != nil {
+				return fmt.Errorf("invalid signer key '%s': %w", tag[2], err)
+			}
+		} else if err := signer.Shard.DecodeHex(tag[2]); err != nil {
 			return fmt.Errorf("invalid encoded shard '%s': %w", tag[2], err)
 		}
+		for _, x := range tag[3:] {
+			extra := frost.PublicKeyShard{}
+			if err := extra.DecodeHex(x); err != nil {
+				return fmt.Errorf("invalid encoded shard '%s': %w", x, err)
+			}
+			signer.ExtraShards = append(signer.ExtraShards, extra)
+		}
 
 		a.Signers = append(a.Signers, signer)
 	}
-	if len(a.Signers) < a.Threshold {
+
+	// sub-committees are signers too, but they come in 'committee' tags with their members
+	for tag := range evt.Tags.FindAll("committee") {
+		if a.Method != frost.MethodFROST {
+			return fmt.Errorf("sub-committees are only supported with frost")
+		}
+		signer, err := a.decodeCommittee(tag)
+		if err != nil {
+			return err
+		}
+		a.Signers = append(a.Signers, signer)
+	}
+
+	// no two shards can have the same identifier, not even two held by the same weighted signer (with musig2 the
+	// identifiers only come later, from the aggregation)
+	if a.Method != frost.MethodMuSig2 {
+		ids := make(map[int]struct{}, len(a.Signers))
+		for _, signer := range a.Signers {
+			for _, shard := range signer.Shards() {
+				if _, exists := ids[shard.ID]; exists {
+					return fmt.Errorf("shard %d appears twice", shard.ID)
+				}
+				ids[shard.ID] = struct{}{}
+			}
+		}
+	}
+
+	if a.MaxSigners() < a.Threshold {
 		return fmt.Errorf("missing signers")
 	}
 
+	if a.Method == frost.MethodMuSig2 {
+		if err := a.aggregateKeys(); err != nil {
+			return err
+		}
+	}
+
 	// profiles
-	for tag := range evt.Tags.FindAll("profile") {
-		if len(tag) != 4 {
-			return fmt.Errorf("invalid profile tag length: 4 expected, got %d", len(tag))
+	if err := a.decodeProfiles(evt.Tags); err != nil {
+		return err
+	}
+
+	// derived accounts
+	if err := a.decodeDerived(evt.Tags); err != nil {
+		return err
+	}
+	for i := range a.Derived {
+		a.Derived[i].PubKey = DerivePubKey(a.PubKey, a.Derived[i].Index)
+	}
+
+	return nil
+}
+
+func (a *AccountRegistration) decodeCommittee(tag nostr.Tag) (Signer, error) {
+	if len(tag) < 5 || len(tag)%2 != 1 {
+		return Signer{}, fmt.Errorf("invalid committee tag length: %d", len(tag))
+	}
+
+	signer := Signer{Committee: &Committee{}}
+	if err := signer.Shard.DecodeHex(tag[1]); err != nil {
+		return signer, fmt.Errorf("invalid encoded shard '%s': %w", tag[1], err)
+	}
+	signer.PeerPubKey = nostr.PubKey(*signer.Shard.PublicKey.X.Bytes())
+
+	var err error
+	signer.Committee.Threshold, err = strconv.Atoi(tag[2])
+	if err != nil || signer.Committee.Threshold <= 0 || signer.Committee.Threshold > 20 {
+		return signer, fmt.Errorf("committee threshold ('%s') is not a valid number", tag[2])
+	}
+
+	for i := 3; i < len(tag); i += 2 {
+		member := CommitteeMember{}
+		if member.PeerPubKey, err = nostr.PubKeyFromHex(tag[i]); err != nil {
+			return signer, fmt.Errorf("invalid committee member '%s'", tag[i])
+		}
+		if err := member.Shard.DecodeHex(tag[i+1]); err != nil {
+			return signer, fmt.Errorf("invalid encoded sub-shard '%s': %w", tag[i+1], err)
+		}
+
+		// the same key can't be in two places, we wouldn't know who is signing for what
+		if slices.ContainsFunc(a.Signers, func(other Signer) bool {
+			if other.PeerPubKey == member.PeerPubKey {
+				return true
+			}
+			if other.Committee != nil {
+				_, isMember := other.Committee.Member(member.PeerPubKey)
+				return isMember
+			}
+			return false
+		}) {
+			return signer, fmt.Errorf("signer %s appears twice", member.PeerPubKey)
+		}
+		if _, isMember := signer.Committee.Member(member.PeerPubKey); isMember {
+			return signer, fmt.Errorf("committee member %s appears twice", member.PeerPubKey)
+		}
+		if slices.ContainsFunc(signer.Committee.Members, func(other CommitteeMember) bool {
+			return other.Shard.ID == member.Shard.ID
+		}) {
+			return signer, fmt.Errorf("committee sub-shard %d appears twice", member.Shard.ID)
+		}
+
+		signer.Committee.Members = append(signer.Committee.Members, member)
+	}
+	if len(signer.Committee.Members) < signer.Committee.Threshold {
+		return signer, fmt.Errorf("committee has %d members, needs %d",
+			len(signer.Committee.Members), signer.Committee.Threshold)
+	}
+
+	return signer, nil
+}
+
+// DecodeTemplate reads only the parts of the registration that are chosen by the user (the handler secret and
+// the profiles) from a list of tags, as sent to the coordinator in a distributed key generation invite, when
+// there is no key yet to sign the actual registration event.
+func (a *AccountRegistration) DecodeTemplate(tags nostr.Tags) error {
+	if err := a.decodeHandler(tags); err != nil {
+		return err
+	}
+	if err := a.decodeProfiles(tags); err != nil {
+		return err
+	}
+	return a.decodeDerived(tags)
+}
+
+// EncodeTemplate is the counterpart of DecodeTemplate.
+func (a AccountRegistration) EncodeTemplate() nostr.Tags {
+	tags := make(nostr.Tags, 2, 2+len(a.Profiles)+len(a.Derived)*2)
+	tags[0] = nostr.Tag{"handlersecret", a.HandlerSecret.Hex()}
+	tags[1] = nostr.Tag{"h", a.HandlerSecret.Public().Hex()}
+	for _, profile := range a.Profiles {
+		tags = append(tags, profile.tag())
+	}
+	for _, derived := range a.Derived {
+		tags = append(tags, derived.tags()...)
+	}
+	return tags
+}
+
+func (a *AccountRegistration) decodeHandler(tags nostr.Tags) error {
+	tag := tags.Find("handlersecret")
+	if tag == nil {
+		return fmt.Errorf("missing 'handlersecret' tag")
+	}
+
+	var err error
+	a.HandlerSecret, err = nostr.SecretKeyFromHex(tag[1])
+	if err != nil {
+		return fmt.Errorf("invalid 'handlersecret': %w", err)
+	}
+
+	// the first 'h' tag is ours, the others belong to derived accounts
+	handlerPubKey := nostr.GetPublicKey(a.HandlerSecret)
+	if tag := tags.Find("h"); tag == nil {
+		return fmt.Errorf("missing 'h' tag")
+	} else if handlerPubKey.Hex() != tag[1] {
+		return fmt.Errorf("'h' tag pubkey doesn't match 'handlersecret'")
+	}
+
+	return nil
+}
+
+func (a *AccountRegistration) decodeDerived(tags nostr.Tags) error {
+	for tag := range tags.FindAll("derived") {
+		if len(tag) != 3 {
+			return fmt.Errorf("invalid derived tag length: 3 expected, got %d", len(tag))
+		}
+
+		index, err := strconv.ParseUint(tag[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid derived account index '%s'", tag[1])
+		}
+		if slices.ContainsFunc(a.Derived, func(d DerivedAccount) bool { return d.Index == uint32(index) }) {
+			return fmt.Errorf("derived account %d declared twice", index)
+		}
+
+		derived := DerivedAccount{Index: uint32(index)}
+		derived.HandlerSecret, err = nostr.SecretKeyFromHex(tag[2])
+		if err != nil {
+			return fmt.Errorf("invalid handler secret for derived account %d: %w", index, err)
+		}
+
+		// the handler must be queryable just like the main one
+		handlerPubKey := derived.HandlerSecret.Public()
+		if handlerPubKey == a.HandlerSecret.Public() {
+			return fmt.Errorf("derived account %d uses the main handler", index)
+		}
+		if tags.FindWithValue("h", handlerPubKey.Hex()) == nil {
+			return fmt.Errorf("missing 'h' tag for derived account %d", index)
+		}
+
+		a.Derived = append(a.Derived, derived)
+	}
+
+	return nil
+}
+
+func (derived DerivedAccount) tags() nostr.Tags {
+	return nostr.Tags{
+		{"derived", strconv.FormatUint(uint64(derived.Index), 10), derived.HandlerSecret.Hex()},
+		{"h", derived.HandlerSecret.Public().Hex()},
+	}
+}
+
+// aggregateKeys gives each signer of a musig2 registration its shard, making sure the keys add up to the pubkey.
+func (a *AccountRegistration) aggregateKeys() error {
+	if a.Threshold != len(a.Signers) {
+		return fmt.Errorf("musig2 needs all the %d signers, but the threshold is %d", len(a.Signers), a.Threshold)
+	}
+
+	keys := make([]*btcec.JacobianPoint, len(a.Signers))
+	for i, signer := range a.Signers {
+		keys[i] = signer.Key
+	}
+	pubkey, shards, err := frost.MuSig2AggregateKeys(keys)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate signer keys: %w", err)
+	}
+	if nostr.PubKey(*pubkey.X.Bytes()) != a.PubKey {
+		return fmt.Errorf("signer keys add up to %x, not to the account pubkey", *pubkey.X.Bytes())
+	}
+
+	for i := range a.Signers {
+		a.Signers[i].Shard = shards[i]
+	}
+	return nil
+}
+
+func decodeSignerKey(x string) (*btcec.JacobianPoint, error) {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 33 {
+		return nil, fmt.Errorf("expected 33 bytes, got %d", len(b))
+	}
+	pk, err := btcec.ParsePubKey(b)
+	if err != nil {
+		return nil, err
+	}
+	key := new(btcec.JacobianPoint)
+	pk.AsJacobian(key)
+	return key, nil
+}
+
+func encodeSignerKey(key *btcec.JacobianPoint) string {
+	key.ToAffine()
+	return hex.EncodeToString(btcec.NewPublicKey(&key.X, &key.Y).SerializeCompressed())
+}
+
+// DerivePubKey gives the pubkey of the child account at index.
+func DerivePubKey(account nostr.PubKey, index uint32) nostr.PubKey {
+	ipk := make([]byte, 33)
+	ipk[0] = 2
+	copy(ipk[1:], account[:])
+	pubkey, _ := btcec.ParseJacobian(ipk)
+
+	cfg := frost.Configuration{PublicKey: &pubkey, Tweak: frost.DeriveTweak(&pubkey, index)}
+	child, _ := cfg.TweakedPublicKey()
+	return nostr.PubKey(*child.X.Bytes())
+}
+
+func (a *AccountRegistration) decodeProfiles(tags nostr.Tags) error {
+	for tag := range tags.FindAll("profile") {
+		if len(tag) != 4 && len(tag) != 5 {
+			return fmt.Errorf("invalid profile tag length: 4 or 5 expected, got %d", len(tag))
 		}
 
 		profile := AccountProfile{
@@ -127,26 +530,61 @@ func (a *AccountRegistration) Decode(evt nostr.Event) error {
 			}
 		}
 
+		if len(tag) == 5 {
+			if tag[4] != "nip04" {
+				return fmt.Errorf("invalid profile permission '%s'", tag[4])
+			}
+			profile.NIP04 = true
+		}
+
 		a.Profiles = append(a.Profiles, profile)
 	}
 
 	return nil
 }
 
+func (profile AccountProfile) tag() nostr.Tag {
+	restrictionsJSON := []byte{}
+	if profile.Restrictions != nil {
+		restrictionsJSON, _ = json.Marshal(profile.Restrictions)
+	}
+	tag := nostr.Tag{"profile", profile.Name, profile.Secret, string(restrictionsJSON)}
+	if profile.NIP04 {
+		tag = append(tag, "nip04")
+	}
+	return tag
+}
+
 func (a AccountRegistration) Encode() nostr.Event {
-	tags := make(nostr.Tags, 3, 3+len(a.Signers)+len(a.Profiles))
+	tags := make(nostr.Tags, 3, 4+len(a.Signers)+len(a.Profiles)+len(a.Derived)*2)
 	tags[0] = nostr.Tag{"threshold", strconv.Itoa(a.Threshold)}
 	tags[1] = nostr.Tag{"handlersecret", a.HandlerSecret.Hex()}
 	tags[2] = nostr.Tag{"h", a.HandlerSecret.Public().Hex()}
+	if a.Method != frost.MethodFROST {
+		tags = append(tags, nostr.Tag{"method", a.Method.String()})
+	}
 	for _, signer := range a.Signers {
-		tags = append(tags, nostr.Tag{"p", signer.PeerPubKey.Hex(), signer.Shard.Hex()})
+		if a.Method == frost.MethodMuSig2 {
+			tags = append(tags, nostr.Tag{"p", signer.PeerPubKey.Hex(), encodeSignerKey(signer.Key)})
+		} else if signer.Committee != nil {
+			tag := nostr.Tag{"committee", signer.Shard.Hex(), strconv.Itoa(signer.Committee.Threshold)}
+			for _, member := range signer.Committee.Members {
+				tag = append(tag, member.PeerPubKey.Hex(), member.Shard.Hex())
+			}
+			tags = append(tags, tag)
+		} else {
+			tag := nostr.Tag{"p", signer.PeerPubKey.Hex()}
+			for _, shard := range signer.Shards() {
+				tag = append(tag, shard.Hex())
+			}
+			tags = append(tags, tag)
+		}
 	}
 	for _, profile := range a.Profiles {
-		restrictionsJSON := []byte{}
-		if profile.Restrictions != nil {
-			restrictionsJSON, _ = json.Marshal(profile.Restrictions)
-		}
-		tags = append(tags, nostr.Tag{"profile", profile.Name, profile.Secret, string(restrictionsJSON)})
+		tags = append(tags, profile.tag())
+	}
+	for _, derived := range a.Derived {
+		tags = append(tags, derived.tags()...)
 	}
 
 	return nostr.Event{
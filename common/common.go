@@ -8,6 +8,7 @@ const (
 
 	// internal coordinator bookkeeping, meaningless
 	KindClientSecretAssociation = 26431
+	KindSignerReputation        = 26455
 
 	// user sends a shard encrypted to the signer, gets an ACK back if it's accepted
 	KindShard       = 26428
@@ -20,12 +21,53 @@ const (
 	KindGroupCommit      = 26432 // coordinator to signer
 	KindEventToBeSigned  = 26433 // coordinator to signer
 	KindPartialSignature = 26434 // signer to coordinator
+
+	// distributed key generation flow events
+	KindDKGInvite      = 26435 // user to signers and coordinator
+	KindDKGCommit      = 26436 // signer to coordinator
+	KindDKGGroupCommit = 26437 // coordinator to signer
+	KindDKGShare       = 26438 // signer to signer, through the coordinator
+	KindDKGResult      = 26439 // signer to coordinator
+
+	// proactive share refresh flow events (shares are sent as KindDKGShare)
+	KindRefreshConfiguration = 26440 // coordinator to signer
+	KindRefreshCommit        = 26441 // signer to coordinator
+	KindRefreshGroupCommit   = 26442 // coordinator to signer
+	KindRefreshResult        = 26443 // signer to coordinator
+
+	// resharing flow events (shares are sent as KindDKGShare)
+	KindReshareRequest       = 26444 // user to new signers and coordinator
+	KindReshareReady         = 26445 // new signer to coordinator
+	KindReshareConfiguration = 26446 // coordinator to old signer
+	KindReshareCommit        = 26447 // old signer to coordinator
+	KindReshareGroupCommit   = 26448 // coordinator to new signer
+	KindReshareResult        = 26449 // new signer to coordinator
+
+	// share repair flow events (pieces and repair shares are sent as KindDKGShare)
+	KindRepairRequest       = 26450 // lost signer to coordinator
+	KindRepairConfiguration = 26451 // coordinator to helpers and lost signer
+	KindRepairResult        = 26452 // lost signer to coordinator
+
+	// emergency export flow events
+	KindExportRequest = 26453 // user to signers
+	KindExportShard   = 26454 // signer to user
+
+	// one-round and ROAST signing flow events (commits and partial signatures are sent as in the normal flow)
+	KindNonceBatch                = 26456 // signer to coordinator
+	KindPreprocessedConfiguration = 26457 // coordinator to signer
+	KindRoastConfiguration        = 26458 // coordinator to signer
+
+	// threshold ecdh flow events
+	KindECDHConfiguration = 26459 // coordinator to signer
+	KindECDHShare         = 26460 // signer to coordinator
 )
 
 // signers should never sign these kinds
 var ForbiddenKinds = []nostr.Kind{
 	KindShard,
 	KindAccountRegistration,
+	KindReshareRequest,
+	KindExportRequest,
 
 	// https://github.com/nostr-protocol/nips/pull/829
 	1776,
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/frost"
+)
+
+// this is the type represented by the event kind 26435
+// it is sent by the user to all the chosen signers and to the coordinator in order to generate a new key
+// jointly, without any single party ever knowing the full secret
+type DKGInvite struct {
+	Coordinator string
+	Threshold   int
+
+	// FROST identifiers are given by the order in which signers are listed here, starting at 1
+	Signers []nostr.PubKey
+
+	// with musig2 there is no actual dkg, each signer just makes a key and these get aggregated, in the order above
+	Method frost.Method
+
+	// the key that will be allowed to sign export requests for the account, if any
+	Recovery nostr.PubKey
+
+	// this is encrypted to the coordinator and contains the tags returned by AccountRegistration.EncodeTemplate()
+	EncryptedTemplate string
+
+	Event *nostr.Event
+}
+
+func (d *DKGInvite) Decode(evt nostr.Event) error {
+	if evt.Kind != KindDKGInvite {
+		return fmt.Errorf("wrong kind %d, expected %d", evt.Kind, KindDKGInvite)
+	}
+
+	if tag := evt.Tags.Find("coordinator"); tag == nil || !nostr.IsValidRelayURL(tag[1]) {
+		return fmt.Errorf("missing or invalid 'coordinator' tag")
+	} else {
+		d.Coordinator = nostr.NormalizeURL(tag[1])
+	}
+
+	if tag := evt.Tags.Find("threshold"); tag == nil {
+		return fmt.Errorf("missing 'threshold' tag")
+	} else {
+		var err error
+		d.Threshold, err = strconv.Atoi(tag[1])
+		if err != nil || d.Threshold <= 0 || d.Threshold > 20 {
+			return fmt.Errorf("'threshold' ('%s') is not a valid number", tag[1])
+		}
+	}
+
+	var err error
+	d.Signers, err = decodeIdentifiedSigners(evt.Tags, d.Threshold)
+	if err != nil {
+		return err
+	}
+
+	d.Method = frost.MethodFROST
+	if tag := evt.Tags.Find("method"); tag != nil {
+		if d.Method, err = frost.ParseMethod(tag[1]); err != nil {
+			return err
+		}
+	}
+	if d.Method == frost.MethodMuSig2 && d.Threshold != len(d.Signers) {
+		return fmt.Errorf("musig2 needs all the %d signers, but the threshold is %d", len(d.Signers), d.Threshold)
+	}
+
+	if d.Recovery, err = RecoveryKey(evt.Tags); err != nil {
+		return err
+	}
+
+	d.EncryptedTemplate = evt.Content
+	d.Event = &evt
+
+	return nil
+}
+
+func (d DKGInvite) Encode() nostr.Event {
+	tags := make(nostr.Tags, 2, 4+len(d.Signers))
+	tags[0] = nostr.Tag{"coordinator", d.Coordinator}
+	tags[1] = nostr.Tag{"threshold", strconv.Itoa(d.Threshold)}
+	if d.Method != frost.MethodFROST {
+		tags = append(tags, nostr.Tag{"method", d.Method.String()})
+	}
+	if d.Recovery != nostr.ZeroPK {
+		tags = append(tags, nostr.Tag{"recovery", d.Recovery.Hex()})
+	}
+	tags = appendIdentifiedSigners(tags, d.Signers)
+
+	return nostr.Event{
+		Kind:      KindDKGInvite,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+		Content:   d.EncryptedTemplate,
+	}
+}
+
+// ID returns the FROST identifier for the given signer, or 0 if it isn't part of this invite.
+func (d DKGInvite) ID(signer nostr.PubKey) int {
+	return slices.Index(d.Signers, signer) + 1
+}
+
+// decodeIdentifiedSigners reads the ["p", "<pubkey>", "<id>"] tags in which ids must be 1..n in order.
+func decodeIdentifiedSigners(tags nostr.Tags, threshold int) ([]nostr.PubKey, error) {
+	signers := make([]nostr.PubKey, 0, threshold*2)
+	for tag := range tags.FindAll("p") {
+		if len(tag) != 3 {
+			return nil, fmt.Errorf("invalid signer tag length: 3 expected, got %d", len(tag))
+		}
+		pk, err := nostr.PubKeyFromHex(tag[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag: %v", tag)
+		}
+		if id, err := strconv.Atoi(tag[2]); err != nil || id != len(signers)+1 {
+			return nil, fmt.Errorf("signer %s has identifier '%s', expected %d", pk, tag[2], len(signers)+1)
+		}
+		if slices.Contains(signers, pk) {
+			return nil, fmt.Errorf("signer %s is repeated", pk)
+		}
+		signers = append(signers, pk)
+	}
+	if len(signers) < threshold {
+		return nil, fmt.Errorf("missing signers")
+	}
+	return signers, nil
+}
+
+func appendIdentifiedSigners(tags nostr.Tags, signers []nostr.PubKey) nostr.Tags {
+	for i, signer := range signers {
+		tags = append(tags, nostr.Tag{"p", signer.Hex(), strconv.Itoa(i + 1)})
+	}
+	return tags
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"fiatjaf.com/nostr"
+)
+
+// this is the type represented by the event kind 26453
+// it is signed by the recovery key the user registered when creating the account (never by the account key, which
+// signers refuse to sign this kind with, so whoever holds a bunker url can't take the key away) and sent to the
+// signers, which will reply with their shards encrypted to the one-off key given here
+type ExportRequest struct {
+	// the account whose shards are being asked for
+	Account nostr.PubKey
+
+	// the one-off key the shards will be encrypted to
+	To nostr.PubKey
+
+	// relays the user will be listening on for the shards, besides its inbox relays
+	Reply []string
+
+	Event *nostr.Event
+}
+
+// ExportRequestMaxAge is how old an export request can be for a signer to still honor it.
+const ExportRequestMaxAge = time.Minute * 10
+
+func (r *ExportRequest) Decode(evt nostr.Event) error {
+	if evt.Kind != KindExportRequest {
+		return fmt.Errorf("wrong kind %d, expected %d", evt.Kind, KindExportRequest)
+	}
+
+	if tag := evt.Tags.Find("P"); tag == nil {
+		return fmt.Errorf("missing 'P' tag")
+	} else {
+		var err error
+		r.Account, err = nostr.PubKeyFromHex(tag[1])
+		if err != nil {
+			return fmt.Errorf("'P' ('%s') is not a valid pubkey", tag[1])
+		}
+	}
+	if r.Account == evt.PubKey {
+		return fmt.Errorf("export requests must be signed by the recovery key, not by the account key")
+	}
+
+	if tag := evt.Tags.Find("to"); tag == nil {
+		return fmt.Errorf("missing 'to' tag")
+	} else {
+		var err error
+		r.To, err = nostr.PubKeyFromHex(tag[1])
+		if err != nil {
+			return fmt.Errorf("'to' ('%s') is not a valid pubkey", tag[1])
+		}
+	}
+	if r.To == evt.PubKey || r.To == r.Account {
+		return fmt.Errorf("shards can't be sent to the recovery key or to the account key")
+	}
+
+	if tag := evt.Tags.Find("reply"); tag != nil {
+		r.Reply = tag[1:]
+	}
+
+	r.Event = &evt
+
+	return nil
+}
+
+func (r ExportRequest) Encode(signers []nostr.PubKey) nostr.Event {
+	tags := make(nostr.Tags, 3, 3+len(signers))
+	tags[0] = nostr.Tag{"P", r.Account.Hex()}
+	tags[1] = nostr.Tag{"to", r.To.Hex()}
+	tags[2] = append(nostr.Tag{"reply"}, r.Reply...)
+	for _, signer := range signers {
+		tags = append(tags, nostr.Tag{"p", signer.Hex()})
+	}
+
+	return nostr.Event{
+		Kind:      KindExportRequest,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+	}
+}
+
+// RecoveryKey reads the optional ["recovery", "<pubkey>"] tag with which the user registers, along with a new shard,
+// dkg invite or reshare request, the key that will be allowed to sign export requests for the account.
+// it returns nostr.ZeroPK if there is no such tag.
+func RecoveryKey(tags nostr.Tags) (nostr.PubKey, error) {
+	tag := tags.Find("recovery")
+	if tag == nil {
+		return nostr.ZeroPK, nil
+	}
+	pk, err := nostr.PubKeyFromHex(tag[1])
+	if err != nil {
+		return nostr.ZeroPK, fmt.Errorf("'recovery' ('%s') is not a valid pubkey", tag[1])
+	}
+	return pk, nil
+}
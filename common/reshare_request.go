@@ -0,0 +1,82 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"fiatjaf.com/nostr"
+)
+
+// this is the type represented by the event kind 26444
+// it is signed by the account key itself and sent to the coordinator and to all the new signers in order to move
+// the key to a new set of signers and/or a new threshold, without changing it
+type ReshareRequest struct {
+	Coordinator string
+	Threshold   int
+
+	// FROST identifiers in the new group are given by the order in which signers are listed here, starting at 1
+	Signers []nostr.PubKey
+
+	// the key that will be allowed to sign export requests for the account, if any
+	Recovery nostr.PubKey
+
+	Event *nostr.Event
+}
+
+func (r *ReshareRequest) Decode(evt nostr.Event) error {
+	if evt.Kind != KindReshareRequest {
+		return fmt.Errorf("wrong kind %d, expected %d", evt.Kind, KindReshareRequest)
+	}
+
+	if tag := evt.Tags.Find("coordinator"); tag == nil || !nostr.IsValidRelayURL(tag[1]) {
+		return fmt.Errorf("missing or invalid 'coordinator' tag")
+	} else {
+		r.Coordinator = nostr.NormalizeURL(tag[1])
+	}
+
+	if tag := evt.Tags.Find("threshold"); tag == nil {
+		return fmt.Errorf("missing 'threshold' tag")
+	} else {
+		var err error
+		r.Threshold, err = strconv.Atoi(tag[1])
+		if err != nil || r.Threshold <= 0 || r.Threshold > 20 {
+			return fmt.Errorf("'threshold' ('%s') is not a valid number", tag[1])
+		}
+	}
+
+	var err error
+	r.Signers, err = decodeIdentifiedSigners(evt.Tags, r.Threshold)
+	if err != nil {
+		return err
+	}
+
+	if r.Recovery, err = RecoveryKey(evt.Tags); err != nil {
+		return err
+	}
+
+	r.Event = &evt
+
+	return nil
+}
+
+func (r ReshareRequest) Encode() nostr.Event {
+	tags := make(nostr.Tags, 2, 3+len(r.Signers))
+	tags[0] = nostr.Tag{"coordinator", r.Coordinator}
+	tags[1] = nostr.Tag{"threshold", strconv.Itoa(r.Threshold)}
+	if r.Recovery != nostr.ZeroPK {
+		tags = append(tags, nostr.Tag{"recovery", r.Recovery.Hex()})
+	}
+	tags = appendIdentifiedSigners(tags, r.Signers)
+
+	return nostr.Event{
+		Kind:      KindReshareRequest,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+	}
+}
+
+// ID returns the FROST identifier for the given signer in the new group, or 0 if it isn't part of it.
+func (r ReshareRequest) ID(signer nostr.PubKey) int {
+	return slices.Index(r.Signers, signer) + 1
+}
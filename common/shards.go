@@ -0,0 +1,43 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+
+	"fiatjaf.com/promenade/frost"
+)
+
+// EncodeShards gives the content of a shard event (kind 26428) or an export shard event (kind 26454) before it is
+// encrypted: the hex-encoded secret shard, or, for a weighted signer, all of its shards separated by commas.
+func EncodeShards(shards []frost.KeyShard) string {
+	encoded := make([]string, len(shards))
+	for i, shard := range shards {
+		encoded[i] = shard.Hex()
+	}
+	return strings.Join(encoded, ",")
+}
+
+// DecodeShards is the counterpart of EncodeShards, it makes sure all the shards are for the same key and have
+// different identifiers.
+func DecodeShards(x string) ([]frost.KeyShard, error) {
+	parts := strings.Split(x, ",")
+	shards := make([]frost.KeyShard, len(parts))
+	for i, part := range parts {
+		if err := shards[i].DecodeHex(part); err != nil {
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+		if i == 0 {
+			continue
+		}
+		if !shards[i].PublicKey.X.Equals(&shards[0].PublicKey.X) ||
+			!shards[i].PublicKey.Y.Equals(&shards[0].PublicKey.Y) {
+			return nil, fmt.Errorf("shard %d is for a different key", i)
+		}
+		for _, prev := range shards[:i] {
+			if prev.ID == shards[i].ID {
+				return nil, fmt.Errorf("shard %d appears twice", shards[i].ID)
+			}
+		}
+	}
+	return shards, nil
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 
 	"fiatjaf.com/nostr"
 	"fiatjaf.com/nostr/khatru"
 	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
 )
 
 func filterOutEverythingExceptWhatWeWant(ctx context.Context, event nostr.Event) (reject bool, msg string) {
@@ -28,12 +31,77 @@ func filterOutEverythingExceptWhatWeWant(ctx context.Context, event nostr.Event)
 		if err := ar.Decode(event); err != nil {
 			return true, "error: account registration event is malformed: " + err.Error()
 		}
+		if err := checkRegistrationCommitments(ar); err != nil {
+			return true, "invalid: account registration is inconsistent: " + err.Error()
+		}
 
 		return false, ""
 	}
 	return true, "blocked: this event is not accepted"
 }
 
+// checkRegistrationCommitments makes sure the public shards of all the signers come from the same polynomial, which
+// must be a commitment to the account key, such that the secret shards they got can only be shards of that key.
+// with musig2 there is no polynomial, the shards come from the signers' own keys, which Decode has already checked.
+func checkRegistrationCommitments(ar common.AccountRegistration) error {
+	if ar.Method == frost.MethodMuSig2 {
+		return nil
+	}
+
+	ipk := make([]byte, 33)
+	ipk[0] = 2
+	copy(ipk[1:], ar.PubKey[:])
+	pubkey, err := btcec.ParseJacobian(ipk)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+
+	cfg := frost.Configuration{
+		PublicKey:  &pubkey,
+		Threshold:  ar.Threshold,
+		MaxSigners: ar.MaxSigners(),
+	}
+
+	vss := frost.VssCommitment(ar.Signers[0].Shard.VssCommitment)
+	ids := make(map[int]struct{}, cfg.MaxSigners)
+	for _, signer := range ar.Signers {
+		for _, shard := range signer.Shards() {
+			if len(shard.VssCommitment) == 0 {
+				return fmt.Errorf("shard for %s has no vss commitment", signer.PeerPubKey)
+			}
+			if !vss.Equals(shard.VssCommitment) {
+				return fmt.Errorf("shard for %s has a different vss commitment", signer.PeerPubKey)
+			}
+			if err := cfg.ValidatePublicKeyShard(shard); err != nil {
+				return fmt.Errorf("shard for %s: %w", signer.PeerPubKey, err)
+			}
+			if _, exists := ids[shard.ID]; exists {
+				return fmt.Errorf("multiple shards for %d", shard.ID)
+			}
+			ids[shard.ID] = struct{}{}
+		}
+
+		// and the sub-shards of the members of a sub-committee must come from a polynomial of their own, which
+		// must be a commitment to the shard of the sub-committee
+		if signer.Committee != nil {
+			committee := signer.Configuration(nil)
+			for _, member := range signer.Committee.Members {
+				if len(member.Shard.VssCommitment) == 0 {
+					return fmt.Errorf("sub-shard for %s has no vss commitment", member.PeerPubKey)
+				}
+				if !frost.VssCommitment(signer.Committee.Members[0].Shard.VssCommitment).Equals(member.Shard.VssCommitment) {
+					return fmt.Errorf("sub-shard for %s has a different vss commitment", member.PeerPubKey)
+				}
+				if err := committee.ValidatePublicKeyShard(member.Shard); err != nil {
+					return fmt.Errorf("sub-shard for %s: %w", member.PeerPubKey, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 func handleCreate(ctx context.Context, evt nostr.Event) {
 	if evt.Kind != common.KindAccountRegistration {
 		return
@@ -51,17 +119,34 @@ func handleCreate(ctx context.Context, evt nostr.Event) {
 	}
 	log.Info().Str("pubkey", ar.PubKey.Hex()).Any("signers", signers).Msg("account registered")
 
-	// let signers know we have this registered here
+	// the shards may have changed, so forget what we had before
+	groupContextsByHandlerPubKey.Delete(ar.HandlerSecret.Public())
+	for _, derived := range ar.Derived {
+		groupContextsByHandlerPubKey.Delete(derived.HandlerSecret.Public())
+	}
+
+	// let signers know we have this registered here, or the members of a sub-committee, as it is them that hold
+	// something
 	for _, signer := range ar.Signers {
-		ackEvt := nostr.Event{
-			CreatedAt: nostr.Now(),
-			Kind:      common.KindShardACK,
-			Tags: nostr.Tags{
-				nostr.Tag{"P", ar.PubKey.Hex()},
-				nostr.Tag{"p", signer.PeerPubKey.Hex()},
-			},
+		recipients := []nostr.PubKey{signer.PeerPubKey}
+		if signer.Committee != nil {
+			recipients = recipients[:0]
+			for _, member := range signer.Committee.Members {
+				recipients = append(recipients, member.PeerPubKey)
+			}
+		}
+
+		for _, recipient := range recipients {
+			ackEvt := nostr.Event{
+				CreatedAt: nostr.Now(),
+				Kind:      common.KindShardACK,
+				Tags: nostr.Tags{
+					nostr.Tag{"P", ar.PubKey.Hex()},
+					nostr.Tag{"p", recipient.Hex()},
+				},
+			}
+			ackEvt.Sign(s.SecretKey)
+			relay.BroadcastEvent(ackEvt)
 		}
-		ackEvt.Sign(s.SecretKey)
-		relay.BroadcastEvent(ackEvt)
 	}
 }
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip44"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+func handleDKGInvite(inviteEvt nostr.Event) {
+	invite := common.DKGInvite{}
+	if err := invite.Decode(inviteEvt); err != nil {
+		log.Warn().Err(err).Stringer("event", inviteEvt).Msg("invalid dkg invite")
+		return
+	}
+
+	// the user tells us (and only us) the handler secret and the profiles
+	ck, err := nip44.GenerateConversationKey(inviteEvt.PubKey, s.SecretKey)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to compute conversation key for dkg invite")
+		return
+	}
+	plaintext, err := nip44.Decrypt(invite.EncryptedTemplate, ck)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to decrypt dkg invite template")
+		return
+	}
+	var templateTags nostr.Tags
+	if err := json.Unmarshal([]byte(plaintext), &templateTags); err != nil {
+		log.Warn().Err(err).Msg("dkg invite template is not a list of tags")
+		return
+	}
+	ar := common.AccountRegistration{
+		Threshold: invite.Threshold,
+		Signers:   make([]common.Signer, len(invite.Signers)),
+		Method:    invite.Method,
+	}
+	if err := ar.DecodeTemplate(templateTags); err != nil {
+		log.Warn().Err(err).Msg("invalid dkg invite template")
+		return
+	}
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Minute*3,
+		fmt.Errorf("distributed key generation took too long"))
+	defer cancel()
+
+	if err := runDKGSession(ctx, invite, &ar); err != nil {
+		log.Warn().Err(err).Str("invite", inviteEvt.ID.Hex()).Msg("distributed key generation failed")
+		return
+	}
+
+	// let the user know it all went well
+	ackEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindShardACK,
+		Tags: nostr.Tags{
+			nostr.Tag{"P", ar.PubKey.Hex()},
+			nostr.Tag{"p", inviteEvt.PubKey.Hex()},
+			nostr.Tag{"e", inviteEvt.ID.Hex()},
+		},
+	}
+	ackEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(ackEvt)
+}
+
+func runDKGSession(ctx context.Context, invite common.DKGInvite, ar *common.AccountRegistration) (err error) {
+	sessionId := invite.Event.ID
+	log := log.With().Str("session", sessionId.Hex()).Logger()
+
+	chosenSigners := make(map[nostr.PubKey]common.Signer, len(invite.Signers))
+	for i, pubkey := range invite.Signers {
+		chosenSigners[pubkey] = common.Signer{
+			PeerPubKey: pubkey,
+			Shard:      frost.PublicKeyShard{ID: i + 1},
+		}
+	}
+
+	ch := make(chan nostr.Event)
+	session := &Session{
+		ch:            ch,
+		chosenSigners: chosenSigners,
+		status:        "dkg-initializing",
+	}
+	signingSessions.Store(sessionId, session)
+
+	defer func() {
+		// set status to error
+		if err != nil {
+			session.status = err.Error()
+		}
+
+		// keep sessions for 5 minutes for debugging then delete them
+		go func() {
+			time.Sleep(time.Minute * 5)
+			signingSessions.Delete(sessionId)
+		}()
+	}()
+
+	log.Info().
+		Any("signers", invite.Signers).
+		Int("threshold", invite.Threshold).
+		Stringer("method", invite.Method).
+		Msg("starting distributed key generation")
+
+	// step-1 and step-2: find out what the key is
+	if invite.Method == frost.MethodMuSig2 {
+		err = aggregateMuSig2Keys(ctx, session, invite, ar)
+	} else {
+		err = exchangeDKGCommitments(ctx, session, invite, ar)
+	}
+	if err != nil {
+		return err
+	}
+
+	// step-3 (receive): get the results, which must match what we computed, and the nonce commitments for signing
+	// the account registration with the new key
+	session.status = "dkg-results"
+	results := make(map[nostr.PubKey]frost.PublicKeyShard, len(chosenSigners))
+	commitments := make(map[nostr.PubKey]frost.Commitment, len(chosenSigners))
+	for len(results) < len(chosenSigners) || len(commitments) < len(chosenSigners) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout receiving dkg results, missing: %v", missingFrom(chosenSigners, results))
+		case evt := <-ch:
+			switch evt.Kind {
+			case common.KindDKGResult:
+				pks := frost.PublicKeyShard{}
+				if err := pks.DecodeHex(evt.Content); err != nil {
+					return fmt.Errorf("failed to decode dkg result from %s: %w", evt.PubKey, err)
+				}
+				expected := ar.Signers[slices.Index(invite.Signers, evt.PubKey)].Shard
+				if pks.Hex() != expected.Hex() {
+					return fmt.Errorf("signer %s got a different result from the dkg", evt.PubKey)
+				}
+				results[evt.PubKey] = pks
+			case common.KindCommit:
+				commit := frost.Commitment{}
+				if err := commit.DecodeHex(evt.Content); err != nil {
+					return fmt.Errorf("failed to decode commit: %w", err)
+				}
+				if commit.SignerID != chosenSigners[evt.PubKey].Shard.ID {
+					return fmt.Errorf("signer %s sent a commit for %d, expected %d",
+						evt.PubKey, commit.SignerID, chosenSigners[evt.PubKey].Shard.ID)
+				}
+				commitments[evt.PubKey] = commit
+			default:
+				return fmt.Errorf("got an unexpected kind %d from %s", evt.Kind, evt.PubKey)
+			}
+		}
+	}
+
+	log.Info().Str("pubkey", ar.PubKey.Hex()).Msg("key generated, signing the account registration")
+
+	// step-4: the group's first signature is its own registration
+	session.status = "dkg-registration"
+	if err := signAccountRegistration(ctx, session, sessionId, *ar, commitments); err != nil {
+		return err
+	}
+
+	session.status = "done"
+	log.Info().Str("pubkey", ar.PubKey.Hex()).Int("threshold", ar.Threshold).
+		Msg("distributed key generation finished")
+	return nil
+}
+
+// exchangeDKGCommitments gets the polynomial commitments from all the signers and sends them back to everybody, so
+// they can exchange their shares and we can tell the key and the public shards they will end up with.
+func exchangeDKGCommitments(
+	ctx context.Context,
+	session *Session,
+	invite common.DKGInvite,
+	ar *common.AccountRegistration,
+) error {
+	sessionId := invite.Event.ID
+	chosenSigners := session.chosenSigners
+
+	// step-1 (receive): get the polynomial commitments from all signers
+	session.status = "dkg-commits"
+	dkgCommitments := make(map[nostr.PubKey]frost.DKGCommitment, len(chosenSigners))
+	for len(dkgCommitments) < len(chosenSigners) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout receiving dkg commits, missing: %v", missingFrom(chosenSigners, dkgCommitments))
+		case evt := <-session.ch:
+			if evt.Kind != common.KindDKGCommit {
+				return fmt.Errorf("got a kind %d instead of %d (dkg commit) from %s",
+					evt.Kind, common.KindDKGCommit, evt.PubKey)
+			}
+
+			com := frost.DKGCommitment{}
+			if err := com.DecodeHex(evt.Content); err != nil {
+				return fmt.Errorf("failed to decode dkg commit from %s: %w", evt.PubKey, err)
+			}
+			if com.SignerID != chosenSigners[evt.PubKey].Shard.ID {
+				return fmt.Errorf("signer %s sent a dkg commit for %d, expected %d",
+					evt.PubKey, com.SignerID, chosenSigners[evt.PubKey].Shard.ID)
+			}
+			if err := frost.ValidateDKGCommitment(com, invite.Threshold, len(invite.Signers), sessionId[:]); err != nil {
+				return fmt.Errorf("invalid dkg commit from %s: %w", evt.PubKey, err)
+			}
+
+			dkgCommitments[evt.PubKey] = com
+		}
+	}
+
+	// sorting them makes it easier for everybody
+	allCommitments := slices.SortedFunc(maps.Values(dkgCommitments), func(a, b frost.DKGCommitment) int {
+		return a.SignerID - b.SignerID
+	})
+
+	// step-2 (send): let everybody know the same commitments -- they will exchange their shares directly
+	session.status = "dkg-shares"
+	encoded := make([]byte, 0, len(allCommitments)*(4+33+32+33*invite.Threshold))
+	for _, com := range allCommitments {
+		encoded = append(encoded, com.Encode()...)
+	}
+	groupCommitEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindDKGGroupCommit,
+		Content:   hex.EncodeToString(encoded),
+		Tags:      make(nostr.Tags, 0, 1+len(chosenSigners)),
+	}
+	groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"e", sessionId.Hex()})
+	for _, signer := range chosenSigners {
+		groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
+	}
+	groupCommitEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(groupCommitEvt)
+
+	// we can already tell what everybody's public shard should be
+	pubkey, vss, _ := frost.AggregateDKGCommitments(allCommitments)
+	ar.PubKey = nostr.PubKey(*pubkey.X.Bytes())
+	for i, signerPubKey := range invite.Signers {
+		ar.Signers[i] = common.Signer{
+			PeerPubKey: signerPubKey,
+			Shard:      vss.PublicKeyShard(i + 1),
+		}
+	}
+
+	return nil
+}
+
+// aggregateMuSig2Keys is what happens instead of the dkg with musig2: the signers just tell us their own keys, which
+// we send back to everybody so they can all compute the aggregate key and their shards of it.
+func aggregateMuSig2Keys(
+	ctx context.Context,
+	session *Session,
+	invite common.DKGInvite,
+	ar *common.AccountRegistration,
+) error {
+	sessionId := invite.Event.ID
+	chosenSigners := session.chosenSigners
+
+	// step-1 (receive): get the keys of all the signers
+	session.status = "musig2-keys"
+	keys := make(map[nostr.PubKey]frost.PublicKeyShard, len(chosenSigners))
+	for len(keys) < len(chosenSigners) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout receiving musig2 keys, missing: %v", missingFrom(chosenSigners, keys))
+		case evt := <-session.ch:
+			if evt.Kind != common.KindDKGCommit {
+				return fmt.Errorf("got a kind %d instead of %d (dkg commit) from %s",
+					evt.Kind, common.KindDKGCommit, evt.PubKey)
+			}
+
+			key := frost.PublicKeyShard{}
+			if err := key.DecodeHex(evt.Content); err != nil {
+				return fmt.Errorf("failed to decode musig2 key from %s: %w", evt.PubKey, err)
+			}
+			if key.ID != chosenSigners[evt.PubKey].Shard.ID {
+				return fmt.Errorf("signer %s sent a key for %d, expected %d",
+					evt.PubKey, key.ID, chosenSigners[evt.PubKey].Shard.ID)
+			}
+			if len(key.VssCommitment) > 0 {
+				return fmt.Errorf("signer %s sent a key with a vss commitment", evt.PubKey)
+			}
+
+			keys[evt.PubKey] = key
+		}
+	}
+
+	// step-2 (send): let everybody know all the keys, in order
+	session.status = "musig2-aggregate"
+	points := make([]*btcec.JacobianPoint, len(invite.Signers))
+	encoded := make([]byte, 0, len(invite.Signers)*(4+4+33))
+	for i, signer := range invite.Signers {
+		points[i] = keys[signer].PublicKey
+		encoded = append(encoded, keys[signer].Encode()...)
+	}
+	pubkey, shards, err := frost.MuSig2AggregateKeys(points)
+	if err != nil {
+		return err
+	}
+
+	groupCommitEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindDKGGroupCommit,
+		Content:   hex.EncodeToString(encoded),
+		Tags:      make(nostr.Tags, 0, 1+len(chosenSigners)),
+	}
+	groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"e", sessionId.Hex()})
+	for _, signer := range chosenSigners {
+		groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
+	}
+	groupCommitEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(groupCommitEvt)
+
+	ar.PubKey = nostr.PubKey(*pubkey.X.Bytes())
+	for i, signerPubKey := range invite.Signers {
+		ar.Signers[i] = common.Signer{
+			PeerPubKey: signerPubKey,
+			Shard:      shards[i],
+			Key:        points[i],
+		}
+	}
+
+	return nil
+}
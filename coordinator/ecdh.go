@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// each attempt at getting the ecdh shares from a threshold of signers gets this much time, so there is still time
+// to try again with others if some are too slow
+const ecdhAttemptTimeout = time.Second * 3
+
+// conversationKey gets the NIP-44 conversation key between the account (or the derived account) and counterparty.
+func (kuc *GroupContext) conversationKey(ctx context.Context, counterparty nostr.PubKey) (ck [32]byte, err error) {
+	shared, err := kuc.sharedSecret(ctx, counterparty, "nip44")
+	if err != nil {
+		return ck, err
+	}
+
+	prk, err := hkdf.Extract(sha256.New, shared[:], []byte("nip44-v2"))
+	if err != nil {
+		return ck, err
+	}
+	copy(ck[:], prk)
+	return ck, nil
+}
+
+// sharedSecret gets the x coordinate of the shared point between the account (or the derived account) and
+// counterparty from the ecdh shares of a threshold of signers, for use with the given scheme ("nip44" or "nip04"),
+// which signers may have different policies for. whenever a signer sends a bad share, takes too long or refuses to
+// take part it is left out and we try again with the others.
+func (kuc *GroupContext) sharedSecret(ctx context.Context, counterparty nostr.PubKey, scheme string) (x [32]byte, err error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, signingTimeout, errSigningTimeout)
+	defer cancel()
+
+	log := log.With().Str("user", kuc.PubKey.Hex()).Str("counterparty", counterparty.Hex()).
+		Str("scheme", scheme).Logger()
+
+	// shards are sent encrypted between the user and each signer (or each member of a sub-committee), so we must
+	// never get these
+	if slices.ContainsFunc(kuc.Signers, func(signer common.Signer) bool { return signer.PeerPubKey == counterparty }) ||
+		slices.Contains(kuc.Peers(), counterparty) {
+		return x, fmt.Errorf("can't do ecdh with a signer of this account")
+	}
+
+	theirs, err := btcec.ParsePubKey(append([]byte{2}, counterparty[:]...))
+	if err != nil {
+		return x, fmt.Errorf("invalid counterparty pubkey: %w", err)
+	}
+	target := new(btcec.JacobianPoint)
+	theirs.AsJacobian(target)
+
+	ipk := make([]byte, 33)
+	ipk[0] = 2
+	copy(ipk[1:], kuc.PubKey[:])
+	pubkey, _ := btcec.ParseJacobian(ipk)
+
+	// the best behaved signers go first
+	candidates := make([]common.Signer, 0, len(kuc.Signers))
+	for _, signer := range kuc.Signers {
+		if _, isOnline := onlineSigners.Load(signer.PeerPubKey); isOnline {
+			candidates = append(candidates, signer)
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b common.Signer) int {
+		return getReputation(a.PeerPubKey).score() - getReputation(b.PeerPubKey).score()
+	})
+
+	for attempt := 1; ; attempt++ {
+		if ctx.Err() != nil {
+			return x, fmt.Errorf("gave up on ecdh: %w", context.Cause(ctx))
+		}
+		available := 0
+		for _, signer := range candidates {
+			available += signer.Weight()
+		}
+		if available < kuc.Threshold {
+			return x, fmt.Errorf("not enough signers available for ecdh: have %d, needed %d",
+				available, kuc.Threshold)
+		}
+
+		// the first ones until their weights add up to the threshold
+		chosen := make(map[nostr.PubKey]common.Signer, kuc.Threshold)
+		for weight, i := 0, 0; weight < kuc.Threshold; i++ {
+			chosen[candidates[i].PeerPubKey] = candidates[i]
+			weight += candidates[i].Weight()
+		}
+
+		shared, failed, err := kuc.collectECDHShares(ctx, pubkey, target, counterparty, scheme, chosen)
+		if err == nil {
+			log.Info().Int("attempt", attempt).Msg("ecdh done")
+			return *shared.X.Bytes(), nil
+		}
+		if len(failed) == 0 {
+			return x, err
+		}
+
+		log.Warn().Err(err).Int("attempt", attempt).Msg("ecdh failed, trying again without some signers")
+		candidates = slices.DeleteFunc(candidates, func(signer common.Signer) bool {
+			_, hasFailed := failed[signer.PeerPubKey]
+			return hasFailed
+		})
+	}
+}
+
+// collectECDHShares runs a single ecdh session with the chosen signers, which send a share for each of their shards.
+// if it fails because of some of them these are returned in failed.
+func (kuc *GroupContext) collectECDHShares(
+	ctx context.Context,
+	pubkey btcec.JacobianPoint,
+	target *btcec.JacobianPoint,
+	counterparty nostr.PubKey,
+	scheme string,
+	chosen map[nostr.PubKey]common.Signer,
+) (shared *btcec.JacobianPoint, failed map[nostr.PubKey]struct{}, err error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, ecdhAttemptTimeout, errSigningTimeout)
+	defer cancel()
+
+	cfg := &frost.Configuration{
+		Threshold:    kuc.Threshold,
+		MaxSigners:   kuc.MaxSigners(),
+		PublicKey:    &pubkey,
+		Participants: make([]int, 0, len(chosen)),
+		Method:       kuc.Method,
+	}
+	if kuc.Derived != nil {
+		cfg.Tweak = frost.DeriveTweak(&pubkey, kuc.Derived.Index)
+	}
+	publicShards := make([]frost.PublicKeyShard, 0, len(chosen))
+	for _, signer := range chosen {
+		for _, shard := range signer.Shards() {
+			cfg.Participants = append(cfg.Participants, shard.ID)
+			publicShards = append(publicShards, shard)
+		}
+	}
+	slices.Sort(cfg.Participants)
+
+	// the signers don't need the tweak, we add it ourselves in the end
+	signerCfg := *cfg
+	signerCfg.Tweak = nil
+
+	configEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindECDHConfiguration,
+		Content:   signerCfg.Hex(),
+		Tags:      make(nostr.Tags, 0, 2+len(chosen)),
+	}
+	configEvt.Tags = append(configEvt.Tags,
+		nostr.Tag{"counterparty", counterparty.Hex()},
+		nostr.Tag{"scheme", scheme},
+	)
+	for signer := range chosen {
+		configEvt.Tags = append(configEvt.Tags, nostr.Tag{"p", signer.Hex()})
+	}
+	configEvt.Sign(s.SecretKey)
+
+	ch := make(chan nostr.Event)
+	session := &Session{
+		ch:            ch,
+		done:          make(chan struct{}),
+		chosenSigners: chosen,
+		status:        "ecdh",
+	}
+	signingSessions.Store(configEvt.ID, session)
+	defer func() {
+		if err != nil {
+			session.status = err.Error()
+		}
+		close(session.done)
+
+		// keep it for 5 minutes for debugging like the signing sessions
+		go func() {
+			time.Sleep(time.Minute * 5)
+			signingSessions.Delete(configEvt.ID)
+		}()
+	}()
+	relay.BroadcastEvent(configEvt)
+
+	// by shard identifier
+	shares := make(map[int]frost.ECDHShare, len(publicShards))
+	for len(shares) < len(publicShards) {
+		select {
+		case <-ctx.Done():
+			missing := make(map[nostr.PubKey]struct{}, len(chosen))
+			for signer := range chosen {
+				for _, shard := range chosen[signer].Shards() {
+					if _, ok := shares[shard.ID]; !ok {
+						missing[signer] = struct{}{}
+					}
+				}
+			}
+			err := timedOut(ctx, session.status, missing)
+			var abort abortError
+			if !errors.As(err, &abort) {
+				return nil, nil, err
+			}
+			for _, fault := range abort.faults {
+				recordFault(fault)
+			}
+			return nil, missing, err
+		case evt := <-ch:
+			if evt.Kind != common.KindECDHShare {
+				continue
+			}
+
+			// signers may not want to do ecdh with this counterparty, which is fine, we just won't ask them again
+			if tag := evt.Tags.Find("refused"); tag != nil {
+				return nil, map[nostr.PubKey]struct{}{evt.PubKey: {}},
+					fmt.Errorf("%s refused to do ecdh: %s", evt.PubKey, tag[1])
+			}
+
+			share := frost.ECDHShare{}
+			if err := share.DecodeHex(evt.Content); err != nil {
+				recordFault(misbehaved(evt.PubKey, session.status, "failed to decode ecdh share: %s", err))
+				return nil, map[nostr.PubKey]struct{}{evt.PubKey: {}}, err
+			}
+
+			shard, isTheirs := chosen[evt.PubKey].ShardByID(share.SignerID)
+			if !isTheirs {
+				err := fmt.Errorf("sent an ecdh share for %d, which isn't theirs", share.SignerID)
+				recordFault(misbehaved(evt.PubKey, session.status, "%s", err))
+				return nil, map[nostr.PubKey]struct{}{evt.PubKey: {}}, err
+			}
+			if _, ok := shares[share.SignerID]; ok {
+				continue
+			}
+
+			if err := cfg.VerifyECDHShare(shard, target, share, lambdaRegistry); err != nil {
+				recordFault(misbehaved(evt.PubKey, session.status, "%s", err))
+				return nil, map[nostr.PubKey]struct{}{evt.PubKey: {}}, err
+			}
+
+			shares[share.SignerID] = share
+		}
+	}
+
+	// everything was checked already, but this checks it again and takes care of the tweak
+	shared, err = cfg.AggregateECDHShards(target, slices.Collect(maps.Values(shares)), publicShards, lambdaRegistry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return shared, nil, nil
+}
@@ -37,17 +37,16 @@ func handleRequest(ctx context.Context, filter nostr.Filter) (reject bool, msg s
 		return true, "restricted: needs a single 'p' tag equal to your own pubkey"
 	}
 
-	if len(filter.Kinds) == 3 &&
-		slices.Contains(filter.Kinds, common.KindConfiguration) ||
+	if slices.Contains(filter.Kinds, common.KindDKGGroupCommit) || slices.Contains(filter.Kinds, common.KindDKGShare) {
+		// this is a signer taking part in a distributed key generation or share refresh, it may not be registered
+		// anywhere yet but it will only get what is addressed to itself anyway
+		return false, ""
+	} else if slices.Contains(filter.Kinds, common.KindConfiguration) ||
 		slices.Contains(filter.Kinds, common.KindGroupCommit) ||
 		slices.Contains(filter.Kinds, common.KindEventToBeSigned) {
 		// ok, this is the signing flow
-		for range db.QueryEvents(nostr.Filter{
-			Tags:  nostr.TagMap{"p": []string{requester.Hex()}},
-			Kinds: []nostr.Kind{common.KindAccountRegistration},
-			Limit: 1,
-		}, 1) {
-			// found something, that means this is a valid signer and the request can be fulfilled
+		if isSigner(requester) {
+			// that means this is a valid signer and the request can be fulfilled
 			keepTrackOfWhoIsListening(ctx, requester)
 
 			return false, ""
@@ -63,6 +62,31 @@ func handleRequest(ctx context.Context, filter nostr.Filter) (reject bool, msg s
 	}
 }
 
+// isSigner says if pubkey signs for any account, either on its own or as a member of a sub-committee.
+func isSigner(pubkey nostr.PubKey) bool {
+	for range db.QueryEvents(nostr.Filter{
+		Tags:  nostr.TagMap{"p": []string{pubkey.Hex()}},
+		Kinds: []nostr.Kind{common.KindAccountRegistration},
+		Limit: 1,
+	}, 1) {
+		return true
+	}
+
+	// members of sub-committees are only in the 'committee' tags, which aren't indexed, so we must look at all of them
+	for evt := range db.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{common.KindAccountRegistration}}, 5000) {
+		for tag := range evt.Tags.FindAll("committee") {
+			// ["committee", "<shard>", "<threshold>", "<member>", "<sub-shard>", ...]
+			for i := 3; i < len(tag); i += 2 {
+				if tag[i] == pubkey.Hex() {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
 func keepTrackOfWhoIsListening(ctx context.Context, signer nostr.PubKey) {
 	conn := khatru.GetConnection(ctx)
 
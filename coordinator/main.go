@@ -28,6 +28,9 @@ type Settings struct {
 	SecretKey    nostr.SecretKey
 
 	EventstorePath string `envconfig:"DB_PATH" default:"/tmp/promenade-eventstore"`
+
+	// how often each account gets its shards refreshed, 0 disables it
+	RefreshInterval time.Duration `envconfig:"REFRESH_INTERVAL" default:"720h"`
 }
 
 //go:embed static/*
@@ -67,29 +70,7 @@ func main() {
 	}
 
 	// relay setup
-	relay.Info.Name = "promenade relay"
-	relay.Info.Description = "a relay that acts as nip-46 provider for multisignature conglomerates"
-	relay.Info.Software = "https://pkg.go.dev/fiatjaf.com/promenade/coordinator"
-
-	pk := s.SecretKey.Public()
-	relay.Info.PubKey = &pk
-	relay.ChallengePrefix = "frostbunker:"
-
-	relay.UseEventstore(db, 400)
-
-	relay.OnEvent = filterOutEverythingExceptWhatWeWant
-	relay.OnRequest = policies.SeqRequest(
-		policies.FilterIPRateLimiter(20, time.Minute, 100),
-		handleRequest,
-	)
-	relay.OnEphemeralEvent = func(ctx context.Context, event nostr.Event) {
-		if event.Kind == nostr.KindNostrConnect {
-			handleNIP46Request(ctx, event)
-		} else if slices.Contains([]nostr.Kind{common.KindCommit, common.KindPartialSignature}, event.Kind) {
-			handleSignerStuff(ctx, event)
-		}
-	}
-	relay.OnEventSaved = handleCreate
+	setupRelay()
 	mux := relay.Router()
 
 	// routes
@@ -103,6 +84,9 @@ func main() {
 		component.Render(r.Context(), w)
 	})
 
+	// proactive share refresh
+	go refreshPeriodically(context.Background())
+
 	// start
 	log.Print("listening at http://0.0.0.0:" + s.Port)
 	server := &http.Server{
@@ -120,3 +104,50 @@ func main() {
 	<-sc
 	server.Close()
 }
+
+// setupRelay gives the relay its info and all the hooks through which everything we do happens, once s and db are set.
+func setupRelay() {
+	relay.Info.Name = "promenade relay"
+	relay.Info.Description = "a relay that acts as nip-46 provider for multisignature conglomerates"
+	relay.Info.Software = "https://pkg.go.dev/fiatjaf.com/promenade/coordinator"
+
+	pk := s.SecretKey.Public()
+	relay.Info.PubKey = &pk
+	relay.ChallengePrefix = "frostbunker:"
+
+	relay.UseEventstore(db, 400)
+
+	relay.OnEvent = filterOutEverythingExceptWhatWeWant
+	relay.OnRequest = policies.SeqRequest(
+		policies.FilterIPRateLimiter(20, time.Minute, 100),
+		handleRequest,
+	)
+	relay.OnEphemeralEvent = func(ctx context.Context, event nostr.Event) {
+		if event.Kind == nostr.KindNostrConnect {
+			handleNIP46Request(ctx, event)
+		} else if event.Kind == common.KindDKGInvite {
+			go handleDKGInvite(event)
+		} else if event.Kind == common.KindReshareRequest {
+			go handleReshareRequest(event)
+		} else if event.Kind == common.KindRepairRequest {
+			go handleRepairRequest(event)
+		} else if event.Kind == common.KindNonceBatch {
+			handleNonceBatch(ctx, event)
+		} else if slices.Contains([]nostr.Kind{
+			common.KindCommit,
+			common.KindPartialSignature,
+			common.KindDKGCommit,
+			common.KindDKGResult,
+			common.KindRefreshCommit,
+			common.KindRefreshResult,
+			common.KindReshareReady,
+			common.KindReshareCommit,
+			common.KindReshareResult,
+			common.KindRepairResult,
+			common.KindECDHShare,
+		}, event.Kind) {
+			handleSignerStuff(ctx, event)
+		}
+	}
+	relay.OnEventSaved = handleCreate
+}
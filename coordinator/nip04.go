@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip04"
+	"fiatjaf.com/nostr/nip44"
+	"fiatjaf.com/nostr/nip46"
+	"fiatjaf.com/promenade/common"
+)
+
+// EncryptNIP04 uses the legacy NIP-04 scheme, with the shared secret we get from the signers by threshold ecdh.
+func (kuc *GroupContext) EncryptNIP04(
+	ctx context.Context,
+	plaintext string,
+	recipientPublicKey nostr.PubKey,
+) (ciphertext string, err error) {
+	shared, err := kuc.sharedSecret(ctx, recipientPublicKey, "nip04")
+	if err != nil {
+		return "", err
+	}
+	return nip04.Encrypt(plaintext, shared[:])
+}
+
+// DecryptNIP04 uses the legacy NIP-04 scheme, just like EncryptNIP04.
+func (kuc *GroupContext) DecryptNIP04(
+	ctx context.Context,
+	ciphertext string,
+	senderPublicKey nostr.PubKey,
+) (plaintext string, err error) {
+	shared, err := kuc.sharedSecret(ctx, senderPublicKey, "nip04")
+	if err != nil {
+		return "", err
+	}
+	return nip04.Decrypt(ciphertext, shared[:])
+}
+
+// handleNIP04Request answers 'nip04_encrypt' and 'nip04_decrypt', which nip46.DynamicSigner doesn't know about, so
+// it has already parsed the request but gave up on it.
+func handleNIP04Request(
+	ctx context.Context,
+	event nostr.Event,
+	req nip46.Request,
+) (resp nip46.Response, eventResponse nostr.Event, err error) {
+	handler := event.Tags.Find("p")
+	if handler == nil {
+		return resp, eventResponse, fmt.Errorf("missing \"p\" tag")
+	}
+	handlerPubkey, err := nostr.PubKeyFromHex(handler[1])
+	if err != nil {
+		return resp, eventResponse, fmt.Errorf("%s is invalid pubkey: %w", handler[1], err)
+	}
+
+	ctx, handlerSecret, err := nip46Signer.GetHandlerSecretKey(ctx, handlerPubkey)
+	if err != nil {
+		return resp, eventResponse, fmt.Errorf("no private key for %s: %w", handlerPubkey, err)
+	}
+	ctx, keyer, err := nip46Signer.GetUserKeyer(ctx, handlerPubkey)
+	if err != nil {
+		return resp, eventResponse, fmt.Errorf("failed to get user keyer for %s: %w", handlerPubkey, err)
+	}
+	kuc := keyer.(*GroupContext)
+
+	session := nip46.Session{}
+	session.ConversationKey, err = nip44.GenerateConversationKey(event.PubKey, handlerSecret)
+	if err != nil {
+		return resp, eventResponse, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+
+	var result string
+	var resultErr error
+	if len(req.Params) != 2 {
+		resultErr = fmt.Errorf("wrong number of arguments to '%s'", req.Method)
+	} else if thirdPartyPubkey, err := nostr.PubKeyFromHex(req.Params[0]); err != nil {
+		resultErr = fmt.Errorf("first argument to '%s' is not a valid pubkey hex", req.Method)
+	} else if err := authorizeNIP04(ctx, event.PubKey); err != nil {
+		resultErr = fmt.Errorf("refusing to use nip04: %w", err)
+	} else {
+		switch req.Method {
+		case "nip04_encrypt":
+			result, resultErr = kuc.EncryptNIP04(ctx, req.Params[1], thirdPartyPubkey)
+		case "nip04_decrypt":
+			result, resultErr = kuc.DecryptNIP04(ctx, req.Params[1], thirdPartyPubkey)
+		default:
+			return resp, eventResponse, fmt.Errorf("unknown method '%s'", req.Method)
+		}
+	}
+
+	resp, eventResponse, err = session.MakeResponse(req.ID, event.PubKey, result, resultErr)
+	if err != nil {
+		return resp, eventResponse, err
+	}
+	err = eventResponse.Sign(handlerSecret)
+
+	return resp, eventResponse, err
+}
+
+// authorizeNIP04 is like AuthorizeEncryption, but NIP-04 must be allowed explicitly in the profile.
+func authorizeNIP04(ctx context.Context, from nostr.PubKey) error {
+	val := ctx.Value(ACCOUNT)
+	if val == nil {
+		return fmt.Errorf("invalid account context")
+	}
+	ar := val.(common.AccountRegistration)
+
+	profile, err := clientProfile(ctx, ar, from)
+	if err != nil {
+		return err
+	}
+
+	if !profile.NIP04 {
+		log.Info().Str("pubkey", ar.PubKey.Hex()).Str("profile", profile.Name).
+			Msg("disallowed nip04 for profile")
+		return fmt.Errorf("not allowed for this profile")
+	}
+
+	if profile.Restrictions != nil && profile.Restrictions.Until > 0 && profile.Restrictions.Until <= nostr.Now() {
+		log.Info().Str("pubkey", ar.PubKey.Hex()).Str("profile", profile.Name).
+			Msg("disallowed nip04 for expired profile")
+		return fmt.Errorf("profile expired")
+	}
+
+	return nil
+}
@@ -14,7 +14,19 @@ import (
 	"fiatjaf.com/promenade/common"
 )
 
-const ACCOUNT = "account"
+const (
+	ACCOUNT = "account"
+	DERIVED = "derived"
+)
+
+// servedPubKey is the pubkey of the account we are handling requests for, which may be one derived from the
+// group key.
+func servedPubKey(ctx context.Context, ar common.AccountRegistration) nostr.PubKey {
+	if derived, ok := ctx.Value(DERIVED).(*common.DerivedAccount); ok && derived != nil {
+		return derived.PubKey
+	}
+	return ar.PubKey
+}
 
 var nip46Signer = &nip46.DynamicSigner{
 	GetHandlerSecretKey: func(ctx context.Context, handlerPubkey nostr.PubKey) (context.Context, nostr.SecretKey, error) {
@@ -33,12 +45,6 @@ var nip46Signer = &nip46.DynamicSigner{
 			return ctx, [32]byte{}, fmt.Errorf("no result from 'h' query")
 		}
 
-		handlerSecret := evt.Tags.Find("handlersecret")
-		sk, err := nostr.SecretKeyFromHex(handlerSecret[1])
-		if err != nil {
-			return ctx, [32]byte{}, fmt.Errorf("invalid handlersecret: %w", err)
-		}
-
 		ar := common.AccountRegistration{}
 		if err := ar.Decode(evt); err != nil {
 			return ctx, [32]byte{}, fmt.Errorf("event is an invalid account registration: %w", err)
@@ -48,7 +54,23 @@ var nip46Signer = &nip46.DynamicSigner{
 			ACCOUNT,
 			ar,
 		)
-		return ctx, sk, err
+
+		if ar.HandlerSecret.Public() == handlerPubkey {
+			return ctx, ar.HandlerSecret, nil
+		}
+
+		// this is the handler of one of the derived accounts
+		for i, derived := range ar.Derived {
+			if derived.HandlerSecret.Public() == handlerPubkey {
+				ctx = context.WithValue(ctx,
+					DERIVED,
+					&ar.Derived[i],
+				)
+				return ctx, derived.HandlerSecret, nil
+			}
+		}
+
+		return ctx, [32]byte{}, fmt.Errorf("no handler for %s", handlerPubkey)
 	},
 	OnConnect: func(ctx context.Context, from nostr.PubKey, secret string) error {
 		val := ctx.Value(ACCOUNT)
@@ -63,7 +85,7 @@ var nip46Signer = &nip46.DynamicSigner{
 			PubKey:  from,
 			Content: secret,
 			Tags: nostr.Tags{
-				nostr.Tag{"p", ar.PubKey.Hex()},
+				nostr.Tag{"p", servedPubKey(ctx, ar).Hex()},
 			},
 			CreatedAt: nostr.Now(),
 		}
@@ -77,8 +99,9 @@ var nip46Signer = &nip46.DynamicSigner{
 		}
 		ar := val.(common.AccountRegistration)
 
+		derived, _ := ctx.Value(DERIVED).(*common.DerivedAccount)
 		kuc, _ := groupContextsByHandlerPubKey.LoadOrCompute(handlerPubkey, func() *GroupContext {
-			return &GroupContext{ar}
+			return &GroupContext{ar, derived}
 		})
 
 		return ctx, kuc, nil
@@ -111,70 +134,115 @@ var nip46Signer = &nip46.DynamicSigner{
 			return fmt.Errorf("can't sign event in the future")
 		}
 
-		// get previously associated secret
-		next, done := iter.Pull(db.QueryEvents(nostr.Filter{
-			Kinds:   []nostr.Kind{common.KindClientSecretAssociation},
-			Authors: []nostr.PubKey{from},
-			Tags: nostr.TagMap{
-				"p": []string{ar.PubKey.Hex()},
-			},
-			Limit: 1,
-		}, 1))
-		evt, ok := next()
-		done()
-		if !ok {
-			log.Warn().Str("client", from.Hex()).Str("user", ar.PubKey.Hex()).
-				Msg("no secret associated")
-			return fmt.Errorf("client not registered, must call 'connect'")
-		}
-		secret := evt.Content
-
-		for _, profile := range ar.Profiles {
-			if profile.Secret == secret {
-				if profile.Restrictions == nil {
-					// everything is allowed
-					return nil
-				}
-
-				if profile.Restrictions.Until > 0 {
-					if !(profile.Restrictions.Until > nostr.Now() /* real-time expiration is ok */ &&
-						profile.Restrictions.Until > event.CreatedAt /* event-based expiration is ok */) {
-						log.Info().Str("pubkey", ar.PubKey.Hex()).
-							Any("exp", profile.Restrictions.Until).
-							Int64("created_at", int64(event.CreatedAt)).
-							Int64("now", int64(nostr.Now())).
-							Msg("disallowed timestamp")
-						return fmt.Errorf("profile expired")
-					}
-				}
-
-				if len(profile.Restrictions.Kinds) > 0 {
-					if !slices.Contains(profile.Restrictions.Kinds, event.Kind) {
-						log.Info().Str("pubkey", ar.PubKey.Hex()).
-							Any("allowed", profile.Restrictions.Kinds).
-							Uint16("kind", event.Kind.Num()).
-							Msg("disallowed kind")
-						return fmt.Errorf("disallowed kind")
-					}
-				}
-
-				return nil
+		profile, err := clientProfile(ctx, ar, from)
+		if err != nil {
+			return err
+		}
+
+		if profile.Restrictions == nil {
+			// everything is allowed
+			return nil
+		}
+
+		if profile.Restrictions.Until > 0 {
+			if !(profile.Restrictions.Until > nostr.Now() /* real-time expiration is ok */ &&
+				profile.Restrictions.Until > event.CreatedAt /* event-based expiration is ok */) {
+				log.Info().Str("pubkey", ar.PubKey.Hex()).
+					Any("exp", profile.Restrictions.Until).
+					Int64("created_at", int64(event.CreatedAt)).
+					Int64("now", int64(nostr.Now())).
+					Msg("disallowed timestamp")
+				return fmt.Errorf("profile expired")
 			}
 		}
 
-		return fmt.Errorf("no profile matched")
+		if len(profile.Restrictions.Kinds) > 0 {
+			if !slices.Contains(profile.Restrictions.Kinds, event.Kind) {
+				log.Info().Str("pubkey", ar.PubKey.Hex()).
+					Any("allowed", profile.Restrictions.Kinds).
+					Uint16("kind", event.Kind.Num()).
+					Msg("disallowed kind")
+				return fmt.Errorf("disallowed kind")
+			}
+		}
+
+		return nil
+	},
+	AuthorizeEncryption: func(ctx context.Context, from nostr.PubKey) bool {
+		val := ctx.Value(ACCOUNT)
+		if val == nil {
+			return false
+		}
+		ar := val.(common.AccountRegistration)
+
+		profile, err := clientProfile(ctx, ar, from)
+		if err != nil {
+			log.Info().Err(err).Str("client", from.Hex()).Msg("disallowed encryption")
+			return false
+		}
+
+		if profile.Restrictions == nil {
+			// everything is allowed
+			return true
+		}
+
+		// profiles that can only sign some kinds can't read or write encrypted messages
+		if len(profile.Restrictions.Kinds) > 0 {
+			log.Info().Str("pubkey", ar.PubKey.Hex()).Str("profile", profile.Name).
+				Msg("disallowed encryption for restricted profile")
+			return false
+		}
+
+		if profile.Restrictions.Until > 0 && profile.Restrictions.Until <= nostr.Now() {
+			log.Info().Str("pubkey", ar.PubKey.Hex()).Str("profile", profile.Name).
+				Msg("disallowed encryption for expired profile")
+			return false
+		}
+
+		return true
 	},
-	AuthorizeEncryption: func(ctx context.Context, from nostr.PubKey) bool { return false },
 	OnEventSigned: func(event nostr.Event) {
 		log.Info().Str("id", event.ID.Hex()).Str("pubkey", event.PubKey.Hex()).Msg("event signed")
 	},
 }
 
+// clientProfile finds the profile a client is using, by the secret it gave us when it called 'connect'.
+func clientProfile(ctx context.Context, ar common.AccountRegistration, from nostr.PubKey) (common.AccountProfile, error) {
+	// get previously associated secret
+	next, done := iter.Pull(db.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{common.KindClientSecretAssociation},
+		Authors: []nostr.PubKey{from},
+		Tags: nostr.TagMap{
+			"p": []string{servedPubKey(ctx, ar).Hex()},
+		},
+		Limit: 1,
+	}, 1))
+	evt, ok := next()
+	done()
+	if !ok {
+		log.Warn().Str("client", from.Hex()).Str("user", ar.PubKey.Hex()).
+			Msg("no secret associated")
+		return common.AccountProfile{}, fmt.Errorf("client not registered, must call 'connect'")
+	}
+	secret := evt.Content
+
+	for _, profile := range ar.Profiles {
+		if profile.Secret == secret {
+			return profile, nil
+		}
+	}
+
+	return common.AccountProfile{}, fmt.Errorf("no profile matched")
+}
+
 func handleNIP46Request(ctx context.Context, event nostr.Event) {
 	ctx, cancel := context.WithTimeoutCause(ctx, time.Second*10, fmt.Errorf("handling took too long"))
 	defer cancel()
 
 	req, resp, eventResponse, err := nip46Signer.HandleRequest(ctx, event)
+	if err != nil && strings.HasPrefix(req.Method, "nip04_") {
+		resp, eventResponse, err = handleNIP04Request(ctx, event, req)
+	}
 	if err != nil {
 		log.Warn().Err(err).Stringer("request", req).Msg("failed to handle request")
 		useIPFailedAttemptsRateLimit(khatru.GetIP(ctx))
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"sync"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+)
+
+type preprocessedKey struct {
+	signer  nostr.PubKey
+	account nostr.PubKey
+}
+
+var (
+	// nonce commitments the signers have sent us ahead of time, each can be used only once
+	preprocessedCommitments     = make(map[preprocessedKey][]frost.Commitment)
+	preprocessedCommitmentsLock = sync.Mutex{}
+)
+
+// handleNonceBatch is called when a signer sends us a fresh batch of nonce commitments for an account. it replaces
+// whatever we had from that signer before, as the signer may have restarted and forgotten those.
+func handleNonceBatch(ctx context.Context, evt nostr.Event) {
+	pTag := evt.Tags.Find("P")
+	if pTag == nil {
+		return
+	}
+	account, err := nostr.PubKeyFromHex(pTag[1])
+	if err != nil {
+		return
+	}
+
+	next, done := iter.Pull(db.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{common.KindAccountRegistration},
+		Authors: []nostr.PubKey{account},
+		Limit:   1,
+	}, 1))
+	regEvt, ok := next()
+	done()
+	if !ok {
+		return
+	}
+	ar := common.AccountRegistration{}
+	if err := ar.Decode(regEvt); err != nil {
+		return
+	}
+
+	idx := slices.IndexFunc(ar.Signers, func(signer common.Signer) bool { return signer.PeerPubKey == evt.PubKey })
+	if idx == -1 {
+		log.Warn().Str("pubkey", account.Hex()).Str("signer", evt.PubKey.Hex()).
+			Msg("nonce batch from someone that isn't a signer")
+		return
+	}
+
+	batch := frost.CommitmentList{}
+	if err := batch.DecodeHex(evt.Content); err != nil {
+		log.Warn().Err(err).Str("signer", evt.PubKey.Hex()).Msg("failed to decode nonce batch")
+		return
+	}
+	for _, commitment := range batch {
+		// weighted signers send commitments for each of their shards in the same batch
+		if _, ok := ar.Signers[idx].ShardByID(commitment.SignerID); !ok {
+			log.Warn().Str("signer", evt.PubKey.Hex()).Int("expected", ar.Signers[idx].Shard.ID).
+				Int("got", commitment.SignerID).Msg("nonce batch for the wrong signer id")
+			return
+		}
+	}
+
+	preprocessedCommitmentsLock.Lock()
+	preprocessedCommitments[preprocessedKey{evt.PubKey, account}] = batch
+	preprocessedCommitmentsLock.Unlock()
+
+	log.Info().Str("pubkey", account.Hex()).Str("signer", evt.PubKey.Hex()).Int("count", len(batch)).
+		Msg("got nonce batch")
+
+	// this may be the answer to a signing session that tried to use nonces the signer didn't have anymore
+	handleSignerStuff(ctx, evt)
+}
+
+// takePreprocessed gives us one of the commitments a signer has sent ahead of time for each of its shards (so just
+// one unless it's weighted) and removes them so they are never used again.
+func takePreprocessed(account nostr.PubKey, signer common.Signer) (frost.CommitmentList, bool) {
+	preprocessedCommitmentsLock.Lock()
+	defer preprocessedCommitmentsLock.Unlock()
+
+	key := preprocessedKey{signer.PeerPubKey, account}
+	batch := preprocessedCommitments[key]
+
+	taken := make([]int, 0, signer.Weight())
+	for _, shard := range signer.Shards() {
+		idx := slices.IndexFunc(batch, func(c frost.Commitment) bool { return c.SignerID == shard.ID })
+		if idx == -1 {
+			return nil, false
+		}
+		taken = append(taken, idx)
+	}
+
+	commitments := make(frost.CommitmentList, 0, len(taken))
+	remaining := make([]frost.Commitment, 0, len(batch)-len(taken))
+	for i, commitment := range batch {
+		if slices.Contains(taken, i) {
+			commitments = append(commitments, commitment)
+		} else {
+			remaining = append(remaining, commitment)
+		}
+	}
+	preprocessedCommitments[key] = remaining
+	return commitments, true
+}
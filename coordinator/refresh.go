@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// refreshPeriodically goes through all the registered accounts and refreshes the shards of the ones that haven't
+// been refreshed (or created) in the last RefreshInterval, as long as all their signers are online.
+func refreshPeriodically(ctx context.Context) {
+	if s.RefreshInterval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(s.RefreshInterval, time.Hour))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// collect them first so we don't hold the db while we run the sessions
+		due := make([]common.AccountRegistration, 0, 10)
+		before := nostr.Now() - nostr.Timestamp(s.RefreshInterval.Seconds())
+		for evt := range db.QueryEvents(nostr.Filter{
+			Kinds: []nostr.Kind{common.KindAccountRegistration},
+			Until: before,
+		}, 5000) {
+			ar := common.AccountRegistration{}
+			if err := ar.Decode(evt); err != nil {
+				continue
+			}
+			if ar.Method != frost.MethodFROST {
+				// musig2 keys are bound to the signers' own keys, there is nothing to refresh
+				continue
+			}
+			if ar.Weighted() {
+				// weighted signers only know how to sign and do ecdh with their many shards
+				continue
+			}
+			if ar.HasCommittees() {
+				// the members of a sub-committee only know how to sign with their sub-shards
+				continue
+			}
+			if slices.ContainsFunc(ar.Signers, func(signer common.Signer) bool {
+				_, isOnline := onlineSigners.Load(signer.PeerPubKey)
+				return !isOnline
+			}) {
+				// we'll try again later
+				continue
+			}
+			due = append(due, ar)
+		}
+
+		for _, ar := range due {
+			ctx, cancel := context.WithTimeoutCause(ctx, time.Minute*3,
+				fmt.Errorf("share refresh took too long"))
+			if err := runRefreshSession(ctx, ar); err != nil {
+				log.Warn().Err(err).Str("pubkey", ar.PubKey.Hex()).Msg("share refresh failed")
+			}
+			cancel()
+		}
+	}
+}
+
+// runRefreshSession makes all the signers of an account replace their shards with new ones for the same key,
+// such that shards leaked before the refresh become useless.
+func runRefreshSession(ctx context.Context, ar common.AccountRegistration) (err error) {
+	ipk := make([]byte, 33)
+	ipk[0] = 2
+	copy(ipk[1:], ar.PubKey[:])
+	pubkey, _ := btcec.ParseJacobian(ipk)
+
+	// every signer must take part in a refresh
+	chosenSigners := make(map[nostr.PubKey]common.Signer, len(ar.Signers))
+	cfg := &frost.Configuration{
+		Threshold:    ar.Threshold,
+		MaxSigners:   len(ar.Signers),
+		PublicKey:    &pubkey,
+		Participants: make([]int, 0, len(ar.Signers)),
+	}
+	for _, signer := range ar.Signers {
+		chosenSigners[signer.PeerPubKey] = signer
+		cfg.Participants = append(cfg.Participants, signer.Shard.ID)
+	}
+
+	// step-1 (send): tell the signers to start, they will need to know each other's ids
+	confEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindRefreshConfiguration,
+		Content:   cfg.Hex(),
+		Tags:      make(nostr.Tags, 0, len(chosenSigners)),
+	}
+	for _, signer := range chosenSigners {
+		confEvt.Tags = append(confEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex(), strconv.Itoa(signer.Shard.ID)})
+	}
+	confEvt.Sign(s.SecretKey)
+
+	sessionId := confEvt.ID
+	log := log.With().Str("session", sessionId.Hex()).Str("pubkey", ar.PubKey.Hex()).Logger()
+
+	ch := make(chan nostr.Event)
+	session := &Session{
+		ch:            ch,
+		chosenSigners: chosenSigners,
+		status:        "refresh-initializing",
+	}
+	signingSessions.Store(sessionId, session)
+
+	defer func() {
+		// set status to error
+		if err != nil {
+			session.status = err.Error()
+		}
+
+		// keep sessions for 5 minutes for debugging then delete them
+		go func() {
+			time.Sleep(time.Minute * 5)
+			signingSessions.Delete(sessionId)
+		}()
+	}()
+
+	log.Info().Msg("starting share refresh")
+	relay.BroadcastEvent(confEvt)
+
+	// step-2 (receive): get the zero-polynomial commitments from all signers
+	session.status = "refresh-commits"
+	refreshCommitments := make(map[nostr.PubKey]frost.RefreshCommitment, len(chosenSigners))
+	for len(refreshCommitments) < len(chosenSigners) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout receiving refresh commits, missing: %v",
+				missingFrom(chosenSigners, refreshCommitments))
+		case evt := <-ch:
+			if evt.Kind != common.KindRefreshCommit {
+				return fmt.Errorf("got a kind %d instead of %d (refresh commit) from %s",
+					evt.Kind, common.KindRefreshCommit, evt.PubKey)
+			}
+
+			com := frost.RefreshCommitment{}
+			if err := com.DecodeHex(evt.Content); err != nil {
+				return fmt.Errorf("failed to decode refresh commit from %s: %w", evt.PubKey, err)
+			}
+			if com.SignerID != chosenSigners[evt.PubKey].Shard.ID {
+				return fmt.Errorf("signer %s sent a refresh commit for %d, expected %d",
+					evt.PubKey, com.SignerID, chosenSigners[evt.PubKey].Shard.ID)
+			}
+			if err := frost.ValidateRefreshCommitment(com, cfg.Threshold, cfg.MaxSigners); err != nil {
+				return fmt.Errorf("invalid refresh commit from %s: %w", evt.PubKey, err)
+			}
+
+			refreshCommitments[evt.PubKey] = com
+		}
+	}
+
+	allCommitments := slices.SortedFunc(maps.Values(refreshCommitments), func(a, b frost.RefreshCommitment) int {
+		return a.SignerID - b.SignerID
+	})
+
+	// step-3 (send): let everybody know the same commitments -- they will exchange their shares directly
+	session.status = "refresh-shares"
+	encoded := make([]byte, 0, len(allCommitments)*(4+33*cfg.Threshold))
+	for _, com := range allCommitments {
+		encoded = append(encoded, com.Encode()...)
+	}
+	groupCommitEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindRefreshGroupCommit,
+		Content:   hex.EncodeToString(encoded),
+		Tags:      make(nostr.Tags, 0, 1+len(chosenSigners)),
+	}
+	groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"e", sessionId.Hex()})
+	for _, signer := range chosenSigners {
+		groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
+	}
+	groupCommitEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(groupCommitEvt)
+
+	// we can already tell what everybody's public shard should be
+	updated := ar
+	updated.Signers = make([]common.Signer, len(ar.Signers))
+	expected := make(map[nostr.PubKey]frost.PublicKeyShard, len(ar.Signers))
+	for i, signer := range ar.Signers {
+		updated.Signers[i] = common.Signer{
+			PeerPubKey: signer.PeerPubKey,
+			Shard:      frost.RefreshPublicKeyShard(signer.Shard, allCommitments),
+		}
+		expected[signer.PeerPubKey] = updated.Signers[i].Shard
+	}
+
+	// step-4 (receive): get the results, which must match what we computed, and the nonce commitments for signing
+	// the updated account registration with the new shards
+	session.status = "refresh-results"
+	results := make(map[nostr.PubKey]frost.PublicKeyShard, len(chosenSigners))
+	commitments := make(map[nostr.PubKey]frost.Commitment, len(chosenSigners))
+	for len(results) < len(chosenSigners) || len(commitments) < len(chosenSigners) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout receiving refresh results, missing: %v", missingFrom(chosenSigners, results))
+		case evt := <-ch:
+			switch evt.Kind {
+			case common.KindRefreshResult:
+				pks := frost.PublicKeyShard{}
+				if err := pks.DecodeHex(evt.Content); err != nil {
+					return fmt.Errorf("failed to decode refresh result from %s: %w", evt.PubKey, err)
+				}
+				if pks.Hex() != expected[evt.PubKey].Hex() {
+					return fmt.Errorf("signer %s got a different result from the refresh", evt.PubKey)
+				}
+				results[evt.PubKey] = pks
+			case common.KindCommit:
+				commit := frost.Commitment{}
+				if err := commit.DecodeHex(evt.Content); err != nil {
+					return fmt.Errorf("failed to decode commit: %w", err)
+				}
+				if commit.SignerID != chosenSigners[evt.PubKey].Shard.ID {
+					return fmt.Errorf("signer %s sent a commit for %d, expected %d",
+						evt.PubKey, commit.SignerID, chosenSigners[evt.PubKey].Shard.ID)
+				}
+				commitments[evt.PubKey] = commit
+			default:
+				return fmt.Errorf("got an unexpected kind %d from %s", evt.Kind, evt.PubKey)
+			}
+		}
+	}
+
+	// step-5: the group signs the registration with the new shards, which proves they work
+	session.status = "refresh-registration"
+	if err := signAccountRegistration(ctx, session, sessionId, updated, commitments); err != nil {
+		return err
+	}
+
+	// step-6 (send): now the signers can safely throw away their old shards
+	ackEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindShardACK,
+		Tags:      make(nostr.Tags, 0, 2+len(chosenSigners)),
+	}
+	ackEvt.Tags = append(ackEvt.Tags, nostr.Tag{"P", ar.PubKey.Hex()}, nostr.Tag{"e", sessionId.Hex()})
+	for _, signer := range chosenSigners {
+		ackEvt.Tags = append(ackEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
+	}
+	ackEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(ackEvt)
+
+	session.status = "done"
+	log.Info().Msg("share refresh finished")
+	return nil
+}
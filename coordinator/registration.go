@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/mailru/easyjson"
+)
+
+// signAccountRegistration makes all the signers in a session sign the account registration with their newly
+// obtained shards, then stores it. this is how a group proves it controls the key after it has been generated or
+// its shards have been changed, and it's the only situation in which signers accept signing a kind:16430.
+func signAccountRegistration(
+	ctx context.Context,
+	session *Session,
+	sessionId nostr.ID,
+	ar common.AccountRegistration,
+	commitments map[nostr.PubKey]frost.Commitment,
+) error {
+	regEvt := ar.Encode()
+	msg := regEvt.GetID()
+	regEvt.ID = msg
+
+	ipk := make([]byte, 33)
+	ipk[0] = 2
+	copy(ipk[1:], ar.PubKey[:])
+	pubkey, _ := btcec.ParseJacobian(ipk)
+
+	cfg := &frost.Configuration{
+		Threshold:    ar.Threshold,
+		MaxSigners:   len(ar.Signers),
+		PublicKey:    &pubkey,
+		Participants: make([]int, 0, len(session.chosenSigners)),
+		Method:       ar.Method,
+	}
+	for _, signer := range session.chosenSigners {
+		cfg.Participants = append(cfg.Participants, signer.Shard.ID)
+	}
+	groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(
+		slices.Collect(maps.Values(commitments)),
+		msg[:],
+	)
+
+	jevt, _ := easyjson.Marshal(regEvt)
+	for _, step := range []nostr.Event{
+		{Kind: common.KindGroupCommit, Content: groupCommitment.Hex()},
+		{Kind: common.KindEventToBeSigned, Content: string(jevt)},
+	} {
+		step.CreatedAt = nostr.Now()
+		step.Tags = make(nostr.Tags, 0, 1+len(session.chosenSigners))
+		step.Tags = append(step.Tags, nostr.Tag{"e", sessionId.Hex()})
+		for _, signer := range session.chosenSigners {
+			step.Tags = append(step.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
+		}
+		step.Sign(s.SecretKey)
+		relay.BroadcastEvent(step)
+	}
+
+	// the shards we verify against are the new ones, as given in the registration
+	shards := make(map[nostr.PubKey]frost.PublicKeyShard, len(ar.Signers))
+	for _, signer := range ar.Signers {
+		shards[signer.PeerPubKey] = signer.Shard
+	}
+
+	partialSigs := make(map[nostr.PubKey]frost.PartialSignature, len(session.chosenSigners))
+	for len(partialSigs) < len(session.chosenSigners) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout receiving partial signatures, missing: %v",
+				missingFrom(session.chosenSigners, partialSigs))
+		case evt := <-session.ch:
+			if evt.Kind != common.KindPartialSignature {
+				return fmt.Errorf("got a kind %d instead of %d (partial sig) from %s",
+					evt.Kind, common.KindPartialSignature, evt.PubKey)
+			}
+
+			partialSig := frost.PartialSignature{}
+			if err := partialSig.DecodeHex(evt.Content); err != nil {
+				return fmt.Errorf("failed to decode partial signature from %s", evt.PubKey)
+			}
+
+			if partialSig.SignerIdentifier != shards[evt.PubKey].ID {
+				return fmt.Errorf("signer %s sent a partial signature for %d, expected %d",
+					evt.PubKey, partialSig.SignerIdentifier, shards[evt.PubKey].ID)
+			}
+
+			partialSigs[evt.PubKey] = partialSig
+		}
+	}
+
+	// everybody must have signed, so we can check them all at once
+	signers := slices.Collect(maps.Keys(partialSigs))
+	pubShards := make([]frost.PublicKeyShard, len(signers))
+	commits := make([]frost.BinoncePublic, len(signers))
+	sigs := make([]frost.PartialSignature, len(signers))
+	for i, signer := range signers {
+		pubShards[i] = shards[signer]
+		commits[i] = commitments[signer].BinoncePublic
+		sigs[i] = partialSigs[signer]
+	}
+	if err := cfg.VerifyPartialSignatures(pubShards, commits, bindingCoefficient, finalNonce, sigs, msg[:],
+		lambdaRegistry,
+	); err != nil {
+		var bad frost.PartialSignatureError
+		if errors.As(err, &bad) {
+			if idx := slices.IndexFunc(sigs, func(sig frost.PartialSignature) bool {
+				return sig.SignerIdentifier == bad.SignerID
+			}); idx != -1 {
+				return fmt.Errorf("partial signature from signer %s isn't good: %w", signers[idx], err)
+			}
+		}
+		return err
+	}
+
+	sig, err := cfg.AggregateSignatures(finalNonce, sigs)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate signatures: %w", err)
+	}
+	regEvt.Sig = [64]byte(sig.Serialize())
+	if !regEvt.VerifySignature() {
+		return fmt.Errorf("account registration signature is invalid")
+	}
+
+	// this goes through the normal path, so it will be saved and acked to the signers
+	if _, err := relay.AddEvent(ctx, regEvt); err != nil {
+		return fmt.Errorf("failed to save account registration: %w", err)
+	}
+
+	return nil
+}
+
+func missingFrom[V any](chosenSigners map[nostr.PubKey]common.Signer, got map[nostr.PubKey]V) []nostr.PubKey {
+	missing := make([]nostr.PubKey, 0, len(chosenSigners)-len(got))
+	for pubkey := range chosenSigners {
+		if _, ok := got[pubkey]; !ok {
+			missing = append(missing, pubkey)
+		}
+	}
+	return missing
+}
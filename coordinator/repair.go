@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/mailru/easyjson"
+)
+
+// handleRepairRequest is called when one of the signers of an account has lost its shard and wants it back.
+func handleRepairRequest(requestEvt nostr.Event) {
+	pTag := requestEvt.Tags.Find("P")
+	if pTag == nil {
+		log.Warn().Stringer("event", requestEvt).Msg("repair request without an account")
+		return
+	}
+	account, err := nostr.PubKeyFromHex(pTag[1])
+	if err != nil {
+		log.Warn().Stringer("event", requestEvt).Msg("repair request with an invalid account")
+		return
+	}
+
+	next, done := iter.Pull(db.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{common.KindAccountRegistration},
+		Authors: []nostr.PubKey{account},
+		Limit:   1,
+	}, 1))
+	regEvt, ok := next()
+	done()
+	if !ok {
+		log.Warn().Str("pubkey", account.Hex()).Msg("repair request for an unknown account")
+		return
+	}
+	ar := common.AccountRegistration{}
+	if err := ar.Decode(regEvt); err != nil {
+		log.Warn().Err(err).Msg("stored account registration is invalid")
+		return
+	}
+	ar.Event = &regEvt
+	if ar.Method != frost.MethodFROST {
+		// with musig2 nobody else has anything that could bring a lost key back
+		log.Warn().Str("pubkey", account.Hex()).Msg("repair request for a musig2 account")
+		return
+	}
+	if ar.Weighted() {
+		// signers hold a single shard in every flow but signing and ecdh
+		log.Warn().Str("pubkey", account.Hex()).Msg("repair request for an account with weighted signers")
+		return
+	}
+	if ar.HasCommittees() {
+		// and sub-committees only ever sign
+		log.Warn().Str("pubkey", account.Hex()).Msg("repair request for an account with sub-committees")
+		return
+	}
+
+	// only the signer itself can ask for its shard to be repaired
+	idx := slices.IndexFunc(ar.Signers, func(signer common.Signer) bool { return signer.PeerPubKey == requestEvt.PubKey })
+	if idx == -1 {
+		log.Warn().Str("pubkey", account.Hex()).Str("signer", requestEvt.PubKey.Hex()).
+			Msg("repair request from someone that isn't a signer")
+		return
+	}
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Minute*3,
+		fmt.Errorf("share repair took too long"))
+	defer cancel()
+
+	if err := runRepairSession(ctx, requestEvt.ID, ar, ar.Signers[idx]); err != nil {
+		log.Warn().Err(err).Str("request", requestEvt.ID.Hex()).Msg("share repair failed")
+		return
+	}
+}
+
+// runRepairSession has a threshold of the other signers recover the shard of the lost signer for it without
+// learning it themselves. we never see anything secret, we just pass their encrypted pieces around.
+func runRepairSession(ctx context.Context, sessionId nostr.ID, ar common.AccountRegistration, lost common.Signer) (err error) {
+	log := log.With().Str("session", sessionId.Hex()).Str("pubkey", ar.PubKey.Hex()).Logger()
+
+	// pick a threshold of the other signers that is online to act as helpers
+	shuffle(ar.Signers)
+	helpers := make(map[nostr.PubKey]common.Signer, ar.Threshold)
+	for _, signer := range ar.Signers {
+		if signer.PeerPubKey == lost.PeerPubKey {
+			continue
+		}
+		if _, isOnline := onlineSigners.Load(signer.PeerPubKey); isOnline && len(helpers) < ar.Threshold {
+			helpers[signer.PeerPubKey] = signer
+		}
+	}
+	if len(helpers) < ar.Threshold {
+		return fmt.Errorf("not enough signers online: have %d, needed %d", len(helpers), ar.Threshold)
+	}
+
+	// only the lost signer talks to us, the helpers just exchange shares
+	ch := make(chan nostr.Event)
+	session := &Session{
+		ch:            ch,
+		chosenSigners: map[nostr.PubKey]common.Signer{lost.PeerPubKey: lost},
+		status:        "repair-initializing",
+	}
+	signingSessions.Store(sessionId, session)
+
+	defer func() {
+		// set status to error
+		if err != nil {
+			session.status = err.Error()
+		}
+
+		// keep sessions for 5 minutes for debugging then delete them
+		go func() {
+			time.Sleep(time.Minute * 5)
+			signingSessions.Delete(sessionId)
+		}()
+	}()
+
+	log.Info().
+		Any("helpers", slices.Collect(maps.Keys(helpers))).
+		Int("lost", lost.Shard.ID).
+		Msg("starting share repair")
+
+	// step-1 (send): give everybody the registration as signed by the user, so they can check who is who themselves
+	jreg, _ := easyjson.Marshal(ar.Event)
+	confEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindRepairConfiguration,
+		Content:   string(jreg),
+		Tags:      make(nostr.Tags, 0, 3+len(helpers)),
+	}
+	confEvt.Tags = append(confEvt.Tags,
+		nostr.Tag{"e", sessionId.Hex()},
+		nostr.Tag{"P", ar.PubKey.Hex()},
+		nostr.Tag{"lost", lost.PeerPubKey.Hex(), strconv.Itoa(lost.Shard.ID)},
+		nostr.Tag{"p", lost.PeerPubKey.Hex()},
+	)
+	for _, helper := range helpers {
+		confEvt.Tags = append(confEvt.Tags, nostr.Tag{"p", helper.PeerPubKey.Hex(), strconv.Itoa(helper.Shard.ID)})
+	}
+	confEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(confEvt)
+
+	// step-2 (receive): the lost signer tells us it got a shard that matches its public shard
+	session.status = "repair-waiting"
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("timeout waiting for repair result")
+	case evt := <-ch:
+		if evt.Kind != common.KindRepairResult {
+			return fmt.Errorf("got a kind %d instead of %d (repair result) from %s",
+				evt.Kind, common.KindRepairResult, evt.PubKey)
+		}
+
+		pks := frost.PublicKeyShard{}
+		if err := pks.DecodeHex(evt.Content); err != nil {
+			return fmt.Errorf("failed to decode repair result: %w", err)
+		}
+		if pks.Hex() != lost.Shard.Hex() {
+			return fmt.Errorf("signer %s got a different shard from the repair", evt.PubKey)
+		}
+	}
+
+	// step-3 (send): the signer can now store its shard and go back to signing
+	ackEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindShardACK,
+		Tags: nostr.Tags{
+			nostr.Tag{"P", ar.PubKey.Hex()},
+			nostr.Tag{"p", lost.PeerPubKey.Hex()},
+			nostr.Tag{"e", sessionId.Hex()},
+		},
+	}
+	ackEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(ackEvt)
+
+	session.status = "done"
+	log.Info().Msg("share repair finished")
+	return nil
+}
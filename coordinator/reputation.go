@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// signerFault says which signer made a signing attempt fail, at which step and why.
+type signerFault struct {
+	signer   nostr.PubKey
+	step     string
+	timedOut bool
+	reason   string
+}
+
+// abortError is returned when signing failed because of some of the signers.
+type abortError struct {
+	faults []signerFault
+}
+
+func (e abortError) Error() string {
+	descs := make([]string, len(e.faults))
+	for i, fault := range e.faults {
+		if fault.timedOut {
+			descs[i] = fmt.Sprintf("%s timed out at %s", fault.signer.Hex(), fault.step)
+		} else {
+			descs[i] = fmt.Sprintf("%s misbehaved at %s: %s", fault.signer.Hex(), fault.step, fault.reason)
+		}
+	}
+	return "signing aborted: " + strings.Join(descs, ", ")
+}
+
+func misbehaved(signer nostr.PubKey, step string, reason string, args ...any) signerFault {
+	return signerFault{signer: signer, step: step, reason: fmt.Sprintf(reason, args...)}
+}
+
+// timedOut blames everybody still missing at step, but only if it was the signers that were too slow and not the
+// client that gave up on us.
+func timedOut(ctx context.Context, step string, missing map[nostr.PubKey]struct{}) error {
+	if !errors.Is(context.Cause(ctx), errSigningTimeout) {
+		return fmt.Errorf("gave up at %s, missing: %v: %w", step, slices.Collect(maps.Keys(missing)), context.Cause(ctx))
+	}
+
+	faults := make([]signerFault, 0, len(missing))
+	for signer := range missing {
+		faults = append(faults, signerFault{signer: signer, step: step, timedOut: true})
+	}
+	return abortError{faults: faults}
+}
+
+// Reputation is what we know about how a signer has behaved in signing sessions, kept across restarts.
+type Reputation struct {
+	Misbehaved int `json:"misbehaved"`
+	TimedOut   int `json:"timed_out"`
+
+	LastFaultStep   string          `json:"last_fault_step,omitempty"`
+	LastFaultReason string          `json:"last_fault_reason,omitempty"`
+	LastFaultAt     nostr.Timestamp `json:"last_fault_at,omitempty"`
+}
+
+// score is lower for better signers, misbehaving is much worse than being slow.
+func (r Reputation) score() int {
+	return r.Misbehaved*10 + r.TimedOut
+}
+
+var reputations = xsync.NewMapOf[nostr.PubKey, Reputation]()
+
+func getReputation(signer nostr.PubKey) Reputation {
+	rep, _ := reputations.LoadOrCompute(signer, func() Reputation {
+		rep := Reputation{}
+
+		next, done := iter.Pull(db.QueryEvents(nostr.Filter{
+			Kinds:   []nostr.Kind{common.KindSignerReputation},
+			Authors: []nostr.PubKey{signer},
+			Limit:   1,
+		}, 1))
+		evt, ok := next()
+		done()
+		if ok {
+			if err := json.Unmarshal([]byte(evt.Content), &rep); err != nil {
+				log.Warn().Err(err).Str("signer", signer.Hex()).Msg("stored reputation is invalid")
+			}
+		}
+
+		return rep
+	})
+	return rep
+}
+
+func recordFault(fault signerFault) {
+	getReputation(fault.signer)
+	rep, _ := reputations.Compute(fault.signer, func(rep Reputation, _ bool) (Reputation, bool) {
+		if fault.timedOut {
+			rep.TimedOut++
+			rep.LastFaultReason = "timed out"
+		} else {
+			rep.Misbehaved++
+			rep.LastFaultReason = fault.reason
+		}
+		rep.LastFaultStep = fault.step
+		rep.LastFaultAt = nostr.Now()
+		return rep, false
+	})
+
+	log.Warn().
+		Str("signer", fault.signer.Hex()).
+		Str("step", fault.step).
+		Str("reason", rep.LastFaultReason).
+		Int("misbehaved", rep.Misbehaved).
+		Int("timed_out", rep.TimedOut).
+		Msg("signer fault")
+
+	// this is just like a client secret association, an internal record only we can read
+	content, _ := json.Marshal(rep)
+	record := nostr.Event{
+		Kind:      common.KindSignerReputation,
+		PubKey:    fault.signer,
+		Content:   string(content),
+		CreatedAt: nostr.Now(),
+	}
+	record.ID = record.GetID()
+	if err := db.ReplaceEvent(record); err != nil {
+		log.Error().Err(err).Str("signer", fault.signer.Hex()).Msg("failed to store reputation")
+	}
+}
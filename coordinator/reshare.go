@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/mailru/easyjson"
+)
+
+func handleReshareRequest(requestEvt nostr.Event) {
+	request := common.ReshareRequest{}
+	if err := request.Decode(requestEvt); err != nil {
+		log.Warn().Err(err).Stringer("event", requestEvt).Msg("invalid reshare request")
+		return
+	}
+
+	// the request is signed by the account itself, so it must be registered here
+	next, done := iter.Pull(db.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{common.KindAccountRegistration},
+		Authors: []nostr.PubKey{requestEvt.PubKey},
+		Limit:   1,
+	}, 1))
+	regEvt, ok := next()
+	done()
+	if !ok {
+		log.Warn().Str("pubkey", requestEvt.PubKey.Hex()).Msg("reshare request for an unknown account")
+		return
+	}
+	ar := common.AccountRegistration{}
+	if err := ar.Decode(regEvt); err != nil {
+		log.Warn().Err(err).Msg("stored account registration is invalid")
+		return
+	}
+	if ar.Method != frost.MethodFROST {
+		log.Warn().Str("pubkey", ar.PubKey.Hex()).Msg("reshare request for a musig2 account")
+		return
+	}
+	if ar.Weighted() {
+		log.Warn().Str("pubkey", ar.PubKey.Hex()).Msg("reshare request for an account with weighted signers")
+		return
+	}
+	if ar.HasCommittees() {
+		log.Warn().Str("pubkey", ar.PubKey.Hex()).Msg("reshare request for an account with sub-committees")
+		return
+	}
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Minute*3,
+		fmt.Errorf("resharing took too long"))
+	defer cancel()
+
+	if err := runReshareSession(ctx, request, ar); err != nil {
+		log.Warn().Err(err).Str("request", requestEvt.ID.Hex()).Msg("resharing failed")
+		return
+	}
+
+	// let the user know it all went well
+	ackEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindShardACK,
+		Tags: nostr.Tags{
+			nostr.Tag{"P", ar.PubKey.Hex()},
+			nostr.Tag{"p", ar.PubKey.Hex()},
+			nostr.Tag{"e", requestEvt.ID.Hex()},
+		},
+	}
+	ackEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(ackEvt)
+}
+
+// runReshareSession has a threshold of the current signers deal sub-shares of their shards to the new signers, then
+// has the new signers sign the updated account registration. the current signers are only replaced after that.
+func runReshareSession(ctx context.Context, request common.ReshareRequest, ar common.AccountRegistration) (err error) {
+	sessionId := request.Event.ID
+	log := log.With().Str("session", sessionId.Hex()).Str("pubkey", ar.PubKey.Hex()).Logger()
+
+	ipk := make([]byte, 33)
+	ipk[0] = 2
+	copy(ipk[1:], ar.PubKey[:])
+	pubkey, _ := btcec.ParseJacobian(ipk)
+
+	// pick a threshold of the current signers that is online to act as dealers
+	shuffle(ar.Signers)
+	dealers := make(map[nostr.PubKey]common.Signer, ar.Threshold)
+	participants := make([]int, 0, ar.Threshold)
+	for _, signer := range ar.Signers {
+		if _, isOnline := onlineSigners.Load(signer.PeerPubKey); isOnline && len(dealers) < ar.Threshold {
+			dealers[signer.PeerPubKey] = signer
+			participants = append(participants, signer.Shard.ID)
+		}
+	}
+	if len(dealers) < ar.Threshold {
+		return fmt.Errorf("not enough signers online: have %d, needed %d", len(dealers), ar.Threshold)
+	}
+
+	newSigners := make(map[nostr.PubKey]common.Signer, len(request.Signers))
+	for i, pubkey := range request.Signers {
+		newSigners[pubkey] = common.Signer{
+			PeerPubKey: pubkey,
+			Shard:      frost.PublicKeyShard{ID: i + 1},
+		}
+	}
+
+	// during the resharing both the dealers and the new signers talk to us
+	ch := make(chan nostr.Event)
+	session := &Session{
+		ch:            ch,
+		chosenSigners: make(map[nostr.PubKey]common.Signer, len(dealers)+len(newSigners)),
+		status:        "reshare-initializing",
+	}
+	maps.Copy(session.chosenSigners, dealers)
+	maps.Copy(session.chosenSigners, newSigners)
+	signingSessions.Store(sessionId, session)
+
+	defer func() {
+		// set status to error
+		if err != nil {
+			session.status = err.Error()
+		}
+
+		// keep sessions for 5 minutes for debugging then delete them
+		go func() {
+			time.Sleep(time.Minute * 5)
+			signingSessions.Delete(sessionId)
+		}()
+	}()
+
+	log.Info().
+		Any("dealers", slices.Collect(maps.Keys(dealers))).
+		Any("signers", request.Signers).
+		Int("threshold", request.Threshold).
+		Msg("starting resharing")
+
+	// step-1 (receive): the new signers must be listening before anyone sends them shares
+	session.status = "reshare-waiting"
+	ready := make(map[nostr.PubKey]struct{}, len(newSigners))
+	for len(ready) < len(newSigners) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for new signers, missing: %v", missingFrom(newSigners, ready))
+		case evt := <-ch:
+			if _, isNewSigner := newSigners[evt.PubKey]; evt.Kind != common.KindReshareReady || !isNewSigner {
+				return fmt.Errorf("got a kind %d instead of %d (reshare ready) from %s",
+					evt.Kind, common.KindReshareReady, evt.PubKey)
+			}
+			ready[evt.PubKey] = struct{}{}
+		}
+	}
+
+	// step-2 (send): give the dealers the request as signed by the user, so they can check it themselves
+	jreq, _ := easyjson.Marshal(request.Event)
+	confEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindReshareConfiguration,
+		Content:   string(jreq),
+		Tags:      make(nostr.Tags, 0, 1+len(dealers)),
+	}
+	confEvt.Tags = append(confEvt.Tags, nostr.Tag{"e", sessionId.Hex()})
+	for _, dealer := range dealers {
+		confEvt.Tags = append(confEvt.Tags, nostr.Tag{"p", dealer.PeerPubKey.Hex(), strconv.Itoa(dealer.Shard.ID)})
+	}
+	confEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(confEvt)
+
+	// step-3 (receive): the dealers' commitments, which must match their current public shards
+	session.status = "reshare-commits"
+	reshareCommitments := make(map[nostr.PubKey]frost.ReshareCommitment, len(dealers))
+	for len(reshareCommitments) < len(dealers) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout receiving reshare commits, missing: %v", missingFrom(dealers, reshareCommitments))
+		case evt := <-ch:
+			dealer, isDealer := dealers[evt.PubKey]
+			if evt.Kind != common.KindReshareCommit || !isDealer {
+				return fmt.Errorf("got a kind %d instead of %d (reshare commit) from %s",
+					evt.Kind, common.KindReshareCommit, evt.PubKey)
+			}
+
+			com := frost.ReshareCommitment{}
+			if err := com.DecodeHex(evt.Content); err != nil {
+				return fmt.Errorf("failed to decode reshare commit from %s: %w", evt.PubKey, err)
+			}
+			if err := frost.ValidateReshareCommitment(com, dealer.Shard, participants, request.Threshold); err != nil {
+				return fmt.Errorf("invalid reshare commit from %s: %w", evt.PubKey, err)
+			}
+
+			reshareCommitments[evt.PubKey] = com
+		}
+	}
+
+	allCommitments := slices.SortedFunc(maps.Values(reshareCommitments), func(a, b frost.ReshareCommitment) int {
+		return a.SignerID - b.SignerID
+	})
+
+	// step-4 (send): the new signers get the commitments and who the dealers are -- the shares come directly from them
+	session.status = "reshare-shares"
+	encoded := make([]byte, 0, len(allCommitments)*(4+33*request.Threshold))
+	for _, com := range allCommitments {
+		encoded = append(encoded, com.Encode()...)
+	}
+	groupCommitEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindReshareGroupCommit,
+		Content:   hex.EncodeToString(encoded),
+		Tags:      make(nostr.Tags, 0, 1+len(dealers)+len(newSigners)),
+	}
+	groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"e", sessionId.Hex()})
+	for _, dealer := range dealers {
+		groupCommitEvt.Tags = append(groupCommitEvt.Tags,
+			nostr.Tag{"dealer", dealer.PeerPubKey.Hex(), strconv.Itoa(dealer.Shard.ID)})
+	}
+	for _, signer := range newSigners {
+		groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
+	}
+	groupCommitEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(groupCommitEvt)
+
+	// we can already tell what everybody's new public shard should be
+	vss := frost.AggregateReshareCommitments(allCommitments)
+	if !vss[0].X.Equals(&pubkey.X) || !vss[0].Y.Equals(&pubkey.Y) {
+		return fmt.Errorf("reshare commitments don't add up to the account pubkey")
+	}
+	updated := ar
+	updated.Threshold = request.Threshold
+	updated.Signers = make([]common.Signer, len(request.Signers))
+	for i, signerPubKey := range request.Signers {
+		updated.Signers[i] = common.Signer{
+			PeerPubKey: signerPubKey,
+			Shard:      vss.PublicKeyShard(i + 1),
+		}
+	}
+
+	// step-5 (receive): get the results from the new signers and the nonce commitments for signing the registration
+	session.status = "reshare-results"
+	results := make(map[nostr.PubKey]frost.PublicKeyShard, len(newSigners))
+	commitments := make(map[nostr.PubKey]frost.Commitment, len(newSigners))
+	for len(results) < len(newSigners) || len(commitments) < len(newSigners) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout receiving reshare results, missing: %v", missingFrom(newSigners, results))
+		case evt := <-ch:
+			newSigner, isNewSigner := newSigners[evt.PubKey]
+			if !isNewSigner {
+				return fmt.Errorf("got an unexpected kind %d from dealer %s", evt.Kind, evt.PubKey)
+			}
+
+			switch evt.Kind {
+			case common.KindReshareResult:
+				pks := frost.PublicKeyShard{}
+				if err := pks.DecodeHex(evt.Content); err != nil {
+					return fmt.Errorf("failed to decode reshare result from %s: %w", evt.PubKey, err)
+				}
+				if pks.Hex() != updated.Signers[newSigner.Shard.ID-1].Shard.Hex() {
+					return fmt.Errorf("signer %s got a different result from the resharing", evt.PubKey)
+				}
+				results[evt.PubKey] = pks
+			case common.KindCommit:
+				commit := frost.Commitment{}
+				if err := commit.DecodeHex(evt.Content); err != nil {
+					return fmt.Errorf("failed to decode commit: %w", err)
+				}
+				if commit.SignerID != newSigner.Shard.ID {
+					return fmt.Errorf("signer %s sent a commit for %d, expected %d",
+						evt.PubKey, commit.SignerID, newSigner.Shard.ID)
+				}
+				commitments[evt.PubKey] = commit
+			default:
+				return fmt.Errorf("got an unexpected kind %d from %s", evt.Kind, evt.PubKey)
+			}
+		}
+	}
+
+	// step-6: every new signer has its shard, now they must prove it works by signing the updated registration,
+	// which replaces the current one
+	session.status = "reshare-registration"
+	session = &Session{
+		ch:            ch,
+		chosenSigners: newSigners,
+		status:        "reshare-registration",
+	}
+	signingSessions.Store(sessionId, session)
+	if err := signAccountRegistration(ctx, session, sessionId, updated, commitments); err != nil {
+		return err
+	}
+
+	// step-7 (send): now the dealers can throw away their old shards
+	ackEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindShardACK,
+		Tags:      make(nostr.Tags, 0, 2+len(dealers)),
+	}
+	ackEvt.Tags = append(ackEvt.Tags, nostr.Tag{"P", ar.PubKey.Hex()}, nostr.Tag{"e", sessionId.Hex()})
+	for _, dealer := range dealers {
+		ackEvt.Tags = append(ackEvt.Tags, nostr.Tag{"p", dealer.PeerPubKey.Hex()})
+	}
+	ackEvt.Sign(s.SecretKey)
+	relay.BroadcastEvent(ackEvt)
+
+	session.status = "done"
+	log.Info().Msg("resharing finished")
+	return nil
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"iter"
 	"maps"
 	"slices"
-	"sync"
+	"strconv"
 	"time"
 
 	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip44"
 	"fiatjaf.com/promenade/common"
 	"fiatjaf.com/promenade/frost"
 	"github.com/btcsuite/btcd/btcec/v2"
@@ -21,25 +24,77 @@ var (
 	onlineSigners                = xsync.NewMapOf[nostr.PubKey, int]()
 	groupContextsByHandlerPubKey = xsync.NewMapOf[nostr.PubKey, *GroupContext]()
 	signingSessions              = xsync.NewMapOf[nostr.ID, *Session]()
-	lambdaRegistry               = make(frost.LambdaRegistry)
-	lambdaRegistryLock           = sync.Mutex{}
+	lambdaRegistry               = frost.NewLambdaRegistry(frost.DefaultLambdaRegistrySize)
 )
 
 type GroupContext struct {
 	common.AccountRegistration
+
+	// set when we are signing for one of the accounts derived from the group key
+	Derived *common.DerivedAccount
 }
 
 type Session struct {
 	chosenSigners map[nostr.PubKey]common.Signer
 	ch            chan nostr.Event
 	status        string
+
+	// closed when nobody is reading from ch anymore, nil for sessions that don't care
+	done chan struct{}
 }
 
 func (kuc *GroupContext) GetPublicKey(ctx context.Context) (nostr.PubKey, error) {
+	if kuc.Derived != nil {
+		return kuc.Derived.PubKey, nil
+	}
 	return kuc.PubKey, nil
 }
 
+// signing gets this much time, a bit less than what the client gives us, so we can still tell who was too slow
+const signingTimeout = time.Second * 8
+
+var errSigningTimeout = fmt.Errorf("signing took too long")
+
+// roastSession is one of the sessions started within a ROAST run, with signers that were ready at the time, adding
+// up to at least the threshold, and the commitments they had given us, by shard identifier (weighted signers give
+// one for each of their shards, and then one partial signature for each too).
+type roastSession struct {
+	id                 nostr.ID
+	cfg                *frost.Configuration
+	signers            map[nostr.PubKey]common.Signer
+	commitments        map[int]frost.Commitment
+	groupCommitment    frost.BinoncePublic
+	bindingCoefficient *btcec.ModNScalar
+	finalNonce         *btcec.JacobianPoint
+	partialSigs        map[int]frost.PartialSignature
+
+	// the inner rounds of the sub-committees in this session, by their pubkey
+	committees map[nostr.PubKey]*committeeRound
+}
+
+// committeeRound is what a sub-committee does within a session: a threshold of its members sign for it, their
+// commitments add up to the one it is in the session with and their partial signatures add up to its own.
+type committeeRound struct {
+	cfg         *frost.Configuration
+	commitments map[nostr.PubKey]frost.Commitment
+	partialSigs map[int]frost.PartialSignature
+}
+
+// SignEvent signs with ROAST (https://eprint.iacr.org/2022/550): all the online signers are invited and every time
+// a threshold of them is ready (i.e. they have given us nonce commitments and are not busy in another session) a
+// new session is started with those. a signer becomes ready again as soon as it has sent its partial signatures and
+// fresh commitments, so sessions overlap and the first one to get all its partial signatures wins. as long as a
+// threshold of the signers is honest and responsive this finishes, no matter what the others do. signers count
+// towards the threshold by their weight, i.e. by how many shards they hold.
+//
+// a sub-committee takes part like any other signer, but it is its members that send us commitments and partial
+// signatures: it is ready when a threshold of them have given us a commitment, and these are then put in an inner
+// round (see frost.SubSigner) whose results we add up into the commitment and the partial signature of the
+// sub-committee.
 func (kuc *GroupContext) SignEvent(ctx context.Context, event *nostr.Event) (err error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, signingTimeout, errSigningTimeout)
+	defer cancel()
+
 	log := log.With().Str("user", kuc.PubKey.Hex()).Logger()
 
 	ipk := make([]byte, 33)
@@ -47,31 +102,44 @@ func (kuc *GroupContext) SignEvent(ctx context.Context, event *nostr.Event) (err
 	copy(ipk[1:], kuc.PubKey[:])
 	pubkey, _ := btcec.ParseJacobian(ipk)
 
-	// signers that are online and that we have chosen to participate in this round
-	chosenSigners := make(map[nostr.PubKey]common.Signer, kuc.Threshold)
+	// prepare event to be signed so we have our msg hash
+	event.PubKey, _ = kuc.GetPublicKey(ctx)
+	msg := sha256.Sum256(event.Serialize())
+	event.ID = msg
+	jevt, _ := easyjson.Marshal(event)
 
-	cfg := &frost.Configuration{
-		Threshold:    int(kuc.Threshold),
-		MaxSigners:   len(kuc.Signers),
-		PublicKey:    &pubkey,
-		Participants: make([]int, 0, kuc.Threshold),
+	byReputation := func(a, b nostr.PubKey) int {
+		return getReputation(a).score() - getReputation(b).score()
 	}
 
-	// shuffle signers so we don't always use the same
-	shuffle(kuc.Signers)
-
-	// pick a threshold that is online
-	printPicked := make([]string, 0, cfg.Threshold)
+	// invite everybody that is online, and the sub-committees that have enough members online
+	invited := make(map[nostr.PubKey]common.Signer, len(kuc.Signers))
+	committeeOf := make(map[nostr.PubKey]nostr.PubKey)
 	printOnline := make([]string, 0, len(kuc.Signers))
 	printOffline := make([]string, 0, len(kuc.Signers))
 	for _, signer := range kuc.Signers {
+		if signer.Committee != nil {
+			online := make([]nostr.PubKey, 0, len(signer.Committee.Members))
+			for _, member := range signer.Committee.Members {
+				if _, isOnline := onlineSigners.Load(member.PeerPubKey); isOnline {
+					online = append(online, member.PeerPubKey)
+				} else {
+					printOffline = append(printOffline, member.PeerPubKey.Hex())
+				}
+			}
+			if len(online) >= signer.Committee.Threshold {
+				invited[signer.PeerPubKey] = signer
+				for _, member := range online {
+					committeeOf[member] = signer.PeerPubKey
+					printOnline = append(printOnline, member.Hex())
+				}
+			}
+			continue
+		}
+
 		if _, isOnline := onlineSigners.Load(signer.PeerPubKey); isOnline {
+			invited[signer.PeerPubKey] = signer
 			printOnline = append(printOnline, signer.PeerPubKey.Hex())
-			if len(chosenSigners) < cfg.Threshold {
-				chosenSigners[signer.PeerPubKey] = signer
-				cfg.Participants = append(cfg.Participants, signer.Shard.ID)
-				printPicked = append(printPicked, signer.PeerPubKey.Hex())
-			}
 		} else {
 			printOffline = append(printOffline, signer.PeerPubKey.Hex())
 		}
@@ -79,38 +147,106 @@ func (kuc *GroupContext) SignEvent(ctx context.Context, event *nostr.Event) (err
 	log.Info().
 		Strs("online", printOnline).
 		Strs("offline", printOffline).
-		Strs("picked", printPicked).
 		Msg("signer selection")
 
+	// how many shards these signers hold together
+	weightOf := func(signers iter.Seq[nostr.PubKey]) int {
+		weight := 0
+		for signer := range signers {
+			if signer, ok := invited[signer]; ok {
+				weight += signer.Weight()
+			}
+		}
+		return weight
+	}
+
 	// fail if we don't have enough online signers
-	if len(chosenSigners) < cfg.Threshold {
-		return fmt.Errorf("not enough signers online: have %d, needed %d, missing: %v", len(chosenSigners), cfg.Threshold, printOffline)
+	if weight := weightOf(maps.Keys(invited)); weight < kuc.Threshold {
+		return fmt.Errorf("not enough signers online: have %d, needed %d, missing: %v",
+			weight, kuc.Threshold, printOffline)
+	}
+
+	cfg := &frost.Configuration{
+		Threshold:    kuc.Threshold,
+		MaxSigners:   kuc.MaxSigners(),
+		PublicKey:    &pubkey,
+		Participants: make([]int, 0, len(invited)),
+		Method:       kuc.Method,
+	}
+	if kuc.Derived != nil {
+		cfg.Tweak = frost.DeriveTweak(&pubkey, kuc.Derived.Index)
+	}
+	for _, signer := range invited {
+		for _, shard := range signer.Shards() {
+			cfg.Participants = append(cfg.Participants, shard.ID)
+		}
+	}
+	slices.Sort(cfg.Participants)
+
+	// signers that are ready have given us a commitment for each of their shards (or, for sub-committees, a threshold
+	// of their members have given us one for their sub-shards) and aren't taking part in any session right now
+	ready := make([]nostr.PubKey, 0, len(invited))
+	commitments := make(map[nostr.PubKey]frost.CommitmentList, len(invited))
+	memberCommitments := make(map[nostr.PubKey]map[nostr.PubKey]frost.Commitment)
+	busy := make(map[nostr.PubKey]*roastSession, len(invited))
+	malicious := make(map[nostr.PubKey]struct{})
+	lostNonces := make(map[nostr.PubKey]struct{})
+	hasAllCommitments := func(signer nostr.PubKey) bool {
+		if committee := invited[signer].Committee; committee != nil {
+			return len(memberCommitments[signer]) >= committee.Threshold
+		}
+		return len(commitments[signer]) == invited[signer].Weight()
 	}
 
-	// step-1 (send): initialize each participant.
+	// those that have sent us commitments ahead of time are ready from the start, the best behaved first
+	for _, signer := range slices.SortedFunc(maps.Keys(invited), byReputation) {
+		if invited[signer].Committee != nil {
+			// members of sub-committees never do
+			continue
+		}
+		if signerCommitments, ok := takePreprocessed(kuc.PubKey, invited[signer]); ok {
+			commitments[signer] = signerCommitments
+			ready = append(ready, signer)
+		}
+	}
+
+	// step-1 (send): ask all the others for a commitment
 	//
-	// this should cause the signers to reply with their nonces commits and then with their signatures.
-	confEvt := nostr.Event{
+	// this should cause the signers to reply with their nonces commits, then with their signatures for each session
+	// they're put in, each time followed by a new commit.
+	inviteEvt := nostr.Event{
 		CreatedAt: nostr.Now(),
-		Kind:      common.KindConfiguration,
+		Kind:      common.KindRoastConfiguration,
 		Content:   cfg.Hex(),
-		Tags:      make(nostr.Tags, 0, len(chosenSigners)),
+		Tags:      make(nostr.Tags, 0, len(invited)),
+	}
+	for _, signer := range invited {
+		if !hasAllCommitments(signer.PeerPubKey) && signer.Committee == nil {
+			inviteEvt.Tags = append(inviteEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
+		}
 	}
-	for _, signer := range chosenSigners {
-		confEvt.Tags = append(confEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
+	for member := range committeeOf {
+		inviteEvt.Tags = append(inviteEvt.Tags, nostr.Tag{"p", member.Hex()})
 	}
-	confEvt.Sign(s.SecretKey)
-	relay.BroadcastEvent(confEvt)
+	inviteEvt.Sign(s.SecretKey)
 
-	// each signing session is identified by this initial event's id
-	sessionId := confEvt.ID
+	// the whole run is identified by the invite event id, and each session in it by the id of its own event
+	roastId := inviteEvt.ID
 	ch := make(chan nostr.Event)
 	session := &Session{
 		ch:            ch,
-		chosenSigners: chosenSigners,
+		done:          make(chan struct{}),
+		chosenSigners: maps.Clone(invited),
 		status:        "initializing",
 	}
-	signingSessions.Store(sessionId, session)
+	for member, committee := range committeeOf {
+		session.chosenSigners[member] = invited[committee]
+	}
+	signingSessions.Store(roastId, session)
+	sessions := make(map[nostr.ID]*roastSession)
+	if len(inviteEvt.Tags) > 0 {
+		relay.BroadcastEvent(inviteEvt)
+	}
 
 	defer func() {
 		// set status to error
@@ -118,187 +254,453 @@ func (kuc *GroupContext) SignEvent(ctx context.Context, event *nostr.Event) (err
 			session.status = err.Error()
 		}
 
+		// nobody will be reading from this session anymore
+		close(session.done)
+
 		// keep signing sessions for 5 minutes for debugging then delete them
 		go func() {
 			time.Sleep(time.Minute * 5)
-			signingSessions.Delete(sessionId)
+			signingSessions.Delete(roastId)
+			for id := range sessions {
+				signingSessions.Delete(id)
+			}
 		}()
 	}()
 
-	// prepare event to be signed so we have our msg hash
-	session.status = "prepare"
-	event.PubKey = kuc.PubKey
-	msg := sha256.Sum256(event.Serialize())
-	event.ID = msg
-
-	log = log.With().Str("session", sessionId.Hex()).Str("event", event.ID.Hex()).Logger()
-
+	log = log.With().Str("session", roastId.Hex()).Str("event", event.ID.Hex()).Logger()
 	log.Info().
-		Any("signers", slices.Collect(maps.Keys(chosenSigners))).
-		Msg("starting signing session")
-
-	// step-2 (receive): get all pre-commit nonces from signers
-	session.status = "nonces"
-	commitments := make(map[nostr.PubKey]frost.Commitment, len(chosenSigners))
-	missing := make(map[nostr.PubKey]struct{}, len(chosenSigners))
-	for pubkey := range chosenSigners {
-		missing[pubkey] = struct{}{}
+		Any("signers", slices.Collect(maps.Keys(invited))).
+		Int("preprocessed", len(ready)).
+		Msg("starting signing")
+
+	becomeReady := func(signer nostr.PubKey) {
+		if !hasAllCommitments(signer) {
+			return
+		}
+		if _, isBusy := busy[signer]; isBusy || slices.Contains(ready, signer) {
+			return
+		}
+		ready = append(ready, signer)
 	}
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("timeout receiving commits, missing: %v", slices.Collect(maps.Keys(missing)))
-		case evt := <-ch:
-			if evt.Kind != common.KindCommit {
-				return fmt.Errorf("got a kind %d instead of %d (commit) from %s",
-					evt.Kind, common.KindCommit, evt.PubKey)
-			}
 
-			if _, ok := chosenSigners[evt.PubKey]; !ok {
-				log.Warn().Str("pubkey", evt.PubKey.Hex()).Str("session", sessionId.Hex()).
-					Msg("got commit from unrelated signer")
-				continue
+	// a signer that misbehaves once is never trusted again in this run
+	markMalicious := func(signer nostr.PubKey, reason string, args ...any) {
+		malicious[signer] = struct{}{}
+		recordFault(misbehaved(signer, session.status, reason, args...))
+
+		// when it's a member of a sub-committee the session the sub-committee is in can't finish, but the other
+		// members may still be enough to sign for it
+		if committee, isMember := committeeOf[signer]; isMember {
+			delete(memberCommitments[committee], signer)
+			if rs, isBusy := busy[committee]; isBusy {
+				if _, inRound := rs.committees[committee].commitments[signer]; inRound {
+					delete(busy, committee)
+				}
 			}
-
-			commit := frost.Commitment{}
-			if err := commit.DecodeHex(evt.Content); err != nil {
-				return fmt.Errorf("failed to decode commit: %w", err)
+			if !hasAllCommitments(committee) {
+				ready = slices.DeleteFunc(ready, func(pk nostr.PubKey) bool { return pk == committee })
 			}
-			commitments[evt.PubKey] = commit
 
-			delete(missing, evt.PubKey)
+			honest := 0
+			for member, c := range committeeOf {
+				if _, isMalicious := malicious[member]; c == committee && !isMalicious {
+					honest++
+				}
+			}
+			if honest >= invited[committee].Committee.Threshold {
+				becomeReady(committee)
+				return
+			}
+			malicious[committee] = struct{}{}
+			signer = committee
 		}
 
-		if len(commitments) == len(chosenSigners) {
-			break
-		}
+		delete(busy, signer)
+		delete(commitments, signer)
+		ready = slices.DeleteFunc(ready, func(pk nostr.PubKey) bool { return pk == signer })
 	}
 
-	// prepare aggregated group commitment and finalNonce
-	session.status = "commit"
-	groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(
-		slices.Collect(maps.Values(commitments)),
-		msg[:],
-	)
+	// a sub-committee goes into a session with the first of its members that have given us a commitment, the best
+	// behaved first, in just the number needed
+	startRound := func(committee nostr.PubKey) (*committeeRound, frost.Commitment) {
+		signer := invited[committee]
+		members := slices.SortedFunc(maps.Keys(memberCommitments[committee]), byReputation)
+		members = members[0:signer.Committee.Threshold]
 
-	// step-3 (send): group commits and send the result to signers
-	groupCommitEvt := nostr.Event{
-		CreatedAt: nostr.Now(),
-		Kind:      common.KindGroupCommit,
-		Content:   groupCommitment.Hex(),
-		Tags:      make(nostr.Tags, 0, 1+len(chosenSigners)),
-	}
-	groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"e", sessionId.Hex()})
-	for _, signer := range chosenSigners {
-		groupCommitEvt.Tags = append(groupCommitEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
-	}
-	groupCommitEvt.Sign(s.SecretKey)
-	relay.BroadcastEvent(groupCommitEvt)
+		round := &committeeRound{
+			commitments: make(map[nostr.PubKey]frost.Commitment, len(members)),
+			partialSigs: make(map[int]frost.PartialSignature, len(members)),
+		}
+		ids := make([]int, 0, len(members))
+		for _, member := range members {
+			round.commitments[member] = memberCommitments[committee][member]
+			ids = append(ids, round.commitments[member].SignerID)
+			delete(memberCommitments[committee], member)
+		}
+		slices.Sort(ids)
+		round.cfg = signer.Configuration(ids)
 
-	// step-4 (send): send event to be signed
-	session.status = "event"
-	jevt, _ := easyjson.Marshal(event)
-	evtEvt := nostr.Event{
-		CreatedAt: nostr.Now(),
-		Kind:      common.KindEventToBeSigned,
-		Content:   string(jevt),
-		Tags:      make(nostr.Tags, 0, 1+len(chosenSigners)),
-	}
-	evtEvt.Tags = append(evtEvt.Tags, nostr.Tag{"e", sessionId.Hex()})
-	for _, signer := range chosenSigners {
-		evtEvt.Tags = append(evtEvt.Tags, nostr.Tag{"p", signer.PeerPubKey.Hex()})
-	}
-	evtEvt.Sign(s.SecretKey)
-	relay.BroadcastEvent(evtEvt)
-
-	// step-5 (receive): get partial signature from each participant
-	session.status = "partialsigs"
-	partialSigs := make([]frost.PartialSignature, 0, len(chosenSigners))
-	missing = make(map[nostr.PubKey]struct{}, len(chosenSigners))
-	for pubkey := range chosenSigners {
-		missing[pubkey] = struct{}{}
+		return round, frost.AggregateSubCommitments(signer.Shard.ID, slices.Collect(maps.Values(round.commitments)))
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("timeout receiving partial signatures, missing: %v", slices.Collect(maps.Keys(missing)))
-		case evt := <-ch:
-			if evt.Kind != common.KindPartialSignature {
-				return fmt.Errorf("got a kind %d instead of %d (partial sig) from %s",
-					evt.Kind, common.KindPartialSignature, evt.PubKey)
+	startSessions := func() {
+		for weightOf(slices.Values(ready)) >= cfg.Threshold {
+			// the first ones until their weights add up to the threshold (or a bit more, which is fine)
+			n, weight := 0, 0
+			for weight < cfg.Threshold {
+				weight += invited[ready[n]].Weight()
+				n++
 			}
-
-			if _, ok := chosenSigners[evt.PubKey]; !ok {
-				log.Warn().Str("pubkey", evt.PubKey.Hex()).Str("session", sessionId.Hex()).
-					Msg("got partial signature from unrelated signer")
-				continue
+			members := ready[0:n]
+			ready = slices.Clone(ready[n:])
+
+			rs := &roastSession{
+				cfg: &frost.Configuration{
+					Threshold:    cfg.Threshold,
+					MaxSigners:   cfg.MaxSigners,
+					PublicKey:    cfg.PublicKey,
+					Participants: make([]int, 0, weight),
+					Tweak:        cfg.Tweak,
+					Method:       cfg.Method,
+				},
+				signers:     make(map[nostr.PubKey]common.Signer, len(members)),
+				commitments: make(map[int]frost.Commitment, weight),
+				partialSigs: make(map[int]frost.PartialSignature, weight),
+				committees:  make(map[nostr.PubKey]*committeeRound),
 			}
-
-			partialSig := frost.PartialSignature{}
-			if err := partialSig.DecodeHex(evt.Content); err != nil {
-				return fmt.Errorf("failed to decode partial signature from %s", evt.PubKey)
+			commitmentList := make(frost.CommitmentList, 0, weight)
+			for _, signer := range members {
+				rs.signers[signer] = invited[signer]
+				if invited[signer].Committee != nil {
+					round, commitment := startRound(signer)
+					rs.committees[signer] = round
+					commitments[signer] = frost.CommitmentList{commitment}
+				}
+				for _, commitment := range commitments[signer] {
+					rs.commitments[commitment.SignerID] = commitment
+					commitmentList = append(commitmentList, commitment)
+				}
+				delete(commitments, signer)
+				busy[signer] = rs
+			}
+			slices.SortFunc(commitmentList, func(a, b frost.Commitment) int { return a.SignerID - b.SignerID })
+			for _, commitment := range commitmentList {
+				rs.cfg.Participants = append(rs.cfg.Participants, commitment.SignerID)
 			}
 
-			lambdaRegistryLock.Lock()
-			if err := cfg.VerifyPartialSignature(
-				chosenSigners[evt.PubKey].Shard,
-				commitments[evt.PubKey].BinoncePublic,
-				bindingCoefficient,
-				finalNonce,
-				partialSig,
-				msg[:],
-				lambdaRegistry,
-			); err != nil {
-				lambdaRegistryLock.Unlock()
-				return fmt.Errorf("partial signature from signer %s isn't good: %w", evt.PubKey, err)
+			// step-2 (send): have these sign with the commitments they gave us
+			sessionEvt := nostr.Event{
+				CreatedAt: nostr.Now(),
+				Kind:      common.KindPreprocessedConfiguration,
+				Content:   string(jevt),
+				Tags:      make(nostr.Tags, 0, 3+len(members)),
+			}
+			sessionEvt.Tags = append(sessionEvt.Tags,
+				nostr.Tag{"e", roastId.Hex()},
+				nostr.Tag{"config", rs.cfg.Hex()},
+				nostr.Tag{"commitments", commitmentList.Hex()},
+			)
+			for _, signer := range members {
+				round, isCommittee := rs.committees[signer]
+				if !isCommittee {
+					sessionEvt.Tags = append(sessionEvt.Tags, nostr.Tag{"p", signer.Hex()})
+					continue
+				}
+
+				// the members of a sub-committee get what they need for their inner round
+				memberCommitmentList := slices.SortedFunc(maps.Values(round.commitments), func(a, b frost.Commitment) int {
+					return a.SignerID - b.SignerID
+				})
+				sessionEvt.Tags = append(sessionEvt.Tags, nostr.Tag{
+					"committee",
+					strconv.Itoa(rs.signers[signer].Shard.ID),
+					round.cfg.Hex(),
+					frost.CommitmentList(memberCommitmentList).Hex(),
+				})
+				for member := range round.commitments {
+					sessionEvt.Tags = append(sessionEvt.Tags, nostr.Tag{"p", member.Hex()})
+				}
 			}
-			partialSigs = append(partialSigs, partialSig)
+			sessionEvt.Sign(s.SecretKey)
 
-			log.Info().
-				Int("count", len(partialSigs)).
-				Int("need", len(chosenSigners)).
-				Str("signer", evt.PubKey.Hex()).
-				Msg("got good partial signature")
+			// prepare aggregated group commitment and finalNonce
+			rs.groupCommitment, rs.bindingCoefficient, rs.finalNonce = rs.cfg.ComputeGroupCommitment(
+				slices.Collect(maps.Values(rs.commitments)),
+				msg[:],
+			)
 
-			if len(partialSigs) == len(chosenSigners) {
-				lambdaRegistryLock.Unlock()
-				goto aggregate
-			}
+			rs.id = sessionEvt.ID
+			sessions[rs.id] = rs
+			signingSessions.Store(rs.id, session)
+			relay.BroadcastEvent(sessionEvt)
 
-			// reuse this lock to protect our signature counting
-			lambdaRegistryLock.Unlock()
+			log.Info().Str("id", rs.id.Hex()).Any("signers", members).Msg("started session")
 		}
 	}
 
-aggregate:
-	// aggregate signature
-	session.status = "aggregating"
-	log.Info().Msg("aggregating")
-	sig, err := cfg.AggregateSignatures(finalNonce, partialSigs)
-	if err != nil {
-		return fmt.Errorf("failed to aggregate signatures: %w", err)
-	}
+	session.status = "signing"
+	startSessions()
+	for {
+		if weightOf(maps.Keys(invited))-weightOf(maps.Keys(malicious)) < cfg.Threshold {
+			return fmt.Errorf("not enough honest signers left, %d misbehaved", len(malicious))
+		}
+
+		select {
+		case <-ctx.Done():
+			stuck := make(map[nostr.PubKey]struct{}, len(busy))
+			for signer, rs := range busy {
+				round, isCommittee := rs.committees[signer]
+				if !isCommittee {
+					stuck[signer] = struct{}{}
+					continue
+				}
+				// with sub-committees it's the members that haven't signed that are to blame
+				for member, commitment := range round.commitments {
+					if _, signed := round.partialSigs[commitment.SignerID]; !signed {
+						stuck[member] = struct{}{}
+					}
+				}
+			}
+			err := timedOut(ctx, session.status, stuck)
+			var abort abortError
+			if errors.As(err, &abort) {
+				for _, fault := range abort.faults {
+					recordFault(fault)
+				}
+			}
+			return err
+		case evt := <-ch:
+			if _, isMalicious := malicious[evt.PubKey]; isMalicious {
+				continue
+			}
 
-	event.Sig = [64]byte(sig.Serialize())
-	session.status = "done"
-	return nil
+			committee, isMember := committeeOf[evt.PubKey]
+
+			switch evt.Kind {
+			case common.KindCommit:
+				// step-1 (receive): the signer is ready for a session, or will be when it's done with the current,
+				// once we have a commit for each of its shards
+				if !isMember && hasAllCommitments(evt.PubKey) {
+					continue
+				}
+
+				commit := frost.Commitment{}
+				if err := commit.DecodeHex(evt.Content); err != nil {
+					markMalicious(evt.PubKey, "failed to decode commit: %s", err)
+					continue
+				}
+
+				// members of a sub-committee commit with their sub-shards, we keep all of these, so there are
+				// always enough for the next session of the sub-committee
+				if isMember {
+					member, _ := invited[committee].Committee.Member(evt.PubKey)
+					if commit.SignerID != member.Shard.ID {
+						markMalicious(evt.PubKey, "sent a commit for %d, expected %d", commit.SignerID, member.Shard.ID)
+						continue
+					}
+					if _, alreadyHave := memberCommitments[committee][evt.PubKey]; alreadyHave {
+						continue
+					}
+					if memberCommitments[committee] == nil {
+						memberCommitments[committee] = make(map[nostr.PubKey]frost.Commitment)
+					}
+					memberCommitments[committee][evt.PubKey] = commit
+					becomeReady(committee)
+					break
+				}
+
+				if _, ok := invited[evt.PubKey].ShardByID(commit.SignerID); !ok {
+					markMalicious(evt.PubKey, "sent a commit for %d, expected %d",
+						commit.SignerID, invited[evt.PubKey].Shard.ID)
+					continue
+				}
+				if slices.ContainsFunc(commitments[evt.PubKey], func(c frost.Commitment) bool {
+					return c.SignerID == commit.SignerID
+				}) {
+					continue
+				}
+
+				commitments[evt.PubKey] = append(commitments[evt.PubKey], commit)
+				becomeReady(evt.PubKey)
+			case common.KindNonceBatch:
+				// the signer didn't have the nonces for a commitment it had sent us ahead of time anymore, so the
+				// session it's in can't finish. it has sent new ones along though, so it can be ready again. that
+				// happens after a restart, but a signer doing it again is just keeping sessions from finishing
+				if _, isBusy := busy[evt.PubKey]; isBusy {
+					if _, already := lostNonces[evt.PubKey]; already {
+						markMalicious(evt.PubKey, "lost its nonces again")
+						continue
+					}
+					lostNonces[evt.PubKey] = struct{}{}
+				}
+				delete(busy, evt.PubKey)
+				if !hasAllCommitments(evt.PubKey) {
+					if signerCommitments, ok := takePreprocessed(kuc.PubKey, invited[evt.PubKey]); ok {
+						commitments[evt.PubKey] = signerCommitments
+					}
+				}
+				becomeReady(evt.PubKey)
+			case common.KindPartialSignature:
+				// step-2 (receive): get partial signatures for the session the signer is in
+				signer := evt.PubKey
+				if isMember {
+					signer = committee
+				}
+
+				eTag := evt.Tags.Find("e")
+				id, _ := nostr.IDFromHex(eTag[1])
+				rs, ok := sessions[id]
+				if !ok || busy[signer] != rs {
+					continue
+				}
+
+				partialSig := frost.PartialSignature{}
+				if err := partialSig.DecodeHex(evt.Content); err != nil {
+					markMalicious(evt.PubKey, "failed to decode partial signature: %s", err)
+					continue
+				}
+
+				if isMember {
+					// members of a sub-committee sign in its inner round, once they all have we have the partial
+					// signature of the sub-committee, which is good if theirs are
+					round := rs.committees[committee]
+					commit, inRound := round.commitments[evt.PubKey]
+					if !inRound {
+						continue
+					}
+					member, _ := invited[committee].Committee.Member(evt.PubKey)
+					if partialSig.SignerIdentifier != member.Shard.ID {
+						markMalicious(evt.PubKey, "sent a partial signature for %d, which isn't theirs",
+							partialSig.SignerIdentifier)
+						continue
+					}
+					if _, alreadyHave := round.partialSigs[partialSig.SignerIdentifier]; alreadyHave {
+						continue
+					}
+
+					err := rs.cfg.VerifySubPartialSignature(
+						rs.signers[committee].Shard,
+						round.cfg,
+						member.Shard,
+						commit.BinoncePublic,
+						rs.groupCommitment,
+						rs.bindingCoefficient,
+						partialSig,
+						msg[:],
+						lambdaRegistry,
+					)
+					if err != nil {
+						markMalicious(evt.PubKey, "partial signature isn't good: %s", err)
+						continue
+					}
+
+					round.partialSigs[partialSig.SignerIdentifier] = partialSig
+					log.Info().
+						Str("id", rs.id.Hex()).
+						Int("count", len(round.partialSigs)).
+						Int("need", len(round.commitments)).
+						Str("member", evt.PubKey.Hex()).
+						Str("committee", committee.Hex()).
+						Msg("got good partial signature from sub-committee member")
+					if len(round.partialSigs) < len(round.commitments) {
+						continue
+					}
+
+					partialSig, err = frost.AggregateSubSignatures(rs.signers[committee].Shard.ID,
+						slices.Collect(maps.Values(round.partialSigs)))
+					if err != nil {
+						return fmt.Errorf("failed to aggregate sub-committee signatures: %w", err)
+					}
+				} else {
+					// weighted signers send one for each of their shards
+					shard, isTheirs := rs.signers[signer].ShardByID(partialSig.SignerIdentifier)
+					if !isTheirs {
+						markMalicious(evt.PubKey, "sent a partial signature for %d, which isn't theirs",
+							partialSig.SignerIdentifier)
+						continue
+					}
+					if _, alreadyHave := rs.partialSigs[partialSig.SignerIdentifier]; alreadyHave {
+						continue
+					}
+
+					err := rs.cfg.VerifyPartialSignature(
+						shard,
+						rs.commitments[partialSig.SignerIdentifier].BinoncePublic,
+						rs.bindingCoefficient,
+						rs.finalNonce,
+						partialSig,
+						msg[:],
+						lambdaRegistry,
+					)
+					if err != nil {
+						markMalicious(evt.PubKey, "partial signature isn't good: %s", err)
+						continue
+					}
+				}
+
+				rs.partialSigs[partialSig.SignerIdentifier] = partialSig
+				if !slices.ContainsFunc(rs.signers[signer].Shards(), func(shard frost.PublicKeyShard) bool {
+					_, has := rs.partialSigs[shard.ID]
+					return !has
+				}) {
+					delete(busy, signer)
+					becomeReady(signer)
+				}
+
+				log.Info().
+					Str("id", rs.id.Hex()).
+					Int("count", len(rs.partialSigs)).
+					Int("need", len(rs.commitments)).
+					Str("signer", signer.Hex()).
+					Msg("got good partial signature")
+
+				if len(rs.partialSigs) == len(rs.commitments) {
+					// aggregate signature
+					session.status = "aggregating"
+					log.Info().Str("id", rs.id.Hex()).Msg("aggregating")
+					sig, err := rs.cfg.AggregateSignatures(rs.finalNonce, slices.Collect(maps.Values(rs.partialSigs)))
+					if err != nil {
+						return fmt.Errorf("failed to aggregate signatures: %w", err)
+					}
+
+					event.Sig = [64]byte(sig.Serialize())
+					session.status = "done"
+					return nil
+				}
+			default:
+				markMalicious(evt.PubKey, "sent an unexpected kind %d", evt.Kind)
+				continue
+			}
+
+			startSessions()
+		}
+	}
 }
 
+// Encrypt uses NIP-44, with a conversation key we get from the signers by threshold ecdh.
 func (kuc *GroupContext) Encrypt(
 	ctx context.Context,
 	plaintext string,
 	recipientPublicKey nostr.PubKey,
 ) (base64ciphertext string, err error) {
-	return "", fmt.Errorf("not implemented")
+	ck, err := kuc.conversationKey(ctx, recipientPublicKey)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Encrypt(plaintext, ck)
 }
 
+// Decrypt uses NIP-44, just like Encrypt.
 func (kuc *GroupContext) Decrypt(
 	ctx context.Context,
 	base64ciphertext string,
 	senderPublicKey nostr.PubKey,
 ) (plaintext string, err error) {
-	return "", fmt.Errorf("not implemented")
+	ck, err := kuc.conversationKey(ctx, senderPublicKey)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Decrypt(base64ciphertext, ck)
 }
 
 func handleSignerStuff(ctx context.Context, evt nostr.Event) {
@@ -313,7 +715,10 @@ func handleSignerStuff(ctx context.Context, evt nostr.Event) {
 
 	if session, ok := signingSessions.Load(sessionId); ok {
 		if _, ok := session.chosenSigners[evt.PubKey]; ok {
-			session.ch <- evt
+			select {
+			case session.ch <- evt:
+			case <-session.done:
+			}
 		}
 	}
 }
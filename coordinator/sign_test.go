@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/eventstore/slicestore"
+	"fiatjaf.com/nostr/khatru"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// startCoordinator runs the relay with all its hooks on a test server, with an empty database.
+func startCoordinator(t *testing.T) string {
+	s.SecretKey = nostr.Generate()
+	db = &slicestore.SliceStore{}
+	db.Init()
+	relay = khatru.NewRelay()
+	setupRelay()
+
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+	return "ws" + server.URL[len("http"):]
+}
+
+// testSigner does what a signer does in the signing flow, either with a shard of its own or, as a member of a
+// sub-committee, with a sub-shard.
+type testSigner struct {
+	sk     nostr.SecretKey
+	shard  frost.KeyShard
+	sub    *frost.SubShard
+	nonces *frost.NoncePool
+	conn   *nostr.Relay
+
+	// instead of signing it says it lost its nonces and sends a new batch, every time
+	losesNonces bool
+}
+
+func newTestSigner(shard frost.KeyShard, sub *frost.SubShard) *testSigner {
+	if sub != nil {
+		shard = sub.KeyShard
+	}
+	return &testSigner{
+		sk:     nostr.Generate(),
+		shard:  shard,
+		sub:    sub,
+		nonces: frost.NewNoncePool(10),
+	}
+}
+
+// subscribe authenticates and asks for the signing flow events like a signer does, it gives the reason if the
+// coordinator refuses it.
+func (ts *testSigner) subscribe(t *testing.T, url string) (*nostr.Subscription, string) {
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second*5)
+	defer cancel()
+
+	var err error
+	ts.conn, err = nostr.RelayConnect(t.Context(), url, nostr.RelayOptions{})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { ts.conn.Close() })
+
+	filter := nostr.Filter{
+		Kinds: []nostr.Kind{
+			common.KindConfiguration,
+			common.KindGroupCommit,
+			common.KindEventToBeSigned,
+			common.KindPreprocessedConfiguration,
+			common.KindRoastConfiguration,
+		},
+		Tags: nostr.TagMap{"p": []string{ts.sk.Public().Hex()}},
+	}
+
+	// we are told to authenticate first
+	sub, err := ts.conn.Subscribe(ctx, filter, nostr.SubscriptionOptions{})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	select {
+	case reason := <-sub.ClosedReason:
+		if !strings.HasPrefix(reason, "auth-required:") {
+			t.Fatalf("expected to be asked to authenticate, got %q", reason)
+		}
+	case <-ctx.Done():
+		t.Fatal("wasn't asked to authenticate")
+	}
+	if err := ts.conn.Auth(ctx, func(ctx context.Context, evt *nostr.Event) error {
+		return evt.Sign(ts.sk)
+	}); err != nil {
+		t.Fatalf("failed to authenticate: %v", err)
+	}
+
+	sub, err = ts.conn.Subscribe(t.Context(), filter, nostr.SubscriptionOptions{})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	select {
+	case <-sub.EndOfStoredEvents:
+		return sub, ""
+	case reason := <-sub.ClosedReason:
+		return nil, reason
+	case <-ctx.Done():
+		t.Fatal("subscription got no answer")
+		return nil, ""
+	}
+}
+
+func (ts *testSigner) publish(ctx context.Context, sessionId nostr.ID, kind nostr.Kind, content string, tags ...nostr.Tag) error {
+	evt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      kind,
+		Content:   content,
+		Tags:      append(nostr.Tags{{"e", sessionId.Hex()}}, tags...),
+	}
+	if err := evt.Sign(ts.sk); err != nil {
+		return err
+	}
+	return ts.conn.Publish(ctx, evt)
+}
+
+// commit sends a fresh commitment for the given run.
+func (ts *testSigner) commit(ctx context.Context, roastId nostr.ID) error {
+	commitment := ts.nonces.Generate(ts.shard, roastId.Hex()+"/"+strconv.Itoa(ts.nonces.Len()), 1)[0]
+	return ts.publish(ctx, roastId, common.KindCommit, commitment.Hex())
+}
+
+// sendNonceBatch is what a signer does when it was put in a session with nonces it doesn't have anymore.
+func (ts *testSigner) sendNonceBatch(ctx context.Context, sessionId nostr.ID) error {
+	batch := ts.nonces.Generate(ts.shard, sessionId.Hex(), 5)
+	account := nostr.PubKey(*ts.shard.PublicKey.X.Bytes())
+	return ts.publish(ctx, sessionId, common.KindNonceBatch, batch.Hex(), nostr.Tag{"P", account.Hex()})
+}
+
+// sign answers a session with our partial signature and then a fresh commitment, like signers do.
+func (ts *testSigner) sign(ctx context.Context, evt nostr.Event) error {
+	cfg := frost.Configuration{}
+	if err := cfg.DecodeHex(evt.Tags.Find("config")[1]); err != nil {
+		return err
+	}
+	commitments := frost.CommitmentList{}
+	if err := commitments.DecodeHex(evt.Tags.Find("commitments")[1]); err != nil {
+		return err
+	}
+	toSign := nostr.Event{}
+	if err := toSign.UnmarshalJSON([]byte(evt.Content)); err != nil {
+		return err
+	}
+
+	var signer *frost.Signer
+	var commitment frost.Commitment
+	if ts.sub != nil {
+		tag := evt.Tags.FindWithValue("committee", strconv.Itoa(ts.sub.Parent.ID))
+		committee := frost.Configuration{}
+		if err := committee.DecodeHex(tag[2]); err != nil {
+			return err
+		}
+		memberCommitments := frost.CommitmentList{}
+		if err := memberCommitments.DecodeHex(tag[3]); err != nil {
+			return err
+		}
+		idx := slices.IndexFunc(memberCommitments, func(c frost.Commitment) bool { return c.SignerID == ts.sub.ID })
+		commitment = memberCommitments[idx]
+
+		var err error
+		if signer, err = cfg.SubSigner(*ts.sub, &committee, lambdaRegistry); err != nil {
+			return err
+		}
+	} else {
+		idx := slices.IndexFunc(commitments, func(c frost.Commitment) bool { return c.SignerID == ts.shard.ID })
+		commitment = commitments[idx]
+
+		var err error
+		if signer, err = cfg.Signer(ts.shard, lambdaRegistry); err != nil {
+			return err
+		}
+	}
+	if err := signer.UsePreprocessed(ts.nonces, commitment); err != nil {
+		return err
+	}
+
+	groupCommitment, _, _ := cfg.ComputeGroupCommitment(commitments, toSign.ID[:])
+	partialSig, err := signer.Sign(toSign.ID[:], groupCommitment)
+	if err != nil {
+		return err
+	}
+	if err := ts.publish(ctx, evt.ID, common.KindPartialSignature, partialSig.Hex()); err != nil {
+		return err
+	}
+
+	roastId, _ := nostr.IDFromHex(evt.Tags.Find("e")[1])
+	return ts.commit(ctx, roastId)
+}
+
+// run answers everything the coordinator sends us until the test is over.
+func (ts *testSigner) run(t *testing.T, sub *nostr.Subscription) {
+	for evt := range sub.Events {
+		var err error
+		switch evt.Kind {
+		case common.KindRoastConfiguration:
+			err = ts.commit(t.Context(), evt.ID)
+		case common.KindPreprocessedConfiguration:
+			if ts.losesNonces {
+				err = ts.sendNonceBatch(t.Context(), evt.ID)
+			} else {
+				err = ts.sign(t.Context(), evt)
+			}
+		}
+		if err != nil && t.Context().Err() == nil {
+			t.Errorf("signer %s failed to answer k:%d: %v", ts.sk.Public().Hex(), evt.Kind, err)
+		}
+	}
+}
+
+// dealAccount makes a key for an account and deals it to maxSigners shards, threshold of which can sign.
+func dealAccount(threshold, maxSigners int) (nostr.SecretKey, []frost.KeyShard) {
+	accountSk := nostr.Generate()
+	secret := new(btcec.ModNScalar)
+	secret.SetByteSlice(accountSk[:])
+	shards, _, _ := frost.TrustedKeyDeal(secret, threshold, maxSigners)
+	return accountSk, shards
+}
+
+// register stores the registration of an account, as it would have come to us, and gives what we sign for it with.
+func register(t *testing.T, accountSk nostr.SecretKey, threshold int, signers []common.Signer) *GroupContext {
+	ar := common.AccountRegistration{
+		PubKey:        accountSk.Public(),
+		HandlerSecret: nostr.Generate(),
+		Threshold:     threshold,
+		Signers:       signers,
+	}
+	evt := ar.Encode()
+	if err := evt.Sign(accountSk); err != nil {
+		t.Fatalf("failed to sign registration: %v", err)
+	}
+	if reject, msg := filterOutEverythingExceptWhatWeWant(t.Context(), evt); reject {
+		t.Fatalf("registration refused: %s", msg)
+	}
+	if err := db.SaveEvent(evt); err != nil {
+		t.Fatalf("failed to save registration: %v", err)
+	}
+
+	kuc := &GroupContext{}
+	if err := kuc.Decode(evt); err != nil {
+		t.Fatalf("failed to decode registration: %v", err)
+	}
+	return kuc
+}
+
+func TestCommitteeMemberSigns(t *testing.T) {
+	url := startCoordinator(t)
+
+	// a signer and a 2-of-3 sub-committee
+	accountSk, shards := dealAccount(2, 2)
+	subShards, err := frost.SplitKeyShard(shards[1], 2, 3)
+	if err != nil {
+		t.Fatalf("failed to split shard: %v", err)
+	}
+	plain := newTestSigner(shards[0], nil)
+	members := make([]*testSigner, len(subShards))
+	committee := &common.Committee{Threshold: 2}
+	for i := range subShards {
+		members[i] = newTestSigner(frost.KeyShard{}, &subShards[i])
+		committee.Members = append(committee.Members, common.CommitteeMember{
+			PeerPubKey: members[i].sk.Public(),
+			Shard:      subShards[i].PublicKeyShard,
+		})
+	}
+	kuc := register(t, accountSk, 2, []common.Signer{
+		{PeerPubKey: plain.sk.Public(), Shard: shards[0].PublicKeyShard},
+		{Shard: shards[1].PublicKeyShard, Committee: committee},
+	})
+
+	// whoever isn't in the registration can't follow the signing flow
+	if _, reason := newTestSigner(frost.KeyShard{}, nil).subscribe(t, url); !strings.HasPrefix(reason, "restricted:") {
+		t.Fatalf("stranger wasn't refused, got %q", reason)
+	}
+
+	// the signer and two of the members of the sub-committee are online, which is enough
+	for _, ts := range []*testSigner{plain, members[0], members[1]} {
+		sub, reason := ts.subscribe(t, url)
+		if sub == nil {
+			t.Fatalf("signer %s was refused: %s", ts.sk.Public().Hex(), reason)
+		}
+		go ts.run(t, sub)
+	}
+
+	event := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      1,
+		Content:   "signed by a sub-committee too",
+	}
+	if err := kuc.SignEvent(t.Context(), &event); err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if !event.VerifySignature() {
+		t.Fatal("signature is not valid")
+	}
+}
+
+func TestSignerLosingNoncesAgainIsMalicious(t *testing.T) {
+	url := startCoordinator(t)
+
+	accountSk, shards := dealAccount(2, 3)
+	testSigners := make([]*testSigner, len(shards))
+	signers := make([]common.Signer, len(shards))
+	for i, shard := range shards {
+		testSigners[i] = newTestSigner(shard, nil)
+		signers[i] = common.Signer{PeerPubKey: testSigners[i].sk.Public(), Shard: shard.PublicKeyShard}
+	}
+	kuc := register(t, accountSk, 2, signers)
+
+	// this one gets out of every session it is put in, and the only other signer online is stuck in all of them
+	bad := testSigners[0]
+	bad.losesNonces = true
+	for _, ts := range testSigners[0:2] {
+		sub, reason := ts.subscribe(t, url)
+		if sub == nil {
+			t.Fatalf("signer %s was refused: %s", ts.sk.Public().Hex(), reason)
+		}
+		go ts.run(t, sub)
+	}
+
+	// the first time is fine, the second time it is taken out, so we can tell right away that this won't work
+	// instead of waiting until we time out
+	event := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      1,
+		Content:   "never signed",
+	}
+	err := kuc.SignEvent(t.Context(), &event)
+	if err == nil || !strings.Contains(err.Error(), "not enough honest signers left") {
+		t.Fatalf("expected signing to fail because of the signer, got %v", err)
+	}
+	if rep := getReputation(bad.sk.Public()); rep.Misbehaved != 1 {
+		t.Fatalf("signer that kept losing its nonces was blamed %d times", rep.Misbehaved)
+	}
+}
+
+func TestRegistrationWithRepeatedShardIsRefused(t *testing.T) {
+	startCoordinator(t)
+
+	accountSk, shards := dealAccount(2, 3)
+	for name, signers := range map[string][]common.Signer{
+		"held twice by the same signer": {
+			{PeerPubKey: nostr.Generate().Public(), Shard: shards[0].PublicKeyShard,
+				ExtraShards: []frost.PublicKeyShard{shards[1].PublicKeyShard, shards[0].PublicKeyShard}},
+		},
+		"held by two signers": {
+			{PeerPubKey: nostr.Generate().Public(), Shard: shards[0].PublicKeyShard},
+			{PeerPubKey: nostr.Generate().Public(), Shard: shards[1].PublicKeyShard,
+				ExtraShards: []frost.PublicKeyShard{shards[0].PublicKeyShard}},
+		},
+	} {
+		ar := common.AccountRegistration{
+			PubKey:        accountSk.Public(),
+			HandlerSecret: nostr.Generate(),
+			Threshold:     2,
+			Signers:       signers,
+		}
+		evt := ar.Encode()
+		if err := evt.Sign(accountSk); err != nil {
+			t.Fatalf("failed to sign registration: %v", err)
+		}
+
+		if err := (&common.AccountRegistration{}).Decode(evt); err == nil || !strings.Contains(err.Error(), "appears twice") {
+			t.Fatalf("shard %s: decoded, got %v", name, err)
+		}
+		if reject, _ := filterOutEverythingExceptWhatWeWant(t.Context(), evt); !reject {
+			t.Fatalf("shard %s: registration accepted", name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package frost
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// An adaptor signature (or pre-signature) is a signature made with the nonce R' = R + T, where T = t * G is the
+// Adaptor point of the configuration, and without t: s' = r + c * x. it doesn't verify by itself, but anyone who
+// knows t can complete it into a valid signature, s = s' + t, and then anyone who sees both can extract t = s - s'.
+// this is what makes atomic swaps and DLCs work: the signature is only published when the secret is revealed.
+//
+// the signers do nothing different, they just sign for R' instead of R (and negate their nonces if R' has an odd y,
+// in which case it's -t that completes the pre-signature). the aggregated pre-signature carries x(R').
+
+// VerifyAdaptorSignature checks that preSignature will be a valid signature of message once completed with the
+// secret of the Adaptor point. negated says if it must be completed with -t instead of t (CompleteAdaptorSignature
+// and ExtractAdaptorSecret figure that out by themselves).
+func (c *Configuration) VerifyAdaptorSignature(
+	preSignature *schnorr.Signature,
+	message []byte,
+) (negated bool, err error) {
+	if c.Adaptor == nil {
+		return false, fmt.Errorf("configuration has no adaptor")
+	}
+
+	finalNonce, s, err := splitSignature(preSignature)
+	if err != nil {
+		return false, err
+	}
+
+	signingKey, _ := c.TweakedPublicKey()
+	challenge := chainhash.TaggedHash(chainhash.TagBIP0340Challenge,
+		finalNonce.X.Bytes()[:],
+		signingKey.X.Bytes()[:],
+		message,
+	)
+	e := new(btcec.ModNScalar)
+	e.SetBytes((*[32]byte)(challenge))
+
+	// R' + c * X - s' * G should be T (or -T if R' had to be negated)
+	point := new(btcec.JacobianPoint)
+	btcec.ScalarMultNonConst(e, signingKey, point)
+	btcec.AddNonConst(point, finalNonce, point)
+	sG := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(s.Negate(), sG)
+	btcec.AddNonConst(point, sG, point)
+	point.ToAffine()
+
+	adaptor := new(btcec.JacobianPoint)
+	adaptor.Set(c.Adaptor)
+	adaptor.ToAffine()
+	if !point.X.Equals(&adaptor.X) {
+		return false, fmt.Errorf("invalid adaptor signature")
+	}
+	return !point.Y.Equals(&adaptor.Y), nil
+}
+
+// CompleteAdaptorSignature turns preSignature into a valid signature of message with secret, the discrete log of
+// the Adaptor point.
+func (c *Configuration) CompleteAdaptorSignature(
+	preSignature *schnorr.Signature,
+	secret *btcec.ModNScalar,
+	message []byte,
+) (*schnorr.Signature, error) {
+	if err := c.checkAdaptorSecret(secret); err != nil {
+		return nil, err
+	}
+
+	negated, err := c.VerifyAdaptorSignature(preSignature, message)
+	if err != nil {
+		return nil, err
+	}
+
+	finalNonce, s, _ := splitSignature(preSignature)
+	t := new(btcec.ModNScalar).Set(secret)
+	if negated {
+		t.Negate()
+	}
+	s.Add(t)
+	t.Zero()
+
+	return schnorr.NewSignature(&finalNonce.X, s), nil
+}
+
+// ExtractAdaptorSecret gets the discrete log of the Adaptor point from preSignature and the signature that was
+// made from it with CompleteAdaptorSignature.
+func (c *Configuration) ExtractAdaptorSecret(
+	preSignature *schnorr.Signature,
+	signature *schnorr.Signature,
+	message []byte,
+) (*btcec.ModNScalar, error) {
+	negated, err := c.VerifyAdaptorSignature(preSignature, message)
+	if err != nil {
+		return nil, err
+	}
+
+	preNonce, preS, _ := splitSignature(preSignature)
+	finalNonce, s, err := splitSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	if !preNonce.X.Equals(&finalNonce.X) {
+		return nil, fmt.Errorf("signature has a different nonce than the pre-signature")
+	}
+
+	// t = s - s'
+	secret := s.Add(preS.Negate())
+	if negated {
+		secret.Negate()
+	}
+	if err := c.checkAdaptorSecret(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+func (c *Configuration) checkAdaptorSecret(secret *btcec.ModNScalar) error {
+	if c.Adaptor == nil {
+		return fmt.Errorf("configuration has no adaptor")
+	}
+	if secret == nil || secret.IsZero() {
+		return fmt.Errorf("invalid adaptor secret (nil or zero scalar)")
+	}
+
+	point := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(secret, point)
+	point.ToAffine()
+	adaptor := new(btcec.JacobianPoint)
+	adaptor.Set(c.Adaptor)
+	adaptor.ToAffine()
+	if !point.X.Equals(&adaptor.X) || !point.Y.Equals(&adaptor.Y) {
+		return fmt.Errorf("adaptor secret doesn't match the adaptor point")
+	}
+	return nil
+}
+
+// splitSignature gives the nonce point (with an even y) and the scalar of sig.
+func splitSignature(sig *schnorr.Signature) (*btcec.JacobianPoint, *btcec.ModNScalar, error) {
+	b := sig.Serialize()
+
+	pubkey, err := schnorr.ParsePubKey(b[0:32])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid signature nonce: %w", err)
+	}
+	nonce := new(btcec.JacobianPoint)
+	pubkey.AsJacobian(nonce)
+
+	s := new(btcec.ModNScalar)
+	if overflow := s.SetByteSlice(b[32:64]); overflow {
+		return nil, nil, fmt.Errorf("invalid signature scalar")
+	}
+
+	return nonce, s, nil
+}
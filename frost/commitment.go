@@ -78,23 +78,42 @@ func (c *Configuration) ValidateCommitment(commitment Commitment) error {
 	return nil
 }
 
+var (
+	tagBinding        = []byte("frost/binding")
+	tagAdaptorBinding = []byte("frost/binding/adaptor")
+)
+
+// computeBindingCoefficient hashes everything a session is bound to. with an adaptor point it goes right before the
+// message and the hash gets a tag of its own, so the same nonces can never be made to sign for R and for R + T,
+// while without one it is the same as it has always been.
 func computeBindingCoefficient(
 	publicKey *btcec.JacobianPoint,
 	aggNonce BinoncePublic,
 	message []byte,
 	participants []int,
+	adaptor *btcec.JacobianPoint,
 ) *btcec.ModNScalar {
-	preimage := make([]byte, 32+4+32*len(participants)+33+len(message))
+	tag := tagBinding
+	adaptorSize := 0
+	if adaptor != nil {
+		tag = tagAdaptorBinding
+		adaptorSize = 33
+	}
+	preimage := make([]byte, 32+4+32*len(participants)+33+adaptorSize+len(message))
 
 	publicKey.X.PutBytesUnchecked(preimage[0:32])
 	binary.BigEndian.PutUint32(preimage[32:32+4], uint32(len(participants)))
 	for i, part := range participants {
 		new(btcec.ModNScalar).SetInt(uint32(part)).PutBytesUnchecked(preimage[32+4+i*32 : 32+4+(i+1)*32])
 	}
-	writePointTo(preimage[32+4+32*len(participants):32+4+32*len(participants)+33], aggNonce[0])
-	copy(preimage[32+4+32*len(participants)+33:], message)
+	offset := 32 + 4 + 32*len(participants)
+	writePointTo(preimage[offset:offset+33], aggNonce[0])
+	if adaptor != nil {
+		writePointTo(preimage[offset+33:offset+33+33], adaptor)
+	}
+	copy(preimage[offset+33+adaptorSize:], message)
 
-	hash := chainhash.TaggedHash([]byte("frost/binding"), preimage)
+	hash := chainhash.TaggedHash(tag, preimage)
 	s := new(btcec.ModNScalar)
 	s.SetBytes((*[32]byte)(hash))
 
@@ -137,11 +156,15 @@ func encodeCommitmentList(commitments []commitmentWithEncodedID) []byte {
 func bindFinalNonce(
 	groupCommitment BinoncePublic,
 	bindingCoeff *btcec.ModNScalar,
+	adaptor *btcec.JacobianPoint,
 ) (finalNonce *btcec.JacobianPoint, negate bool) {
 	finalNonce = new(btcec.JacobianPoint)
 
 	btcec.ScalarMultNonConst(bindingCoeff, groupCommitment[1], finalNonce)
 	btcec.AddNonConst(finalNonce, groupCommitment[0], finalNonce)
+	if adaptor != nil {
+		btcec.AddNonConst(finalNonce, adaptor, finalNonce)
+	}
 	finalNonce.ToAffine()
 
 	if finalNonce.Y.IsOdd() {
@@ -163,8 +186,9 @@ func (c *BinoncePublic) DecodeHex(x string) error {
 }
 
 func (c BinoncePublic) Encode() []byte {
-	out := make([]byte, 33+33)
-	c.encodeTo(out)
+	out := make([]byte, wireHeaderSize+33+33)
+	putWireHeader(out, wireBinoncePublic)
+	c.encodeTo(out[wireHeaderSize:])
 	return out
 }
 
@@ -174,27 +198,40 @@ func (c BinoncePublic) encodeTo(out []byte) {
 }
 
 func (c *BinoncePublic) Decode(in []byte) error {
-	if len(in) < 33+33 {
-		return fmt.Errorf("too small")
+	versioned, err := readWireHeader(in, wireBinoncePublic)
+	if err != nil {
+		return err
 	}
-
-	if pt, err := btcec.ParsePubKey(in[0:33]); err != nil {
-		return fmt.Errorf("failed to decode binding nonce: %w", err)
-	} else {
-		c[0] = new(btcec.JacobianPoint)
-		pt.AsJacobian(c[0])
+	if versioned {
+		in = in[wireHeaderSize:]
+	}
+	if err := checkWireLength(in, 33+33); err != nil {
+		return err
 	}
+	return c.decodeFrom(in)
+}
 
-	if pt, err := btcec.ParsePubKey(in[33 : 33+33]); err != nil {
+func (c *BinoncePublic) decodeFrom(in []byte) error {
+	var err error
+	if c[0], err = readPoint(in[0:33]); err != nil {
 		return fmt.Errorf("failed to decode hiding nonce: %w", err)
-	} else {
-		c[1] = new(btcec.JacobianPoint)
-		pt.AsJacobian(c[1])
 	}
-
+	if c[1], err = readPoint(in[33 : 33+33]); err != nil {
+		return fmt.Errorf("failed to decode binding nonce: %w", err)
+	}
 	return nil
 }
 
+// Zero wipes the secret nonces from memory and forgets them, so they can't be used to sign anymore.
+func (c *BinonceSecret) Zero() {
+	for i := range c {
+		if c[i] != nil {
+			c[i].Zero()
+			c[i] = nil
+		}
+	}
+}
+
 func (c Commitment) Hex() string { return hex.EncodeToString(c.Encode()) }
 func (c *Commitment) DecodeHex(x string) error {
 	b, err := hex.DecodeString(x)
@@ -205,25 +242,39 @@ func (c *Commitment) DecodeHex(x string) error {
 }
 
 func (c Commitment) Encode() []byte {
-	out := make([]byte, 2+33+33)
-
-	binary.LittleEndian.PutUint16(out[0:2], uint16(c.SignerID))
+	out := make([]byte, wireHeaderSize+2+33+33)
+	putWireHeader(out, wireCommitment)
+	c.encodeTo(out[wireHeaderSize:])
+	return out
+}
 
+func (c Commitment) encodeTo(out []byte) {
+	binary.BigEndian.PutUint16(out[0:2], uint16(c.SignerID))
 	c.BinoncePublic.encodeTo(out[2:])
-
-	return out
 }
 
 func (c *Commitment) Decode(in []byte) error {
-	if len(in) < 2+33+33 {
-		return fmt.Errorf("too small")
+	versioned, err := readWireHeader(in, wireCommitment)
+	if err != nil {
+		return err
+	}
+	if !versioned {
+		// the old encoding had the identifier in little-endian
+		if err := checkWireLength(in, 2+33+33); err != nil {
+			return err
+		}
+		c.SignerID = int(binary.LittleEndian.Uint16(in[0:2]))
+		return c.BinoncePublic.decodeFrom(in[2:])
 	}
 
-	c.SignerID = int(binary.LittleEndian.Uint16(in[0:2]))
-
-	if err := c.BinoncePublic.Decode(in[2:]); err != nil {
+	in = in[wireHeaderSize:]
+	if err := checkWireLength(in, 2+33+33); err != nil {
 		return err
 	}
+	return c.decodeFrom(in)
+}
 
-	return nil
+func (c *Commitment) decodeFrom(in []byte) error {
+	c.SignerID = int(binary.BigEndian.Uint16(in[0:2]))
+	return c.BinoncePublic.decodeFrom(in[2:])
 }
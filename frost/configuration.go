@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
 type Configuration struct {
@@ -14,10 +13,79 @@ type Configuration struct {
 	Threshold    int
 	MaxSigners   int
 	Participants []int
+
+	// Tweak is added to the group key (and to each shard) to sign for a derived key or a taproot output key (see
+	// UseTaproot), nil means no tweak.
+	Tweak *btcec.ModNScalar
+
+	// Ciphersuite is CiphersuiteBIP340 unless we're signing with CiphersuiteRFC9591, in which case the *RFC9591
+	// methods must be used for signing.
+	Ciphersuite Ciphersuite
+
+	// Method is MethodFROST unless the group key is a MuSig2 aggregate (see MuSig2AggregateKeys), in which case all
+	// the signers must be participants.
+	Method Method
+
+	// Adaptor is the point T an adaptor signature is locked to (see adaptor.go), nil for normal signatures.
+	Adaptor *btcec.JacobianPoint
+}
+
+// TweakedPublicKey is the key signatures are made for: the group key plus the tweak, if any, always with an even y.
+// negate says if the tweaked key had to be negated for that, in which case the tweaked shards must be negated too.
+func (c *Configuration) TweakedPublicKey() (pubkey *btcec.JacobianPoint, negate bool) {
+	if c.Tweak == nil {
+		return c.PublicKey, false
+	}
+
+	pubkey = new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(c.Tweak, pubkey)
+	btcec.AddNonConst(c.PublicKey, pubkey, pubkey)
+	pubkey.ToAffine()
+
+	if pubkey.Y.IsOdd() {
+		pubkey.Y.Negate(1)
+		pubkey.Y.Normalize()
+		negate = true
+	}
+
+	return pubkey, negate
+}
+
+// tweakedPublicShard is the public side of what a signer effectively uses when signing for the tweaked key: since
+// the Lagrange coefficients of any group of participants add up to 1, adding the tweak to every shard gives shards
+// of the tweaked key (with MuSig2 only one shard gets it, see shardTweak).
+func (c *Configuration) tweakedPublicShard(pks PublicKeyShard) *btcec.JacobianPoint {
+	if c.Tweak == nil {
+		return pks.PublicKey
+	}
+
+	_, negate := c.TweakedPublicKey()
+
+	pt := new(btcec.JacobianPoint)
+	if tweak := c.shardTweak(pks.ID); tweak != nil {
+		btcec.ScalarBaseMultNonConst(tweak, pt)
+		btcec.AddNonConst(pks.PublicKey, pt, pt)
+	} else {
+		pt.Set(pks.PublicKey)
+	}
+	pt.ToAffine()
+
+	if negate {
+		pt.Y.Negate(1)
+		pt.Y.Normalize()
+	}
+
+	return pt
 }
 
 // Signer returns a new participant of the protocol instantiated from the Configuration and the signer's key shard.
-func (c *Configuration) Signer(keyshard KeyShard, lambdaRegistry LambdaRegistry) (*Signer, error) {
+func (c *Configuration) Signer(keyshard KeyShard, lambdaRegistry *LambdaRegistry) (*Signer, error) {
+	if c.Method == MethodMuSig2 {
+		if err := c.checkMuSig2(); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := c.ValidateKeyShard(keyshard); err != nil {
 		return nil, err
 	}
@@ -52,12 +120,16 @@ func (c *Configuration) ComputeGroupCommitment(commitments []Commitment, message
 
 	// SignRound(ski, pk, S, statei, ρ, m) -- from https://eprint.iacr.org/2023/899.pdf, page 15
 	// 6 : b ← Hnon(X, S, ρ, m)
-	bindingCoefficient = computeBindingCoefficient(c.PublicKey, groupCommitment, message, c.Participants)
+	// (with a tweak X is the tweaked key, i.e. the taproot output key, already with an even y, and with an adaptor
+	// T is hashed in too)
+	signingKey, _ := c.TweakedPublicKey()
+	bindingCoefficient = c.bindingCoefficient(signingKey, groupCommitment, message)
 
 	// 7 : R ← DEb
-	finalNonce, negate := bindFinalNonce(groupCommitment, bindingCoefficient)
+	// (with an adaptor the final nonce is R + T)
+	finalNonce, negate := bindFinalNonce(groupCommitment, bindingCoefficient, c.Adaptor)
 
-	// BIP-340 special
+	// BIP-340 special (the parity of the nonce is unrelated to the parity of the key, tweaked or not)
 	if negate {
 		for i := range commitments {
 			commitments[i].BinoncePublic[0].Y.Negate(1)
@@ -83,9 +155,53 @@ func (c *Configuration) ValidatePublicKeyShard(pks PublicKeyShard) error {
 		return fmt.Errorf("public key is invalid: %w", err)
 	}
 
+	// shards from before we kept track of the commitments don't have them
+	if len(pks.VssCommitment) > 0 {
+		if err := c.ValidateVssCommitment(pks.VssCommitment); err != nil {
+			return err
+		}
+
+		expected := VssCommitment(pks.VssCommitment).evaluate(new(btcec.ModNScalar).SetInt(uint32(pks.ID)))
+		if !expected.X.Equals(&pks.PublicKey.X) || !expected.Y.Equals(&pks.PublicKey.Y) {
+			return fmt.Errorf("public key for %d doesn't match the vss commitment", pks.ID)
+		}
+	}
+
 	return nil
 }
 
+// ValidateVssCommitment checks that vss commits to a polynomial of the degree implied by the threshold whose constant
+// term is the group secret, such that all shares checked against it are shares of the same key.
+func (c *Configuration) ValidateVssCommitment(vss VssCommitment) error {
+	if len(vss) == 0 {
+		return fmt.Errorf("missing vss commitment")
+	}
+	if len(vss) != c.Threshold {
+		return fmt.Errorf("vss commitment has %d points, expected %d", len(vss), c.Threshold)
+	}
+
+	for i, pt := range vss {
+		if pt == nil || (pt.X.IsZero() && pt.Y.IsZero()) {
+			return fmt.Errorf("vss commitment has an invalid point at %d", i)
+		}
+	}
+
+	if !vss[0].X.Equals(&c.PublicKey.X) || !vss[0].Y.Equals(&c.PublicKey.Y) {
+		return fmt.Errorf("vss commitment is for a different public key")
+	}
+
+	return nil
+}
+
+// VerifySecretShare checks that the secret share of the participant id lies on the polynomial committed to by vss.
+func (c *Configuration) VerifySecretShare(id int, share *btcec.ModNScalar, vss VssCommitment) error {
+	if err := c.ValidateVssCommitment(vss); err != nil {
+		return err
+	}
+
+	return vss.VerifyShare(id, share)
+}
+
 func (c *Configuration) ValidateKeyShard(keyshard KeyShard) error {
 	if err := c.ValidatePublicKeyShard(keyshard.PublicKeyShard); err != nil {
 		return err
@@ -103,7 +219,7 @@ func (c *Configuration) ValidateKeyShard(keyshard KeyShard) error {
 	}
 
 	pt := new(btcec.JacobianPoint)
-	btcec.ScalarBaseMultNonConst(keyshard.Secret, pt)
+	scalarBaseMultLadder(keyshard.Secret, pt)
 	pt.ToAffine()
 
 	if !pt.X.Equals(&keyshard.PublicKeyShard.PublicKey.X) || !pt.Y.Equals(&keyshard.PublicKeyShard.PublicKey.Y) {
@@ -117,6 +233,12 @@ func (c *Configuration) ValidateKeyShard(keyshard KeyShard) error {
 			public, keyshard.Secret.Bytes(), derived)
 	}
 
+	if len(keyshard.PublicKeyShard.VssCommitment) > 0 {
+		if err := c.VerifySecretShare(keyshard.ID, keyshard.Secret, keyshard.PublicKeyShard.VssCommitment); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -148,40 +270,159 @@ func (c *Configuration) DecodeHex(x string) error {
 }
 
 func (c *Configuration) Encode() []byte {
-	out := make([]byte, 6+33+len(c.Participants)*2)
-
-	binary.LittleEndian.PutUint16(out[0:2], uint16(c.Threshold))
-	binary.LittleEndian.PutUint16(out[2:4], uint16(c.MaxSigners))
-	binary.LittleEndian.PutUint16(out[4:6], uint16(len(c.Participants)))
+	size := wireHeaderSize + 7 + 33 + len(c.Participants)*2
+	var flags byte
+	if c.Tweak != nil {
+		size += 32
+		flags |= 1
+	}
+	if c.Ciphersuite == CiphersuiteRFC9591 {
+		flags |= 2
+	}
+	if c.Method == MethodMuSig2 {
+		flags |= 4
+	}
+	if c.Adaptor != nil {
+		size += 33
+		flags |= 8
+	}
+	out := make([]byte, size)
+	putWireHeader(out, wireConfiguration)
+	body := out[wireHeaderSize:]
 
-	writePointTo(out[6:6+33], c.PublicKey)
+	binary.BigEndian.PutUint16(body[0:2], uint16(c.Threshold))
+	binary.BigEndian.PutUint16(body[2:4], uint16(c.MaxSigners))
+	binary.BigEndian.PutUint16(body[4:6], uint16(len(c.Participants)))
+	body[6] = flags
+	writePointTo(body[7:7+33], c.PublicKey)
 
 	for i, part := range c.Participants {
-		binary.BigEndian.PutUint16(out[6+33+i*2:], uint16(part))
+		binary.BigEndian.PutUint16(body[7+33+i*2:], uint16(part))
+	}
+
+	rest := body[7+33+len(c.Participants)*2:]
+	if c.Tweak != nil {
+		c.Tweak.PutBytesUnchecked(rest[0:32])
+		rest = rest[32:]
+	}
+	if c.Adaptor != nil {
+		writePointTo(rest[0:33], c.Adaptor)
 	}
 
 	return out
 }
 
 func (c *Configuration) Decode(in []byte) error {
+	versioned, err := readWireHeader(in, wireConfiguration)
+	if err != nil {
+		return err
+	}
+	if !versioned {
+		return c.decodeLegacy(in)
+	}
+	body := in[wireHeaderSize:]
+
+	if len(body) < 7+33 {
+		return fmt.Errorf("too small")
+	}
+
+	c.Threshold = int(binary.BigEndian.Uint16(body[0:2]))
+	c.MaxSigners = int(binary.BigEndian.Uint16(body[2:4]))
+	n := int(binary.BigEndian.Uint16(body[4:6]))
+	if n > c.MaxSigners {
+		return fmt.Errorf("%d participants for %d signers", n, c.MaxSigners)
+	}
+	flags := body[6]
+	if flags&^15 != 0 {
+		return fmt.Errorf("unknown flags %x", flags)
+	}
+
+	size := 7 + 33 + n*2
+	if flags&1 != 0 {
+		size += 32
+	}
+	if flags&8 != 0 {
+		size += 33
+	}
+	if err := checkWireLength(body, size); err != nil {
+		return err
+	}
+
+	if c.PublicKey, err = readPoint(body[7 : 7+33]); err != nil {
+		return fmt.Errorf("failed to decode pubkey: %w", err)
+	}
+
+	c.Participants = make([]int, n)
+	for i := range c.Participants {
+		c.Participants[i] = int(binary.BigEndian.Uint16(body[7+33+i*2 : 7+33+(i+1)*2]))
+	}
+
+	c.Ciphersuite = CiphersuiteBIP340
+	if flags&2 != 0 {
+		c.Ciphersuite = CiphersuiteRFC9591
+	}
+
+	c.Method = MethodFROST
+	if flags&4 != 0 {
+		c.Method = MethodMuSig2
+	}
+
+	rest := body[7+33+n*2:]
+	c.Tweak = nil
+	if flags&1 != 0 {
+		if c.Tweak, err = readScalar(rest[0:32]); err != nil {
+			return fmt.Errorf("invalid tweak: %w", err)
+		}
+		rest = rest[32:]
+	}
+
+	c.Adaptor = nil
+	if flags&8 != 0 {
+		if c.Adaptor, err = readPoint(rest[0:33]); err != nil {
+			return fmt.Errorf("invalid adaptor: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// decodeLegacy reads the encoding from before there was a header, in which the counts were little-endian and the
+// participants big-endian, with the tweak optionally at the end.
+func (c *Configuration) decodeLegacy(in []byte) error {
 	if len(in) < 6+33 {
 		return fmt.Errorf("too small")
 	}
 
 	c.Threshold = int(binary.LittleEndian.Uint16(in[0:2]))
 	c.MaxSigners = int(binary.LittleEndian.Uint16(in[2:4]))
-	c.Participants = make([]int, binary.LittleEndian.Uint16(in[4:6]))
+	n := int(binary.LittleEndian.Uint16(in[4:6]))
+	if n > c.MaxSigners {
+		return fmt.Errorf("%d participants for %d signers", n, c.MaxSigners)
+	}
+	if len(in) != 6+33+n*2 && len(in) != 6+33+n*2+32 {
+		return fmt.Errorf("wrong length %d for %d participants", len(in), n)
+	}
 
-	if pk, err := secp256k1.ParsePubKey(in[6 : 6+33]); err != nil {
+	var err error
+	if c.PublicKey, err = readPoint(in[6 : 6+33]); err != nil {
 		return fmt.Errorf("failed to decode pubkey: %w", err)
-	} else {
-		c.PublicKey = new(btcec.JacobianPoint)
-		pk.AsJacobian(c.PublicKey)
 	}
 
+	c.Participants = make([]int, n)
 	for i := range c.Participants {
 		c.Participants[i] = int(binary.BigEndian.Uint16(in[6+33+i*2 : 6+33+(i+1)*2]))
 	}
 
+	c.Ciphersuite = CiphersuiteBIP340
+	c.Method = MethodFROST
+	c.Adaptor = nil
+
+	c.Tweak = nil
+	if rest := in[6+33+n*2:]; len(rest) == 32 {
+		if c.Tweak, err = readScalar(rest); err != nil {
+			return fmt.Errorf("invalid tweak: %w", err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,442 @@
+package frost
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// DKGCommitment is what each participant broadcasts in the first round of the distributed key generation:
+// a commitment to its own secret polynomial and a proof of knowledge of that polynomial's constant term.
+type DKGCommitment struct {
+	// VssCommitment to the participant's own polynomial, the first item is the public key of its constant term.
+	VssCommitment VssCommitment
+
+	// ProofOfKnowledge is a schnorr signature (R, mu) with the constant term of the polynomial as the secret key.
+	ProofOfKnowledge struct {
+		R  *btcec.JacobianPoint
+		Mu *btcec.ModNScalar
+	}
+
+	SignerID int
+}
+
+// DKGShare is the secret evaluation of one participant's polynomial at the identifier of another.
+// It must only ever be sent to its recipient through an encrypted channel.
+type DKGShare struct {
+	Value *btcec.ModNScalar
+	From  int
+	To    int
+}
+
+// DKGParticipant holds the secret state of one signer during a distributed key generation session.
+type DKGParticipant struct {
+	ID         int
+	Threshold  int
+	MaxSigners int
+
+	// Context binds the proofs of knowledge to this session, so they can't be replayed in another one.
+	Context []byte
+
+	polynomial Polynomial
+}
+
+// NewDKGParticipant creates a random polynomial for the participant id and returns the commitment that must be
+// broadcast to all the other participants, as in the first round of https://eprint.iacr.org/2023/899.pdf, page 13
+// (PedPoP).
+func NewDKGParticipant(id, threshold, maxSigners int, context []byte) (*DKGParticipant, DKGCommitment, error) {
+	if threshold <= 0 || maxSigners < threshold {
+		return nil, DKGCommitment{}, fmt.Errorf("bad threshold %d for %d signers", threshold, maxSigners)
+	}
+	if id <= 0 || id > maxSigners {
+		return nil, DKGCommitment{}, fmt.Errorf("identifier %d is out of range", id)
+	}
+
+	var random [32]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, DKGCommitment{}, fmt.Errorf("failed to read random: %w", err)
+	}
+	secret := new(btcec.ModNScalar)
+	secret.SetBytes(&random)
+	if secret.IsZero() {
+		return nil, DKGCommitment{}, fmt.Errorf("got a zero secret, this is extremely unlikely")
+	}
+
+	polynomial, err := makePolynomial(secret, threshold)
+	if err != nil {
+		return nil, DKGCommitment{}, err
+	}
+
+	p := &DKGParticipant{
+		ID:         id,
+		Threshold:  threshold,
+		MaxSigners: maxSigners,
+		Context:    context,
+		polynomial: polynomial,
+	}
+
+	com := DKGCommitment{
+		SignerID:      id,
+		VssCommitment: VSSCommit(polynomial),
+	}
+
+	// proof of knowledge of the constant term
+	var nonceBytes [32]byte
+	if _, err := rand.Read(nonceBytes[:]); err != nil {
+		return nil, DKGCommitment{}, fmt.Errorf("failed to read random: %w", err)
+	}
+	k := new(btcec.ModNScalar)
+	k.SetBytes(&nonceBytes)
+	R := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(k, R)
+	R.ToAffine()
+
+	c := dkgChallenge(id, context, com.VssCommitment[0], R)
+	com.ProofOfKnowledge.R = R
+	com.ProofOfKnowledge.Mu = c.Mul(polynomial[0]).Add(k)
+
+	secret.Zero()
+	k.Zero()
+	clear(random[:])
+	clear(nonceBytes[:])
+
+	return p, com, nil
+}
+
+// Zero wipes our secret polynomial from memory, after all the shares have been sent and Finalize was called.
+func (p *DKGParticipant) Zero() { p.polynomial.Zero() }
+
+func dkgChallenge(id int, context []byte, constant *btcec.JacobianPoint, R *btcec.JacobianPoint) *btcec.ModNScalar {
+	preimage := make([]byte, 32+33+33+len(context))
+
+	new(btcec.ModNScalar).SetInt(uint32(id)).PutBytesUnchecked(preimage[0:32])
+	writePointTo(preimage[32:32+33], constant)
+	writePointTo(preimage[32+33:32+33+33], R)
+	copy(preimage[32+33+33:], context)
+
+	hash := chainhash.TaggedHash([]byte("frost/dkg/pok"), preimage)
+	c := new(btcec.ModNScalar)
+	c.SetBytes((*[32]byte)(hash))
+	return c
+}
+
+// ValidateDKGCommitment checks that a commitment has the right number of points and that its proof of knowledge is
+// valid for the given session context.
+func ValidateDKGCommitment(com DKGCommitment, threshold, maxSigners int, context []byte) error {
+	if com.SignerID <= 0 || com.SignerID > maxSigners {
+		return fmt.Errorf("identifier %d is out of range", com.SignerID)
+	}
+
+	if len(com.VssCommitment) != threshold {
+		return fmt.Errorf("commitment from %d has %d points, expected %d",
+			com.SignerID, len(com.VssCommitment), threshold)
+	}
+
+	for i, pt := range com.VssCommitment {
+		if pt == nil || (pt.X.IsZero() && pt.Y.IsZero()) {
+			return fmt.Errorf("commitment from %d has an invalid point at %d", com.SignerID, i)
+		}
+	}
+
+	if com.ProofOfKnowledge.R == nil || com.ProofOfKnowledge.Mu == nil {
+		return fmt.Errorf("commitment from %d is missing its proof of knowledge", com.SignerID)
+	}
+
+	// mu * G == R + c * A
+	c := dkgChallenge(com.SignerID, context, com.VssCommitment[0], com.ProofOfKnowledge.R)
+
+	leftSide := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(com.ProofOfKnowledge.Mu, leftSide)
+
+	rightSide := new(btcec.JacobianPoint)
+	btcec.ScalarMultNonConst(c, com.VssCommitment[0], rightSide)
+	btcec.AddNonConst(rightSide, com.ProofOfKnowledge.R, rightSide)
+
+	leftSide.ToAffine()
+	rightSide.ToAffine()
+	if !leftSide.X.Equals(&rightSide.X) || !leftSide.Y.Equals(&rightSide.Y) {
+		return fmt.Errorf("invalid proof of knowledge from %d", com.SignerID)
+	}
+
+	return nil
+}
+
+// Share returns the secret share this participant must send to the participant identified by to.
+func (p *DKGParticipant) Share(to int) DKGShare {
+	return DKGShare{
+		From:  p.ID,
+		To:    to,
+		Value: p.polynomial.evaluate(new(btcec.ModNScalar).SetInt(uint32(to))),
+	}
+}
+
+// Finalize validates all the commitments and the shares received from the other participants, then sums them into
+// our final KeyShard. commitments must include one for every participant (our own included) and shares must include
+// one from every other participant.
+func (p *DKGParticipant) Finalize(commitments []DKGCommitment, shares []DKGShare) (KeyShard, error) {
+	if len(commitments) != p.MaxSigners {
+		return KeyShard{}, fmt.Errorf("expected %d commitments, got %d", p.MaxSigners, len(commitments))
+	}
+
+	for i, com := range commitments {
+		if err := ValidateDKGCommitment(com, p.Threshold, p.MaxSigners, p.Context); err != nil {
+			return KeyShard{}, err
+		}
+		for _, prev := range commitments[:i] {
+			if prev.SignerID == com.SignerID {
+				return KeyShard{}, fmt.Errorf("multiple commitments from %d", com.SignerID)
+			}
+		}
+	}
+
+	// our own share to ourselves is not sent through the wire
+	secret := p.polynomial.evaluate(new(btcec.ModNScalar).SetInt(uint32(p.ID)))
+
+	for _, com := range commitments {
+		if com.SignerID == p.ID {
+			continue
+		}
+
+		idx := slices.IndexFunc(shares, func(sh DKGShare) bool { return sh.From == com.SignerID })
+		if idx == -1 {
+			return KeyShard{}, fmt.Errorf("missing share from %d", com.SignerID)
+		}
+		share := shares[idx]
+		if share.To != p.ID {
+			return KeyShard{}, fmt.Errorf("share from %d was meant for %d", share.From, share.To)
+		}
+
+		if err := com.VssCommitment.VerifyShare(p.ID, share.Value); err != nil {
+			return KeyShard{}, fmt.Errorf("share from %d: %w", share.From, err)
+		}
+
+		secret.Add(share.Value)
+	}
+
+	pubkey, vss, negate := AggregateDKGCommitments(commitments)
+
+	// BIP-340 special
+	if negate {
+		secret.Negate()
+	}
+
+	pks := vss.PublicKeyShard(p.ID)
+	shard := KeyShard{
+		Secret:         secret,
+		PublicKey:      pubkey,
+		PublicKeyShard: pks,
+	}
+
+	return shard, nil
+}
+
+// AggregateDKGCommitments sums the commitments of all participants into the commitment to the group polynomial,
+// from which the group public key and everybody's PublicKeyShard can be derived. If the resulting public key has an
+// odd y the whole commitment is negated and negate is true, meaning every participant must negate its secret share.
+func AggregateDKGCommitments(commitments []DKGCommitment) (
+	pubkey *btcec.JacobianPoint,
+	vss VssCommitment,
+	negate bool,
+) {
+	vss = make(VssCommitment, len(commitments[0].VssCommitment))
+	for k := range vss {
+		vss[k] = new(btcec.JacobianPoint)
+		for _, com := range commitments {
+			btcec.AddNonConst(vss[k], com.VssCommitment[k], vss[k])
+		}
+		vss[k].ToAffine()
+	}
+
+	// BIP-340 special
+	if vss[0].Y.IsOdd() {
+		negate = true
+		for _, pt := range vss {
+			pt.Y.Negate(1)
+			pt.Y.Normalize()
+		}
+	}
+
+	pubkey = new(btcec.JacobianPoint)
+	pubkey.Set(vss[0])
+
+	return pubkey, vss, negate
+}
+
+// evaluate computes the public counterpart of the polynomial at x, i.e. ∑ Cₖxᵏ.
+func (v VssCommitment) evaluate(x *btcec.ModNScalar) *btcec.JacobianPoint {
+	result := new(btcec.JacobianPoint)
+	xk := new(btcec.ModNScalar).SetInt(1)
+	for _, coeff := range v {
+		term := new(btcec.JacobianPoint)
+		btcec.ScalarMultNonConst(xk, coeff, term)
+		btcec.AddNonConst(result, term, result)
+		xk.Mul(x)
+	}
+	result.ToAffine()
+	return result
+}
+
+// VerifyShare checks that a secret share for the participant id lies on the polynomial committed to.
+func (v VssCommitment) VerifyShare(id int, share *btcec.ModNScalar) error {
+	if share == nil || share.IsZero() {
+		return fmt.Errorf("share is nil or zero")
+	}
+
+	expected := v.evaluate(new(btcec.ModNScalar).SetInt(uint32(id)))
+
+	actual := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(share, actual)
+	actual.ToAffine()
+
+	if !expected.X.Equals(&actual.X) || !expected.Y.Equals(&actual.Y) {
+		return fmt.Errorf("share for %d doesn't match the vss commitment", id)
+	}
+
+	return nil
+}
+
+// Equals tells whether two commitments are to the same polynomial.
+func (v VssCommitment) Equals(other VssCommitment) bool {
+	if len(v) != len(other) {
+		return false
+	}
+	for i := range v {
+		if v[i] == nil || other[i] == nil || !v[i].X.Equals(&other[i].X) || !v[i].Y.Equals(&other[i].Y) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicKeyShard derives the public key shard for the participant id from the commitment to the group polynomial.
+func (v VssCommitment) PublicKeyShard(id int) PublicKeyShard {
+	return PublicKeyShard{
+		ID:            id,
+		PublicKey:     v.evaluate(new(btcec.ModNScalar).SetInt(uint32(id))),
+		VssCommitment: v,
+	}
+}
+
+func (c DKGCommitment) Hex() string { return hex.EncodeToString(c.Encode()) }
+func (c *DKGCommitment) DecodeHex(x string) error {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return err
+	}
+	_, err = c.Decode(b)
+	return err
+}
+
+func (c DKGCommitment) Encode() []byte {
+	out := make([]byte, wireHeaderSize+4+33+32+33*len(c.VssCommitment))
+	putWireHeader(out, wireDKGCommitment)
+	body := out[wireHeaderSize:]
+
+	binary.BigEndian.PutUint16(body[0:2], uint16(c.SignerID))
+	binary.BigEndian.PutUint16(body[2:4], uint16(len(c.VssCommitment)))
+
+	writePointTo(body[4:4+33], c.ProofOfKnowledge.R)
+	c.ProofOfKnowledge.Mu.PutBytesUnchecked(body[4+33 : 4+33+32])
+
+	for i, pt := range c.VssCommitment {
+		writePointTo(body[4+33+32+i*33:], pt)
+	}
+
+	return out
+}
+
+// Decode reads a commitment from the start of in and returns the number of bytes it took. the old encoding, without
+// a header and with the integers in little-endian, is accepted too.
+func (c *DKGCommitment) Decode(in []byte) (int, error) {
+	versioned, err := readWireHeader(in, wireDKGCommitment)
+	if err != nil {
+		return 0, err
+	}
+
+	order := binary.ByteOrder(binary.LittleEndian)
+	offset := 0
+	if versioned {
+		order = binary.BigEndian
+		offset = wireHeaderSize
+	}
+	in = in[offset:]
+
+	if len(in) < 4+33+32 {
+		return 0, fmt.Errorf("too small")
+	}
+
+	c.SignerID = int(order.Uint16(in[0:2]))
+	c.VssCommitment = make(VssCommitment, order.Uint16(in[2:4]))
+
+	fullLength := 4 + 33 + 32 + 33*len(c.VssCommitment)
+	if len(in) < fullLength {
+		return 0, fmt.Errorf("too small for vss commitments")
+	}
+
+	if c.ProofOfKnowledge.R, err = readPoint(in[4:]); err != nil {
+		return 0, fmt.Errorf("failed to decode proof nonce: %w", err)
+	}
+	if c.ProofOfKnowledge.Mu, err = readScalar(in[4+33:]); err != nil {
+		return 0, fmt.Errorf("failed to decode proof scalar: %w", err)
+	}
+
+	for i := range c.VssCommitment {
+		if c.VssCommitment[i], err = readPoint(in[4+33+32+i*33:]); err != nil {
+			return 0, fmt.Errorf("failed to decode vss commitment %d: %w", i, err)
+		}
+	}
+
+	return offset + fullLength, nil
+}
+
+func (s DKGShare) Hex() string { return hex.EncodeToString(s.Encode()) }
+func (s *DKGShare) DecodeHex(x string) error {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return err
+	}
+	return s.Decode(b)
+}
+
+func (s DKGShare) Encode() []byte {
+	out := make([]byte, wireHeaderSize+2+2+32)
+	putWireHeader(out, wireDKGShare)
+
+	binary.BigEndian.PutUint16(out[wireHeaderSize:wireHeaderSize+2], uint16(s.From))
+	binary.BigEndian.PutUint16(out[wireHeaderSize+2:wireHeaderSize+4], uint16(s.To))
+	s.Value.PutBytesUnchecked(out[wireHeaderSize+4 : wireHeaderSize+4+32])
+
+	return out
+}
+
+func (s *DKGShare) Decode(in []byte) error {
+	versioned, err := readWireHeader(in, wireDKGShare)
+	if err != nil {
+		return err
+	}
+
+	order := binary.ByteOrder(binary.LittleEndian)
+	if versioned {
+		if err := checkWireLength(in, wireHeaderSize+2+2+32); err != nil {
+			return err
+		}
+		order = binary.BigEndian
+		in = in[wireHeaderSize:]
+	} else if len(in) < 2+2+32 {
+		return fmt.Errorf("too small")
+	}
+
+	s.From = int(order.Uint16(in[0:2]))
+	s.To = int(order.Uint16(in[2:4]))
+
+	if s.Value, err = readScalar(in[4:]); err != nil {
+		return fmt.Errorf("failed to decode share: %w", err)
+	}
+
+	return nil
+}
@@ -1,41 +1,256 @@
 package frost
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
-func (c *Configuration) AggregateECDHShards(shards []*btcec.JacobianPoint) (*btcec.JacobianPoint, error) {
+// ECDHShare is a signer's part of the shared secret between the group key and some other pubkey P, already
+// multiplied by the signer's Lagrange coefficient λi, such that the shares of all the participants add up to the
+// shared secret.
+//
+// It comes with a Chaum-Pedersen proof that log_G(λi * Yi) == log_P(Point), where Yi is the signer's public key
+// shard, so a share made with anything other than the signer's actual shard can be spotted.
+type ECDHShare struct {
+	SignerID int
+	Point    *btcec.JacobianPoint // λi * si * P
+
+	// the DLEQ proof
+	Challenge *btcec.ModNScalar
+	Response  *btcec.ModNScalar
+}
+
+// ECDHShareError says which signer has sent a bad ECDH share.
+type ECDHShareError struct {
+	SignerID int
+	Err      error
+}
+
+func (e ECDHShareError) Error() string {
+	return fmt.Sprintf("invalid ecdh share from signer %d: %s", e.SignerID, e.Err)
+}
+func (e ECDHShareError) Unwrap() error { return e.Err }
+
+// AggregateECDHShards verifies each share against the public key shard of the signer that made it, then adds them
+// up into the shared secret between the group key and pubkey. if any share is bad an ECDHShareError is returned.
+//
+// with a tweak the result is the shared secret of the tweaked key instead: the signers don't have to know about it,
+// as the tweak is public and can be added here.
+func (c *Configuration) AggregateECDHShards(
+	pubkey *btcec.JacobianPoint,
+	shares []ECDHShare,
+	publicShards []PublicKeyShard,
+	lambdaRegistry *LambdaRegistry,
+) (*btcec.JacobianPoint, error) {
+	if len(shares) != len(c.Participants) {
+		return nil, fmt.Errorf("got %d ecdh shares for %d participants", len(shares), len(c.Participants))
+	}
+
 	res := new(btcec.JacobianPoint)
-	for _, shard := range shards {
-		if shard == nil || shard.X.IsZero() || shard.Y.IsZero() {
-			return nil, errors.New("invalid ecdh shard (nil or zero scalar)")
+	for i, share := range shares {
+		for _, prev := range shares[:i] {
+			if prev.SignerID == share.SignerID {
+				return nil, fmt.Errorf("got multiple ecdh shares from signer %d", share.SignerID)
+			}
+		}
+
+		var pks *PublicKeyShard
+		for j := range publicShards {
+			if publicShards[j].ID == share.SignerID {
+				pks = &publicShards[j]
+				break
+			}
+		}
+		if pks == nil {
+			return nil, ECDHShareError{share.SignerID, errors.New("unknown signer")}
+		}
+
+		if err := c.VerifyECDHShare(*pks, pubkey, share, lambdaRegistry); err != nil {
+			return nil, err
+		}
+
+		btcec.AddNonConst(share.Point, res, res)
+	}
+
+	if c.Tweak != nil {
+		_, negate := c.TweakedPublicKey()
+
+		tweaked := new(btcec.JacobianPoint)
+		btcec.ScalarMultNonConst(c.Tweak, pubkey, tweaked)
+		btcec.AddNonConst(res, tweaked, res)
+		res.ToAffine()
+
+		if negate {
+			res.Y.Negate(1)
+			res.Y.Normalize()
 		}
-		btcec.AddNonConst(shard, res, res)
 	}
 	res.ToAffine()
+
 	return res, nil
 }
 
+// CreateECDHShare makes our part of the shared secret between the group key and pubkey, with a proof that it was
+// made with our shard.
 func (c *Configuration) CreateECDHShare(
 	keyshard KeyShard,
 	pubkey *btcec.JacobianPoint,
-	lambdaRegistry LambdaRegistry,
-) *btcec.JacobianPoint {
-	res := new(btcec.JacobianPoint)
-	btcec.ScalarMultNonConst(keyshard.Secret, pubkey, res)
-
-	btcec.ScalarMultNonConst(
-		new(btcec.ModNScalar).
-			Mul2(
-				lambdaRegistry.getOrNew(c.Participants, keyshard.ID),
-				keyshard.Secret,
-			),
-		pubkey,
-		res,
+	lambdaRegistry *LambdaRegistry,
+) ECDHShare {
+	// x = λi * si
+	x := new(btcec.ModNScalar).Mul2(
+		c.coefficient(lambdaRegistry, keyshard.ID),
+		keyshard.Secret,
 	)
 
+	res := new(btcec.JacobianPoint)
+	scalarMultLadder(x, pubkey, res)
 	res.ToAffine()
-	return res
+
+	// X = λi * Yi
+	X := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(x, X)
+	X.ToAffine()
+
+	// Chaum-Pedersen: A1 = k * G, A2 = k * P, c = H(X, P, xP, A1, A2), z = k + c * x
+	k, A1 := generateNonce("frost/ecdh", keyshard.Secret, c.PublicKey)
+	A2 := new(btcec.JacobianPoint)
+	scalarMultLadder(k, pubkey, A2)
+	A2.ToAffine()
+
+	challenge := computeDLEQChallenge(X, pubkey, res, A1, A2)
+	response := new(btcec.ModNScalar).Mul2(challenge, x).Add(k)
+
+	x.Zero()
+	k.Zero()
+
+	return ECDHShare{
+		SignerID:  keyshard.ID,
+		Point:     res,
+		Challenge: challenge,
+		Response:  response,
+	}
+}
+
+// VerifyECDHShare checks the DLEQ proof of an ECDH share against the public key shard of the signer that made it
+// and its Lagrange coefficient in this configuration.
+func (c *Configuration) VerifyECDHShare(
+	pks PublicKeyShard,
+	pubkey *btcec.JacobianPoint,
+	share ECDHShare,
+	lambdaRegistry *LambdaRegistry,
+) error {
+	if share.SignerID != pks.ID {
+		return ECDHShareError{share.SignerID, fmt.Errorf("checked against the shard of %d", pks.ID)}
+	}
+	if share.Point == nil || (share.Point.X.IsZero() && share.Point.Y.IsZero()) {
+		return ECDHShareError{share.SignerID, errors.New("nil or zero point")}
+	}
+	if share.Challenge == nil || share.Response == nil {
+		return ECDHShareError{share.SignerID, errors.New("missing proof")}
+	}
+
+	// X = λi * Yi
+	X := new(btcec.JacobianPoint)
+	btcec.ScalarMultNonConst(c.coefficient(lambdaRegistry, pks.ID), pks.PublicKey, X)
+	X.ToAffine()
+
+	// A1 = z * G - c * X
+	negC := new(btcec.ModNScalar).NegateVal(share.Challenge)
+	A1 := new(btcec.JacobianPoint)
+	aux := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(share.Response, A1)
+	btcec.ScalarMultNonConst(negC, X, aux)
+	btcec.AddNonConst(A1, aux, A1)
+	A1.ToAffine()
+
+	// A2 = z * P - c * xP
+	A2 := new(btcec.JacobianPoint)
+	btcec.ScalarMultNonConst(share.Response, pubkey, A2)
+	btcec.ScalarMultNonConst(negC, share.Point, aux)
+	btcec.AddNonConst(A2, aux, A2)
+	A2.ToAffine()
+
+	if (A1.X.IsZero() && A1.Y.IsZero()) || (A2.X.IsZero() && A2.Y.IsZero()) {
+		return ECDHShareError{share.SignerID, errors.New("invalid proof")}
+	}
+
+	if !computeDLEQChallenge(X, pubkey, share.Point, A1, A2).Equals(share.Challenge) {
+		return ECDHShareError{share.SignerID, errors.New("proof doesn't match")}
+	}
+
+	return nil
+}
+
+func computeDLEQChallenge(X, P, xP, A1, A2 *btcec.JacobianPoint) *btcec.ModNScalar {
+	preimage := make([]byte, 33*5)
+	writePointTo(preimage[0:33], X)
+	writePointTo(preimage[33:33*2], P)
+	writePointTo(preimage[33*2:33*3], xP)
+	writePointTo(preimage[33*3:33*4], A1)
+	writePointTo(preimage[33*4:33*5], A2)
+
+	hash := chainhash.TaggedHash([]byte("frost/dleq"), preimage)
+	s := new(btcec.ModNScalar)
+	s.SetBytes((*[32]byte)(hash))
+
+	return s
+}
+
+func (s ECDHShare) Hex() string { return hex.EncodeToString(s.Encode()) }
+func (s *ECDHShare) DecodeHex(x string) error {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return err
+	}
+	return s.Decode(b)
+}
+
+func (s ECDHShare) Encode() []byte {
+	out := make([]byte, wireHeaderSize+2+33+32+32)
+	putWireHeader(out, wireECDHShare)
+	body := out[wireHeaderSize:]
+
+	binary.BigEndian.PutUint16(body[0:2], uint16(s.SignerID))
+	writePointTo(body[2:2+33], s.Point)
+	s.Challenge.PutBytesUnchecked(body[2+33 : 2+33+32])
+	s.Response.PutBytesUnchecked(body[2+33+32 : 2+33+32+32])
+
+	return out
+}
+
+func (s *ECDHShare) Decode(in []byte) error {
+	versioned, err := readWireHeader(in, wireECDHShare)
+	if err != nil {
+		return err
+	}
+
+	order := binary.ByteOrder(binary.LittleEndian)
+	if versioned {
+		if err := checkWireLength(in, wireHeaderSize+2+33+32+32); err != nil {
+			return err
+		}
+		order = binary.BigEndian
+		in = in[wireHeaderSize:]
+	} else if len(in) < 2+33+32+32 {
+		return fmt.Errorf("too small")
+	}
+
+	s.SignerID = int(order.Uint16(in[0:2]))
+
+	if s.Point, err = readPoint(in[2:]); err != nil {
+		return fmt.Errorf("failed to decode point: %w", err)
+	}
+
+	s.Challenge = new(btcec.ModNScalar)
+	s.Challenge.SetBytes((*[32]byte)(in[2+33 : 2+33+32]))
+	s.Response = new(btcec.ModNScalar)
+	s.Response.SetBytes((*[32]byte)(in[2+33+32 : 2+33+32+32]))
+
+	return nil
 }
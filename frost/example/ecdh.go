@@ -35,13 +35,15 @@ func ecdhFlow(
 		Participants: participants,
 	}
 
-	lambdaRegistry := make(frost.LambdaRegistry)
-	ecdhShards := make([]*btcec.JacobianPoint, threshold)
+	lambdaRegistry := frost.NewLambdaRegistry(0)
+	ecdhShares := make([]frost.ECDHShare, threshold)
+	publicShards := make([]frost.PublicKeyShard, threshold)
 	for i := range threshold {
-		ecdhShards[i] = cfg.CreateECDHShare(shards[i], targetPubKey, lambdaRegistry)
+		ecdhShares[i] = cfg.CreateECDHShare(shards[i], targetPubKey, lambdaRegistry)
+		publicShards[i] = shards[i].PublicKeyShard
 	}
 
-	ecdh, err := cfg.AggregateECDHShards(ecdhShards)
+	ecdh, err := cfg.AggregateECDHShards(targetPubKey, ecdhShares, publicShards, lambdaRegistry)
 	if err != nil {
 		panic(err)
 	}
@@ -78,7 +78,7 @@ func coordinator(
 	signers []chan string,
 	message []byte,
 ) ([]byte, error) {
-	lambdaRegistry := make(frost.LambdaRegistry)
+	lambdaRegistry := frost.NewLambdaRegistry(0)
 
 	// step-1 (send): initialize each participant
 	cfgHex := cfg.Hex()
@@ -190,7 +190,7 @@ func signer(ch chan string, shard frost.KeyShard) {
 		panic(err)
 	}
 
-	signer, err := cfg.Signer(shard, make(frost.LambdaRegistry))
+	signer, err := cfg.Signer(shard, frost.NewLambdaRegistry(0))
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,168 @@
+package frost
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+var benchmarkSizes = [][2]int{{2, 3}, {7, 10}, {34, 50}}
+
+// benchmarkSession is everything up to the partial signatures of a signing session with threshold signers.
+type benchmarkSession struct {
+	cfg                *Configuration
+	shards             []KeyShard
+	commitments        []Commitment
+	groupCommitment    BinoncePublic
+	bindingCoefficient *btcec.ModNScalar
+	finalNonce         *btcec.JacobianPoint
+	partialSigs        []PartialSignature
+	pubShards          []PublicKeyShard
+	commits            []BinoncePublic
+	message            []byte
+}
+
+func newBenchmarkSession(b *testing.B, threshold, maxSigners int) benchmarkSession {
+	secret := new(btcec.ModNScalar).SetInt(uint32(threshold*1000 + maxSigners))
+	shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+	message := sha256.Sum256([]byte("benchmark"))
+
+	cfg := &Configuration{
+		Threshold:  threshold,
+		MaxSigners: maxSigners,
+		PublicKey:  pubkey,
+	}
+	for _, shard := range shards[0:threshold] {
+		cfg.Participants = append(cfg.Participants, shard.ID)
+	}
+
+	signers := make([]*Signer, threshold)
+	commitments := make([]Commitment, threshold)
+	for i := range signers {
+		signer, err := cfg.Signer(shards[i], lambdaRegistry)
+		if err != nil {
+			b.Fatal(err)
+		}
+		signers[i] = signer
+		commitments[i] = signer.Commit("benchmark")
+	}
+	groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, message[:])
+
+	partialSigs := make([]PartialSignature, threshold)
+	pubShards := make([]PublicKeyShard, threshold)
+	commits := make([]BinoncePublic, threshold)
+	for i, signer := range signers {
+		partialSig, err := signer.Sign(message[:], groupCommitment)
+		if err != nil {
+			b.Fatal(err)
+		}
+		partialSigs[i] = partialSig
+		pubShards[i] = shards[i].PublicKeyShard
+		commits[i] = commitments[i].BinoncePublic
+	}
+
+	return benchmarkSession{
+		cfg:                cfg,
+		shards:             shards,
+		commitments:        commitments,
+		groupCommitment:    groupCommitment,
+		bindingCoefficient: bindingCoefficient,
+		finalNonce:         finalNonce,
+		partialSigs:        partialSigs,
+		pubShards:          pubShards,
+		commits:            commits,
+		message:            message[:],
+	}
+}
+
+func forEachBenchmarkSize(b *testing.B, run func(b *testing.B, threshold, maxSigners int)) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%d-of-%d", size[0], size[1]), func(b *testing.B) {
+			run(b, size[0], size[1])
+		})
+	}
+}
+
+func BenchmarkTrustedKeyDeal(b *testing.B) {
+	forEachBenchmarkSize(b, func(b *testing.B, threshold, maxSigners int) {
+		secret := new(btcec.ModNScalar).SetInt(12345)
+		for b.Loop() {
+			TrustedKeyDeal(secret, threshold, maxSigners)
+		}
+	})
+}
+
+func BenchmarkCommit(b *testing.B) {
+	session := newBenchmarkSession(b, 2, 3)
+	for b.Loop() {
+		signer, _ := session.cfg.Signer(session.shards[0], lambdaRegistry)
+		signer.Commit("benchmark")
+	}
+}
+
+func BenchmarkComputeGroupCommitment(b *testing.B) {
+	forEachBenchmarkSize(b, func(b *testing.B, threshold, maxSigners int) {
+		session := newBenchmarkSession(b, threshold, maxSigners)
+		for b.Loop() {
+			session.cfg.ComputeGroupCommitment(session.commitments, session.message)
+		}
+	})
+}
+
+func BenchmarkSign(b *testing.B) {
+	forEachBenchmarkSize(b, func(b *testing.B, threshold, maxSigners int) {
+		session := newBenchmarkSession(b, threshold, maxSigners)
+		for b.Loop() {
+			// the nonces are gone after each signature, so a new signer must commit every time
+			b.StopTimer()
+			signer, _ := session.cfg.Signer(session.shards[0], lambdaRegistry)
+			signer.Commit("benchmark")
+			b.StartTimer()
+
+			if _, err := signer.Sign(session.message, session.groupCommitment); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkVerifyPartialSignature(b *testing.B) {
+	forEachBenchmarkSize(b, func(b *testing.B, threshold, maxSigners int) {
+		session := newBenchmarkSession(b, threshold, maxSigners)
+		for b.Loop() {
+			for i, partialSig := range session.partialSigs {
+				if err := session.cfg.VerifyPartialSignature(session.pubShards[i], session.commits[i],
+					session.bindingCoefficient, session.finalNonce, partialSig, session.message, lambdaRegistry,
+				); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkVerifyPartialSignatures(b *testing.B) {
+	forEachBenchmarkSize(b, func(b *testing.B, threshold, maxSigners int) {
+		session := newBenchmarkSession(b, threshold, maxSigners)
+		for b.Loop() {
+			if err := session.cfg.VerifyPartialSignatures(session.pubShards, session.commits,
+				session.bindingCoefficient, session.finalNonce, session.partialSigs, session.message, lambdaRegistry,
+			); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAggregateSignatures(b *testing.B) {
+	forEachBenchmarkSize(b, func(b *testing.B, threshold, maxSigners int) {
+		session := newBenchmarkSession(b, threshold, maxSigners)
+		for b.Loop() {
+			if _, err := session.cfg.AggregateSignatures(session.finalNonce, session.partialSigs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
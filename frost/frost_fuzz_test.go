@@ -1,16 +1,23 @@
 package frost
 
 import (
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math/rand/v2"
 	"slices"
+	"sync"
 	"testing"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
-var lambdaRegistry = make(LambdaRegistry)
+var lambdaRegistry = NewLambdaRegistry(0)
 
 func FuzzFrostTrustedKeyDealAndSigning(f *testing.F) {
 	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 0, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc1})
@@ -96,10 +103,24 @@ func FuzzFrostTrustedKeyDealAndSigning(f *testing.F) {
 			Participants: participants,
 		}
 
+		// every shard must be consistent with the commitments, and only its own secret must be
+		for i, shard := range shards {
+			if err := cfg.ValidatePublicKeyShard(shard.PublicKeyShard); err != nil {
+				t.Fatalf("shard %d public key doesn't match the commitments: %v", i, err)
+			}
+			if err := cfg.VerifySecretShare(shard.ID, shard.Secret, commits); err != nil {
+				t.Fatalf("shard %d secret doesn't match the commitments: %v", i, err)
+			}
+			wrong := new(btcec.ModNScalar).Add2(shard.Secret, new(btcec.ModNScalar).SetInt(1))
+			if err := cfg.VerifySecretShare(shard.ID, wrong, commits); err == nil {
+				t.Fatalf("tampered shard %d was accepted", i)
+			}
+		}
+
 		// create signers
 		signers := make([]*Signer, threshold)
 		for i := 0; i < threshold; i++ {
-			signer, err := cfg.Signer(shards[i], make(LambdaRegistry))
+			signer, err := cfg.Signer(shards[i], NewLambdaRegistry(0))
 			if err != nil {
 				t.Fatalf("failed to create signer %d: %v", i, err)
 			}
@@ -133,6 +154,11 @@ func FuzzFrostTrustedKeyDealAndSigning(f *testing.F) {
 				t.Fatalf("failed to sign with signer %d: %v", i, err)
 			}
 			partialSigs[i] = partialSig
+
+			// the nonces are gone, so signing again must fail
+			if _, err := signer.Sign(messageBytes, groupCommitment); err == nil {
+				t.Fatalf("signer %d signed twice with the same nonces", i)
+			}
 		}
 
 		// verify each partial signature
@@ -221,3 +247,2604 @@ func testEncodingDecoding(t *testing.T, shard KeyShard, commitment Commitment, p
 		t.Fatal("configuration threshold mismatch after encoding/decoding")
 	}
 }
+
+func FuzzFrostDKGAndSigning(f *testing.F) {
+	f.Add(3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 0)
+
+	f.Fuzz(func(t *testing.T,
+		threshold,
+		maxSigners int,
+		messageBytes []byte,
+		seed int,
+	) {
+		if len(messageBytes) != 32 {
+			t.Skip("message must be 32 bytes")
+		}
+		if threshold < 1 || threshold > 10 {
+			t.Skip("threshold must be between 1 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+		context := []byte("dkg-test-session")
+
+		// round 1: everybody commits
+		participants := make([]*DKGParticipant, maxSigners)
+		dkgCommitments := make([]DKGCommitment, maxSigners)
+		for i := range maxSigners {
+			p, com, err := NewDKGParticipant(i+1, threshold, maxSigners, context)
+			if err != nil {
+				t.Fatalf("failed to create dkg participant %d: %v", i+1, err)
+			}
+			participants[i] = p
+
+			// commitments go through the wire
+			decoded := DKGCommitment{}
+			if err := decoded.DecodeHex(com.Hex()); err != nil {
+				t.Fatalf("failed to decode dkg commitment: %v", err)
+			}
+			dkgCommitments[i] = decoded
+		}
+
+		// a proof of knowledge must not be valid in another session
+		if err := ValidateDKGCommitment(dkgCommitments[0], threshold, maxSigners, []byte("other")); err == nil {
+			t.Fatal("proof of knowledge was accepted for another context")
+		}
+
+		// round 2: everybody sends shares to everybody else
+		shards := make([]KeyShard, maxSigners)
+		for i, p := range participants {
+			shares := make([]DKGShare, 0, maxSigners-1)
+			for _, other := range participants {
+				if other.ID == p.ID {
+					continue
+				}
+				share := DKGShare{}
+				if err := share.DecodeHex(other.Share(p.ID).Hex()); err != nil {
+					t.Fatalf("failed to decode dkg share: %v", err)
+				}
+				shares = append(shares, share)
+			}
+
+			shard, err := p.Finalize(dkgCommitments, shares)
+			if err != nil {
+				t.Fatalf("participant %d failed to finalize: %v", p.ID, err)
+			}
+			shards[i] = shard
+		}
+
+		pubkey, vss, _ := AggregateDKGCommitments(dkgCommitments)
+		if pubkey.Y.IsOdd() {
+			t.Fatal("dkg produced a pubkey with odd y")
+		}
+		for _, shard := range shards {
+			if err := vss.VerifyShare(shard.ID, shard.Secret); err != nil {
+				t.Fatalf("final shard doesn't match the group commitment: %v", err)
+			}
+		}
+
+		// a tampered share must be rejected
+		if maxSigners > 1 {
+			bad := participants[1].Share(participants[0].ID)
+			bad.Value.Add(new(btcec.ModNScalar).SetInt(1))
+			shares := []DKGShare{bad}
+			for _, other := range participants[2:] {
+				shares = append(shares, other.Share(participants[0].ID))
+			}
+			if _, err := participants[0].Finalize(dkgCommitments, shares); err == nil {
+				t.Fatal("tampered share was accepted")
+			}
+		}
+
+		// sign with a random subset
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+		chosen := shards[0:threshold]
+		slices.SortFunc(chosen, func(a, b KeyShard) int { return a.ID - b.ID })
+
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: make([]int, threshold),
+		}
+		for i, shard := range chosen {
+			cfg.Participants[i] = shard.ID
+		}
+
+		signers := make([]*Signer, threshold)
+		commitments := make([]Commitment, threshold)
+		for i, shard := range chosen {
+			signer, err := cfg.Signer(shard, NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer %d: %v", i, err)
+			}
+			signers[i] = signer
+			commitments[i] = signer.Commit("dkg-session")
+		}
+
+		groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, messageBytes)
+
+		partialSigs := make([]PartialSignature, threshold)
+		for i, signer := range signers {
+			partialSig, err := signer.Sign(messageBytes, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign with signer %d: %v", i, err)
+			}
+			if err := cfg.VerifyPartialSignature(
+				signer.KeyShard.PublicKeyShard,
+				commitments[i].BinoncePublic,
+				bindingCoefficient,
+				finalNonce,
+				partialSig,
+				messageBytes,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature %d verification failed: %v", i, err)
+			}
+			partialSigs[i] = partialSig
+		}
+
+		signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+
+		pk, err := schnorr.ParsePubKey(pubkey.X.Bytes()[:])
+		if err != nil {
+			t.Fatalf("failed to parse public key: %v", err)
+		}
+		if !signature.Verify(messageBytes, pk) {
+			t.Fatal("final signature verification failed")
+		}
+	})
+}
+
+func FuzzFrostRefresh(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 0)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		messageBytes []byte,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if len(messageBytes) != 32 {
+			t.Skip("message must be 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+
+		// everybody commits
+		participants := make([]*RefreshParticipant, maxSigners)
+		refreshCommitments := make([]RefreshCommitment, maxSigners)
+		for i, shard := range shards {
+			p, com, err := NewRefreshParticipant(shard.ID, threshold, maxSigners)
+			if err != nil {
+				t.Fatalf("failed to create refresh participant: %v", err)
+			}
+			participants[i] = p
+
+			decoded := RefreshCommitment{}
+			if err := decoded.DecodeHex(com.Hex()); err != nil {
+				t.Fatalf("failed to decode refresh commitment: %v", err)
+			}
+			refreshCommitments[i] = decoded
+		}
+
+		// everybody refreshes
+		refreshed := make([]KeyShard, maxSigners)
+		for i, p := range participants {
+			shares := make([]DKGShare, 0, maxSigners-1)
+			for _, other := range participants {
+				if other.ID != p.ID {
+					shares = append(shares, other.Share(p.ID))
+				}
+			}
+
+			newShard, err := p.Refresh(shards[i], refreshCommitments, shares)
+			if err != nil {
+				t.Fatalf("failed to refresh %d: %v", p.ID, err)
+			}
+			if newShard.Secret.Equals(shards[i].Secret) {
+				t.Fatalf("shard %d didn't change", p.ID)
+			}
+
+			expected := RefreshPublicKeyShard(shards[i].PublicKeyShard, refreshCommitments)
+			if expected.Hex() != newShard.PublicKeyShard.Hex() {
+				t.Fatalf("public shard computed from the outside doesn't match for %d", p.ID)
+			}
+			refreshed[i] = newShard
+		}
+
+		sign := func(chosen []KeyShard) bool {
+			chosen = slices.Clone(chosen)
+			slices.SortFunc(chosen, func(a, b KeyShard) int { return a.ID - b.ID })
+			cfg := &Configuration{
+				Threshold:    threshold,
+				MaxSigners:   maxSigners,
+				PublicKey:    pubkey,
+				Participants: make([]int, len(chosen)),
+			}
+			for i, shard := range chosen {
+				cfg.Participants[i] = shard.ID
+			}
+
+			signers := make([]*Signer, len(chosen))
+			commitments := make([]Commitment, len(chosen))
+			for i, shard := range chosen {
+				signer, err := cfg.Signer(shard, NewLambdaRegistry(0))
+				if err != nil {
+					t.Fatalf("failed to create signer: %v", err)
+				}
+				signers[i] = signer
+				commitments[i] = signer.Commit("refresh")
+			}
+			groupCommitment, _, finalNonce := cfg.ComputeGroupCommitment(commitments, messageBytes)
+			partialSigs := make([]PartialSignature, len(chosen))
+			for i, signer := range signers {
+				partialSig, err := signer.Sign(messageBytes, groupCommitment)
+				if err != nil {
+					t.Fatalf("failed to sign: %v", err)
+				}
+				partialSigs[i] = partialSig
+			}
+			signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+			if err != nil {
+				t.Fatalf("failed to aggregate signatures: %v", err)
+			}
+			pk, _ := schnorr.ParsePubKey(pubkey.X.Bytes()[:])
+			return signature.Verify(messageBytes, pk)
+		}
+
+		order := rnd.Perm(maxSigners)
+		chosenNew := make([]KeyShard, threshold)
+		chosenMixed := make([]KeyShard, threshold)
+		for i := range threshold {
+			chosenNew[i] = refreshed[order[i]]
+			chosenMixed[i] = refreshed[order[i]]
+		}
+		chosenMixed[0] = shards[order[0]]
+
+		if !sign(chosenNew) {
+			t.Fatal("signature with refreshed shards failed")
+		}
+		if sign(chosenMixed) {
+			t.Fatal("signature mixing old and refreshed shards worked")
+		}
+	})
+}
+
+func FuzzFrostReshare(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 2, 3, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 0)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners,
+		newThreshold,
+		newMaxSigners int,
+		messageBytes []byte,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if len(messageBytes) != 32 {
+			t.Skip("message must be 32 bytes")
+		}
+		if threshold < 1 || threshold > 10 || newThreshold < 1 || newThreshold > 10 {
+			t.Skip("thresholds must be between 1 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 || newMaxSigners < newThreshold || newMaxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+
+		// a random threshold of the old signers act as dealers
+		order := rnd.Perm(maxSigners)
+		participants := make([]int, threshold)
+		for i := range threshold {
+			participants[i] = shards[order[i]].ID
+		}
+
+		dealers := make([]*ReshareDealer, threshold)
+		commitments := make([]ReshareCommitment, threshold)
+		for i, id := range participants {
+			shard := shards[id-1]
+			d, com, err := NewReshareDealer(shard, participants, newThreshold)
+			if err != nil {
+				t.Fatalf("failed to create dealer: %v", err)
+			}
+			dealers[i] = d
+
+			decoded := ReshareCommitment{}
+			if err := decoded.DecodeHex(com.Hex()); err != nil {
+				t.Fatalf("failed to decode reshare commitment: %v", err)
+			}
+			if err := ValidateReshareCommitment(decoded, shard.PublicKeyShard, participants, newThreshold); err != nil {
+				t.Fatalf("reshare commitment from %d is invalid: %v", id, err)
+			}
+			commitments[i] = decoded
+		}
+
+		// a dealer claiming to be someone else must be caught
+		if threshold > 1 {
+			other := shards[participants[1]-1].PublicKeyShard
+			lying := ReshareCommitment{SignerID: other.ID, VssCommitment: commitments[0].VssCommitment}
+			if err := ValidateReshareCommitment(lying, other, participants, newThreshold); err == nil {
+				t.Fatal("reshare commitment with somebody else's public shard was accepted")
+			}
+		}
+
+		// all the new signers get their shards
+		vss := AggregateReshareCommitments(commitments)
+		newShards := make([]KeyShard, newMaxSigners)
+		for j := range newMaxSigners {
+			shares := make([]DKGShare, threshold)
+			for i, d := range dealers {
+				shares[i] = d.Share(j + 1)
+			}
+
+			shard, err := FinalizeReshare(j+1, pubkey, commitments, shares)
+			if err != nil {
+				t.Fatalf("failed to finalize reshare for %d: %v", j+1, err)
+			}
+			if shard.PublicKeyShard.Hex() != vss.PublicKeyShard(j+1).Hex() {
+				t.Fatalf("public shard computed from the outside doesn't match for %d", j+1)
+			}
+			newShards[j] = shard
+		}
+
+		// any new threshold of them can sign
+		newOrder := rnd.Perm(newMaxSigners)
+		cfg := &Configuration{
+			Threshold:    newThreshold,
+			MaxSigners:   newMaxSigners,
+			PublicKey:    pubkey,
+			Participants: make([]int, newThreshold),
+		}
+		signers := make([]*Signer, newThreshold)
+		signingCommitments := make([]Commitment, newThreshold)
+		for i := range newThreshold {
+			shard := newShards[newOrder[i]]
+			cfg.Participants[i] = shard.ID
+			signer, err := cfg.Signer(shard, NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer: %v", err)
+			}
+			signers[i] = signer
+			signingCommitments[i] = signer.Commit("reshare")
+		}
+		groupCommitment, _, finalNonce := cfg.ComputeGroupCommitment(signingCommitments, messageBytes)
+		partialSigs := make([]PartialSignature, newThreshold)
+		for i, signer := range signers {
+			partialSig, err := signer.Sign(messageBytes, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			partialSigs[i] = partialSig
+		}
+		signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+		pk, _ := schnorr.ParsePubKey(pubkey.X.Bytes()[:])
+		if !signature.Verify(messageBytes, pk) {
+			t.Fatal("signature with reshared shards failed")
+		}
+	})
+}
+
+func FuzzFrostRepair(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, 0)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if threshold < 1 || threshold > 10 {
+			t.Skip("threshold must be between 1 and 10")
+		}
+		if maxSigners <= threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be > threshold and <= 10")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+
+		// one signer loses its shard and a threshold of the others help
+		order := rnd.Perm(maxSigners)
+		lost := shards[order[0]]
+		helpers := make([]int, threshold)
+		for i := range threshold {
+			helpers[i] = shards[order[i+1]].ID
+		}
+
+		if _, err := RepairShareStep1(lost, append(slices.Clone(helpers), lost.ID), lost.ID); err == nil {
+			t.Fatal("signer was allowed to help repairing itself")
+		}
+
+		pieces := make([][]DKGShare, threshold)
+		for i, id := range helpers {
+			deltas, err := RepairShareStep1(shards[id-1], helpers, lost.ID)
+			if err != nil {
+				t.Fatalf("step 1 failed for %d: %v", id, err)
+			}
+			pieces[i] = deltas
+		}
+
+		sigmas := make([]DKGShare, threshold)
+		for j, id := range helpers {
+			received := make([]DKGShare, threshold)
+			for i := range helpers {
+				received[i] = pieces[i][j]
+			}
+			sigma, err := RepairShareStep2(id, helpers, lost.ID, received)
+			if err != nil {
+				t.Fatalf("step 2 failed for %d: %v", id, err)
+			}
+			sigmas[j] = sigma
+		}
+
+		repaired, err := RepairShareStep3(lost.PublicKeyShard, pubkey, helpers, sigmas)
+		if err != nil {
+			t.Fatalf("step 3 failed: %v", err)
+		}
+		if !repaired.Secret.Equals(lost.Secret) {
+			t.Fatal("repaired shard is different from the lost one")
+		}
+
+		// a helper that cheats is caught
+		sigmas[0].Value = new(btcec.ModNScalar).Add2(sigmas[0].Value, new(btcec.ModNScalar).SetInt(1))
+		if _, err := RepairShareStep3(lost.PublicKeyShard, pubkey, helpers, sigmas); err == nil {
+			t.Fatal("tampered repair was accepted")
+		}
+	})
+}
+
+func FuzzFrostReconstruct(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, 0)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if threshold < 1 || threshold > 10 {
+			t.Skip("threshold must be between 1 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		original := new(btcec.ModNScalar)
+		if overflow := original.SetByteSlice(secretKeyBytes); overflow || original.IsZero() {
+			t.Skip("invalid secret key")
+		}
+		originalPubkey := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(original, originalPubkey)
+		originalPubkey.ToAffine()
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, _, _ := TrustedKeyDeal(new(btcec.ModNScalar).Set(original), threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+
+		// any quorum works
+		quorum := threshold + rnd.IntN(maxSigners-threshold+1)
+		secret, err := ReconstructSecret(threshold, shards[:quorum])
+		if err != nil {
+			t.Fatalf("failed to reconstruct from %d shards: %v", quorum, err)
+		}
+
+		expected := new(btcec.ModNScalar).Set(original)
+		if originalPubkey.Y.IsOdd() {
+			expected.Negate()
+		}
+		if !secret.Equals(expected) {
+			t.Fatal("reconstructed secret is not the original one")
+		}
+
+		// less than that doesn't
+		if _, err := ReconstructSecret(threshold, shards[:threshold-1]); err == nil {
+			t.Fatal("reconstructed from less than threshold shards")
+		}
+		if threshold > 1 {
+			if _, err := ReconstructSecret(threshold-1, shards[:threshold-1]); err == nil {
+				t.Fatal("reconstructed with a lower threshold")
+			}
+		}
+
+		// and a shard that was tampered with is caught
+		shards[0].Secret = new(btcec.ModNScalar).Add2(shards[0].Secret, new(btcec.ModNScalar).SetInt(1))
+		if _, err := ReconstructSecret(threshold, shards[:quorum]); err == nil {
+			t.Fatal("reconstructed from a tampered shard")
+		}
+	})
+}
+
+func FuzzFrostPreprocessedSigning(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 8, 0)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		messageBytes []byte,
+		batchSize int,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if len(messageBytes) != 32 {
+			t.Skip("message must be 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+		if batchSize < 1 || batchSize > 20 {
+			t.Skip("batch size must be between 1 and 20")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+		shards = shards[:threshold]
+		slices.SortFunc(shards, func(a, b KeyShard) int { return a.ID - b.ID })
+
+		// everybody generates their batch ahead of time, it goes through the wire
+		pools := make([]*NoncePool, threshold)
+		batches := make([]CommitmentList, threshold)
+		for i, shard := range shards {
+			pools[i] = NewNoncePool(batchSize)
+			batch := pools[i].Generate(shard, "batch", batchSize)
+			if pools[i].Len() != batchSize {
+				t.Fatalf("pool %d has %d nonces, expected %d", i, pools[i].Len(), batchSize)
+			}
+
+			decoded := CommitmentList{}
+			if err := decoded.DecodeHex(batch.Hex()); err != nil {
+				t.Fatalf("failed to decode batch %d: %v", i, err)
+			}
+			if len(decoded) != batchSize || decoded.Hex() != batch.Hex() {
+				t.Fatalf("batch %d changed after encoding/decoding", i)
+			}
+			batches[i] = decoded
+		}
+
+		participants := make([]int, threshold)
+		for i, shard := range shards {
+			participants[i] = shard.ID
+		}
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: participants,
+		}
+
+		// the coordinator picks any commitment from each batch and the message, then it's a single round
+		idx := rnd.IntN(batchSize)
+		commitments := make([]Commitment, threshold)
+		for i := range shards {
+			commitments[i] = batches[i][idx]
+		}
+		if err := cfg.ValidateCommitmentList(commitments); err != nil {
+			t.Fatalf("invalid commitment list: %v", err)
+		}
+
+		signers := make([]*Signer, threshold)
+		for i, shard := range shards {
+			signer, err := cfg.Signer(shard, NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer %d: %v", i, err)
+			}
+			if err := signer.UsePreprocessed(pools[i], commitments[i]); err != nil {
+				t.Fatalf("signer %d failed to use preprocessed nonces: %v", i, err)
+			}
+			signers[i] = signer
+		}
+
+		groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, messageBytes)
+
+		partialSigs := make([]PartialSignature, threshold)
+		for i, signer := range signers {
+			partialSig, err := signer.Sign(messageBytes, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign with signer %d: %v", i, err)
+			}
+			if err := cfg.VerifyPartialSignature(
+				shards[i].PublicKeyShard,
+				commitments[i].BinoncePublic,
+				bindingCoefficient,
+				finalNonce,
+				partialSig,
+				messageBytes,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature %d verification failed: %v", i, err)
+			}
+			partialSigs[i] = partialSig
+		}
+
+		signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+		pk, err := schnorr.ParsePubKey(pubkey.X.Bytes()[:])
+		if err != nil {
+			t.Fatalf("failed to parse public key: %v", err)
+		}
+		if !signature.Verify(messageBytes, pk) {
+			t.Fatal("final signature verification failed")
+		}
+
+		// nonces can't be used twice, or by someone else
+		for i, signer := range signers {
+			if pools[i].Len() != batchSize-1 {
+				t.Fatalf("pool %d still has %d nonces after signing", i, pools[i].Len())
+			}
+			if err := signer.UsePreprocessed(pools[i], commitments[i]); err == nil {
+				t.Fatalf("signer %d reused a nonce", i)
+			}
+		}
+		other := batches[1][(idx+1)%batchSize]
+		if err := signers[0].UsePreprocessed(pools[0], other); err == nil {
+			t.Fatal("signer used a commitment from someone else")
+		}
+
+		// the oldest nonces are forgotten as new ones come
+		pools[0].Generate(shards[0], "next", batchSize)
+		if pools[0].Len() != batchSize {
+			t.Fatalf("pool has %d nonces, expected at most %d", pools[0].Len(), batchSize)
+		}
+	})
+}
+
+func FuzzFrostTweakedSigning(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, uint32(0), 0)
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 2, 3, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, uint32(7), 1)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		messageBytes []byte,
+		index uint32,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if len(messageBytes) != 32 {
+			t.Skip("message must be 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(new(btcec.ModNScalar).Set(secret), threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+
+		participants := make([]int, threshold)
+		for i := range participants {
+			participants[i] = shards[i].ID
+		}
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: participants,
+			Tweak:        DeriveTweak(pubkey, index),
+		}
+
+		// the child key is the (even) parent secret plus the tweak
+		childSecret := new(btcec.ModNScalar).Set(secret)
+		if original := new(btcec.JacobianPoint); true {
+			btcec.ScalarBaseMultNonConst(secret, original)
+			original.ToAffine()
+			if original.Y.IsOdd() {
+				childSecret.Negate()
+			}
+		}
+		childSecret.Add(cfg.Tweak)
+		expected := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(childSecret, expected)
+		expected.ToAffine()
+
+		childKey, _ := cfg.TweakedPublicKey()
+		if !childKey.X.Equals(&expected.X) {
+			t.Fatal("tweaked public key is not the public key of the tweaked secret")
+		}
+		if childKey.Y.IsOdd() {
+			t.Fatal("tweaked public key has an odd y")
+		}
+
+		// the tweak goes through the wire with the configuration
+		decodedCfg := &Configuration{}
+		if err := decodedCfg.DecodeHex(cfg.Hex()); err != nil {
+			t.Fatalf("failed to decode configuration: %v", err)
+		}
+		if decodedCfg.Tweak == nil || !decodedCfg.Tweak.Equals(cfg.Tweak) {
+			t.Fatal("tweak lost after encoding/decoding")
+		}
+		cfg = decodedCfg
+
+		signers := make([]*Signer, threshold)
+		commitments := make([]Commitment, threshold)
+		for i := range signers {
+			signer, err := cfg.Signer(shards[i], NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer %d: %v", i, err)
+			}
+			signers[i] = signer
+			commitments[i] = signer.Commit("tweaked")
+		}
+
+		groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, messageBytes)
+
+		partialSigs := make([]PartialSignature, threshold)
+		for i, signer := range signers {
+			partialSig, err := signer.Sign(messageBytes, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign with signer %d: %v", i, err)
+			}
+			if err := cfg.VerifyPartialSignature(
+				shards[i].PublicKeyShard,
+				commitments[i].BinoncePublic,
+				bindingCoefficient,
+				finalNonce,
+				partialSig,
+				messageBytes,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature %d verification failed: %v", i, err)
+			}
+			partialSigs[i] = partialSig
+		}
+
+		signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+
+		pk, err := schnorr.ParsePubKey(childKey.X.Bytes()[:])
+		if err != nil {
+			t.Fatalf("failed to parse public key: %v", err)
+		}
+		if !signature.Verify(messageBytes, pk) {
+			t.Fatal("signature doesn't verify for the tweaked key")
+		}
+
+		parent, _ := schnorr.ParsePubKey(pubkey.X.Bytes()[:])
+		if signature.Verify(messageBytes, parent) {
+			t.Fatal("signature for the tweaked key verifies for the group key")
+		}
+	})
+}
+
+func FuzzFrostAdaptorSigning(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, []byte{0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70}, 0)
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 2, 3, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, []byte{0x71, 0x72, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x7b, 0x7c, 0x7d, 0x7e, 0x7f, 0x80, 0x81, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89, 0x8a, 0x8b, 0x8c, 0x8d, 0x8e, 0x8f, 0x90}, 1)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		messageBytes []byte,
+		adaptorSecretBytes []byte,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if len(messageBytes) != 32 {
+			t.Skip("message must be 32 bytes")
+		}
+		if len(adaptorSecretBytes) != 32 {
+			t.Skip("adaptor secret must be 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+		adaptorSecret := new(btcec.ModNScalar)
+		if overflow := adaptorSecret.SetByteSlice(adaptorSecretBytes); overflow || adaptorSecret.IsZero() {
+			t.Skip("invalid adaptor secret")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+
+		adaptor := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(adaptorSecret, adaptor)
+		adaptor.ToAffine()
+
+		participants := make([]int, threshold)
+		for i := range participants {
+			participants[i] = shards[i].ID
+		}
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: participants,
+			Adaptor:      adaptor,
+		}
+		if seed%2 == 1 {
+			cfg.Tweak = DeriveTweak(pubkey, uint32(seed))
+		}
+
+		// the adaptor goes through the wire with the configuration
+		decodedCfg := &Configuration{}
+		if err := decodedCfg.DecodeHex(cfg.Hex()); err != nil {
+			t.Fatalf("failed to decode configuration: %v", err)
+		}
+		if decodedCfg.Adaptor == nil || !decodedCfg.Adaptor.X.Equals(&adaptor.X) ||
+			!decodedCfg.Adaptor.Y.Equals(&adaptor.Y) {
+			t.Fatal("adaptor lost after encoding/decoding")
+		}
+		cfg = decodedCfg
+
+		signers := make([]*Signer, threshold)
+		commitments := make([]Commitment, threshold)
+		for i := range signers {
+			signer, err := cfg.Signer(shards[i], NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer %d: %v", i, err)
+			}
+			signers[i] = signer
+			commitments[i] = signer.Commit("adaptor")
+		}
+
+		groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, messageBytes)
+
+		// the adaptor is bound to the session, so the same commitments can't sign for R alone or for another T
+		signingKey, _ := cfg.TweakedPublicKey()
+		plainCfg := *cfg
+		plainCfg.Adaptor = nil
+		if plainCfg.bindingCoefficient(signingKey, groupCommitment, messageBytes).Equals(bindingCoefficient) {
+			t.Fatal("binding coefficient doesn't depend on the adaptor")
+		}
+		otherCfg := *cfg
+		otherCfg.Adaptor = new(btcec.JacobianPoint)
+		btcec.AddNonConst(adaptor, adaptor, otherCfg.Adaptor)
+		otherCfg.Adaptor.ToAffine()
+		if otherCfg.bindingCoefficient(signingKey, groupCommitment, messageBytes).Equals(bindingCoefficient) {
+			t.Fatal("binding coefficient is the same for another adaptor")
+		}
+
+		partialSigs := make([]PartialSignature, threshold)
+		for i, signer := range signers {
+			partialSig, err := signer.Sign(messageBytes, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign with signer %d: %v", i, err)
+			}
+			if err := cfg.VerifyPartialSignature(
+				shards[i].PublicKeyShard,
+				commitments[i].BinoncePublic,
+				bindingCoefficient,
+				finalNonce,
+				partialSig,
+				messageBytes,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature %d verification failed: %v", i, err)
+			}
+			partialSigs[i] = partialSig
+		}
+
+		preSignature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+
+		pk, err := schnorr.ParsePubKey(signingKey.X.Bytes()[:])
+		if err != nil {
+			t.Fatalf("failed to parse public key: %v", err)
+		}
+		if preSignature.Verify(messageBytes, pk) {
+			t.Fatal("pre-signature verifies without the adaptor secret")
+		}
+		if _, err := cfg.VerifyAdaptorSignature(preSignature, messageBytes); err != nil {
+			t.Fatalf("pre-signature doesn't verify as an adaptor signature: %v", err)
+		}
+		otherMessage := slices.Clone(messageBytes)
+		otherMessage[0] ^= 1
+		if _, err := cfg.VerifyAdaptorSignature(preSignature, otherMessage); err == nil {
+			t.Fatal("pre-signature verifies for another message")
+		}
+
+		// only the adaptor secret completes it
+		wrongSecret := new(btcec.ModNScalar).Add2(adaptorSecret, new(btcec.ModNScalar).SetInt(1))
+		if _, err := cfg.CompleteAdaptorSignature(preSignature, wrongSecret, messageBytes); err == nil {
+			t.Fatal("pre-signature completed with the wrong secret")
+		}
+		signature, err := cfg.CompleteAdaptorSignature(preSignature, adaptorSecret, messageBytes)
+		if err != nil {
+			t.Fatalf("failed to complete adaptor signature: %v", err)
+		}
+		if !signature.Verify(messageBytes, pk) {
+			t.Fatal("completed signature doesn't verify")
+		}
+
+		// and the secret comes out of the two
+		extracted, err := cfg.ExtractAdaptorSecret(preSignature, signature, messageBytes)
+		if err != nil {
+			t.Fatalf("failed to extract adaptor secret: %v", err)
+		}
+		if !extracted.Equals(adaptorSecret) {
+			t.Fatal("extracted adaptor secret is not the adaptor secret")
+		}
+	})
+}
+
+// TestBindingCoefficientVector pins the binding coefficient without an adaptor to what it has always been, so
+// signers that know nothing about adaptors keep agreeing with us.
+func TestBindingCoefficientVector(t *testing.T) {
+	publicKey, _ := pointFromHex("02f37c34b66ced1fb51c34a90bdae006901f10625cc06c4f64663b0eae87d87b4f")
+	hiding, _ := pointFromHex("03c699af97d26bb4d3f05232ec5e1938c12f1e6ae97643c8f8f11c9820303f1904")
+	binding, _ := pointFromHex("02fa2aaccd51b948c9dc1a325d77226e98a5a3fe65fe9ba213761a60123040a45e")
+	message, _ := hex.DecodeString("74657374")
+
+	b := computeBindingCoefficient(publicKey, BinoncePublic{hiding, binding}, message, []int{1, 3}, nil)
+	if actual := b.Bytes(); hex.EncodeToString(actual[:]) !=
+		"52ba2479a386600ff9d3292c28a28542d0cb8528c0fbf36f664f12e6cf389309" {
+		t.Fatalf("binding coefficient changed: %x", actual)
+	}
+
+	adaptor, _ := pointFromHex("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	if computeBindingCoefficient(publicKey, BinoncePublic{hiding, binding}, message, []int{1, 3}, adaptor).Equals(b) {
+		t.Fatal("binding coefficient is the same with an adaptor")
+	}
+}
+
+func FuzzFrostNestedSigning(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 0)
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 2, 3, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 5)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		messageBytes []byte,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if len(messageBytes) != 32 {
+			t.Skip("message must be 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+
+		participants := make([]int, threshold)
+		for i := range participants {
+			participants[i] = shards[i].ID
+		}
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: participants,
+		}
+		if seed%2 == 1 {
+			cfg.Tweak = DeriveTweak(pubkey, uint32(seed))
+		}
+
+		// the first participant is a sub-committee, some of whose members will sign for it
+		parent := shards[0]
+		subThreshold := 1 + rnd.IntN(3)
+		subMembers := subThreshold + rnd.IntN(3)
+		subShards, err := SplitKeyShard(parent, subThreshold, subMembers)
+		if err != nil {
+			t.Fatalf("failed to split shard: %v", err)
+		}
+		for i, sub := range subShards {
+			// sub-shards go through the wire
+			var decoded SubShard
+			if err := decoded.DecodeHex(sub.Hex()); err != nil {
+				t.Fatalf("failed to decode sub-shard %d: %v", i, err)
+			}
+			if !slices.Equal(decoded.Encode(), sub.Encode()) {
+				t.Fatalf("sub-shard %d changed after decoding", i)
+			}
+			subShards[i] = decoded
+		}
+
+		rnd.Shuffle(len(subShards), func(i, j int) {
+			subShards[i], subShards[j] = subShards[j], subShards[i]
+		})
+		signing := subShards[0 : subThreshold+rnd.IntN(subMembers-subThreshold+1)]
+		members := make([]int, len(signing))
+		for i, sub := range signing {
+			members[i] = sub.ID
+		}
+		committee := signing[0].Committee(subMembers, members)
+
+		// fewer members than the threshold can't sign
+		if subThreshold > 1 {
+			if _, err := cfg.SubSigner(signing[0], signing[0].Committee(subMembers, members[0:subThreshold-1]),
+				lambdaRegistry,
+			); err == nil {
+				t.Fatal("created a sub-signer for a sub-committee below its threshold")
+			}
+		}
+
+		memberSigners := make([]*Signer, len(signing))
+		memberCommitments := make([]Commitment, len(signing))
+		for i, sub := range signing {
+			signer, err := cfg.SubSigner(sub, committee, NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create sub-signer %d: %v", i, err)
+			}
+			memberSigners[i] = signer
+			memberCommitments[i] = signer.Commit("nested")
+		}
+
+		signers := make([]*Signer, threshold)
+		commitments := make([]Commitment, threshold)
+		commitments[0] = AggregateSubCommitments(parent.ID, memberCommitments)
+		for i := 1; i < threshold; i++ {
+			signer, err := cfg.Signer(shards[i], NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer %d: %v", i, err)
+			}
+			signers[i] = signer
+			commitments[i] = signer.Commit("nested")
+		}
+
+		groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, messageBytes)
+
+		memberSigs := make([]PartialSignature, len(signing))
+		for i, signer := range memberSigners {
+			partialSig, err := signer.Sign(messageBytes, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign with member %d: %v", i, err)
+			}
+			if partialSig.SignerIdentifier != signing[i].ID {
+				t.Fatalf("member %d signed as %d", signing[i].ID, partialSig.SignerIdentifier)
+			}
+			if err := cfg.VerifySubPartialSignature(
+				parent.PublicKeyShard,
+				committee,
+				signing[i].PublicKeyShard,
+				memberCommitments[i].BinoncePublic,
+				groupCommitment,
+				bindingCoefficient,
+				partialSig,
+				messageBytes,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature from member %d verification failed: %v", i, err)
+			}
+
+			bad := PartialSignature{
+				SignerIdentifier: partialSig.SignerIdentifier,
+				Value:            new(btcec.ModNScalar).Add2(partialSig.Value, new(btcec.ModNScalar).SetInt(1)),
+			}
+			if err := cfg.VerifySubPartialSignature(
+				parent.PublicKeyShard,
+				committee,
+				signing[i].PublicKeyShard,
+				memberCommitments[i].BinoncePublic,
+				groupCommitment,
+				bindingCoefficient,
+				bad,
+				messageBytes,
+				lambdaRegistry,
+			); err == nil {
+				t.Fatalf("bad partial signature from member %d verified", i)
+			}
+
+			memberSigs[i] = partialSig
+		}
+
+		// what the members signed is the partial signature of the sub-committee, for the rest of the group
+		partialSigs := make([]PartialSignature, threshold)
+		partialSigs[0], err = AggregateSubSignatures(parent.ID, memberSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate the members' partial signatures: %v", err)
+		}
+		for i := 1; i < threshold; i++ {
+			partialSig, err := signers[i].Sign(messageBytes, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign with signer %d: %v", i, err)
+			}
+			partialSigs[i] = partialSig
+		}
+		for i, partialSig := range partialSigs {
+			if err := cfg.VerifyPartialSignature(
+				shards[i].PublicKeyShard,
+				commitments[i].BinoncePublic,
+				bindingCoefficient,
+				finalNonce,
+				partialSig,
+				messageBytes,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature %d verification failed: %v", i, err)
+			}
+		}
+
+		signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+
+		signingKey, _ := cfg.TweakedPublicKey()
+		pk, err := schnorr.ParsePubKey(signingKey.X.Bytes()[:])
+		if err != nil {
+			t.Fatalf("failed to parse public key: %v", err)
+		}
+		if !signature.Verify(messageBytes, pk) {
+			t.Fatal("signature doesn't verify")
+		}
+	})
+}
+
+func FuzzFrostTaprootSigning(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, []byte{}, 0)
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 2, 3, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, []byte{0xd1, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xdb, 0xdc, 0xdd, 0xde, 0xdf, 0xe0, 0xe1, 0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xeb, 0xec, 0xed, 0xee, 0xef, 0xf0}, 1)
+	f.Add([]byte{0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70}, 4, 7, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, []byte{0xd1, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xdb, 0xdc, 0xdd, 0xde, 0xdf, 0xe0, 0xe1, 0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xeb, 0xec, 0xed, 0xee, 0xef, 0xf0}, 2)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		sighash []byte,
+		merkleRoot []byte,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if len(sighash) != 32 {
+			t.Skip("sighash must be 32 bytes")
+		}
+		if len(merkleRoot) != 0 && len(merkleRoot) != 32 {
+			t.Skip("merkle root must be empty or 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+
+		participants := make([]int, threshold)
+		for i := range participants {
+			participants[i] = shards[i].ID
+		}
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: participants,
+		}
+		if err := cfg.UseTaproot(merkleRoot); err != nil {
+			t.Fatalf("failed to set taproot tweak: %v", err)
+		}
+
+		// compute the output key independently, as in BIP-341's taproot_tweak_pubkey
+		tweakHash := chainhash.TaggedHash(chainhash.TagTapTweak, pubkey.X.Bytes()[:], merkleRoot)
+		tweak := new(btcec.ModNScalar)
+		tweak.SetBytes((*[32]byte)(tweakHash))
+		expected := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(tweak, expected)
+		btcec.AddNonConst(pubkey, expected, expected)
+		expected.ToAffine()
+
+		outputKey, _ := cfg.TweakedPublicKey()
+		if !outputKey.X.Equals(&expected.X) {
+			t.Fatal("output key doesn't match BIP-341")
+		}
+
+		signers := make([]*Signer, threshold)
+		commitments := make([]Commitment, threshold)
+		for i := range signers {
+			signer, err := cfg.Signer(shards[i], NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer %d: %v", i, err)
+			}
+			signers[i] = signer
+			commitments[i] = signer.Commit("taproot")
+		}
+
+		groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, sighash)
+
+		partialSigs := make([]PartialSignature, threshold)
+		for i, signer := range signers {
+			partialSig, err := signer.Sign(sighash, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign with signer %d: %v", i, err)
+			}
+			if err := cfg.VerifyPartialSignature(
+				shards[i].PublicKeyShard,
+				commitments[i].BinoncePublic,
+				bindingCoefficient,
+				finalNonce,
+				partialSig,
+				sighash,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature %d verification failed: %v", i, err)
+			}
+			partialSigs[i] = partialSig
+		}
+
+		signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+
+		pk, err := schnorr.ParsePubKey(expected.X.Bytes()[:])
+		if err != nil {
+			t.Fatalf("failed to parse output key: %v", err)
+		}
+		if !signature.Verify(sighash, pk) {
+			t.Fatal("key-path signature doesn't verify for the output key")
+		}
+
+		internal, _ := schnorr.ParsePubKey(pubkey.X.Bytes()[:])
+		if signature.Verify(sighash, internal) {
+			t.Fatal("key-path signature verifies for the internal key")
+		}
+	})
+}
+
+func FuzzFrostECDH(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 0)
+	f.Add([]byte{0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70}, 2, 2, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 1)
+
+	f.Fuzz(func(t *testing.T,
+		secretKeyBytes []byte,
+		threshold,
+		maxSigners int,
+		otherKeyBytes []byte,
+		seed int,
+	) {
+		if len(secretKeyBytes) != 32 || len(otherKeyBytes) != 32 {
+			t.Skip("keys must be 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+		other := new(btcec.ModNScalar)
+		if overflow := other.SetByteSlice(otherKeyBytes); overflow || other.IsZero() {
+			t.Skip("invalid other key")
+		}
+		otherPubKey := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(other, otherPubKey)
+		otherPubKey.ToAffine()
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+
+		participants := make([]int, threshold)
+		publicShards := make([]PublicKeyShard, threshold)
+		for i := range participants {
+			participants[i] = shards[i].ID
+			publicShards[i] = shards[i].PublicKeyShard
+		}
+		slices.Sort(participants)
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: participants,
+		}
+
+		shares := make([]ECDHShare, threshold)
+		for i := range shares {
+			shares[i] = cfg.CreateECDHShare(shards[i], otherPubKey, lambdaRegistry)
+
+			// shares go through the wire
+			decoded := ECDHShare{}
+			if err := decoded.DecodeHex(shares[i].Hex()); err != nil {
+				t.Fatalf("failed to decode ecdh share %d: %v", i, err)
+			}
+			shares[i] = decoded
+		}
+
+		ecdh, err := cfg.AggregateECDHShards(otherPubKey, shares, publicShards, lambdaRegistry)
+		if err != nil {
+			t.Fatalf("failed to aggregate ecdh shares: %v", err)
+		}
+
+		expected := new(btcec.JacobianPoint)
+		btcec.ScalarMultNonConst(other, pubkey, expected)
+		expected.ToAffine()
+		if !ecdh.X.Equals(&expected.X) || !ecdh.Y.Equals(&expected.Y) {
+			t.Fatal("aggregated ecdh doesn't match the shared secret")
+		}
+
+		// the same shares give the shared secret of a derived key
+		tweakedCfg := *cfg
+		tweakedCfg.Tweak = DeriveTweak(pubkey, uint32(seed))
+		tweakedECDH, err := tweakedCfg.AggregateECDHShards(otherPubKey, shares, publicShards, lambdaRegistry)
+		if err != nil {
+			t.Fatalf("failed to aggregate tweaked ecdh shares: %v", err)
+		}
+		childKey, _ := tweakedCfg.TweakedPublicKey()
+		expected = new(btcec.JacobianPoint)
+		btcec.ScalarMultNonConst(other, childKey, expected)
+		expected.ToAffine()
+		if !tweakedECDH.X.Equals(&expected.X) || !tweakedECDH.Y.Equals(&expected.Y) {
+			t.Fatal("tweaked ecdh doesn't match the shared secret of the derived key")
+		}
+
+		// a share made with the wrong secret must be caught and blamed on who sent it
+		bad := rnd.IntN(threshold)
+		wrong := shards[bad]
+		wrong.Secret = new(btcec.ModNScalar).Set(shards[bad].Secret).Add(new(btcec.ModNScalar).SetInt(1))
+		shares[bad] = cfg.CreateECDHShare(wrong, otherPubKey, lambdaRegistry)
+
+		_, err = cfg.AggregateECDHShards(otherPubKey, shares, publicShards, lambdaRegistry)
+		var shareErr ECDHShareError
+		if !errors.As(err, &shareErr) {
+			t.Fatalf("bad ecdh share wasn't caught: %v", err)
+		}
+		if shareErr.SignerID != shards[bad].ID {
+			t.Fatalf("bad ecdh share blamed on %d instead of %d", shareErr.SignerID, shards[bad].ID)
+		}
+
+		// and so must a good share with a proof for some other point
+		shares[bad] = cfg.CreateECDHShare(shards[bad], otherPubKey, lambdaRegistry)
+		shares[bad].Point = shares[(bad+1)%threshold].Point
+		if err := cfg.VerifyECDHShare(publicShards[bad], otherPubKey, shares[bad], lambdaRegistry); err == nil {
+			t.Fatal("ecdh share with a swapped point passed verification")
+		}
+	})
+}
+
+func FuzzFrostRFC9591Signing(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, []byte("test"), 0)
+	f.Add([]byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 2, 2, []byte{}, 7)
+
+	f.Fuzz(func(t *testing.T, secretKeyBytes []byte, threshold, maxSigners int, messageBytes []byte, seed int) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) {
+			shards[i], shards[j] = shards[j], shards[i]
+		})
+
+		// the commitment list must be sorted, so the participants are too
+		participants := make([]int, threshold)
+		for i := range participants {
+			participants[i] = shards[i].ID
+		}
+		slices.Sort(participants)
+
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: participants,
+			Ciphersuite:  CiphersuiteRFC9591,
+		}
+
+		signers := make([]*Signer, threshold)
+		commitments := make([]Commitment, threshold)
+		for i, id := range participants {
+			shard := shards[slices.IndexFunc(shards, func(s KeyShard) bool { return s.ID == id })]
+			signer, err := cfg.Signer(shard, NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer %d: %v", id, err)
+			}
+			signers[i] = signer
+			commitments[i] = signer.Commit("")
+		}
+
+		bindingFactors, groupCommitment, err := cfg.ComputeBindingFactorsRFC9591(commitments, messageBytes)
+		if err != nil {
+			t.Fatalf("failed to compute binding factors: %v", err)
+		}
+
+		partialSigs := make([]PartialSignature, threshold)
+		for i, signer := range signers {
+			// this is not a BIP-340 configuration
+			if _, err := signer.Sign(messageBytes, BinoncePublic{groupCommitment, groupCommitment}); err == nil {
+				t.Fatalf("signer %d signed with the wrong ciphersuite", i)
+			}
+
+			partialSig, err := signer.SignRFC9591(messageBytes, commitments)
+			if err != nil {
+				t.Fatalf("failed to sign with signer %d: %v", i, err)
+			}
+			if _, err := signer.SignRFC9591(messageBytes, commitments); err == nil {
+				t.Fatalf("signer %d signed twice with the same nonces", i)
+			}
+
+			if err := cfg.VerifyPartialSignatureRFC9591(
+				signer.KeyShard.PublicKeyShard,
+				commitments[i].BinoncePublic,
+				bindingFactors[signer.KeyShard.ID],
+				groupCommitment,
+				partialSig,
+				messageBytes,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature %d verification failed: %v", i, err)
+			}
+			partialSigs[i] = partialSig
+		}
+
+		signature, err := cfg.AggregateSignaturesRFC9591(groupCommitment, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+		if !signature.Verify(messageBytes, pubkey) {
+			t.Fatal("final signature verification failed")
+		}
+		if signature.Verify(append(slices.Clone(messageBytes), 0), pubkey) {
+			t.Fatal("final signature verified for another message")
+		}
+
+		// and a BIP-340 configuration won't do any of this
+		cfg.Ciphersuite = CiphersuiteBIP340
+		if _, _, err := cfg.ComputeBindingFactorsRFC9591(commitments, messageBytes); err == nil {
+			t.Fatal("computed RFC 9591 binding factors for a BIP-340 configuration")
+		}
+	})
+}
+
+func FuzzMuSig2Signing(f *testing.F) {
+	f.Add(2, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, uint32(0), false, 0)
+	f.Add(3, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, uint32(7), true, 1)
+	f.Add(5, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, uint32(3), true, 4)
+
+	f.Fuzz(func(t *testing.T, n int, messageBytes []byte, index uint32, tweaked bool, seed int) {
+		if n < 1 || n > 10 {
+			t.Skip("n must be between 1 and 10")
+		}
+		if len(messageBytes) != 32 {
+			t.Skip("message must be 32 bytes")
+		}
+
+		// each signer has its own key
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+		secrets := make([]*btcec.PrivateKey, n)
+		keys := make([]*btcec.JacobianPoint, n)
+		btcecKeys := make([]*btcec.PublicKey, n)
+		for i := range secrets {
+			var b [32]byte
+			for j := range b {
+				b[j] = byte(rnd.Uint32())
+			}
+			secrets[i], _ = btcec.PrivKeyFromBytes(b[:])
+			if secrets[i].Key.IsZero() {
+				t.Skip("zero secret key")
+			}
+			btcecKeys[i] = secrets[i].PubKey()
+			keys[i] = new(btcec.JacobianPoint)
+			btcecKeys[i].AsJacobian(keys[i])
+		}
+
+		pubkey, publicShards, err := MuSig2AggregateKeys(keys)
+		if err != nil {
+			t.Fatalf("failed to aggregate keys: %v", err)
+		}
+		if pubkey.Y.IsOdd() {
+			t.Fatal("aggregate key has an odd y")
+		}
+
+		// the aggregate key must be the same as BIP-327's
+		var tweaks []musig2.KeyTweakDesc
+		cfg := &Configuration{
+			Threshold:    n,
+			MaxSigners:   n,
+			PublicKey:    pubkey,
+			Participants: make([]int, n),
+			Method:       MethodMuSig2,
+		}
+		for i := range cfg.Participants {
+			cfg.Participants[i] = i + 1
+		}
+		if tweaked {
+			cfg.Tweak = DeriveTweak(pubkey, index)
+			tweaks = []musig2.KeyTweakDesc{{Tweak: cfg.Tweak.Bytes(), IsXOnly: true}}
+		}
+		expected, _, _, err := musig2.AggregateKeys(btcecKeys, false, musig2.WithKeyTweaks(tweaks...))
+		if err != nil {
+			t.Fatalf("btcec failed to aggregate keys: %v", err)
+		}
+		signingKey, _ := cfg.TweakedPublicKey()
+		if !slices.Equal(schnorr.SerializePubKey(expected.FinalKey), signingKey.X.Bytes()[:]) {
+			t.Fatal("aggregate key is not the same as the one from btcec")
+		}
+
+		// the configuration must go through the wire as a musig2 one
+		decodedCfg := &Configuration{}
+		if err := decodedCfg.DecodeHex(cfg.Hex()); err != nil {
+			t.Fatalf("failed to decode configuration: %v", err)
+		}
+		if decodedCfg.Method != MethodMuSig2 {
+			t.Fatal("method lost after encoding/decoding")
+		}
+		cfg = decodedCfg
+
+		signers := make([]*Signer, n)
+		commitments := make([]Commitment, n)
+		secNonces := make([][musig2.SecNonceSize]byte, n)
+		for i := range signers {
+			shard, err := MuSig2KeyShard(keys, i+1, &secrets[i].Key)
+			if err != nil {
+				t.Fatalf("failed to get the shard of %d: %v", i+1, err)
+			}
+			if shard.PublicKeyShard.Hex() != publicShards[i].Hex() {
+				t.Fatalf("shard of %d doesn't match its public shard", i+1)
+			}
+			signers[i], err = cfg.Signer(shard, NewLambdaRegistry(0))
+			if err != nil {
+				t.Fatalf("failed to create signer %d: %v", i+1, err)
+			}
+			commitments[i] = signers[i].Commit("musig2")
+
+			// btcec takes the same nonces, followed by the public key
+			k1 := signers[i].SecretNonces[0].Bytes()
+			k2 := signers[i].SecretNonces[1].Bytes()
+			copy(secNonces[i][0:32], k1[:])
+			copy(secNonces[i][32:64], k2[:])
+			copy(secNonces[i][64:], btcecKeys[i].SerializeCompressed())
+		}
+
+		// the shard of someone else's key won't do
+		if n > 1 {
+			if _, err := MuSig2KeyShard(keys, 1, &secrets[1].Key); err == nil && !keys[0].X.Equals(&keys[1].X) {
+				t.Fatal("got a shard for a key that isn't ours")
+			}
+		}
+
+		// and all the signers must take part
+		if n > 1 {
+			partial := *cfg
+			partial.Participants = cfg.Participants[1:]
+			if _, err := partial.Signer(signers[0].KeyShard, NewLambdaRegistry(0)); err == nil {
+				t.Fatal("created a musig2 signer without all the participants")
+			}
+		}
+
+		groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, messageBytes)
+		var aggNonce [musig2.PubNonceSize]byte
+		writePointTo(aggNonce[0:33], groupCommitment[0])
+		writePointTo(aggNonce[33:66], groupCommitment[1])
+
+		partialSigs := make([]PartialSignature, n)
+		btcecPartialSigs := make([]*musig2.PartialSignature, n)
+		for i, signer := range signers {
+			partialSig, err := signer.Sign(messageBytes, groupCommitment)
+			if err != nil {
+				t.Fatalf("failed to sign with signer %d: %v", i+1, err)
+			}
+			if err := cfg.VerifyPartialSignature(
+				publicShards[i],
+				commitments[i].BinoncePublic,
+				bindingCoefficient,
+				finalNonce,
+				partialSig,
+				messageBytes,
+				lambdaRegistry,
+			); err != nil {
+				t.Fatalf("partial signature %d verification failed: %v", i+1, err)
+			}
+			partialSigs[i] = partialSig
+
+			btcecPartialSigs[i], err = musig2.Sign(secNonces[i], secrets[i], aggNonce, btcecKeys,
+				[32]byte(messageBytes), musig2.WithTweaks(tweaks...))
+			if err != nil {
+				t.Fatalf("btcec failed to sign with signer %d: %v", i+1, err)
+			}
+
+			// the first signer adds the tweak to its partial signature, btcec does it when aggregating
+			if (i != 0 || !tweaked) && !btcecPartialSigs[i].S.Equals(partialSig.Value) {
+				t.Fatalf("partial signature %d is not the same as the one from btcec", i+1)
+			}
+		}
+
+		// someone else's partial signature won't do
+		if n > 1 {
+			if err := cfg.VerifyPartialSignature(
+				publicShards[0],
+				commitments[0].BinoncePublic,
+				bindingCoefficient,
+				finalNonce,
+				PartialSignature{SignerIdentifier: 1, Value: partialSigs[1].Value},
+				messageBytes,
+				lambdaRegistry,
+			); err == nil {
+				t.Fatal("partial signature of 2 verified as 1's")
+			}
+		}
+
+		signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatalf("failed to aggregate signatures: %v", err)
+		}
+
+		pk, err := schnorr.ParsePubKey(signingKey.X.Bytes()[:])
+		if err != nil {
+			t.Fatalf("failed to parse public key: %v", err)
+		}
+		if !signature.Verify(messageBytes, pk) {
+			t.Fatal("signature doesn't verify")
+		}
+
+		btcecSignature := musig2.CombineSigs(btcecPartialSigs[0].R, btcecPartialSigs,
+			musig2.WithTweakedCombine([32]byte(messageBytes), btcecKeys, tweaks, false))
+		if !slices.Equal(signature.Serialize(), btcecSignature.Serialize()) {
+			t.Fatal("signature is not the same as the one from btcec")
+		}
+
+		// ecdh works the same, with the shards adding up to the shared secret
+		other := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(new(btcec.ModNScalar).SetInt(uint32(seed)+1), other)
+		other.ToAffine()
+		ecdhShares := make([]ECDHShare, n)
+		for i, signer := range signers {
+			ecdhShares[i] = cfg.CreateECDHShare(signer.KeyShard, other, lambdaRegistry)
+		}
+		shared, err := cfg.AggregateECDHShards(other, ecdhShares, publicShards, lambdaRegistry)
+		if err != nil {
+			t.Fatalf("failed to aggregate ecdh shares: %v", err)
+		}
+		expectedShared := new(btcec.JacobianPoint)
+		btcec.ScalarMultNonConst(new(btcec.ModNScalar).SetInt(uint32(seed)+1), signingKey, expectedShared)
+		expectedShared.ToAffine()
+		if !shared.X.Equals(&expectedShared.X) {
+			t.Fatal("ecdh shared secret is wrong")
+		}
+	})
+}
+
+func FuzzLadderScalarMult(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0})
+	f.Add(make([]byte, 32), []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0})
+	f.Add([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, []byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe, 0xba, 0xae, 0xdc, 0xe6, 0xaf, 0x48, 0xa0, 0x3b, 0xbf, 0xd2, 0x5e, 0x8c, 0xd0, 0x36, 0x41, 0x40}, []byte{0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70})
+
+	f.Fuzz(func(t *testing.T, scalarBytes []byte, pointKeyBytes []byte) {
+		if len(scalarBytes) != 32 || len(pointKeyBytes) != 32 {
+			t.Skip("scalars must be 32 bytes")
+		}
+
+		k := new(btcec.ModNScalar)
+		if overflow := k.SetByteSlice(scalarBytes); overflow {
+			t.Skip("scalar overflow")
+		}
+		p := new(btcec.ModNScalar)
+		if overflow := p.SetByteSlice(pointKeyBytes); overflow || p.IsZero() {
+			t.Skip("invalid point key")
+		}
+		point := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(p, point)
+		point.ToAffine()
+
+		sameAs := func(a, b *btcec.JacobianPoint) bool {
+			a.ToAffine()
+			b.ToAffine()
+			return a.X.Equals(&b.X) && a.Y.Equals(&b.Y)
+		}
+
+		expected := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(k, expected)
+		actual := new(btcec.JacobianPoint)
+		scalarBaseMultLadder(k, actual)
+		if !sameAs(expected, actual) {
+			t.Fatalf("ladder base multiplication doesn't match for %x", scalarBytes)
+		}
+
+		btcec.ScalarMultNonConst(k, point, expected)
+		scalarMultLadder(k, point, actual)
+		if !sameAs(expected, actual) {
+			t.Fatalf("ladder multiplication doesn't match for %x * %x", scalarBytes, pointKeyBytes)
+		}
+
+		// wiping secrets
+		if k.IsZero() {
+			return
+		}
+		shard := KeyShard{Secret: new(btcec.ModNScalar).Set(k)}
+		shard.Zero()
+		if !shard.Secret.IsZero() {
+			t.Fatalf("shard secret wasn't wiped")
+		}
+
+		nonces := BinonceSecret{new(btcec.ModNScalar).Set(k), new(btcec.ModNScalar).Set(p)}
+		hiding := nonces[0]
+		nonces.Zero()
+		if nonces[0] != nil || nonces[1] != nil || !hiding.IsZero() {
+			t.Fatalf("secret nonces weren't wiped")
+		}
+
+		polynomial, err := makePolynomial(k, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		polynomial.Zero()
+		for i, coeff := range polynomial {
+			if !coeff.IsZero() {
+				t.Fatalf("coefficient %d wasn't wiped", i)
+			}
+		}
+		if k.IsZero() {
+			t.Fatalf("wiping the polynomial wiped the secret it was made from")
+		}
+	})
+}
+
+func FuzzMultiScalarMult(f *testing.F) {
+	f.Add(1, 0)
+	f.Add(4, 1)
+	f.Add(13, 2)
+	f.Add(40, 3)
+
+	f.Fuzz(func(t *testing.T, n int, seed int) {
+		if n < 1 || n > 50 {
+			t.Skip("n must be between 1 and 50")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+		scalar := func() *btcec.ModNScalar {
+			var b [32]byte
+			for i := range b {
+				b[i] = byte(rnd.UintN(256))
+			}
+			switch rnd.IntN(8) {
+			case 0:
+				// zero
+				return new(btcec.ModNScalar)
+			case 1:
+				// small
+				return new(btcec.ModNScalar).SetInt(rnd.Uint32N(100))
+			case 2:
+				// -1, all ones
+				return new(btcec.ModNScalar).SetInt(1).Negate()
+			case 3:
+				// 128 bits, like the random weights in batch verification
+				s := new(btcec.ModNScalar)
+				s.SetByteSlice(b[0:16])
+				return s
+			default:
+				s := new(btcec.ModNScalar)
+				s.SetByteSlice(b[:])
+				return s
+			}
+		}
+
+		scalars := make([]*btcec.ModNScalar, n)
+		points := make([]*btcec.JacobianPoint, n)
+		expected := new(btcec.JacobianPoint)
+		for i := range n {
+			scalars[i] = scalar()
+			if i > 0 && rnd.IntN(5) == 0 {
+				// the same point again, or its negation
+				points[i] = new(btcec.JacobianPoint)
+				points[i].Set(points[rnd.IntN(i)])
+				if rnd.IntN(2) == 0 {
+					points[i].Y.Negate(1)
+					points[i].Y.Normalize()
+				}
+			} else {
+				points[i] = new(btcec.JacobianPoint)
+				btcec.ScalarBaseMultNonConst(new(btcec.ModNScalar).SetInt(rnd.Uint32()+1), points[i])
+				points[i].ToAffine()
+			}
+
+			term := new(btcec.JacobianPoint)
+			btcec.ScalarMultNonConst(scalars[i], points[i], term)
+			btcec.AddNonConst(expected, term, expected)
+		}
+
+		// the digits must add up to the scalar
+		for i, s := range scalars {
+			sum := new(btcec.ModNScalar)
+			power := new(btcec.ModNScalar).SetInt(1)
+			two := new(btcec.ModNScalar).SetInt(2)
+			for _, d := range wnaf(s) {
+				if d != 0 && (d%2 == 0 || d >= 1<<(msmWindow-1) || d <= -(1<<(msmWindow-1))) {
+					t.Fatalf("invalid digit %d for scalar %d", d, i)
+				}
+				digit := new(btcec.ModNScalar).SetInt(uint32(max(d, -d)))
+				if d < 0 {
+					digit.Negate()
+				}
+				sum.Add(digit.Mul(power))
+				power.Mul(two)
+			}
+			if !sum.Equals(s) {
+				t.Fatalf("wnaf digits of scalar %d don't add up to it", i)
+			}
+		}
+
+		actual := new(btcec.JacobianPoint)
+		multiScalarMultNonConst(scalars, points, actual)
+		expected.ToAffine()
+		actual.ToAffine()
+		if !expected.X.Equals(&actual.X) || !expected.Y.Equals(&actual.Y) {
+			t.Fatalf("multi-scalar multiplication of %d points doesn't match", n)
+		}
+	})
+}
+
+func FuzzBatchVerifyPartialSignatures(f *testing.F) {
+	f.Add(2, 3, -1, false, 0)
+	f.Add(3, 5, 0, false, 1)
+	f.Add(7, 10, 4, true, 2)
+	f.Add(5, 5, 2, true, 3)
+
+	f.Fuzz(func(t *testing.T, threshold, maxSigners, bad int, tweaked bool, seed int) {
+		if threshold < 1 || threshold > 15 {
+			t.Skip("threshold must be between 1 and 15")
+		}
+		if maxSigners < threshold || maxSigners > 15 {
+			t.Skip("maxSigners must be >= threshold and <= 15")
+		}
+		if bad < -1 || bad >= threshold {
+			t.Skip("bad must be -1 or the index of a signer")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+		secret := new(btcec.ModNScalar).SetInt(rnd.Uint32() + 1)
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+		rnd.Shuffle(len(shards), func(i, j int) { shards[i], shards[j] = shards[j], shards[i] })
+		message := chainhash.HashB([]byte(fmt.Sprintf("batch %d", seed)))
+
+		cfg := &Configuration{
+			Threshold:  threshold,
+			MaxSigners: maxSigners,
+			PublicKey:  pubkey,
+		}
+		for _, shard := range shards[0:threshold] {
+			cfg.Participants = append(cfg.Participants, shard.ID)
+		}
+		if tweaked {
+			cfg.Tweak = new(btcec.ModNScalar).SetInt(rnd.Uint32())
+		}
+
+		signers := make([]*Signer, threshold)
+		commitments := make([]Commitment, threshold)
+		for i := range signers {
+			signer, err := cfg.Signer(shards[i], lambdaRegistry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signers[i] = signer
+			commitments[i] = signer.Commit(fmt.Sprintf("batch %d", seed))
+		}
+		groupCommitment, bindingCoefficient, finalNonce := cfg.ComputeGroupCommitment(commitments, message)
+
+		partialSigs := make([]PartialSignature, threshold)
+		pubShards := make([]PublicKeyShard, threshold)
+		commits := make([]BinoncePublic, threshold)
+		for i, signer := range signers {
+			partialSig, err := signer.Sign(message, groupCommitment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			partialSigs[i] = partialSig
+			pubShards[i] = shards[i].PublicKeyShard
+			commits[i] = commitments[i].BinoncePublic
+		}
+
+		if err := cfg.VerifyPartialSignatures(pubShards, commits, bindingCoefficient, finalNonce, partialSigs,
+			message, lambdaRegistry,
+		); err != nil {
+			t.Fatalf("good partial signatures failed batch verification: %v", err)
+		}
+
+		signature, err := cfg.AggregateSignatures(finalNonce, partialSigs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signingKey, _ := cfg.TweakedPublicKey()
+		pk, _ := schnorr.ParsePubKey(signingKey.X.Bytes()[:])
+		if !signature.Verify(message, pk) {
+			t.Fatal("final signature verification failed")
+		}
+
+		if bad == -1 {
+			return
+		}
+
+		// a partial signature that is off by anything must be found
+		offBy := new(btcec.ModNScalar).SetInt(rnd.Uint32() + 1)
+		partialSigs[bad].Value = new(btcec.ModNScalar).Add2(partialSigs[bad].Value, offBy)
+		err = cfg.VerifyPartialSignatures(pubShards, commits, bindingCoefficient, finalNonce, partialSigs,
+			message, lambdaRegistry)
+		var psErr PartialSignatureError
+		if !errors.As(err, &psErr) {
+			t.Fatalf("bad partial signature wasn't caught: %v", err)
+		}
+		if psErr.SignerID != partialSigs[bad].SignerIdentifier {
+			t.Fatalf("blamed signer %d instead of %d", psErr.SignerID, partialSigs[bad].SignerIdentifier)
+		}
+		partialSigs[bad].Value.Add(offBy.Negate())
+
+		// and so must one made for the wrong nonces or the wrong shard
+		if threshold > 1 {
+			other := (bad + 1) % threshold
+			commits[bad], commits[other] = commits[other], commits[bad]
+			if err := cfg.VerifyPartialSignatures(pubShards, commits, bindingCoefficient, finalNonce, partialSigs,
+				message, lambdaRegistry,
+			); !errors.As(err, &psErr) {
+				t.Fatalf("partial signatures with swapped commits weren't caught: %v", err)
+			}
+			commits[bad], commits[other] = commits[other], commits[bad]
+
+			pubShards[bad], pubShards[other] = pubShards[other], pubShards[bad]
+			if err := cfg.VerifyPartialSignatures(pubShards, commits, bindingCoefficient, finalNonce, partialSigs,
+				message, lambdaRegistry,
+			); !errors.As(err, &psErr) {
+				t.Fatalf("partial signatures with swapped shards weren't caught: %v", err)
+			}
+		}
+	})
+}
+
+func FuzzLambdaRegistry(f *testing.F) {
+	f.Add(3, 5, 4, 0)
+	f.Add(1, 10, 7, 1)
+	f.Add(50, 6, 3, 2)
+
+	f.Fuzz(func(t *testing.T, size, maxSigners, threshold, seed int) {
+		if size < 1 || size > 100 {
+			t.Skip("size must be between 1 and 100")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+		registry := NewLambdaRegistry(size)
+
+		// a bunch of sessions at the same time, some with the same participants
+		groups := make([][]int, 8)
+		for g := range groups {
+			all := make([]int, maxSigners)
+			for i := range all {
+				all[i] = i + 1
+			}
+			rnd.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+			groups[g] = all[0:threshold]
+			slices.Sort(groups[g])
+		}
+
+		var wg sync.WaitGroup
+		for w := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for r := range 50 {
+					participants := groups[(w+r)%len(groups)]
+					id := participants[r%len(participants)]
+
+					lambda := registry.GetOrNew(participants, id)
+					if !lambda.Equals(computeLambda(id, participants)) {
+						t.Errorf("wrong lambda for %d in %v", id, participants)
+						return
+					}
+
+					// the value we get is ours, messing with it doesn't affect the registry
+					lambda.Zero()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if registry.Len() > size {
+			t.Fatalf("registry has %d values, more than %d", registry.Len(), size)
+		}
+		if registry.Hits()+registry.Misses() != 4*50 {
+			t.Fatalf("expected %d lookups, got %d hits and %d misses", 4*50, registry.Hits(), registry.Misses())
+		}
+		if registry.Misses() < uint64(min(size, registry.Len())) {
+			t.Fatalf("got %d values with only %d misses", registry.Len(), registry.Misses())
+		}
+
+		var nilRegistry *LambdaRegistry
+		if !nilRegistry.GetOrNew(groups[0], groups[0][0]).Equals(computeLambda(groups[0][0], groups[0])) {
+			t.Fatalf("nil registry gave the wrong lambda")
+		}
+	})
+}
+
+func FuzzWireFormat(f *testing.F) {
+	f.Add([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20}, 3, 5, 0, []byte{})
+	f.Add([]byte{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xab, 0xac, 0xad, 0xae, 0xaf, 0xb0, 0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xbb, 0xbc, 0xbd, 0xbe, 0xbf, 0xc0}, 2, 2, 1, []byte{0x00, 0x00, 0x01, 0x06, 0x00, 0x01})
+	f.Add([]byte{0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x5b, 0x5c, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70}, 4, 7, 2, []byte{0x00, 0x00, 0x02, 0x01})
+
+	f.Fuzz(func(t *testing.T, secretKeyBytes []byte, threshold, maxSigners, seed int, garbage []byte) {
+		if len(secretKeyBytes) != 32 {
+			t.Skip("secret key must be 32 bytes")
+		}
+		if threshold < 2 || threshold > 10 {
+			t.Skip("threshold must be between 2 and 10")
+		}
+		if maxSigners < threshold || maxSigners > 10 {
+			t.Skip("maxSigners must be >= threshold and <= 10")
+		}
+		secret := new(btcec.ModNScalar)
+		if overflow := secret.SetByteSlice(secretKeyBytes); overflow || secret.IsZero() {
+			t.Skip("invalid secret key")
+		}
+
+		// decoders must never panic on garbage, whatever it looks like
+		(&Configuration{}).Decode(garbage)
+		(&Commitment{}).Decode(garbage)
+		(&BinoncePublic{}).Decode(garbage)
+		(&PartialSignature{}).Decode(garbage)
+		(&PublicKeyShard{}).Decode(garbage)
+		(&KeyShard{}).Decode(garbage)
+		(&CommitmentList{}).Decode(garbage)
+		(&SubShard{}).Decode(garbage)
+		(&DKGCommitment{}).Decode(garbage)
+		(&DKGShare{}).Decode(garbage)
+		(&RefreshCommitment{}).Decode(garbage)
+		(&ReshareCommitment{}).Decode(garbage)
+		(&ECDHShare{}).Decode(garbage)
+
+		rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+		shards, pubkey, _ := TrustedKeyDeal(secret, threshold, maxSigners)
+		shard := shards[rnd.IntN(len(shards))]
+
+		participants := make([]int, threshold)
+		for i := range participants {
+			participants[i] = shards[i].ID
+		}
+		cfg := &Configuration{
+			Threshold:    threshold,
+			MaxSigners:   maxSigners,
+			PublicKey:    pubkey,
+			Participants: participants,
+		}
+		if seed%2 == 1 {
+			cfg.Tweak = new(btcec.ModNScalar).SetInt(uint32(seed))
+		}
+		if seed%3 == 2 {
+			cfg.Ciphersuite = CiphersuiteRFC9591
+		}
+
+		signer, err := cfg.Signer(shards[0], lambdaRegistry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seed%5 == 4 {
+			// it's only the encoding of it that we care about here
+			cfg.Method = MethodMuSig2
+		}
+		if seed%7 == 6 {
+			cfg.Adaptor = shard.PublicKey
+		}
+		commitments := make(CommitmentList, 0, threshold)
+		for i := range threshold {
+			commitments = append(commitments, signer.Commit(hex.EncodeToString([]byte{byte(seed), byte(i)})))
+			commitments[i].SignerID = participants[i]
+		}
+		partialSig := PartialSignature{
+			SignerIdentifier: shard.ID,
+			Value:            new(btcec.ModNScalar).Add2(shard.Secret, secret),
+		}
+
+		// each type must come back exactly the same from the binary encoding and from json, and must be rejected
+		// if anything is missing or left over
+		type wired interface {
+			Encode() []byte
+		}
+		check := func(name string, value wired, decode func([]byte) error, fromJSON func([]byte) (wired, error)) {
+			encoded := value.Encode()
+			if encoded[0] != 0 || encoded[1] != 0 || encoded[2] != wireVersion {
+				t.Fatalf("%s: missing header: %x", name, encoded)
+			}
+			if err := decode(encoded); err != nil {
+				t.Fatalf("%s: failed to decode: %v", name, err)
+			}
+			if err := decode(encoded[:len(encoded)-1]); err == nil {
+				t.Fatalf("%s: decoded truncated input", name)
+			}
+			if err := decode(append(slices.Clone(encoded), 0)); err == nil {
+				t.Fatalf("%s: decoded input with trailing bytes", name)
+			}
+			wrongVersion := slices.Clone(encoded)
+			wrongVersion[2]++
+			if err := decode(wrongVersion); err == nil {
+				t.Fatalf("%s: decoded input with an unknown version", name)
+			}
+			wrongType := slices.Clone(encoded)
+			wrongType[3] ^= 0x80
+			if err := decode(wrongType); err == nil {
+				t.Fatalf("%s: decoded input of another type", name)
+			}
+
+			j, err := json.Marshal(value)
+			if err != nil {
+				t.Fatalf("%s: failed to encode json: %v", name, err)
+			}
+			back, err := fromJSON(j)
+			if err != nil {
+				t.Fatalf("%s: failed to decode json %s: %v", name, j, err)
+			}
+			if !slices.Equal(back.Encode(), encoded) {
+				t.Fatalf("%s: json round-trip changed %x into %x", name, encoded, back.Encode())
+			}
+		}
+
+		check("configuration", cfg, func(b []byte) error {
+			var decoded Configuration
+			if err := decoded.Decode(b); err != nil {
+				return err
+			}
+			if !slices.Equal(decoded.Encode(), cfg.Encode()) {
+				t.Fatalf("configuration changed after decoding")
+			}
+			return nil
+		}, func(j []byte) (wired, error) {
+			var decoded Configuration
+			return &decoded, json.Unmarshal(j, &decoded)
+		})
+		check("commitment", commitments[0], func(b []byte) error {
+			var decoded Commitment
+			if err := decoded.Decode(b); err != nil {
+				return err
+			}
+			if !slices.Equal(decoded.Encode(), commitments[0].Encode()) {
+				t.Fatalf("commitment changed after decoding")
+			}
+			return nil
+		}, func(j []byte) (wired, error) {
+			var decoded Commitment
+			return decoded, json.Unmarshal(j, &decoded)
+		})
+		check("binonce", commitments[0].BinoncePublic, func(b []byte) error {
+			var decoded BinoncePublic
+			if err := decoded.Decode(b); err != nil {
+				return err
+			}
+			if !slices.Equal(decoded.Encode(), commitments[0].BinoncePublic.Encode()) {
+				t.Fatalf("binonce changed after decoding")
+			}
+			return nil
+		}, func(j []byte) (wired, error) {
+			var decoded BinoncePublic
+			return &decoded, json.Unmarshal(j, &decoded)
+		})
+		check("partial signature", &partialSig, func(b []byte) error {
+			var decoded PartialSignature
+			if err := decoded.Decode(b); err != nil {
+				return err
+			}
+			if !slices.Equal(decoded.Encode(), partialSig.Encode()) {
+				t.Fatalf("partial signature changed after decoding")
+			}
+			return nil
+		}, func(j []byte) (wired, error) {
+			var decoded PartialSignature
+			return &decoded, json.Unmarshal(j, &decoded)
+		})
+		check("public key shard", shard.PublicKeyShard, func(b []byte) error {
+			var decoded PublicKeyShard
+			n, err := decoded.Decode(b)
+			if err != nil {
+				return err
+			}
+			if n != len(b) {
+				return fmt.Errorf("%d bytes left over", len(b)-n)
+			}
+			if !slices.Equal(decoded.Encode(), shard.PublicKeyShard.Encode()) {
+				t.Fatalf("public key shard changed after decoding")
+			}
+			return nil
+		}, func(j []byte) (wired, error) {
+			var decoded PublicKeyShard
+			return decoded, json.Unmarshal(j, &decoded)
+		})
+		check("key shard", shard, func(b []byte) error {
+			var decoded KeyShard
+			if err := decoded.Decode(b); err != nil {
+				return err
+			}
+			if !slices.Equal(decoded.Encode(), shard.Encode()) {
+				t.Fatalf("key shard changed after decoding")
+			}
+			return nil
+		}, func(j []byte) (wired, error) {
+			var decoded KeyShard
+			return decoded, json.Unmarshal(j, &decoded)
+		})
+		subShards, err := SplitKeyShard(shard, 1+rnd.IntN(threshold), threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subShard := subShards[rnd.IntN(len(subShards))]
+		check("sub shard", subShard, func(b []byte) error {
+			var decoded SubShard
+			if err := decoded.Decode(b); err != nil {
+				return err
+			}
+			if !slices.Equal(decoded.Encode(), subShard.Encode()) {
+				t.Fatalf("sub shard changed after decoding")
+			}
+			return nil
+		}, func(j []byte) (wired, error) {
+			var decoded SubShard
+			return decoded, json.Unmarshal(j, &decoded)
+		})
+		check("commitment list", commitments, func(b []byte) error {
+			var decoded CommitmentList
+			if err := decoded.Decode(b); err != nil {
+				return err
+			}
+			if !slices.Equal(decoded.Encode(), commitments.Encode()) {
+				t.Fatalf("commitment list changed after decoding")
+			}
+			return nil
+		}, func(j []byte) (wired, error) {
+			var decoded CommitmentList
+			return decoded, json.Unmarshal(j, &decoded)
+		})
+
+		// and the old encodings must still be accepted
+		point := func(pt *btcec.JacobianPoint) []byte {
+			b := make([]byte, 33)
+			writePointTo(b, pt)
+			return b
+		}
+		legacyCommitment := func(c Commitment) []byte {
+			return slices.Concat(binary.LittleEndian.AppendUint16(nil, uint16(c.SignerID)),
+				point(c.BinoncePublic[0]), point(c.BinoncePublic[1]))
+		}
+		legacyPublicKeyShard := func(p PublicKeyShard) []byte {
+			b := binary.LittleEndian.AppendUint16(nil, uint16(p.ID))
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(p.VssCommitment)))
+			b = append(b, point(p.PublicKey)...)
+			for _, c := range p.VssCommitment {
+				b = append(b, point(c)...)
+			}
+			return b
+		}
+
+		legacyCfg := binary.LittleEndian.AppendUint16(nil, uint16(cfg.Threshold))
+		legacyCfg = binary.LittleEndian.AppendUint16(legacyCfg, uint16(cfg.MaxSigners))
+		legacyCfg = binary.LittleEndian.AppendUint16(legacyCfg, uint16(len(cfg.Participants)))
+		legacyCfg = append(legacyCfg, point(cfg.PublicKey)...)
+		for _, part := range cfg.Participants {
+			legacyCfg = binary.BigEndian.AppendUint16(legacyCfg, uint16(part))
+		}
+		if cfg.Tweak != nil {
+			tweak := cfg.Tweak.Bytes()
+			legacyCfg = append(legacyCfg, tweak[:]...)
+		}
+		var decodedCfg Configuration
+		if err := decodedCfg.Decode(legacyCfg); err != nil {
+			t.Fatalf("failed to decode legacy configuration: %v", err)
+		}
+		legacyCfgExpected := *cfg
+		legacyCfgExpected.Ciphersuite = CiphersuiteBIP340 // the old encoding had no ciphersuite
+		legacyCfgExpected.Method = MethodFROST            // nor method
+		if !slices.Equal(decodedCfg.Encode(), legacyCfgExpected.Encode()) {
+			t.Fatalf("legacy configuration decoded wrong")
+		}
+
+		var decodedCommitment Commitment
+		if err := decodedCommitment.Decode(legacyCommitment(commitments[0])); err != nil {
+			t.Fatalf("failed to decode legacy commitment: %v", err)
+		}
+		if !slices.Equal(decodedCommitment.Encode(), commitments[0].Encode()) {
+			t.Fatalf("legacy commitment decoded wrong")
+		}
+
+		var decodedBinonce BinoncePublic
+		if err := decodedBinonce.Decode(legacyCommitment(commitments[0])[2:]); err != nil {
+			t.Fatalf("failed to decode legacy binonce: %v", err)
+		}
+		if !slices.Equal(decodedBinonce.Encode(), commitments[0].BinoncePublic.Encode()) {
+			t.Fatalf("legacy binonce decoded wrong")
+		}
+
+		var decodedList CommitmentList
+		legacyList := make([]byte, 0, len(commitments)*(2+33+33))
+		for _, c := range commitments {
+			legacyList = append(legacyList, legacyCommitment(c)...)
+		}
+		if err := decodedList.Decode(legacyList); err != nil {
+			t.Fatalf("failed to decode legacy commitment list: %v", err)
+		}
+		if !slices.Equal(decodedList.Encode(), commitments.Encode()) {
+			t.Fatalf("legacy commitment list decoded wrong")
+		}
+
+		var decodedPartialSig PartialSignature
+		value := partialSig.Value.Bytes()
+		legacyPartialSig := append(binary.LittleEndian.AppendUint16(nil, uint16(partialSig.SignerIdentifier)), value[:]...)
+		if err := decodedPartialSig.Decode(legacyPartialSig); err != nil {
+			t.Fatalf("failed to decode legacy partial signature: %v", err)
+		}
+		if !slices.Equal(decodedPartialSig.Encode(), partialSig.Encode()) {
+			t.Fatalf("legacy partial signature decoded wrong")
+		}
+
+		var decodedPKS PublicKeyShard
+		if err := decodedPKS.DecodeHex(hex.EncodeToString(legacyPublicKeyShard(shard.PublicKeyShard))); err != nil {
+			t.Fatalf("failed to decode legacy public key shard: %v", err)
+		}
+		if !slices.Equal(decodedPKS.Encode(), shard.PublicKeyShard.Encode()) {
+			t.Fatalf("legacy public key shard decoded wrong")
+		}
+
+		var decodedShard KeyShard
+		shardSecret := shard.Secret.Bytes()
+		legacyShard := slices.Concat(legacyPublicKeyShard(shard.PublicKeyShard), shardSecret[:], point(shard.PublicKey))
+		if err := decodedShard.Decode(legacyShard); err != nil {
+			t.Fatalf("failed to decode legacy key shard: %v", err)
+		}
+		if !slices.Equal(decodedShard.Encode(), shard.Encode()) {
+			t.Fatalf("legacy key shard decoded wrong")
+		}
+		if err := decodedShard.Decode(legacyShard[:len(legacyShard)-1]); err == nil {
+			t.Fatalf("decoded truncated legacy key shard")
+		}
+
+		// the payloads of the dkg, the refresh, the resharing and the ecdh are encoded the same way, the
+		// commitments among them are sent concatenated so their decoders take trailing bytes and say where they stop
+		dkgCommitment := DKGCommitment{SignerID: shard.ID, VssCommitment: shard.VssCommitment}
+		dkgCommitment.ProofOfKnowledge.R = shard.PublicKey
+		dkgCommitment.ProofOfKnowledge.Mu = shard.Secret
+		dkgShare := DKGShare{Value: shard.Secret, From: shard.ID, To: participants[0]}
+		refreshCommitment := RefreshCommitment{SignerID: shard.ID, VssCommitment: shard.VssCommitment}
+		reshareCommitment := ReshareCommitment{SignerID: shard.ID, VssCommitment: shard.VssCommitment}
+		ecdhShare := ECDHShare{SignerID: shard.ID, Point: pubkey, Challenge: secret, Response: shard.Secret}
+
+		concatenated := func(name string, encoded []byte, decode func([]byte) (int, error)) {
+			if encoded[0] != 0 || encoded[1] != 0 || encoded[2] != wireVersion {
+				t.Fatalf("%s: missing header: %x", name, encoded)
+			}
+			if n, err := decode(append(slices.Clone(encoded), encoded...)); err != nil || n != len(encoded) {
+				t.Fatalf("%s: failed to decode (%d of %d): %v", name, n, len(encoded), err)
+			}
+			if _, err := decode(encoded[:len(encoded)-1]); err == nil {
+				t.Fatalf("%s: decoded truncated input", name)
+			}
+			wrongType := slices.Clone(encoded)
+			wrongType[3] ^= 0x80
+			if _, err := decode(wrongType); err == nil {
+				t.Fatalf("%s: decoded input of another type", name)
+			}
+		}
+		concatenated("dkg commitment", dkgCommitment.Encode(), func(b []byte) (int, error) {
+			var decoded DKGCommitment
+			n, err := decoded.Decode(b)
+			if err == nil && !slices.Equal(decoded.Encode(), dkgCommitment.Encode()) {
+				t.Fatalf("dkg commitment changed after decoding")
+			}
+			return n, err
+		})
+		concatenated("refresh commitment", refreshCommitment.Encode(), func(b []byte) (int, error) {
+			var decoded RefreshCommitment
+			n, err := decoded.Decode(b)
+			if err == nil && !slices.Equal(decoded.Encode(), refreshCommitment.Encode()) {
+				t.Fatalf("refresh commitment changed after decoding")
+			}
+			return n, err
+		})
+		concatenated("reshare commitment", reshareCommitment.Encode(), func(b []byte) (int, error) {
+			var decoded ReshareCommitment
+			n, err := decoded.Decode(b)
+			if err == nil && !slices.Equal(decoded.Encode(), reshareCommitment.Encode()) {
+				t.Fatalf("reshare commitment changed after decoding")
+			}
+			return n, err
+		})
+		if _, err := (&RefreshCommitment{}).Decode(reshareCommitment.Encode()); err == nil {
+			t.Fatalf("decoded a reshare commitment as a refresh commitment")
+		}
+
+		for name, value := range map[string]interface {
+			Encode() []byte
+			Decode([]byte) error
+		}{"dkg share": &dkgShare, "ecdh share": &ecdhShare} {
+			encoded := value.Encode()
+			if encoded[0] != 0 || encoded[1] != 0 || encoded[2] != wireVersion {
+				t.Fatalf("%s: missing header: %x", name, encoded)
+			}
+			if err := value.Decode(encoded); err != nil || !slices.Equal(value.Encode(), encoded) {
+				t.Fatalf("%s: failed to decode: %v", name, err)
+			}
+			if err := value.Decode(encoded[:len(encoded)-1]); err == nil {
+				t.Fatalf("%s: decoded truncated input", name)
+			}
+			if err := value.Decode(append(slices.Clone(encoded), 0)); err == nil {
+				t.Fatalf("%s: decoded input with trailing bytes", name)
+			}
+		}
+
+		legacyVss := func(id int, vss VssCommitment) []byte {
+			b := binary.LittleEndian.AppendUint16(nil, uint16(id))
+			b = binary.LittleEndian.AppendUint16(b, uint16(len(vss)))
+			for _, c := range vss {
+				b = append(b, point(c)...)
+			}
+			return b
+		}
+
+		var decodedDKGCommitment DKGCommitment
+		mu := dkgCommitment.ProofOfKnowledge.Mu.Bytes()
+		legacyDKGCommitment := legacyVss(dkgCommitment.SignerID, dkgCommitment.VssCommitment)
+		legacyDKGCommitment = slices.Concat(legacyDKGCommitment[:4], point(dkgCommitment.ProofOfKnowledge.R), mu[:],
+			legacyDKGCommitment[4:])
+		if n, err := decodedDKGCommitment.Decode(legacyDKGCommitment); err != nil || n != len(legacyDKGCommitment) {
+			t.Fatalf("failed to decode legacy dkg commitment: %v", err)
+		}
+		if !slices.Equal(decodedDKGCommitment.Encode(), dkgCommitment.Encode()) {
+			t.Fatalf("legacy dkg commitment decoded wrong")
+		}
+
+		var decodedRefreshCommitment RefreshCommitment
+		if _, err := decodedRefreshCommitment.Decode(legacyVss(shard.ID, shard.VssCommitment)); err != nil {
+			t.Fatalf("failed to decode legacy refresh commitment: %v", err)
+		}
+		if !slices.Equal(decodedRefreshCommitment.Encode(), refreshCommitment.Encode()) {
+			t.Fatalf("legacy refresh commitment decoded wrong")
+		}
+
+		var decodedDKGShare DKGShare
+		legacyDKGShare := binary.LittleEndian.AppendUint16(nil, uint16(dkgShare.From))
+		legacyDKGShare = binary.LittleEndian.AppendUint16(legacyDKGShare, uint16(dkgShare.To))
+		legacyDKGShare = append(legacyDKGShare, shardSecret[:]...)
+		if err := decodedDKGShare.Decode(legacyDKGShare); err != nil {
+			t.Fatalf("failed to decode legacy dkg share: %v", err)
+		}
+		if !slices.Equal(decodedDKGShare.Encode(), dkgShare.Encode()) {
+			t.Fatalf("legacy dkg share decoded wrong")
+		}
+
+		var decodedECDHShare ECDHShare
+		challenge := ecdhShare.Challenge.Bytes()
+		legacyECDHShare := slices.Concat(binary.LittleEndian.AppendUint16(nil, uint16(ecdhShare.SignerID)),
+			point(ecdhShare.Point), challenge[:], shardSecret[:])
+		if err := decodedECDHShare.Decode(legacyECDHShare); err != nil {
+			t.Fatalf("failed to decode legacy ecdh share: %v", err)
+		}
+		if !slices.Equal(decodedECDHShare.Encode(), ecdhShare.Encode()) {
+			t.Fatalf("legacy ecdh share decoded wrong")
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package frost
 
 import (
+	"encoding/binary"
+	"fmt"
 	"math/big"
 
 	"github.com/btcsuite/btcd/btcec/v2"
@@ -21,3 +23,55 @@ func stringOfScalar(s *btcec.ModNScalar) string {
 	sn, _ := new(big.Int).SetString(sx, 16)
 	return sn.String()
 }
+
+// encodeIdentifiedVss writes t's header followed by [id: 2][n: 2][n * 33-byte points], which is how the
+// commitments exchanged during a refresh or a resharing go through the wire.
+func encodeIdentifiedVss(t wireType, id int, vss VssCommitment) []byte {
+	out := make([]byte, wireHeaderSize+4+33*len(vss))
+	putWireHeader(out, t)
+
+	binary.BigEndian.PutUint16(out[wireHeaderSize:wireHeaderSize+2], uint16(id))
+	binary.BigEndian.PutUint16(out[wireHeaderSize+2:wireHeaderSize+4], uint16(len(vss)))
+	for i, pt := range vss {
+		writePointTo(out[wireHeaderSize+4+i*33:], pt)
+	}
+
+	return out
+}
+
+// decodeIdentifiedVss is the inverse of encodeIdentifiedVss, it also returns the number of bytes it took. the old
+// encoding, without a header and with the integers in little-endian, is accepted too.
+func decodeIdentifiedVss(in []byte, t wireType) (id int, vss VssCommitment, n int, err error) {
+	versioned, err := readWireHeader(in, t)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	order := binary.ByteOrder(binary.LittleEndian)
+	offset := 0
+	if versioned {
+		order = binary.BigEndian
+		offset = wireHeaderSize
+	}
+	in = in[offset:]
+
+	if len(in) < 4 {
+		return 0, nil, 0, fmt.Errorf("too small")
+	}
+
+	id = int(order.Uint16(in[0:2]))
+	vss = make(VssCommitment, order.Uint16(in[2:4]))
+
+	n = 4 + 33*len(vss)
+	if len(in) < n {
+		return 0, nil, 0, fmt.Errorf("too small for vss commitments")
+	}
+
+	for i := range vss {
+		if vss[i], err = readPoint(in[4+i*33:]); err != nil {
+			return 0, nil, 0, fmt.Errorf("failed to decode vss commitment %d: %w", i, err)
+		}
+	}
+
+	return id, vss, offset + n, nil
+}
@@ -0,0 +1,393 @@
+package frost
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// The JSON representation of each type is an object with "type" and "version" (the same as in the binary
+// encoding, see wire.go), points as 33-byte compressed hex, scalars as 32-byte hex and identifiers as numbers:
+//
+//	Configuration:    {"type": "configuration", "version": 1, "threshold": 2, "max_signers": 3,
+//	                   "public_key": "02...", "participants": [1, 3], "tweak": "..." (optional),
+//	                   "ciphersuite": "rfc9591" (optional, "bip340" if missing),
+//	                   "method": "musig2" (optional, "frost" if missing), "adaptor": "02..." (optional)}
+//	Commitment:       {"type": "commitment", "version": 1, "signer_id": 1, "hiding": "02...", "binding": "03..."}
+//	BinoncePublic:    {"type": "binonce", "version": 1, "hiding": "02...", "binding": "03..."}
+//	PartialSignature: {"type": "partial signature", "version": 1, "signer_id": 1, "value": "..."}
+//	PublicKeyShard:   {"type": "public key shard", "version": 1, "id": 1, "public_key": "02...",
+//	                   "vss_commitment": ["02...", ...]}
+//	KeyShard:         {"type": "key shard", "version": 1, "id": 1, "public_key": "02...",
+//	                   "vss_commitment": ["02...", ...], "secret": "...", "group_public_key": "02..."}
+//	SubShard:         {"type": "sub shard", "version": 1, "shard": {<key shard>}, "parent": {<public key shard>},
+//	                   "group_public_key": "02..."}
+
+type jsonHeader struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+}
+
+func newJSONHeader(t wireType) jsonHeader {
+	return jsonHeader{Type: t.String(), Version: wireVersion}
+}
+
+func (h jsonHeader) check(t wireType) error {
+	if h.Type != t.String() {
+		return fmt.Errorf("expected a %s, got '%s'", t, h.Type)
+	}
+	if h.Version != wireVersion {
+		return fmt.Errorf("unsupported version %d", h.Version)
+	}
+	return nil
+}
+
+func pointToHex(pt *btcec.JacobianPoint) string {
+	b := make([]byte, 33)
+	writePointTo(b, pt)
+	return hex.EncodeToString(b)
+}
+
+func pointFromHex(x string) (*btcec.JacobianPoint, error) {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWireLength(b, 33); err != nil {
+		return nil, err
+	}
+	return readPoint(b)
+}
+
+func scalarToHex(s *btcec.ModNScalar) string {
+	b := s.Bytes()
+	return hex.EncodeToString(b[:])
+}
+
+func scalarFromHex(x string) (*btcec.ModNScalar, error) {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWireLength(b, 32); err != nil {
+		return nil, err
+	}
+	return readScalar(b)
+}
+
+type configurationJSON struct {
+	jsonHeader
+	Threshold    int    `json:"threshold"`
+	MaxSigners   int    `json:"max_signers"`
+	PublicKey    string `json:"public_key"`
+	Participants []int  `json:"participants"`
+	Tweak        string `json:"tweak,omitempty"`
+	Ciphersuite  string `json:"ciphersuite,omitempty"`
+	Method       string `json:"method,omitempty"`
+	Adaptor      string `json:"adaptor,omitempty"`
+}
+
+func (c Configuration) MarshalJSON() ([]byte, error) {
+	cj := configurationJSON{
+		jsonHeader:   newJSONHeader(wireConfiguration),
+		Threshold:    c.Threshold,
+		MaxSigners:   c.MaxSigners,
+		PublicKey:    pointToHex(c.PublicKey),
+		Participants: c.Participants,
+	}
+	if c.Tweak != nil {
+		cj.Tweak = scalarToHex(c.Tweak)
+	}
+	if c.Ciphersuite != CiphersuiteBIP340 {
+		cj.Ciphersuite = c.Ciphersuite.String()
+	}
+	if c.Method != MethodFROST {
+		cj.Method = c.Method.String()
+	}
+	if c.Adaptor != nil {
+		cj.Adaptor = pointToHex(c.Adaptor)
+	}
+	return json.Marshal(cj)
+}
+
+func (c *Configuration) UnmarshalJSON(b []byte) error {
+	var cj configurationJSON
+	if err := json.Unmarshal(b, &cj); err != nil {
+		return err
+	}
+	if err := cj.check(wireConfiguration); err != nil {
+		return err
+	}
+
+	pubkey, err := pointFromHex(cj.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public_key: %w", err)
+	}
+	var tweak *btcec.ModNScalar
+	if cj.Tweak != "" {
+		if tweak, err = scalarFromHex(cj.Tweak); err != nil {
+			return fmt.Errorf("invalid tweak: %w", err)
+		}
+	}
+
+	var ciphersuite Ciphersuite
+	switch cj.Ciphersuite {
+	case "", CiphersuiteBIP340.String():
+		ciphersuite = CiphersuiteBIP340
+	case CiphersuiteRFC9591.String():
+		ciphersuite = CiphersuiteRFC9591
+	default:
+		return fmt.Errorf("unknown ciphersuite '%s'", cj.Ciphersuite)
+	}
+
+	method := MethodFROST
+	if cj.Method != "" {
+		if method, err = ParseMethod(cj.Method); err != nil {
+			return err
+		}
+	}
+
+	var adaptor *btcec.JacobianPoint
+	if cj.Adaptor != "" {
+		if adaptor, err = pointFromHex(cj.Adaptor); err != nil {
+			return fmt.Errorf("invalid adaptor: %w", err)
+		}
+	}
+
+	c.Threshold = cj.Threshold
+	c.MaxSigners = cj.MaxSigners
+	c.Ciphersuite = ciphersuite
+	c.Method = method
+	c.PublicKey = pubkey
+	c.Participants = cj.Participants
+	c.Tweak = tweak
+	c.Adaptor = adaptor
+	return nil
+}
+
+type binonceJSON struct {
+	jsonHeader
+	Hiding  string `json:"hiding"`
+	Binding string `json:"binding"`
+}
+
+func (b BinoncePublic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(binonceJSON{
+		jsonHeader: newJSONHeader(wireBinoncePublic),
+		Hiding:     pointToHex(b[0]),
+		Binding:    pointToHex(b[1]),
+	})
+}
+
+func (b *BinoncePublic) UnmarshalJSON(data []byte) error {
+	var bj binonceJSON
+	if err := json.Unmarshal(data, &bj); err != nil {
+		return err
+	}
+	if err := bj.check(wireBinoncePublic); err != nil {
+		return err
+	}
+	return b.fromJSON(bj.Hiding, bj.Binding)
+}
+
+func (b *BinoncePublic) fromJSON(hiding, binding string) error {
+	d, err := pointFromHex(hiding)
+	if err != nil {
+		return fmt.Errorf("invalid hiding nonce: %w", err)
+	}
+	e, err := pointFromHex(binding)
+	if err != nil {
+		return fmt.Errorf("invalid binding nonce: %w", err)
+	}
+	b[0] = d
+	b[1] = e
+	return nil
+}
+
+type commitmentJSON struct {
+	jsonHeader
+	SignerID int    `json:"signer_id"`
+	Hiding   string `json:"hiding"`
+	Binding  string `json:"binding"`
+}
+
+func (c Commitment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(commitmentJSON{
+		jsonHeader: newJSONHeader(wireCommitment),
+		SignerID:   c.SignerID,
+		Hiding:     pointToHex(c.BinoncePublic[0]),
+		Binding:    pointToHex(c.BinoncePublic[1]),
+	})
+}
+
+func (c *Commitment) UnmarshalJSON(b []byte) error {
+	var cj commitmentJSON
+	if err := json.Unmarshal(b, &cj); err != nil {
+		return err
+	}
+	if err := cj.check(wireCommitment); err != nil {
+		return err
+	}
+	if err := c.BinoncePublic.fromJSON(cj.Hiding, cj.Binding); err != nil {
+		return err
+	}
+	c.SignerID = cj.SignerID
+	return nil
+}
+
+type partialSignatureJSON struct {
+	jsonHeader
+	SignerID int    `json:"signer_id"`
+	Value    string `json:"value"`
+}
+
+func (s PartialSignature) MarshalJSON() ([]byte, error) {
+	return json.Marshal(partialSignatureJSON{
+		jsonHeader: newJSONHeader(wirePartialSignature),
+		SignerID:   s.SignerIdentifier,
+		Value:      scalarToHex(s.Value),
+	})
+}
+
+func (s *PartialSignature) UnmarshalJSON(b []byte) error {
+	var sj partialSignatureJSON
+	if err := json.Unmarshal(b, &sj); err != nil {
+		return err
+	}
+	if err := sj.check(wirePartialSignature); err != nil {
+		return err
+	}
+	value, err := scalarFromHex(sj.Value)
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	s.SignerIdentifier = sj.SignerID
+	s.Value = value
+	return nil
+}
+
+type publicKeyShardJSON struct {
+	jsonHeader
+	ID            int      `json:"id"`
+	PublicKey     string   `json:"public_key"`
+	VssCommitment []string `json:"vss_commitment"`
+}
+
+func (p PublicKeyShard) toJSON(t wireType) publicKeyShardJSON {
+	pj := publicKeyShardJSON{
+		jsonHeader:    newJSONHeader(t),
+		ID:            p.ID,
+		PublicKey:     pointToHex(p.PublicKey),
+		VssCommitment: make([]string, len(p.VssCommitment)),
+	}
+	for i, c := range p.VssCommitment {
+		pj.VssCommitment[i] = pointToHex(c)
+	}
+	return pj
+}
+
+func (p *PublicKeyShard) fromJSON(pj publicKeyShardJSON) error {
+	pubkey, err := pointFromHex(pj.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public_key: %w", err)
+	}
+	vss := make([]*btcec.JacobianPoint, len(pj.VssCommitment))
+	for i, c := range pj.VssCommitment {
+		if vss[i], err = pointFromHex(c); err != nil {
+			return fmt.Errorf("invalid vss commitment %d: %w", i, err)
+		}
+	}
+	p.ID = pj.ID
+	p.PublicKey = pubkey
+	p.VssCommitment = vss
+	return nil
+}
+
+func (p PublicKeyShard) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.toJSON(wirePublicKeyShard))
+}
+
+func (p *PublicKeyShard) UnmarshalJSON(b []byte) error {
+	var pj publicKeyShardJSON
+	if err := json.Unmarshal(b, &pj); err != nil {
+		return err
+	}
+	if err := pj.check(wirePublicKeyShard); err != nil {
+		return err
+	}
+	return p.fromJSON(pj)
+}
+
+type keyShardJSON struct {
+	publicKeyShardJSON
+	Secret         string `json:"secret"`
+	GroupPublicKey string `json:"group_public_key"`
+}
+
+func (k KeyShard) MarshalJSON() ([]byte, error) {
+	return json.Marshal(keyShardJSON{
+		publicKeyShardJSON: k.PublicKeyShard.toJSON(wireKeyShard),
+		Secret:             scalarToHex(k.Secret),
+		GroupPublicKey:     pointToHex(k.PublicKey),
+	})
+}
+
+func (k *KeyShard) UnmarshalJSON(b []byte) error {
+	var kj keyShardJSON
+	if err := json.Unmarshal(b, &kj); err != nil {
+		return err
+	}
+	if err := kj.check(wireKeyShard); err != nil {
+		return err
+	}
+	if err := k.PublicKeyShard.fromJSON(kj.publicKeyShardJSON); err != nil {
+		return err
+	}
+	secret, err := scalarFromHex(kj.Secret)
+	if err != nil {
+		return fmt.Errorf("invalid secret: %w", err)
+	}
+	pubkey, err := pointFromHex(kj.GroupPublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid group_public_key: %w", err)
+	}
+	k.Secret = secret
+	k.PublicKey = pubkey
+	return nil
+}
+
+type subShardJSON struct {
+	jsonHeader
+	Shard          KeyShard       `json:"shard"`
+	Parent         PublicKeyShard `json:"parent"`
+	GroupPublicKey string         `json:"group_public_key"`
+}
+
+func (s SubShard) MarshalJSON() ([]byte, error) {
+	return json.Marshal(subShardJSON{
+		jsonHeader:     newJSONHeader(wireSubShard),
+		Shard:          s.KeyShard,
+		Parent:         s.Parent,
+		GroupPublicKey: pointToHex(s.GroupPublicKey),
+	})
+}
+
+func (s *SubShard) UnmarshalJSON(b []byte) error {
+	var sj subShardJSON
+	if err := json.Unmarshal(b, &sj); err != nil {
+		return err
+	}
+	if err := sj.check(wireSubShard); err != nil {
+		return err
+	}
+	pubkey, err := pointFromHex(sj.GroupPublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid group_public_key: %w", err)
+	}
+	s.KeyShard = sj.Shard
+	s.Parent = sj.Parent
+	s.GroupPublicKey = pubkey
+	return nil
+}
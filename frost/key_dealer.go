@@ -10,11 +10,11 @@ func TrustedKeyDeal(
 ) ([]KeyShard, *btcec.JacobianPoint, []*btcec.JacobianPoint) {
 	// BIP-340 special
 	pubkey := new(btcec.JacobianPoint)
-	btcec.ScalarBaseMultNonConst(secret, pubkey)
+	scalarBaseMultLadder(secret, pubkey)
 	pubkey.ToAffine()
 	if pubkey.Y.IsOdd() {
 		secret.Negate()
-		btcec.ScalarBaseMultNonConst(secret, pubkey)
+		scalarBaseMultLadder(secret, pubkey)
 	}
 	pubkey.ToAffine()
 
@@ -26,15 +26,16 @@ func TrustedKeyDeal(
 	if err != nil {
 		panic(err)
 	}
+	defer polynomial.Zero()
+
+	commits := VSSCommit(polynomial)
+	pubkey = commits[0]
 
 	// evaluate the polynomial for each point x=1,...,n
 	shards := make([]KeyShard, maxSigners)
 	for i := 0; i < maxSigners; i++ {
-		shards[i] = makeKeyShard(i+1, polynomial, pubkey)
+		shards[i] = makeKeyShard(i+1, polynomial, pubkey, commits)
 	}
 
-	commits := VSSCommit(polynomial)
-	pubkey = commits[0]
-
 	return shards, pubkey, commits
 }
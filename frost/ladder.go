@@ -0,0 +1,111 @@
+package frost
+
+import (
+	"crypto/sha256"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// btcec only has variable-time scalar multiplication (the "NonConst" functions, which skip over zero digits of the
+// scalar, among other things), so the operations that involve our secrets go through the Montgomery ladder below.
+//
+// it is a fixed sequence of 256 steps of one point addition and one doubling, with a constant-time swap of the two
+// points according to each bit of the scalar, so the bits don't decide which operations are done. this is not
+// constant-time, though: the additions and doublings are btcec's AddNonConst and DoubleNonConst, which branch on
+// the values of the points (the point at infinity, equal points, and which formula fits their z coordinates). the
+// ladder starts from a blinding point B instead of the point at infinity, which makes the first two cases as
+// unlikely as finding the discrete log of B, and B's contribution is subtracted in the end, but the timing still
+// depends on the points.
+
+var (
+	ladderBlind  btcec.JacobianPoint // B
+	ladderUnwind btcec.JacobianPoint // -(2^256 * B)
+	generator    btcec.JacobianPoint
+)
+
+func init() {
+	btcec.Generator().AsJacobian(&generator)
+
+	// B is a point nobody knows the discrete log of
+	seed := sha256.Sum256([]byte("frost/ladder"))
+	for {
+		pk, err := btcec.ParsePubKey(append([]byte{2}, seed[:]...))
+		if err == nil {
+			pk.AsJacobian(&ladderBlind)
+			break
+		}
+		seed = sha256.Sum256(seed[:])
+	}
+
+	ladderUnwind.Set(&ladderBlind)
+	for range 256 {
+		btcec.DoubleNonConst(&ladderUnwind, &ladderUnwind)
+	}
+	ladderUnwind.ToAffine()
+	ladderUnwind.Y.Negate(1)
+	ladderUnwind.Y.Normalize()
+}
+
+// scalarBaseMultLadder is like btcec.ScalarBaseMultNonConst, but goes through the ladder above.
+func scalarBaseMultLadder(k *btcec.ModNScalar, result *btcec.JacobianPoint) {
+	scalarMultLadder(k, &generator, result)
+}
+
+// scalarMultLadder is like btcec.ScalarMultNonConst, but goes through the ladder above. the point is not secret.
+func scalarMultLadder(k *btcec.ModNScalar, point *btcec.JacobianPoint, result *btcec.JacobianPoint) {
+	kb := k.Bytes()
+	defer clear(kb[:])
+
+	// R0 = B, R1 = B + P, so R1 - R0 = P all the time
+	var r0, r1, sum, double btcec.JacobianPoint
+	r0.Set(&ladderBlind)
+	btcec.AddNonConst(&ladderBlind, point, &r1)
+	r1.ToAffine()
+
+	for i := 255; i >= 0; i-- {
+		bit := (kb[31-i/8] >> (i % 8)) & 1
+
+		// with bit 0: R1 = R0 + R1, R0 = 2 * R0
+		// with bit 1: R0 = R0 + R1, R1 = 2 * R1
+		condSwapPoints(&r0, &r1, bit)
+		btcec.AddNonConst(&r0, &r1, &sum)
+		btcec.DoubleNonConst(&r0, &double)
+		r0.Set(&double)
+		r1.Set(&sum)
+		condSwapPoints(&r0, &r1, bit)
+	}
+
+	// R0 = k * P + 2^256 * B
+	btcec.AddNonConst(&r0, &ladderUnwind, result)
+
+	r0 = btcec.JacobianPoint{}
+	r1 = btcec.JacobianPoint{}
+	sum = btcec.JacobianPoint{}
+	double = btcec.JacobianPoint{}
+}
+
+// condSwapPoints swaps a and b if swap is 1 and leaves them alone if it's 0, taking the same time either way.
+func condSwapPoints(a, b *btcec.JacobianPoint, swap byte) {
+	condSwapFields(&a.X, &b.X, swap)
+	condSwapFields(&a.Y, &b.Y, swap)
+	condSwapFields(&a.Z, &b.Z, swap)
+}
+
+func condSwapFields(a, b *btcec.FieldVal, swap byte) {
+	var ab, bb [32]byte
+	a.Normalize().PutBytes(&ab)
+	b.Normalize().PutBytes(&bb)
+
+	mask := -swap
+	for i := range ab {
+		t := mask & (ab[i] ^ bb[i])
+		ab[i] ^= t
+		bb[i] ^= t
+	}
+
+	a.SetBytes(&ab)
+	b.SetBytes(&bb)
+
+	clear(ab[:])
+	clear(bb[:])
+}
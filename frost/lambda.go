@@ -1,8 +1,11 @@
 package frost
 
 import (
+	"container/list"
 	"encoding/binary"
 	"encoding/hex"
+	"sync"
+	"sync/atomic"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 )
@@ -12,6 +15,7 @@ import (
 // - id is non-nil and != 0.
 // - every scalar in participants is non-nil and != 0.
 // - there are no duplicates in participants.
+// The identifiers are all public, so it's fine that the inverse is computed in variable time.
 func computeLambda(id int, participants []int) *btcec.ModNScalar {
 	sid := new(btcec.ModNScalar).SetInt(uint32(id))
 	numerator := new(btcec.ModNScalar).SetInt(1)
@@ -36,11 +40,36 @@ type Lambda struct {
 	Value *btcec.ModNScalar `json:"value"`
 }
 
-type lambdaShadow Lambda
+// DefaultLambdaRegistrySize is how many Lambda values a LambdaRegistry keeps when no size is given.
+const DefaultLambdaRegistrySize = 4096
+
+// LambdaRegistry holds pre-computed Lambda values, indexed by the identifier and the list of participants they are
+// associated to. A sorted set of participants will yield the same Lambda.
+//
+// It is safe for concurrent use and only keeps the most recently used values, up to its size, forgetting the others.
+// The zero value is ready to use with DefaultLambdaRegistrySize.
+type LambdaRegistry struct {
+	size   int
+	mu     sync.Mutex
+	values map[string]*list.Element
+	order  *list.List // of lambdaEntry, the most recently used in the front
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type lambdaEntry struct {
+	key    string
+	lambda Lambda
+}
 
-// LambdaRegistry holds a signers pre-computed Lambda values, indexed by the list of participants they are associated
-// to. A sorted set of participants will yield the same Lambda.
-type LambdaRegistry map[string]*Lambda
+// NewLambdaRegistry creates a registry that keeps at most size values.
+func NewLambdaRegistry(size int) *LambdaRegistry {
+	if size <= 0 {
+		size = DefaultLambdaRegistrySize
+	}
+	return &LambdaRegistry{size: size}
+}
 
 func lambdaRegistryKey(id int, participants []int) string {
 	key := make([]byte, 2+2*len(participants))
@@ -51,48 +80,91 @@ func lambdaRegistryKey(id int, participants []int) string {
 	return hex.EncodeToString(key)
 }
 
-// New creates a new Lambda and for the participant list for the participant id, and registers it.
+// GetOrNew returns the recorded Lambda for the list of participants, or computes, records, and returns a new one if
+// it wasn't found. The value returned is a copy that can be modified freely.
 // This function assumes that:
 // - id is non-nil and != 0.
-// - every participant id is != 0.
+// - every scalar in participants is non-nil and != 0.
 // - there are no duplicates in participants.
-func (l LambdaRegistry) new(id int, participants []int) *btcec.ModNScalar {
-	lambda := computeLambda(id, participants)
-	l.set(id, participants, lambda)
-	return lambda
-}
+// A nil registry just computes the value every time.
+func (l *LambdaRegistry) GetOrNew(participants []int, id int) *btcec.ModNScalar {
+	if l == nil {
+		return computeLambda(id, participants)
+	}
 
-// Get returns the recorded Lambda for the list of participants, or nil if it wasn't found.
-func (l LambdaRegistry) get(id int, participants []int) *btcec.ModNScalar {
 	key := lambdaRegistryKey(id, participants)
 
-	v := l[key]
-	if v == nil {
-		return nil
+	l.mu.Lock()
+	if elem, ok := l.values[key]; ok {
+		l.order.MoveToFront(elem)
+		lambda := new(btcec.ModNScalar).Set(elem.Value.(lambdaEntry).lambda.Value)
+		l.mu.Unlock()
+
+		l.hits.Add(1)
+		return lambda
 	}
+	l.mu.Unlock()
 
-	return v.Value
-}
+	// computed without holding the lock, as this is the slow part
+	l.misses.Add(1)
+	lambda := computeLambda(id, participants)
 
-// GetOrNew returns the recorded Lambda for the list of participants, or created, records, and returns a new one if
-// it wasn't found.
-// This function assumes that:
-// - id is non-nil and != 0.
-// - every scalar in participants is non-nil and != 0.
-// - there are no duplicates in participants.
-func (l LambdaRegistry) getOrNew(participants []int, id int) *btcec.ModNScalar {
-	lambda := l.get(id, participants)
-	if lambda != nil {
-		return lambda
-	} else {
-		return l.new(id, participants)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.values == nil {
+		if l.size <= 0 {
+			l.size = DefaultLambdaRegistrySize
+		}
+		l.values = make(map[string]*list.Element, l.size)
+		l.order = list.New()
+	}
+
+	// someone else may have computed it in the meantime
+	if _, ok := l.values[key]; !ok {
+		entry := lambdaEntry{key, Lambda{Value: new(btcec.ModNScalar).Set(lambda)}}
+		l.values[key] = l.order.PushFront(entry)
+
+		for l.order.Len() > l.size {
+			oldest := l.order.Back()
+			l.order.Remove(oldest)
+			delete(l.values, oldest.Value.(lambdaEntry).key)
+		}
 	}
+
+	return lambda
 }
 
-// Set records Lambda for the given set of participants.
-func (l LambdaRegistry) set(id int, participants []int, value *btcec.ModNScalar) {
-	key := lambdaRegistryKey(id, participants)
-	l[key] = &Lambda{
-		Value: value,
+// Len is the number of values the registry has right now.
+func (l *LambdaRegistry) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.values)
+}
+
+// Hits is the number of times a value was found in the registry.
+func (l *LambdaRegistry) Hits() uint64 { return l.hits.Load() }
+
+// Misses is the number of times a value had to be computed.
+func (l *LambdaRegistry) Misses() uint64 { return l.misses.Load() }
+
+// computeLambdaAt is like computeLambda, but derives the interpolating value for id at x instead of at zero, which
+// is what is needed to recover the value of the polynomial at another identifier.
+func computeLambdaAt(x int, id int, participants []int) *btcec.ModNScalar {
+	sx := new(btcec.ModNScalar).SetInt(uint32(x))
+	sid := new(btcec.ModNScalar).SetInt(uint32(id))
+	numerator := new(btcec.ModNScalar).SetInt(1)
+	denominator := new(btcec.ModNScalar).SetInt(1)
+
+	for _, part := range participants {
+		if part == id {
+			continue
+		}
+
+		spart := new(btcec.ModNScalar).SetInt(uint32(part))
+		numerator.Mul(new(btcec.ModNScalar).Set(sx).Add(new(btcec.ModNScalar).NegateVal(spart)))
+		denominator.Mul(new(btcec.ModNScalar).Set(sid).Add(new(btcec.ModNScalar).NegateVal(spart)))
 	}
+
+	return numerator.Mul(denominator.InverseNonConst())
 }
@@ -0,0 +1,113 @@
+package frost
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// msmWindow is the width of the wNAF digits used by multiScalarMultNonConst: each point gets a table of its first
+// 2^(msmWindow-2) odd multiples and about one in msmWindow+1 digits is nonzero.
+const msmWindow = 5
+
+// multiScalarMultNonConst sets result to scalars[0]*points[0] + scalars[1]*points[1] + ..., all at once with Straus'
+// method: the 256 doublings are shared by all the points, so each extra point only costs about 256/(msmWindow+1)
+// additions (plus its table) instead of a whole scalar multiplication. like btcec's "NonConst" functions its
+// running time depends on the scalars, so it must only be used with public values.
+func multiScalarMultNonConst(
+	scalars []*btcec.ModNScalar,
+	points []*btcec.JacobianPoint,
+	result *btcec.JacobianPoint,
+) {
+	digits := make([][]int8, len(points))
+	tables := make([][]btcec.JacobianPoint, len(points))
+	length := 0
+	for i, point := range points {
+		digits[i] = wnaf(scalars[i])
+		length = max(length, len(digits[i]))
+		if len(digits[i]) == 0 {
+			continue
+		}
+
+		// P, 3P, 5P, ...
+		tables[i] = make([]btcec.JacobianPoint, 1<<(msmWindow-2))
+		tables[i][0].Set(point)
+		double := new(btcec.JacobianPoint)
+		btcec.DoubleNonConst(point, double)
+		for j := 1; j < len(tables[i]); j++ {
+			btcec.AddNonConst(&tables[i][j-1], double, &tables[i][j])
+		}
+	}
+
+	acc := new(btcec.JacobianPoint)
+	negated := new(btcec.JacobianPoint)
+	for b := length - 1; b >= 0; b-- {
+		btcec.DoubleNonConst(acc, acc)
+
+		for i := range points {
+			if b >= len(digits[i]) {
+				continue
+			}
+			switch d := digits[i][b]; {
+			case d > 0:
+				btcec.AddNonConst(acc, &tables[i][d/2], acc)
+			case d < 0:
+				negated.Set(&tables[i][-d/2])
+				negated.Y.Normalize()
+				negated.Y.Negate(1)
+				negated.Y.Normalize()
+				btcec.AddNonConst(acc, negated, acc)
+			}
+		}
+	}
+
+	result.Set(acc)
+}
+
+// wnaf gives the width-msmWindow non-adjacent form of s, least significant digit first: each digit is either zero
+// or odd and between -2^(msmWindow-1) and 2^(msmWindow-1), and there are at least msmWindow-1 zeros after each
+// nonzero digit. the digits times the powers of 2 add up to s.
+func wnaf(s *btcec.ModNScalar) []int8 {
+	b := s.Bytes()
+
+	// little-endian words, with one more for when adding the digits back carries over
+	var k [5]uint64
+	for i := range 4 {
+		k[i] = binary.BigEndian.Uint64(b[32-8*(i+1) : 32-8*i])
+	}
+
+	digits := make([]int8, 0, 257)
+	for k != [5]uint64{} {
+		var d int64
+		if k[0]&1 == 1 {
+			d = int64(k[0] & (1<<msmWindow - 1))
+			if d >= 1<<(msmWindow-1) {
+				d -= 1 << msmWindow
+			}
+
+			// k -= d, which makes the next msmWindow-1 bits zero
+			var borrow, carry uint64
+			if d > 0 {
+				k[0], borrow = bits.Sub64(k[0], uint64(d), 0)
+				for i := 1; i < len(k); i++ {
+					k[i], borrow = bits.Sub64(k[i], 0, borrow)
+				}
+			} else {
+				k[0], carry = bits.Add64(k[0], uint64(-d), 0)
+				for i := 1; i < len(k); i++ {
+					k[i], carry = bits.Add64(k[i], 0, carry)
+				}
+			}
+		}
+		digits = append(digits, int8(d))
+
+		// k >>= 1
+		for i := 0; i < len(k)-1; i++ {
+			k[i] = k[i]>>1 | k[i+1]<<63
+		}
+		k[len(k)-1] >>= 1
+	}
+
+	return digits
+}
@@ -0,0 +1,269 @@
+package frost
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// MuSig2 (BIP-327) is what we use for groups in which all the signers must agree: instead of having shards of a key
+// that was split by a dealer or generated by a DKG, each signer has a key of its own and the group key is the
+// aggregate of all of them, which anyone can compute from the individual public keys.
+//
+// the aggregate is Q = a1*X1 + ... + an*Xn, where the coefficients ai are hashes of all the keys, so we give each
+// signer the shard g*ai*xi (g being -1 if Q has an odd y), and these are additive shards of the group key with an
+// even y, like all of our group keys, that go through the same signing code as FROST shards, except that
+//
+//   - the coefficient of each shard is always 1 instead of a Lagrange coefficient;
+//   - the binding coefficient is the one from BIP-327, b = H(D || E || X || m), with X being the tweaked key (with
+//     an adaptor T it is b = H'(D || E || X || T || m) instead, under a different tag);
+//   - a tweak is only added to the shard of the first signer, instead of to all of them.
+//
+// the partial signatures are the same as the ones BIP-327 gives (but for the first signer when there is a tweak,
+// as BIP-327 adds the tweak when aggregating) and so are the final signatures.
+
+// Method is how the group key came to be, and so how the signers sign for it.
+type Method byte
+
+const (
+	// MethodFROST is for keys split in shards by a dealer or generated by a DKG, with any threshold.
+	MethodFROST Method = 0
+
+	// MethodMuSig2 is for keys aggregated from the keys of the signers with MuSig2AggregateKeys, which all must sign.
+	MethodMuSig2 Method = 1
+)
+
+func (m Method) String() string {
+	switch m {
+	case MethodFROST:
+		return "frost"
+	case MethodMuSig2:
+		return "musig2"
+	default:
+		return fmt.Sprintf("unknown method %d", byte(m))
+	}
+}
+
+// ParseMethod is the inverse of Method.String.
+func ParseMethod(s string) (Method, error) {
+	switch s {
+	case MethodFROST.String():
+		return MethodFROST, nil
+	case MethodMuSig2.String():
+		return MethodMuSig2, nil
+	default:
+		return 0, fmt.Errorf("unknown method '%s'", s)
+	}
+}
+
+var (
+	tagKeyAggList        = []byte("KeyAgg list")
+	tagKeyAggCoefficient = []byte("KeyAgg coefficient")
+	tagMuSig2NonceCoef   = []byte("MuSig/noncecoef")
+
+	tagMuSig2AdaptorNonceCoef = []byte("frost/musig2/adaptor-noncecoef")
+)
+
+// MuSig2AggregateKeys does the BIP-327 key aggregation of the keys of the signers, in the order given, which is also
+// the order of their identifiers (starting at 1). it returns the group key, with an even y, and the public shard
+// each signer signs with.
+func MuSig2AggregateKeys(keys []*btcec.JacobianPoint) (*btcec.JacobianPoint, []PublicKeyShard, error) {
+	pubkey, shards, _, _, err := musig2Aggregate(keys)
+	return pubkey, shards, err
+}
+
+// MuSig2KeyShard turns the secret key of the signer identified by id, whose public key must be keys[id-1], into its
+// shard of the group key.
+func MuSig2KeyShard(keys []*btcec.JacobianPoint, id int, secret *btcec.ModNScalar) (KeyShard, error) {
+	if id < 1 || id > len(keys) {
+		return KeyShard{}, fmt.Errorf("identifier %d is out of range for %d keys", id, len(keys))
+	}
+	if secret == nil || secret.IsZero() {
+		return KeyShard{}, fmt.Errorf("secret key is nil or zero")
+	}
+
+	own := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(secret, own)
+	own.ToAffine()
+	if !own.X.Equals(&keys[id-1].X) || !own.Y.Equals(&keys[id-1].Y) {
+		return KeyShard{}, fmt.Errorf("secret key doesn't match the key of %d", id)
+	}
+
+	pubkey, shards, coefficients, negate, err := musig2Aggregate(keys)
+	if err != nil {
+		return KeyShard{}, err
+	}
+
+	// g * ai * xi
+	s := new(btcec.ModNScalar).Mul2(coefficients[id-1], secret)
+	if negate {
+		s.Negate()
+	}
+
+	return KeyShard{
+		PublicKeyShard: shards[id-1],
+		Secret:         s,
+		PublicKey:      pubkey,
+	}, nil
+}
+
+// musig2Aggregate is MuSig2AggregateKeys also returning the coefficients and whether the aggregate had an odd y (in
+// which case the returned key and shards are already negated).
+func musig2Aggregate(keys []*btcec.JacobianPoint) (
+	pubkey *btcec.JacobianPoint,
+	shards []PublicKeyShard,
+	coefficients []*btcec.ModNScalar,
+	negate bool,
+	err error,
+) {
+	coefficients, err = musig2Coefficients(keys)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+
+	shards = make([]PublicKeyShard, len(keys))
+	pubkey = new(btcec.JacobianPoint)
+	for i, key := range keys {
+		pt := new(btcec.JacobianPoint)
+		btcec.ScalarMultNonConst(coefficients[i], key, pt)
+		btcec.AddNonConst(pubkey, pt, pubkey)
+		shards[i] = PublicKeyShard{ID: i + 1, PublicKey: pt}
+	}
+
+	pubkey.ToAffine()
+	if pubkey.X.IsZero() && pubkey.Y.IsZero() {
+		return nil, nil, nil, false, fmt.Errorf("aggregate key is the point at infinity")
+	}
+
+	// g = -1 goes into all the shards
+	negate = pubkey.Y.IsOdd()
+	if negate {
+		pubkey.Y.Negate(1)
+		pubkey.Y.Normalize()
+	}
+	for _, shard := range shards {
+		shard.PublicKey.ToAffine()
+		if negate {
+			shard.PublicKey.Y.Negate(1)
+			shard.PublicKey.Y.Normalize()
+		}
+	}
+
+	return pubkey, shards, coefficients, negate, nil
+}
+
+// musig2Coefficients gives the KeyAgg coefficient of each key: H(L || Xi), L being the hash of all the keys, except
+// for the second distinct key (the first one that differs from keys[0]), which gets 1.
+func musig2Coefficients(keys []*btcec.JacobianPoint) ([]*btcec.ModNScalar, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys to aggregate")
+	}
+
+	encoded := make([]byte, 33*len(keys))
+	for i, key := range keys {
+		if key == nil || (key.X.IsZero() && key.Y.IsZero()) {
+			return nil, fmt.Errorf("invalid key at %d", i)
+		}
+		key.ToAffine()
+		writePointTo(encoded[i*33:(i+1)*33], key)
+	}
+	list := chainhash.TaggedHash(tagKeyAggList, encoded)
+
+	var second []byte
+	for i := 1; i < len(keys); i++ {
+		if !bytes.Equal(encoded[i*33:(i+1)*33], encoded[0:33]) {
+			second = encoded[i*33 : (i+1)*33]
+			break
+		}
+	}
+
+	coefficients := make([]*btcec.ModNScalar, len(keys))
+	for i := range keys {
+		key := encoded[i*33 : (i+1)*33]
+		coefficients[i] = new(btcec.ModNScalar)
+		if bytes.Equal(key, second) {
+			coefficients[i].SetInt(1)
+			continue
+		}
+		hash := chainhash.TaggedHash(tagKeyAggCoefficient, list[:], key)
+		coefficients[i].SetBytes((*[32]byte)(hash))
+	}
+
+	return coefficients, nil
+}
+
+// computeMuSig2NonceCoefficient is the MuSig2 counterpart of computeBindingCoefficient, publicKey is the key we're
+// signing for (with the tweak, if any). without an adaptor this is exactly BIP-327's, with one the adaptor point goes
+// right after the key and the hash gets a tag of its own, so it can't be confused with a BIP-327 one.
+func computeMuSig2NonceCoefficient(
+	publicKey *btcec.JacobianPoint,
+	aggNonce BinoncePublic,
+	message []byte,
+	adaptor *btcec.JacobianPoint,
+) *btcec.ModNScalar {
+	tag := tagMuSig2NonceCoef
+	adaptorSize := 0
+	if adaptor != nil {
+		tag = tagMuSig2AdaptorNonceCoef
+		adaptorSize = 33
+	}
+	preimage := make([]byte, 33+33+32+adaptorSize+len(message))
+
+	writePointTo(preimage[0:33], aggNonce[0])
+	writePointTo(preimage[33:33+33], aggNonce[1])
+	publicKey.X.PutBytesUnchecked(preimage[33+33 : 33+33+32])
+	if adaptor != nil {
+		writePointTo(preimage[33+33+32:33+33+32+33], adaptor)
+	}
+	copy(preimage[33+33+32+adaptorSize:], message)
+
+	hash := chainhash.TaggedHash(tag, preimage)
+	s := new(btcec.ModNScalar)
+	s.SetBytes((*[32]byte)(hash))
+
+	return s
+}
+
+// checkMuSig2 makes sure a MuSig2 configuration has everybody in it.
+func (c *Configuration) checkMuSig2() error {
+	if c.Threshold != c.MaxSigners {
+		return fmt.Errorf("%s needs all the %d signers, not %d", c.Method, c.MaxSigners, c.Threshold)
+	}
+	if len(c.Participants) != c.MaxSigners {
+		return fmt.Errorf("%s needs all the %d signers to participate, not %d",
+			c.Method, c.MaxSigners, len(c.Participants))
+	}
+	return nil
+}
+
+// coefficient is what the shard of id is multiplied by when signing: its Lagrange coefficient among the participants
+// with FROST, 1 with MuSig2 as the shards add up to the group key already.
+func (c *Configuration) coefficient(lambdaRegistry *LambdaRegistry, id int) *btcec.ModNScalar {
+	if c.Method == MethodMuSig2 {
+		return new(btcec.ModNScalar).SetInt(1)
+	}
+	return lambdaRegistry.GetOrNew(c.Participants, id)
+}
+
+// bindingCoefficient is b in R = D + b*E, for signing with signingKey (and for the Adaptor, if there is one).
+func (c *Configuration) bindingCoefficient(
+	signingKey *btcec.JacobianPoint,
+	groupCommitment BinoncePublic,
+	message []byte,
+) *btcec.ModNScalar {
+	if c.Method == MethodMuSig2 {
+		return computeMuSig2NonceCoefficient(signingKey, groupCommitment, message, c.Adaptor)
+	}
+	return computeBindingCoefficient(signingKey, groupCommitment, message, c.Participants, c.Adaptor)
+}
+
+// shardTweak is what gets added to the shard of id for signing with the tweaked key: the whole tweak with FROST, as
+// the Lagrange coefficients add up to 1, and with MuSig2, as the shards simply add up, only the first signer adds it.
+func (c *Configuration) shardTweak(id int) *btcec.ModNScalar {
+	if c.Method == MethodMuSig2 && id != 1 {
+		return nil
+	}
+	return c.Tweak
+}
@@ -0,0 +1,277 @@
+package frost
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// One of the signers of a group can itself be a group: a sub-committee whose KeyShard (the parent) is split among
+// its members with a threshold of its own, exactly like the group key is split among the signers (see
+// SplitKeyShard). any threshold of the members can then sign for the parent in an inner round: each one signs with
+// the Lagrange coefficient of the parent among the participants times its own among the members taking part, so
+// their commitments add up to the commitment of the parent (AggregateSubCommitments) and their partial signatures
+// add up to its partial signature (AggregateSubSignatures). the rest of the group doesn't see any of this.
+
+// SubShard is what each member of a sub-committee holds.
+type SubShard struct {
+	// the member's share of the parent's secret: its ID is the member's identifier within the sub-committee, its
+	// PublicKey is the public key of the parent (which is to the sub-committee what the group key is to the group)
+	// and its VssCommitment has that as the constant term
+	KeyShard
+
+	// Parent is the public side of the shard that was split, as the rest of the group knows it
+	Parent PublicKeyShard
+
+	// GroupPublicKey is the key of the whole group
+	GroupPublicKey *btcec.JacobianPoint
+}
+
+// SplitKeyShard shares the secret of shard among maxMembers members, threshold of which can sign for it.
+func SplitKeyShard(shard KeyShard, threshold, maxMembers int) ([]SubShard, error) {
+	if maxMembers < threshold || threshold <= 0 {
+		return nil, fmt.Errorf("bad threshold %d for %d members", threshold, maxMembers)
+	}
+
+	polynomial, err := makePolynomial(shard.Secret, threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer polynomial.Zero()
+
+	commits := VSSCommit(polynomial)
+
+	subShards := make([]SubShard, maxMembers)
+	for i := range subShards {
+		subShards[i] = SubShard{
+			KeyShard:       makeKeyShard(i+1, polynomial, shard.PublicKeyShard.PublicKey, commits),
+			Parent:         shard.PublicKeyShard,
+			GroupPublicKey: shard.PublicKey,
+		}
+	}
+
+	return subShards, nil
+}
+
+// Committee is the configuration of the inner round of the sub-committee sub belongs to, in which the given members
+// take part, to be given to SubSigner and VerifySubPartialSignature.
+func (sub SubShard) Committee(maxMembers int, members []int) *Configuration {
+	return &Configuration{
+		PublicKey:    sub.Parent.PublicKey,
+		Threshold:    len(sub.VssCommitment),
+		MaxSigners:   maxMembers,
+		Participants: members,
+	}
+}
+
+// checkNested says if signers in this configuration can be sub-committees.
+func (c *Configuration) checkNested() error {
+	if c.Ciphersuite != CiphersuiteBIP340 {
+		return fmt.Errorf("sub-committees are not supported with the %s ciphersuite", c.Ciphersuite)
+	}
+	if c.Method != MethodFROST {
+		return fmt.Errorf("sub-committees are not supported with %s", c.Method)
+	}
+	return nil
+}
+
+// validateCommittee checks that committee is a good configuration for the inner round of the sub-committee that
+// holds parent.
+func (c *Configuration) validateCommittee(parent PublicKeyShard, committee *Configuration) error {
+	if err := c.checkNested(); err != nil {
+		return err
+	}
+	if err := c.ValidatePublicKeyShard(parent); err != nil {
+		return fmt.Errorf("invalid parent shard: %w", err)
+	}
+	if !slices.Contains(c.Participants, parent.ID) {
+		return fmt.Errorf("parent shard %d is not a participant", parent.ID)
+	}
+
+	if committee.Ciphersuite != CiphersuiteBIP340 || committee.Method != MethodFROST {
+		return fmt.Errorf("sub-committee must use plain FROST")
+	}
+	if committee.Tweak != nil || committee.Adaptor != nil {
+		return fmt.Errorf("sub-committee can't have a tweak or an adaptor, these come from the group")
+	}
+	if committee.PublicKey == nil ||
+		!committee.PublicKey.X.Equals(&parent.PublicKey.X) || !committee.PublicKey.Y.Equals(&parent.PublicKey.Y) {
+		return fmt.Errorf("sub-committee is for a different shard")
+	}
+	if len(committee.Participants) < committee.Threshold {
+		return fmt.Errorf("sub-committee has %d members taking part, needs %d",
+			len(committee.Participants), committee.Threshold)
+	}
+	for i, member := range committee.Participants {
+		if member == 0 || member > committee.MaxSigners {
+			return fmt.Errorf("invalid member %d", member)
+		}
+		if slices.Contains(committee.Participants[:i], member) {
+			return fmt.Errorf("member %d appears twice", member)
+		}
+	}
+
+	return nil
+}
+
+// SubSigner returns a signer for a member of a sub-committee, which signs for the parent of sub in this configuration
+// together with the other members of committee (see SubShard.Committee). its commitments and partial signatures
+// carry its identifier within the sub-committee, they only make sense for the group after aggregation.
+func (c *Configuration) SubSigner(sub SubShard, committee *Configuration, lambdaRegistry *LambdaRegistry) (*Signer, error) {
+	if err := c.validateCommittee(sub.Parent, committee); err != nil {
+		return nil, err
+	}
+	if sub.GroupPublicKey == nil ||
+		!c.PublicKey.X.Equals(&sub.GroupPublicKey.X) || c.PublicKey.Y.IsOdd() != sub.GroupPublicKey.Y.IsOdd() {
+		return nil, fmt.Errorf("sub-shard is for a different group key")
+	}
+	if err := committee.ValidateKeyShard(sub.KeyShard); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(committee.Participants, sub.ID) {
+		return nil, fmt.Errorf("we (%d) are not taking part in the sub-committee", sub.ID)
+	}
+
+	return &Signer{
+		LambdaRegistry: lambdaRegistry,
+		KeyShard:       sub.KeyShard,
+		Configuration:  c,
+		parentID:       sub.Parent.ID,
+		committee:      committee,
+	}, nil
+}
+
+// AggregateSubCommitments sums the commitments of the members of a sub-committee into the commitment of the parent,
+// whose identifier is parentID, which is what goes into the group's commitment list.
+func AggregateSubCommitments(parentID int, commitments []Commitment) Commitment {
+	aggregated := Commitment{
+		SignerID:      parentID,
+		BinoncePublic: BinoncePublic{new(btcec.JacobianPoint), new(btcec.JacobianPoint)},
+	}
+	for _, commitment := range commitments {
+		btcec.AddNonConst(aggregated.BinoncePublic[0], commitment.BinoncePublic[0], aggregated.BinoncePublic[0])
+		btcec.AddNonConst(aggregated.BinoncePublic[1], commitment.BinoncePublic[1], aggregated.BinoncePublic[1])
+	}
+	aggregated.BinoncePublic[0].ToAffine()
+	aggregated.BinoncePublic[1].ToAffine()
+	return aggregated
+}
+
+// AggregateSubSignatures sums the partial signatures of the members of a sub-committee into the partial signature of
+// the parent, whose identifier is parentID, which can be verified and aggregated like any other.
+func AggregateSubSignatures(parentID int, partialSigs []PartialSignature) (PartialSignature, error) {
+	value := new(btcec.ModNScalar)
+	for _, partialSig := range partialSigs {
+		if partialSig.Value == nil || partialSig.Value.IsZero() {
+			return PartialSignature{}, fmt.Errorf("invalid partial signature from member %d (nil or zero scalar)",
+				partialSig.SignerIdentifier)
+		}
+		value.Add(partialSig.Value)
+	}
+
+	return PartialSignature{
+		SignerIdentifier: parentID,
+		Value:            value,
+	}, nil
+}
+
+// VerifySubPartialSignature checks the partial signature of a member of the sub-committee that holds parent against
+// its public sub-shard. commit is the member's commitment as it was sent, and groupCommitment and bindingCoefficient
+// are what ComputeGroupCommitment gave for the group's commitment list (with the aggregated commitments of the
+// sub-committees in it).
+func (c *Configuration) VerifySubPartialSignature(
+	parent PublicKeyShard,
+	committee *Configuration,
+	member PublicKeyShard,
+	commit BinoncePublic,
+	groupCommitment BinoncePublic,
+	bindingCoefficient *btcec.ModNScalar,
+	partialSig PartialSignature,
+	message []byte,
+	lambdaRegistry *LambdaRegistry,
+) error {
+	if partialSig.Value == nil || partialSig.Value.IsZero() {
+		return fmt.Errorf("invalid signature shard (nil or zero scalar): %v", partialSig.Value)
+	}
+	if err := c.validateCommittee(parent, committee); err != nil {
+		return err
+	}
+	if partialSig.SignerIdentifier != member.ID || !slices.Contains(committee.Participants, member.ID) {
+		return fmt.Errorf("partial signature from %d is not from a member taking part", partialSig.SignerIdentifier)
+	}
+
+	// the commitments given to ComputeGroupCommitment were negated if the final nonce had an odd y, the member's
+	// must be too
+	finalNonce, negate := bindFinalNonce(groupCommitment, bindingCoefficient, c.Adaptor)
+	if negate {
+		negated := BinoncePublic{new(btcec.JacobianPoint), new(btcec.JacobianPoint)}
+		for i := range negated {
+			negated[i].Set(commit[i])
+			negated[i].ToAffine()
+			negated[i].Y.Negate(1)
+			negated[i].Y.Normalize()
+		}
+		commit = negated
+	}
+
+	lambda := new(btcec.ModNScalar).Mul2(
+		c.coefficient(lambdaRegistry, parent.ID),
+		committee.coefficient(lambdaRegistry, member.ID),
+	)
+
+	return c.verifyPartialSignature(c.tweakedPublicShard(member), lambda, commit, bindingCoefficient, finalNonce,
+		partialSig, message)
+}
+
+func (s SubShard) Hex() string { return hex.EncodeToString(s.Encode()) }
+func (s *SubShard) DecodeHex(x string) error {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return err
+	}
+	return s.Decode(b)
+}
+
+func (s SubShard) Encode() []byte {
+	kslen := s.KeyShard.encodedSize()
+	plen := s.Parent.encodedSize()
+	out := make([]byte, wireHeaderSize+kslen+plen+33)
+	putWireHeader(out, wireSubShard)
+	body := out[wireHeaderSize:]
+
+	s.KeyShard.encodeTo(body)
+	s.Parent.encodeTo(body[kslen:])
+	writePointTo(body[kslen+plen:], s.GroupPublicKey)
+
+	return out
+}
+
+func (s *SubShard) Decode(in []byte) error {
+	versioned, err := readWireHeader(in, wireSubShard)
+	if err != nil {
+		return err
+	}
+	if !versioned {
+		return fmt.Errorf("missing header")
+	}
+	in = in[wireHeaderSize:]
+
+	kslen, err := s.KeyShard.decodeFrom(in)
+	if err != nil {
+		return err
+	}
+	plen, err := s.Parent.decodeFrom(in[kslen:])
+	if err != nil {
+		return fmt.Errorf("error decoding parent shard: %w", err)
+	}
+	if err := checkWireLength(in, kslen+plen+33); err != nil {
+		return err
+	}
+	if s.GroupPublicKey, err = readPoint(in[kslen+plen:]); err != nil {
+		return fmt.Errorf("failed to decode group pubkey: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package frost
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// CommitmentList is how many commitments go through the wire at once, such as a batch of preprocessed ones.
+type CommitmentList []Commitment
+
+// NoncePool keeps the secret nonces of commitments that were generated ahead of time and handed to the coordinator,
+// such that a signature can later be made in a single round. A secret nonce is removed from the pool as soon as it
+// is taken, so it can never be used to sign twice.
+//
+// Only the most recent max nonces are kept, older ones are dropped as new ones are generated.
+type NoncePool struct {
+	mu      sync.Mutex
+	max     int
+	order   [][33]byte
+	secrets map[[33]byte]BinonceSecret // indexed by the public hiding nonce
+}
+
+func NewNoncePool(max int) *NoncePool {
+	return &NoncePool{
+		max:     max,
+		order:   make([][33]byte, 0, max),
+		secrets: make(map[[33]byte]BinonceSecret, max),
+	}
+}
+
+// Len is the number of secret nonces that are still available.
+func (p *NoncePool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.secrets)
+}
+
+// Generate creates n new nonces for the given shard, keeps the secret parts and returns the commitments, which can
+// be published. batchId must be unique, just like the session id given to Signer.Commit.
+func (p *NoncePool) Generate(shard KeyShard, batchId string, n int) CommitmentList {
+	commitments := make(CommitmentList, n)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range commitments {
+		nonceId := batchId + "/" + strconv.Itoa(i)
+		secHN, pubHN := generateNonce(nonceId+"h", shard.Secret, shard.PublicKey)
+		secBN, pubBN := generateNonce(nonceId+"b", shard.Secret, shard.PublicKey)
+
+		commitments[i] = Commitment{
+			SignerID:      shard.ID,
+			BinoncePublic: BinoncePublic{pubHN, pubBN},
+		}
+
+		var key [33]byte
+		writePointTo(key[:], pubHN)
+		p.secrets[key] = BinonceSecret{secHN, secBN}
+		p.order = append(p.order, key)
+	}
+
+	// forget the oldest
+	for len(p.order) > p.max {
+		evicted := p.secrets[p.order[0]]
+		evicted.Zero()
+		delete(p.secrets, p.order[0])
+		p.order = p.order[1:]
+	}
+
+	return commitments
+}
+
+// take removes the secret nonces for the given public nonces from the pool and returns them.
+func (p *NoncePool) take(binonce BinoncePublic) (BinonceSecret, bool) {
+	var key [33]byte
+	writePointTo(key[:], binonce[0])
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secret, ok := p.secrets[key]
+	if !ok {
+		return BinonceSecret{}, false
+	}
+	delete(p.secrets, key)
+	for i, k := range p.order {
+		if k == key {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+
+	// the binding nonce must match too, otherwise this is someone else's commitment
+	pt := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(secret[1], pt)
+	pt.ToAffine()
+	if !pt.X.Equals(&binonce[1].X) || !pt.Y.Equals(&binonce[1].Y) {
+		return BinonceSecret{}, false
+	}
+
+	return secret, true
+}
+
+// UsePreprocessed loads the secret nonces for a commitment that came from a NoncePool into the signer so Sign can be
+// called right away, skipping Commit. The nonces are gone from the pool even if this fails.
+func (s *Signer) UsePreprocessed(pool *NoncePool, commitment Commitment) error {
+	if commitment.SignerID != s.KeyShard.ID {
+		return fmt.Errorf("commitment is for signer %d, we are %d", commitment.SignerID, s.KeyShard.ID)
+	}
+
+	secret, ok := pool.take(commitment.BinoncePublic)
+	if !ok {
+		return fmt.Errorf("we don't have the nonces for this commitment, they were used already or never existed")
+	}
+
+	s.SecretNonces = secret
+	return nil
+}
+
+func (cl CommitmentList) Hex() string { return hex.EncodeToString(cl.Encode()) }
+func (cl *CommitmentList) DecodeHex(x string) error {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return err
+	}
+	return cl.Decode(b)
+}
+
+func (cl CommitmentList) Encode() []byte {
+	out := make([]byte, wireHeaderSize+2+len(cl)*(2+33+33))
+	putWireHeader(out, wireCommitmentList)
+	binary.BigEndian.PutUint16(out[wireHeaderSize:], uint16(len(cl)))
+	for i, commitment := range cl {
+		commitment.encodeTo(out[wireHeaderSize+2+i*(2+33+33):])
+	}
+	return out
+}
+
+func (cl *CommitmentList) Decode(in []byte) error {
+	versioned, err := readWireHeader(in, wireCommitmentList)
+	if err != nil {
+		return err
+	}
+	if !versioned {
+		// the old encoding was just the old encoding of each commitment, one after the other
+		if len(in)%(2+33+33) != 0 {
+			return fmt.Errorf("invalid length %d", len(in))
+		}
+		*cl = make(CommitmentList, len(in)/(2+33+33))
+		for i := range *cl {
+			if err := (*cl)[i].Decode(in[i*(2+33+33) : (i+1)*(2+33+33)]); err != nil {
+				return fmt.Errorf("failed to decode commitment %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+
+	in = in[wireHeaderSize:]
+	if len(in) < 2 {
+		return fmt.Errorf("too small")
+	}
+	n := int(binary.BigEndian.Uint16(in[0:2]))
+	if err := checkWireLength(in, 2+n*(2+33+33)); err != nil {
+		return err
+	}
+
+	*cl = make(CommitmentList, n)
+	for i := range *cl {
+		if err := (*cl)[i].decodeFrom(in[2+i*(2+33+33):]); err != nil {
+			return fmt.Errorf("failed to decode commitment %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	"github.com/btcsuite/btcd/btcec/v2"
-	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
 type PublicKeyShard struct {
@@ -26,53 +25,102 @@ func (c *PublicKeyShard) DecodeHex(x string) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.Decode(b)
-	return err
+	n, err := c.Decode(b)
+	if err != nil {
+		return err
+	}
+	return checkWireLength(b, n)
 }
 
 func (p PublicKeyShard) Encode() []byte {
-	out := make([]byte, 6+33+33*len(p.VssCommitment))
+	out := make([]byte, wireHeaderSize+p.encodedSize())
+	putWireHeader(out, wirePublicKeyShard)
+	p.encodeTo(out[wireHeaderSize:])
+	return out
+}
 
-	binary.LittleEndian.PutUint16(out[0:2], uint16(p.ID))
-	binary.LittleEndian.PutUint32(out[2:6], uint32(len(p.VssCommitment)))
+func (p PublicKeyShard) encodedSize() int { return 2 + 2 + 33 + 33*len(p.VssCommitment) }
 
-	writePointTo(out[6:], p.PublicKey)
+func (p PublicKeyShard) encodeTo(out []byte) {
+	binary.BigEndian.PutUint16(out[0:2], uint16(p.ID))
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(p.VssCommitment)))
+
+	writePointTo(out[4:4+33], p.PublicKey)
 
 	for i, c := range p.VssCommitment {
-		writePointTo(out[6+33+i*33:], c)
+		writePointTo(out[4+33+i*33:], c)
 	}
-
-	return out
 }
 
+// Decode reads a public key shard from the beginning of in and returns how many bytes it took.
 func (p *PublicKeyShard) Decode(in []byte) (int, error) {
+	versioned, err := readWireHeader(in, wirePublicKeyShard)
+	if err != nil {
+		return 0, err
+	}
+	if !versioned {
+		return p.decodeLegacy(in)
+	}
+
+	n, err := p.decodeFrom(in[wireHeaderSize:])
+	return wireHeaderSize + n, err
+}
+
+func (p *PublicKeyShard) decodeFrom(in []byte) (int, error) {
+	if len(in) < 2+2+33 {
+		return 0, fmt.Errorf("too small (expected length %d, got %d)", 2+2+33, len(in))
+	}
+
+	p.ID = int(binary.BigEndian.Uint16(in[0:2]))
+	p.VssCommitment = make([]*btcec.JacobianPoint, binary.BigEndian.Uint16(in[2:4]))
+
+	fullLength := 2 + 2 + 33 + len(p.VssCommitment)*33
+	if len(in) < fullLength {
+		return 0, fmt.Errorf("too small for %d vss commitments", len(p.VssCommitment))
+	}
+
+	var err error
+	if p.PublicKey, err = readPoint(in[4 : 4+33]); err != nil {
+		return 0, fmt.Errorf("failed to decode pubkey: %w", err)
+	}
+
+	for i := range p.VssCommitment {
+		if p.VssCommitment[i], err = readPoint(in[4+33+33*i:]); err != nil {
+			return 0, fmt.Errorf("failed to decode vss commitment %d: %w", i, err)
+		}
+	}
+
+	return fullLength, nil
+}
+
+// decodeLegacy reads the encoding from before there was a header, in which the identifier was a little-endian
+// uint16 and the number of vss commitments a little-endian uint32.
+func (p *PublicKeyShard) decodeLegacy(in []byte) (int, error) {
 	if len(in) < 6+33 {
 		return 0, fmt.Errorf("too small (expected length %d, got %d)", 6+33, len(in))
 	}
 
 	p.ID = int(binary.LittleEndian.Uint16(in[0:2]))
-	p.VssCommitment = make([]*secp256k1.JacobianPoint, binary.LittleEndian.Uint32(in[2:6]))
-
-	if pk, err := btcec.ParsePubKey(in[6 : 6+33]); err != nil {
-		return 0, fmt.Errorf("failed to decode pubkey: %w", err)
-	} else {
-		p.PublicKey = new(btcec.JacobianPoint)
-		pk.AsJacobian(p.PublicKey)
+	count := binary.LittleEndian.Uint32(in[2:6])
+	if count > 0xffff {
+		return 0, fmt.Errorf("too many vss commitments: %d", count)
 	}
+	p.VssCommitment = make([]*btcec.JacobianPoint, count)
 
 	fullLength := 6 + 33 + len(p.VssCommitment)*33
 	if len(in) < fullLength {
-		return 0, fmt.Errorf("too small for vss commitments")
+		return 0, fmt.Errorf("too small for %d vss commitments", len(p.VssCommitment))
+	}
+
+	var err error
+	if p.PublicKey, err = readPoint(in[6 : 6+33]); err != nil {
+		return 0, fmt.Errorf("failed to decode pubkey: %w", err)
 	}
 
 	for i := range p.VssCommitment {
-		pk, err := btcec.ParsePubKey(in[6+33+33*i : 6+33+33*i+33])
-		if err != nil {
+		if p.VssCommitment[i], err = readPoint(in[6+33+33*i:]); err != nil {
 			return 0, fmt.Errorf("failed to decode vss commitment %d: %w", i, err)
 		}
-		p.VssCommitment[i] = new(btcec.JacobianPoint)
-		pk.AsJacobian(p.VssCommitment[i])
-
 	}
 
 	return fullLength, nil
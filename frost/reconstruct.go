@@ -0,0 +1,79 @@
+package frost
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// ReconstructSecret interpolates the group secret from at least threshold shards, which must all be for the same
+// key, and checks it against that key. This defeats the whole purpose of having shards, so it's only meant for when
+// the user wants to take the key out and stop using FROST with it.
+//
+// Since only the x coordinate of the public key matters the result is always the secret that yields an even y, as
+// BIP-340 wants it, which may be the negation of the key that was originally sharded.
+func ReconstructSecret(threshold int, shards []KeyShard) (*btcec.ModNScalar, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("bad threshold %d", threshold)
+	}
+	if len(shards) < threshold {
+		return nil, fmt.Errorf("need at least %d shards, got %d", threshold, len(shards))
+	}
+
+	pubkey := shards[0].PublicKey
+	if pubkey == nil {
+		return nil, fmt.Errorf("shard %d has no public key", shards[0].ID)
+	}
+	cfg := Configuration{
+		PublicKey: pubkey,
+		Threshold: threshold,
+	}
+
+	participants := make([]int, len(shards))
+	for i, shard := range shards {
+		if shard.ID <= 0 {
+			return nil, fmt.Errorf("identifier %d is out of range", shard.ID)
+		}
+		if shard.Secret == nil || shard.Secret.IsZero() {
+			return nil, fmt.Errorf("shard %d has no secret", shard.ID)
+		}
+		if shard.PublicKey == nil || !shard.PublicKey.X.Equals(&pubkey.X) {
+			return nil, fmt.Errorf("shard %d is for a different key", shard.ID)
+		}
+		for _, prev := range participants[:i] {
+			if prev == shard.ID {
+				return nil, fmt.Errorf("multiple shards for %d", shard.ID)
+			}
+		}
+
+		// shards from before we kept track of the commitments don't have them, but we'll check the result anyway
+		if len(shard.PublicKeyShard.VssCommitment) > 0 {
+			if err := cfg.VerifySecretShare(shard.ID, shard.Secret, shard.PublicKeyShard.VssCommitment); err != nil {
+				return nil, err
+			}
+		}
+
+		participants[i] = shard.ID
+	}
+
+	secret := new(btcec.ModNScalar)
+	for _, shard := range shards {
+		term := computeLambda(shard.ID, participants)
+		term.Mul(shard.Secret)
+		secret.Add(term)
+	}
+
+	actual := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(secret, actual)
+	actual.ToAffine()
+	if !actual.X.Equals(&pubkey.X) {
+		return nil, fmt.Errorf("shards don't add up to the public key")
+	}
+
+	// BIP-340 special
+	if actual.Y.IsOdd() {
+		secret.Negate()
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,227 @@
+package frost
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// RefreshCommitment is what each signer broadcasts in the first round of a proactive share refresh: a commitment to
+// a random polynomial whose constant term is zero. Since that term is known by everybody it is omitted, so the
+// VssCommitment here starts at the coefficient of degree 1.
+type RefreshCommitment struct {
+	VssCommitment VssCommitment
+	SignerID      int
+}
+
+// RefreshParticipant holds the secret state of one signer during a proactive share refresh.
+type RefreshParticipant struct {
+	ID         int
+	Threshold  int
+	MaxSigners int
+
+	polynomial Polynomial
+}
+
+// NewRefreshParticipant creates a random polynomial with a zero constant term for the signer id and returns the
+// commitment that must be broadcast to all the other signers. Every single one of the MaxSigners signers must take
+// part in a refresh, otherwise the ones left out will end up with shards that don't match the others'.
+func NewRefreshParticipant(id, threshold, maxSigners int) (*RefreshParticipant, RefreshCommitment, error) {
+	if threshold <= 0 || maxSigners < threshold {
+		return nil, RefreshCommitment{}, fmt.Errorf("bad threshold %d for %d signers", threshold, maxSigners)
+	}
+	if id <= 0 || id > maxSigners {
+		return nil, RefreshCommitment{}, fmt.Errorf("identifier %d is out of range", id)
+	}
+
+	polynomial := make(Polynomial, threshold)
+	polynomial[0] = new(btcec.ModNScalar)
+	for i := 1; i < threshold; i++ {
+		var random [32]byte
+		if _, err := rand.Read(random[:]); err != nil {
+			return nil, RefreshCommitment{}, fmt.Errorf("failed to read random: %w", err)
+		}
+		polynomial[i] = new(btcec.ModNScalar)
+		polynomial[i].SetBytes(&random)
+	}
+
+	p := &RefreshParticipant{
+		ID:         id,
+		Threshold:  threshold,
+		MaxSigners: maxSigners,
+		polynomial: polynomial,
+	}
+
+	com := RefreshCommitment{
+		SignerID:      id,
+		VssCommitment: VSSCommit(polynomial[1:]),
+	}
+
+	return p, com, nil
+}
+
+// ValidateRefreshCommitment checks that a refresh commitment has the right number of points.
+func ValidateRefreshCommitment(com RefreshCommitment, threshold, maxSigners int) error {
+	if com.SignerID <= 0 || com.SignerID > maxSigners {
+		return fmt.Errorf("identifier %d is out of range", com.SignerID)
+	}
+
+	if len(com.VssCommitment) != threshold-1 {
+		return fmt.Errorf("refresh commitment from %d has %d points, expected %d",
+			com.SignerID, len(com.VssCommitment), threshold-1)
+	}
+
+	for i, pt := range com.VssCommitment {
+		if pt == nil || (pt.X.IsZero() && pt.Y.IsZero()) {
+			return fmt.Errorf("refresh commitment from %d has an invalid point at %d", com.SignerID, i)
+		}
+	}
+
+	return nil
+}
+
+// Zero wipes our refresh polynomial from memory, after all the shares have been sent and Refresh was called.
+func (p *RefreshParticipant) Zero() { p.polynomial.Zero() }
+
+// Share returns the secret share this signer must send to the signer identified by to.
+func (p *RefreshParticipant) Share(to int) DKGShare {
+	return DKGShare{
+		From:  p.ID,
+		To:    to,
+		Value: p.polynomial.evaluate(new(btcec.ModNScalar).SetInt(uint32(to))),
+	}
+}
+
+// Refresh validates all the commitments and the shares received from the other signers and adds them to our
+// current shard, returning the new one. The group public key doesn't change, but the new shard can't be combined
+// with shards from before the refresh.
+func (p *RefreshParticipant) Refresh(
+	shard KeyShard,
+	commitments []RefreshCommitment,
+	shares []DKGShare,
+) (KeyShard, error) {
+	if shard.ID != p.ID {
+		return KeyShard{}, fmt.Errorf("shard is for %d, but we are %d", shard.ID, p.ID)
+	}
+
+	if len(commitments) != p.MaxSigners {
+		return KeyShard{}, fmt.Errorf("expected %d refresh commitments, got %d", p.MaxSigners, len(commitments))
+	}
+
+	for i, com := range commitments {
+		if err := ValidateRefreshCommitment(com, p.Threshold, p.MaxSigners); err != nil {
+			return KeyShard{}, err
+		}
+		for _, prev := range commitments[:i] {
+			if prev.SignerID == com.SignerID {
+				return KeyShard{}, fmt.Errorf("multiple refresh commitments from %d", com.SignerID)
+			}
+		}
+	}
+
+	secret := new(btcec.ModNScalar).Set(shard.Secret)
+	secret.Add(p.polynomial.evaluate(new(btcec.ModNScalar).SetInt(uint32(p.ID))))
+
+	for _, com := range commitments {
+		if com.SignerID == p.ID {
+			continue
+		}
+
+		idx := slices.IndexFunc(shares, func(sh DKGShare) bool { return sh.From == com.SignerID })
+		if idx == -1 {
+			return KeyShard{}, fmt.Errorf("missing refresh share from %d", com.SignerID)
+		}
+		share := shares[idx]
+		if share.To != p.ID {
+			return KeyShard{}, fmt.Errorf("refresh share from %d was meant for %d", share.From, share.To)
+		}
+
+		if share.Value == nil {
+			return KeyShard{}, fmt.Errorf("refresh share from %d is nil", share.From)
+		}
+		expected := com.publicEvaluation(p.ID)
+		actual := new(btcec.JacobianPoint)
+		scalarBaseMultLadder(share.Value, actual)
+		actual.ToAffine()
+		if !expected.X.Equals(&actual.X) || !expected.Y.Equals(&actual.Y) {
+			return KeyShard{}, fmt.Errorf("refresh share from %d doesn't match its commitment", share.From)
+		}
+
+		secret.Add(share.Value)
+	}
+
+	return KeyShard{
+		Secret:         secret,
+		PublicKey:      shard.PublicKey,
+		PublicKeyShard: RefreshPublicKeyShard(shard.PublicKeyShard, commitments),
+	}, nil
+}
+
+// RefreshPublicKeyShard computes what a signer's PublicKeyShard will be after a refresh with the given commitments,
+// so anyone can check the results.
+func RefreshPublicKeyShard(pks PublicKeyShard, commitments []RefreshCommitment) PublicKeyShard {
+	pk := new(btcec.JacobianPoint)
+	pk.Set(pks.PublicKey)
+	for _, com := range commitments {
+		btcec.AddNonConst(pk, com.publicEvaluation(pks.ID), pk)
+	}
+	pk.ToAffine()
+
+	var vss VssCommitment
+	if len(pks.VssCommitment) > 0 {
+		vss = make(VssCommitment, len(pks.VssCommitment))
+		vss[0] = pks.VssCommitment[0]
+		for k := 1; k < len(vss); k++ {
+			vss[k] = new(btcec.JacobianPoint)
+			vss[k].Set(pks.VssCommitment[k])
+			for _, com := range commitments {
+				btcec.AddNonConst(vss[k], com.VssCommitment[k-1], vss[k])
+			}
+			vss[k].ToAffine()
+		}
+	}
+
+	return PublicKeyShard{
+		ID:            pks.ID,
+		PublicKey:     pk,
+		VssCommitment: vss,
+	}
+}
+
+// publicEvaluation is the public counterpart of the refresh polynomial at id, the constant term being zero.
+func (c RefreshCommitment) publicEvaluation(id int) *btcec.JacobianPoint {
+	x := new(btcec.ModNScalar).SetInt(uint32(id))
+	result := new(btcec.JacobianPoint)
+	xk := new(btcec.ModNScalar).Set(x)
+	for _, coeff := range c.VssCommitment {
+		term := new(btcec.JacobianPoint)
+		btcec.ScalarMultNonConst(xk, coeff, term)
+		btcec.AddNonConst(result, term, result)
+		xk.Mul(x)
+	}
+	result.ToAffine()
+	return result
+}
+
+func (c RefreshCommitment) Hex() string { return hex.EncodeToString(c.Encode()) }
+func (c *RefreshCommitment) DecodeHex(x string) error {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return err
+	}
+	_, err = c.Decode(b)
+	return err
+}
+
+func (c RefreshCommitment) Encode() []byte {
+	return encodeIdentifiedVss(wireRefreshCommit, c.SignerID, c.VssCommitment)
+}
+
+// Decode reads a refresh commitment from the start of in and returns the number of bytes it took.
+func (c *RefreshCommitment) Decode(in []byte) (n int, err error) {
+	c.SignerID, c.VssCommitment, n, err = decodeIdentifiedVss(in, wireRefreshCommit)
+	return n, err
+}
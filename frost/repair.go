@@ -0,0 +1,108 @@
+package frost
+
+import (
+	"crypto/rand"
+	"fmt"
+	"slices"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// RepairShareStep1 is run by each of the helpers, a threshold of signers that still have their shards, in order to
+// recover the shard of the signer identified by lost. It splits this helper's contribution into one random piece for
+// each helper (itself included), such that the pieces only reveal anything once they are all summed by lost.
+func RepairShareStep1(shard KeyShard, helpers []int, lost int) ([]DKGShare, error) {
+	if !slices.Contains(helpers, shard.ID) {
+		return nil, fmt.Errorf("%d is not one of the helpers", shard.ID)
+	}
+	if slices.Contains(helpers, lost) {
+		return nil, fmt.Errorf("%d can't help repairing itself", lost)
+	}
+	if lost <= 0 {
+		return nil, fmt.Errorf("identifier %d is out of range", lost)
+	}
+
+	delta := computeLambdaAt(lost, shard.ID, helpers)
+	delta.Mul(shard.Secret)
+
+	deltas := make([]DKGShare, len(helpers))
+	last := -1
+	for i, helper := range helpers {
+		if helper == shard.ID {
+			// our own piece is whatever is left, so they all sum to delta
+			last = i
+			continue
+		}
+
+		var random [32]byte
+		if _, err := rand.Read(random[:]); err != nil {
+			return nil, fmt.Errorf("failed to read random: %w", err)
+		}
+		piece := new(btcec.ModNScalar)
+		piece.SetBytes(&random)
+
+		deltas[i] = DKGShare{From: shard.ID, To: helper, Value: piece}
+		delta.Add(new(btcec.ModNScalar).NegateVal(piece))
+	}
+	deltas[last] = DKGShare{From: shard.ID, To: shard.ID, Value: delta}
+
+	return deltas, nil
+}
+
+// RepairShareStep2 is run by each of the helpers with the pieces it got from all the helpers (its own included)
+// in RepairShareStep1, and returns what must be sent to the signer identified by lost.
+func RepairShareStep2(id int, helpers []int, lost int, deltas []DKGShare) (DKGShare, error) {
+	sigma := new(btcec.ModNScalar)
+	for _, helper := range helpers {
+		idx := slices.IndexFunc(deltas, func(d DKGShare) bool { return d.From == helper })
+		if idx == -1 {
+			return DKGShare{}, fmt.Errorf("missing repair piece from %d", helper)
+		}
+		if deltas[idx].To != id {
+			return DKGShare{}, fmt.Errorf("repair piece from %d was meant for %d", helper, deltas[idx].To)
+		}
+		if deltas[idx].Value == nil {
+			return DKGShare{}, fmt.Errorf("repair piece from %d is nil", helper)
+		}
+		sigma.Add(deltas[idx].Value)
+	}
+
+	return DKGShare{From: id, To: lost, Value: sigma}, nil
+}
+
+// RepairShareStep3 is run by the signer being repaired with what it got from all the helpers in RepairShareStep2.
+// The result is checked against the PublicKeyShard that was registered for it, so a wrong result is never accepted.
+func RepairShareStep3(
+	pks PublicKeyShard,
+	pubkey *btcec.JacobianPoint,
+	helpers []int,
+	sigmas []DKGShare,
+) (KeyShard, error) {
+	secret := new(btcec.ModNScalar)
+	for _, helper := range helpers {
+		idx := slices.IndexFunc(sigmas, func(s DKGShare) bool { return s.From == helper })
+		if idx == -1 {
+			return KeyShard{}, fmt.Errorf("missing repair share from %d", helper)
+		}
+		if sigmas[idx].To != pks.ID {
+			return KeyShard{}, fmt.Errorf("repair share from %d was meant for %d", helper, sigmas[idx].To)
+		}
+		if sigmas[idx].Value == nil {
+			return KeyShard{}, fmt.Errorf("repair share from %d is nil", helper)
+		}
+		secret.Add(sigmas[idx].Value)
+	}
+
+	actual := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(secret, actual)
+	actual.ToAffine()
+	if !actual.X.Equals(&pks.PublicKey.X) || !actual.Y.Equals(&pks.PublicKey.Y) {
+		return KeyShard{}, fmt.Errorf("repaired shard doesn't match the public shard for %d", pks.ID)
+	}
+
+	return KeyShard{
+		Secret:         secret,
+		PublicKey:      pubkey,
+		PublicKeyShard: pks,
+	}, nil
+}
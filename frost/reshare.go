@@ -0,0 +1,197 @@
+package frost
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// ReshareCommitment is what each of the old signers taking part in a resharing broadcasts: a commitment to a random
+// polynomial of the new degree whose constant term is its own shard multiplied by its Lagrange coefficient, so that
+// the constant terms of all the dealers sum to the group secret.
+type ReshareCommitment struct {
+	VssCommitment VssCommitment
+
+	// the identifier of the dealer in the old group
+	SignerID int
+}
+
+// ReshareDealer holds the secret state of one of the old signers during a resharing.
+type ReshareDealer struct {
+	ID int
+
+	polynomial Polynomial
+}
+
+// NewReshareDealer turns one of the old shards into a polynomial for the new group, with newThreshold coefficients.
+// participants are the identifiers of all the old signers taking part as dealers, which must be at least the old
+// threshold, and the resulting shares can be given to any number of new signers.
+func NewReshareDealer(shard KeyShard, participants []int, newThreshold int) (*ReshareDealer, ReshareCommitment, error) {
+	if newThreshold <= 0 {
+		return nil, ReshareCommitment{}, fmt.Errorf("bad threshold %d", newThreshold)
+	}
+	if !slices.Contains(participants, shard.ID) {
+		return nil, ReshareCommitment{}, fmt.Errorf("%d is not one of the participants", shard.ID)
+	}
+
+	constant := computeLambda(shard.ID, participants)
+	constant.Mul(shard.Secret)
+
+	polynomial := make(Polynomial, newThreshold)
+	polynomial[0] = constant
+	for i := 1; i < newThreshold; i++ {
+		var random [32]byte
+		if _, err := rand.Read(random[:]); err != nil {
+			return nil, ReshareCommitment{}, fmt.Errorf("failed to read random: %w", err)
+		}
+		polynomial[i] = new(btcec.ModNScalar)
+		polynomial[i].SetBytes(&random)
+	}
+
+	d := &ReshareDealer{
+		ID:         shard.ID,
+		polynomial: polynomial,
+	}
+
+	com := ReshareCommitment{
+		SignerID:      shard.ID,
+		VssCommitment: VSSCommit(polynomial),
+	}
+
+	return d, com, nil
+}
+
+// ValidateReshareCommitment checks that a dealer committed to the right number of coefficients and that its constant
+// term really is its old PublicKeyShard multiplied by its Lagrange coefficient.
+func ValidateReshareCommitment(com ReshareCommitment, pks PublicKeyShard, participants []int, newThreshold int) error {
+	if com.SignerID != pks.ID {
+		return fmt.Errorf("commitment is from %d, but the public shard is for %d", com.SignerID, pks.ID)
+	}
+	if !slices.Contains(participants, com.SignerID) {
+		return fmt.Errorf("%d is not one of the participants", com.SignerID)
+	}
+
+	if len(com.VssCommitment) != newThreshold {
+		return fmt.Errorf("reshare commitment from %d has %d points, expected %d",
+			com.SignerID, len(com.VssCommitment), newThreshold)
+	}
+
+	for i, pt := range com.VssCommitment {
+		if pt == nil || (pt.X.IsZero() && pt.Y.IsZero()) {
+			return fmt.Errorf("reshare commitment from %d has an invalid point at %d", com.SignerID, i)
+		}
+	}
+
+	expected := new(btcec.JacobianPoint)
+	btcec.ScalarMultNonConst(computeLambda(pks.ID, participants), pks.PublicKey, expected)
+	expected.ToAffine()
+	if !expected.X.Equals(&com.VssCommitment[0].X) || !expected.Y.Equals(&com.VssCommitment[0].Y) {
+		return fmt.Errorf("reshare commitment from %d doesn't match its public shard", com.SignerID)
+	}
+
+	return nil
+}
+
+// Zero wipes the dealer's polynomial, which contains the old shard, from memory after all the shares have been sent.
+func (d *ReshareDealer) Zero() { d.polynomial.Zero() }
+
+// Share returns the secret share this dealer must send to the new signer identified by to.
+func (d *ReshareDealer) Share(to int) DKGShare {
+	return DKGShare{
+		From:  d.ID,
+		To:    to,
+		Value: d.polynomial.evaluate(new(btcec.ModNScalar).SetInt(uint32(to))),
+	}
+}
+
+// FinalizeReshare is called by each new signer, identified by id, with the commitments from all the dealers and the
+// shares each of them sent to it. It returns the new KeyShard, which is for the same pubkey as before.
+func FinalizeReshare(
+	id int,
+	pubkey *btcec.JacobianPoint,
+	commitments []ReshareCommitment,
+	shares []DKGShare,
+) (KeyShard, error) {
+	if len(commitments) == 0 {
+		return KeyShard{}, fmt.Errorf("no reshare commitments")
+	}
+
+	for i, com := range commitments {
+		if len(com.VssCommitment) != len(commitments[0].VssCommitment) {
+			return KeyShard{}, fmt.Errorf("reshare commitment from %d has a different threshold", com.SignerID)
+		}
+		for _, prev := range commitments[:i] {
+			if prev.SignerID == com.SignerID {
+				return KeyShard{}, fmt.Errorf("multiple reshare commitments from %d", com.SignerID)
+			}
+		}
+	}
+
+	// this is what guarantees the dealers didn't lie, as long as the commitments were validated against their public
+	// shards -- which we can't do here, that's up to whoever knows the old group
+	vss := AggregateReshareCommitments(commitments)
+	if !vss[0].X.Equals(&pubkey.X) || !vss[0].Y.Equals(&pubkey.Y) {
+		return KeyShard{}, fmt.Errorf("reshare commitments don't add up to the group public key")
+	}
+
+	secret := new(btcec.ModNScalar)
+	for _, com := range commitments {
+		idx := slices.IndexFunc(shares, func(sh DKGShare) bool { return sh.From == com.SignerID })
+		if idx == -1 {
+			return KeyShard{}, fmt.Errorf("missing reshare share from %d", com.SignerID)
+		}
+		share := shares[idx]
+		if share.To != id {
+			return KeyShard{}, fmt.Errorf("reshare share from %d was meant for %d", share.From, share.To)
+		}
+
+		if err := com.VssCommitment.VerifyShare(id, share.Value); err != nil {
+			return KeyShard{}, fmt.Errorf("reshare share from %d: %w", share.From, err)
+		}
+
+		secret.Add(share.Value)
+	}
+
+	return KeyShard{
+		Secret:         secret,
+		PublicKey:      pubkey,
+		PublicKeyShard: vss.PublicKeyShard(id),
+	}, nil
+}
+
+// AggregateReshareCommitments sums the commitments of all dealers into the commitment to the new group polynomial,
+// from which everybody's new PublicKeyShard can be derived.
+func AggregateReshareCommitments(commitments []ReshareCommitment) VssCommitment {
+	vss := make(VssCommitment, len(commitments[0].VssCommitment))
+	for k := range vss {
+		vss[k] = new(btcec.JacobianPoint)
+		for _, com := range commitments {
+			btcec.AddNonConst(vss[k], com.VssCommitment[k], vss[k])
+		}
+		vss[k].ToAffine()
+	}
+	return vss
+}
+
+func (c ReshareCommitment) Hex() string { return hex.EncodeToString(c.Encode()) }
+func (c *ReshareCommitment) DecodeHex(x string) error {
+	b, err := hex.DecodeString(x)
+	if err != nil {
+		return err
+	}
+	_, err = c.Decode(b)
+	return err
+}
+
+func (c ReshareCommitment) Encode() []byte {
+	return encodeIdentifiedVss(wireReshareCommit, c.SignerID, c.VssCommitment)
+}
+
+// Decode reads a reshare commitment from the start of in and returns the number of bytes it took.
+func (c *ReshareCommitment) Decode(in []byte) (n int, err error) {
+	c.SignerID, c.VssCommitment, n, err = decodeIdentifiedVss(in, wireReshareCommit)
+	return n, err
+}
@@ -0,0 +1,373 @@
+package frost
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// Ciphersuite is the flavor of FROST a Configuration signs with.
+type Ciphersuite byte
+
+const (
+	// CiphersuiteBIP340 is what we have always done: one binding coefficient for the whole group, nonces and keys
+	// with even y and a BIP-340 challenge, so the result is a valid BIP-340 signature (see the README).
+	CiphersuiteBIP340 Ciphersuite = 0
+
+	// CiphersuiteRFC9591 is FROST(secp256k1, SHA-256) exactly as specified by RFC 9591, which can't be used for
+	// nostr events, but can interoperate with other implementations of the RFC.
+	CiphersuiteRFC9591 Ciphersuite = 1
+)
+
+func (cs Ciphersuite) String() string {
+	switch cs {
+	case CiphersuiteBIP340:
+		return "bip340"
+	case CiphersuiteRFC9591:
+		return "rfc9591"
+	default:
+		return fmt.Sprintf("unknown ciphersuite %d", byte(cs))
+	}
+}
+
+// https://www.rfc-editor.org/rfc/rfc9591.html#name-frostsecp256k1-sha-256
+const rfc9591ContextString = "FROST-secp256k1-SHA256-v1"
+
+func rfc9591H1(m ...[]byte) *btcec.ModNScalar {
+	return hashToScalar(rfc9591ContextString+"rho", m...)
+}
+
+func rfc9591H2(m ...[]byte) *btcec.ModNScalar {
+	return hashToScalar(rfc9591ContextString+"chal", m...)
+}
+
+func rfc9591H3(m ...[]byte) *btcec.ModNScalar {
+	return hashToScalar(rfc9591ContextString+"nonce", m...)
+}
+
+func rfc9591H4(m []byte) []byte {
+	h := sha256.Sum256(slices.Concat([]byte(rfc9591ContextString+"msg"), m))
+	return h[:]
+}
+
+func rfc9591H5(m []byte) []byte {
+	h := sha256.Sum256(slices.Concat([]byte(rfc9591ContextString+"com"), m))
+	return h[:]
+}
+
+// hashToScalar is hash_to_field() from RFC 9380 with expand_message_xmd() and SHA-256, taking 48 bytes and reducing
+// them modulo the group order. since it is also used for nonces the reduction is done with scalars, which are
+// constant-time, as hi * 2^256 + lo.
+func hashToScalar(dst string, m ...[]byte) *btcec.ModNScalar {
+	const length = 48
+
+	dstPrime := append([]byte(dst), byte(len(dst)))
+
+	h := sha256.New()
+	h.Write(make([]byte, sha256.BlockSize))
+	for _, part := range m {
+		h.Write(part)
+	}
+	h.Write([]byte{0, length, 0})
+	h.Write(dstPrime)
+	b0 := h.Sum(nil)
+
+	h.Reset()
+	h.Write(b0)
+	h.Write([]byte{1})
+	h.Write(dstPrime)
+	b1 := h.Sum(nil)
+
+	b2 := make([]byte, 32)
+	for i := range b2 {
+		b2[i] = b0[i] ^ b1[i]
+	}
+	h.Reset()
+	h.Write(b2)
+	h.Write([]byte{2})
+	h.Write(dstPrime)
+	b2 = h.Sum(b2[:0])
+
+	uniform := append(b1, b2[0:length-32]...)
+
+	var twoTo128 [32]byte
+	twoTo128[15] = 1
+	twoTo256 := new(btcec.ModNScalar)
+	twoTo256.SetBytes(&twoTo128)
+	twoTo256.Square()
+
+	hi := new(btcec.ModNScalar)
+	hi.SetByteSlice(uniform[0 : length-32])
+	lo := new(btcec.ModNScalar)
+	lo.SetByteSlice(uniform[length-32 : length]) // reduced modulo the order if it overflows
+
+	s := hi.Mul(twoTo256).Add(lo)
+
+	clear(b0)
+	clear(uniform)
+	lo.Zero()
+
+	return s
+}
+
+// RFC9591Signature is a signature as specified by RFC 9591, which is verified with the full group key, parity
+// included, and which is not a BIP-340 signature.
+type RFC9591Signature struct {
+	R *btcec.JacobianPoint
+	Z *btcec.ModNScalar
+}
+
+// Encode is SerializeElement(R) || SerializeScalar(z), 65 bytes.
+func (sig RFC9591Signature) Encode() []byte {
+	out := make([]byte, 33+32)
+	writePointTo(out[0:33], sig.R)
+	sig.Z.PutBytesUnchecked(out[33:])
+	return out
+}
+
+func (sig RFC9591Signature) Hex() string { return hex.EncodeToString(sig.Encode()) }
+
+func (sig *RFC9591Signature) Decode(in []byte) error {
+	if err := checkWireLength(in, 33+32); err != nil {
+		return err
+	}
+
+	var err error
+	if sig.R, err = readPoint(in[0:33]); err != nil {
+		return fmt.Errorf("invalid R: %w", err)
+	}
+	if sig.Z, err = readScalar(in[33:]); err != nil {
+		return fmt.Errorf("invalid z: %w", err)
+	}
+	return nil
+}
+
+// Verify checks that z * G == R + c * PK, with c = H2(R || PK || message).
+func (sig RFC9591Signature) Verify(message []byte, publicKey *btcec.JacobianPoint) bool {
+	if sig.R == nil || sig.Z == nil {
+		return false
+	}
+
+	challenge := rfc9591Challenge(sig.R, publicKey, message)
+
+	leftSide := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(sig.Z, leftSide)
+
+	rightSide := new(btcec.JacobianPoint)
+	btcec.ScalarMultNonConst(challenge, publicKey, rightSide)
+	btcec.AddNonConst(rightSide, sig.R, rightSide)
+
+	leftSide.ToAffine()
+	rightSide.ToAffine()
+	return leftSide.X.Equals(&rightSide.X) && leftSide.Y.Equals(&rightSide.Y)
+}
+
+func rfc9591Challenge(groupCommitment, publicKey *btcec.JacobianPoint, message []byte) *btcec.ModNScalar {
+	encoded := make([]byte, 33+33)
+	writePointTo(encoded[0:33], groupCommitment)
+	writePointTo(encoded[33:66], publicKey)
+	return rfc9591H2(encoded, message)
+}
+
+func (c *Configuration) checkRFC9591() error {
+	if c.Ciphersuite != CiphersuiteRFC9591 {
+		return fmt.Errorf("configuration uses the %s ciphersuite", c.Ciphersuite)
+	}
+	if c.Tweak != nil {
+		return fmt.Errorf("tweaks are not supported with the %s ciphersuite", c.Ciphersuite)
+	}
+	if c.Method != MethodFROST {
+		return fmt.Errorf("%s is not supported with the %s ciphersuite", c.Method, c.Ciphersuite)
+	}
+	if c.Adaptor != nil {
+		return fmt.Errorf("adaptor signatures are not supported with the %s ciphersuite", c.Ciphersuite)
+	}
+	return nil
+}
+
+// ComputeBindingFactorsRFC9591 is the RFC 9591 counterpart of ComputeGroupCommitment: each signer gets its own
+// binding factor, and the group commitment R is the sum of all their hiding nonces plus their binding nonces times
+// their binding factors. nothing is negated.
+func (c *Configuration) ComputeBindingFactorsRFC9591(commitments []Commitment, message []byte) (
+	bindingFactors map[int]*btcec.ModNScalar,
+	groupCommitment *btcec.JacobianPoint,
+	err error,
+) {
+	if err := c.checkRFC9591(); err != nil {
+		return nil, nil, err
+	}
+	// this also ensures the list is sorted by identifier, as the RFC requires
+	if err := c.ValidateCommitmentList(commitments); err != nil {
+		return nil, nil, err
+	}
+
+	// compute_binding_factors()
+	groupPublicKey := make([]byte, 33)
+	writePointTo(groupPublicKey, c.PublicKey)
+	rhoInputPrefix := slices.Concat(
+		groupPublicKey,
+		rfc9591H4(message),
+		rfc9591H5(encodeCommitmentList(commitmentsWithEncodedID(commitments))),
+	)
+
+	bindingFactors = make(map[int]*btcec.ModNScalar, len(commitments))
+	for _, com := range commitments {
+		id := new(btcec.ModNScalar).SetInt(uint32(com.SignerID)).Bytes()
+		bindingFactors[com.SignerID] = rfc9591H1(rhoInputPrefix, id[:])
+	}
+
+	// compute_group_commitment()
+	groupCommitment = new(btcec.JacobianPoint)
+	for _, com := range commitments {
+		bindingNonce := new(btcec.JacobianPoint)
+		btcec.ScalarMultNonConst(bindingFactors[com.SignerID], com.BinoncePublic[1], bindingNonce)
+		btcec.AddNonConst(groupCommitment, com.BinoncePublic[0], groupCommitment)
+		btcec.AddNonConst(groupCommitment, bindingNonce, groupCommitment)
+	}
+	groupCommitment.ToAffine()
+
+	if groupCommitment.X.IsZero() && groupCommitment.Y.IsZero() {
+		return nil, nil, fmt.Errorf("group commitment is the point at infinity")
+	}
+
+	return bindingFactors, groupCommitment, nil
+}
+
+// commitRFC9591 is nonce_generate() from RFC 9591, called twice, with the random bytes given.
+func (s *Signer) commitRFC9591(hidingRandom, bindingRandom []byte) Commitment {
+	secret := s.KeyShard.Secret.Bytes()
+	defer clear(secret[:])
+
+	secHN := rfc9591H3(hidingRandom, secret[:])
+	secBN := rfc9591H3(bindingRandom, secret[:])
+
+	pubHN := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(secHN, pubHN)
+	pubHN.ToAffine()
+	pubBN := new(btcec.JacobianPoint)
+	scalarBaseMultLadder(secBN, pubBN)
+	pubBN.ToAffine()
+
+	s.SecretNonces = BinonceSecret{secHN, secBN}
+
+	return Commitment{
+		SignerID:      s.KeyShard.ID,
+		BinoncePublic: BinoncePublic{pubHN, pubBN},
+	}
+}
+
+func randomNonceBytes() []byte {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic(fmt.Errorf("failed to read random: %w", err))
+	}
+	return random
+}
+
+// SignRFC9591 is the RFC 9591 counterpart of Sign. it takes the full list of commitments instead of the group
+// commitment, since every signer has a different binding factor.
+func (s *Signer) SignRFC9591(message []byte, commitments []Commitment) (PartialSignature, error) {
+	if s.SecretNonces[0] == nil || s.SecretNonces[1] == nil {
+		return PartialSignature{}, fmt.Errorf("no secret nonces, Commit or UsePreprocessed must be called first")
+	}
+
+	bindingFactors, groupCommitment, err := s.Configuration.ComputeBindingFactorsRFC9591(commitments, message)
+	if err != nil {
+		return PartialSignature{}, err
+	}
+	bindingFactor, ok := bindingFactors[s.KeyShard.ID]
+	if !ok {
+		return PartialSignature{}, fmt.Errorf("our commitment is not in the list")
+	}
+
+	lambda := s.LambdaRegistry.GetOrNew(s.Configuration.Participants, s.KeyShard.ID)
+	challenge := rfc9591Challenge(groupCommitment, s.Configuration.PublicKey, message)
+
+	// sig_share = hiding_nonce + (binding_nonce * binding_factor) + (lambda_i * sk_i * challenge)
+	z := new(btcec.ModNScalar).
+		Mul2(s.SecretNonces[1], bindingFactor).
+		Add(s.SecretNonces[0]).
+		Add(
+			new(btcec.ModNScalar).
+				Mul2(lambda, challenge).
+				Mul(s.KeyShard.Secret),
+		)
+
+	s.SecretNonces.Zero()
+
+	return PartialSignature{
+		SignerIdentifier: s.KeyShard.ID,
+		Value:            z,
+	}, nil
+}
+
+// VerifyPartialSignatureRFC9591 is verify_signature_share() from RFC 9591, taking what was computed by
+// ComputeBindingFactorsRFC9591.
+func (c *Configuration) VerifyPartialSignatureRFC9591(
+	pks PublicKeyShard,
+	commit BinoncePublic,
+	bindingFactor *btcec.ModNScalar,
+	groupCommitment *btcec.JacobianPoint,
+	partialSig PartialSignature,
+	message []byte,
+	lambdaRegistry *LambdaRegistry,
+) error {
+	if err := c.checkRFC9591(); err != nil {
+		return err
+	}
+	if partialSig.Value == nil {
+		return fmt.Errorf("invalid signature shard (nil scalar)")
+	}
+	if partialSig.SignerIdentifier != pks.ID {
+		return fmt.Errorf("signature shard is from %d, not %d", partialSig.SignerIdentifier, pks.ID)
+	}
+
+	// comm_share = hiding_nonce_commitment + binding_nonce_commitment * binding_factor
+	leftSide := new(btcec.JacobianPoint)
+	btcec.ScalarMultNonConst(bindingFactor, commit[1], leftSide)
+	btcec.AddNonConst(leftSide, commit[0], leftSide)
+
+	// l = PK_i * (challenge * lambda_i)
+	challenge := rfc9591Challenge(groupCommitment, c.PublicKey, message)
+	cl := new(btcec.ModNScalar).Mul2(challenge, lambdaRegistry.GetOrNew(c.Participants, partialSig.SignerIdentifier))
+	l := new(btcec.JacobianPoint)
+	btcec.ScalarMultNonConst(cl, pks.PublicKey, l)
+	btcec.AddNonConst(leftSide, l, leftSide)
+
+	// sig_share * G == comm_share + l
+	rightSide := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(partialSig.Value, rightSide)
+
+	leftSide.ToAffine()
+	rightSide.ToAffine()
+	if !leftSide.X.Equals(&rightSide.X) || !leftSide.Y.Equals(&rightSide.Y) {
+		return fmt.Errorf("invalid signature shard for signer %d", partialSig.SignerIdentifier)
+	}
+
+	return nil
+}
+
+// AggregateSignaturesRFC9591 is aggregate() from RFC 9591, the partial signatures should all have been verified
+// before, otherwise the result may just be invalid.
+func (c *Configuration) AggregateSignaturesRFC9591(
+	groupCommitment *btcec.JacobianPoint,
+	partialSigs []PartialSignature,
+) (RFC9591Signature, error) {
+	if err := c.checkRFC9591(); err != nil {
+		return RFC9591Signature{}, err
+	}
+
+	z := new(btcec.ModNScalar)
+	for _, partialSig := range partialSigs {
+		if partialSig.Value == nil {
+			return RFC9591Signature{}, fmt.Errorf("invalid signature shard (nil scalar)")
+		}
+		z.Add(partialSig.Value)
+	}
+
+	return RFC9591Signature{R: groupCommitment, Z: z}, nil
+}
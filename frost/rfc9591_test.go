@@ -0,0 +1,188 @@
+package frost
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// TestRFC9591Vectors runs the FROST(secp256k1, SHA-256) test vectors from RFC 9591, appendix E.5. the vectors don't
+// depend on any randomness but the nonce randomness, which we only feed to participant 1, so participant 3 comes in
+// with its commitment and its signature share, which we verify.
+func TestRFC9591Vectors(t *testing.T) {
+	fromHex := func(h string) []byte {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	scalar := func(h string) *btcec.ModNScalar {
+		s, err := scalarFromHex(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	point := func(h string) *btcec.JacobianPoint {
+		pt, err := pointFromHex(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pt
+	}
+	expectScalar := func(name string, actual *btcec.ModNScalar, expected string) {
+		if actual == nil || !actual.Equals(scalar(expected)) {
+			t.Fatalf("%s: expected %s, got %v", name, expected, actual)
+		}
+	}
+	expectPoint := func(name string, actual *btcec.JacobianPoint, expected string) {
+		if actual == nil || pointToHex(actual) != expected {
+			t.Fatalf("%s: expected %s, got %v", name, expected, actual)
+		}
+	}
+
+	message := fromHex("74657374")
+	groupSecretKey := scalar("0d004150d27c3bf2a42f312683d35fac7394b1e9e318249c1bfe7f0795a83114")
+	coefficient := scalar("fbf85eadae3058ea14f19148bb72b45e4399c0b16028acaf0395c9b03c823579")
+
+	// trusted dealer key generation, with the polynomial from the vectors
+	polynomial := Polynomial{groupSecretKey, coefficient}
+	groupPublicKey := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(groupSecretKey, groupPublicKey)
+	groupPublicKey.ToAffine()
+	expectPoint("group_public_key", groupPublicKey,
+		"02f37c34b66ced1fb51c34a90bdae006901f10625cc06c4f64663b0eae87d87b4f")
+
+	shares := make(map[int]*btcec.ModNScalar, 3)
+	for id, expected := range map[int]string{
+		1: "08f89ffe80ac94dcb920c26f3f46140bfc7f95b493f8310f5fc1ea2b01f4254c",
+		2: "04f0feac2edcedc6ce1253b7fab8c86b856a797f44d83d82a385554e6e401984",
+		3: "00e95d59dd0d46b0e303e500b62b7ccb0e555d49f5b849f5e748c071da8c0dbc",
+	} {
+		shares[id] = polynomial.evaluate(new(btcec.ModNScalar).SetInt(uint32(id)))
+		expectScalar("participant_share", shares[id], expected)
+	}
+
+	cfg := &Configuration{
+		Threshold:    2,
+		MaxSigners:   3,
+		PublicKey:    groupPublicKey,
+		Participants: []int{1, 3},
+		Ciphersuite:  CiphersuiteRFC9591,
+	}
+	vss := make(VssCommitment, len(polynomial))
+	for i, coeff := range polynomial {
+		vss[i] = new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(coeff, vss[i])
+		vss[i].ToAffine()
+	}
+	pks := func(id int) PublicKeyShard {
+		pt := new(btcec.JacobianPoint)
+		btcec.ScalarBaseMultNonConst(shares[id], pt)
+		pt.ToAffine()
+		return PublicKeyShard{ID: id, PublicKey: pt, VssCommitment: vss}
+	}
+
+	// round one
+	signer1, err := cfg.Signer(KeyShard{
+		Secret:         shares[1],
+		PublicKey:      groupPublicKey,
+		PublicKeyShard: pks(1),
+	}, NewLambdaRegistry(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitment1 := signer1.commitRFC9591(
+		fromHex("7ea5ed09af19f6ff21040c07ec2d2adbd35b759da5a401d4c99dd26b82391cb2"),
+		fromHex("47acab018f116020c10cb9b9abdc7ac10aae1b48ca6e36dc15acb6ec9be5cdc5"),
+	)
+	expectScalar("P1 hiding_nonce", signer1.SecretNonces[0],
+		"841d3a6450d7580b4da83c8e618414d0f024391f2aeb511d7579224420aa81f0")
+	expectScalar("P1 binding_nonce", signer1.SecretNonces[1],
+		"8d2624f532af631377f33cf44b5ac5f849067cae2eacb88680a31e77c79b5a80")
+	expectPoint("P1 hiding_nonce_commitment", commitment1.BinoncePublic[0],
+		"03c699af97d26bb4d3f05232ec5e1938c12f1e6ae97643c8f8f11c9820303f1904")
+	expectPoint("P1 binding_nonce_commitment", commitment1.BinoncePublic[1],
+		"02fa2aaccd51b948c9dc1a325d77226e98a5a3fe65fe9ba213761a60123040a45e")
+
+	commitment3 := Commitment{
+		SignerID: 3,
+		BinoncePublic: BinoncePublic{
+			point("03077507ba327fc074d2793955ef3410ee3f03b82b4cdc2370f71d865beb926ef6"),
+			point("02ad53031ddfbbacfc5fbda3d3b0c2445c8e3e99cbc4ca2db2aa283fa68525b135"),
+		},
+	}
+
+	if _, _, err := cfg.ComputeBindingFactorsRFC9591([]Commitment{commitment3, commitment1}, message); err == nil {
+		t.Fatal("accepted commitments out of order")
+	}
+
+	commitments := []Commitment{commitment1, commitment3}
+	bindingFactors, groupCommitment, err := cfg.ComputeBindingFactorsRFC9591(commitments, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectScalar("P1 binding_factor", bindingFactors[1],
+		"3e08fe561e075c653cbfd46908a10e7637c70c74f0a77d5fd45d1a750c739ec6")
+	expectScalar("P3 binding_factor", bindingFactors[3],
+		"93f79041bb3fd266105be251adaeb5fd7f8b104fb554a4ba9a0becea48ddbfd7")
+
+	// round two
+	partialSig1, err := signer1.SignRFC9591(message, commitments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectScalar("P1 sig_share", partialSig1.Value,
+		"c4fce1775a1e141fb579944166eab0d65eefe7b98d480a569bbbfcb14f91c197")
+	partialSig3 := PartialSignature{
+		SignerIdentifier: 3,
+		Value:            scalar("0160fd0d388932f4826d2ebcd6b9eaba734f7c71cf25b4279a4ca2581e47b18d"),
+	}
+
+	lambdaRegistry := NewLambdaRegistry(0)
+	for _, v := range []struct {
+		partialSig PartialSignature
+		commitment Commitment
+	}{{partialSig1, commitment1}, {partialSig3, commitment3}} {
+		id := v.partialSig.SignerIdentifier
+		if err := cfg.VerifyPartialSignatureRFC9591(pks(id), v.commitment.BinoncePublic,
+			bindingFactors[id], groupCommitment, v.partialSig, message, lambdaRegistry); err != nil {
+			t.Fatalf("sig_share of P%d: %v", id, err)
+		}
+	}
+	wrong := PartialSignature{
+		SignerIdentifier: 3,
+		Value:            new(btcec.ModNScalar).Add2(partialSig3.Value, new(btcec.ModNScalar).SetInt(1)),
+	}
+	if err := cfg.VerifyPartialSignatureRFC9591(pks(3), commitment3.BinoncePublic,
+		bindingFactors[3], groupCommitment, wrong, message, lambdaRegistry); err == nil {
+		t.Fatal("tampered sig_share was accepted")
+	}
+
+	// aggregation
+	signature, err := cfg.AggregateSignaturesRFC9591(groupCommitment, []PartialSignature{partialSig1, partialSig3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "0205b6d04d3774c8929413e3c76024d54149c372d57aae62574ed74319b5ea14d0" +
+		"c65dde8492a7471437e6c2fe3da49b90d23f642b5c6dbe7e36089f096dd97324"
+	if signature.Hex() != expected {
+		t.Fatalf("sig: expected %s, got %s", expected, signature.Hex())
+	}
+	if !signature.Verify(message, groupPublicKey) {
+		t.Fatal("signature from the vectors doesn't verify")
+	}
+	if signature.Verify([]byte("tesT"), groupPublicKey) {
+		t.Fatal("signature verified for another message")
+	}
+
+	var decoded RFC9591Signature
+	if err := decoded.Decode(fromHex(expected)); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hex() != expected {
+		t.Fatal("signature changed after decoding")
+	}
+}
@@ -13,6 +13,13 @@ type KeyShard struct {
 	PublicKeyShard
 }
 
+// Zero wipes the secret from memory, the shard can't be used for anything after this.
+func (k *KeyShard) Zero() {
+	if k.Secret != nil {
+		k.Secret.Zero()
+	}
+}
+
 func (k KeyShard) Hex() string { return hex.EncodeToString(k.Encode()) }
 func (k *KeyShard) DecodeHex(h string) error {
 	b, err := hex.DecodeString(h)
@@ -23,31 +30,68 @@ func (k *KeyShard) DecodeHex(h string) error {
 }
 
 func (k KeyShard) Encode() []byte {
-	pks := k.PublicKeyShard.Encode()
-	pkslen := len(pks)
-	out := make([]byte, pkslen+32+33)
-	copy(out, pks)
-	k.Secret.PutBytesUnchecked(out[pkslen:])
-	writePointTo(out[pkslen+32:], k.PublicKey)
+	out := make([]byte, wireHeaderSize+k.encodedSize())
+	putWireHeader(out, wireKeyShard)
+	k.encodeTo(out[wireHeaderSize:])
 	return out
 }
 
+func (k KeyShard) encodedSize() int { return k.PublicKeyShard.encodedSize() + 32 + 33 }
+
+func (k KeyShard) encodeTo(out []byte) {
+	pkslen := k.PublicKeyShard.encodedSize()
+	k.PublicKeyShard.encodeTo(out)
+	k.Secret.PutBytesUnchecked(out[pkslen : pkslen+32])
+	writePointTo(out[pkslen+32:pkslen+32+33], k.PublicKey)
+}
+
 func (k *KeyShard) Decode(in []byte) error {
-	pkslen, err := k.PublicKeyShard.Decode(in)
+	versioned, err := readWireHeader(in, wireKeyShard)
 	if err != nil {
-		return fmt.Errorf("error decoding public key shard: %w", err)
+		return err
 	}
 
-	k.Secret = new(btcec.ModNScalar)
-	k.Secret.SetByteSlice(in[pkslen : pkslen+32])
+	if !versioned {
+		// the old encoding started with the old encoding of the public key shard
+		pkslen, err := k.PublicKeyShard.decodeLegacy(in)
+		if err != nil {
+			return fmt.Errorf("error decoding public key shard: %w", err)
+		}
+		if err := checkWireLength(in, pkslen+32+33); err != nil {
+			return err
+		}
+		return k.decodeSecretAndKey(in[pkslen:])
+	}
 
-	pk, err := btcec.ParsePubKey(in[pkslen+32:])
+	in = in[wireHeaderSize:]
+	n, err := k.decodeFrom(in)
 	if err != nil {
 		return err
 	}
+	return checkWireLength(in, n)
+}
 
-	k.PublicKey = new(btcec.JacobianPoint)
-	pk.AsJacobian(k.PublicKey)
+// decodeFrom reads a key shard (without the header) from the beginning of in and returns how many bytes it took.
+func (k *KeyShard) decodeFrom(in []byte) (int, error) {
+	pkslen, err := k.PublicKeyShard.decodeFrom(in)
+	if err != nil {
+		return 0, fmt.Errorf("error decoding public key shard: %w", err)
+	}
+	if len(in) < pkslen+32+33 {
+		return 0, fmt.Errorf("too small (expected length %d, got %d)", pkslen+32+33, len(in))
+	}
+	return pkslen + 32 + 33, k.decodeSecretAndKey(in[pkslen:])
+}
+
+func (k *KeyShard) decodeSecretAndKey(in []byte) error {
+	var err error
+	if k.Secret, err = readScalar(in[0:32]); err != nil {
+		return fmt.Errorf("invalid secret: %w", err)
+	}
+
+	if k.PublicKey, err = readPoint(in[32 : 32+33]); err != nil {
+		return fmt.Errorf("failed to decode pubkey: %w", err)
+	}
 
 	return nil
 }
@@ -27,17 +27,18 @@ func makePolynomial(secret *btcec.ModNScalar, threshold int) (Polynomial, error)
 		rand.Read(random[:])
 		p[i] = new(btcec.ModNScalar)
 		p[i].SetBytes(&random)
+		clear(random[:])
 	}
 
 	return p, nil
 }
 
-func makeKeyShard(id int, p Polynomial, pubkey *btcec.JacobianPoint) KeyShard {
+func makeKeyShard(id int, p Polynomial, pubkey *btcec.JacobianPoint, commits VssCommitment) KeyShard {
 	sid := new(btcec.ModNScalar).SetInt(uint32(id))
 	yi := p.evaluate(sid)
 
 	pksh := new(btcec.JacobianPoint)
-	btcec.ScalarBaseMultNonConst(yi, pksh)
+	scalarBaseMultLadder(yi, pksh)
 	pksh.ToAffine()
 
 	return KeyShard{
@@ -45,7 +46,7 @@ func makeKeyShard(id int, p Polynomial, pubkey *btcec.JacobianPoint) KeyShard {
 		PublicKey: pubkey,
 		PublicKeyShard: PublicKeyShard{
 			PublicKey:     pksh,
-			VssCommitment: nil,
+			VssCommitment: commits,
 			ID:            id,
 		},
 	}
@@ -56,6 +57,15 @@ func makeKeyShard(id int, p Polynomial, pubkey *btcec.JacobianPoint) KeyShard {
 // All operations on the polynomial's coefficient are done modulo the scalar's group order.
 type Polynomial []*btcec.ModNScalar
 
+// Zero wipes all the coefficients from memory, after all the shares have been dealt.
+func (p Polynomial) Zero() {
+	for _, coeff := range p {
+		if coeff != nil {
+			coeff.Zero()
+		}
+	}
+}
+
 // evaluate evaluates the polynomial p at point x using Horner's method.
 func (p Polynomial) evaluate(x *btcec.ModNScalar) *btcec.ModNScalar {
 	// since value is an accumulator and starts with 0, we can skip multiplying by x, and start from the end
@@ -76,7 +86,7 @@ func VSSCommit(polynomial Polynomial) VssCommitment {
 	commits := make(VssCommitment, len(polynomial))
 	for i, coeff := range polynomial {
 		pt := &btcec.JacobianPoint{}
-		btcec.ScalarBaseMultNonConst(coeff, pt)
+		scalarBaseMultLadder(coeff, pt)
 		pt.ToAffine()
 		commits[i] = pt
 	}
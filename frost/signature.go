@@ -1,6 +1,7 @@
 package frost
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -11,6 +12,13 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
+// AggregateSignatures sums the partial signatures into the final signature. with a tweak (a derived key or a taproot
+// output key) this is still all there is to it: each signer has already accounted for its part of the tweak in its
+// partial signature, negating it if the tweaked key had an odd y, and the nonce parity was handled when computing
+// the group commitment.
+//
+// with an Adaptor what comes out is a pre-signature: it only becomes a valid signature once it is completed with the
+// adaptor secret (see CompleteAdaptorSignature).
 func (c *Configuration) AggregateSignatures(
 	finalNonce *btcec.JacobianPoint,
 	partialSigs []PartialSignature,
@@ -36,7 +44,7 @@ func (c *Configuration) VerifyPartialSignature(
 	finalNonce *btcec.JacobianPoint,
 	partialSig PartialSignature,
 	message []byte,
-	lambdaRegistry LambdaRegistry,
+	lambdaRegistry *LambdaRegistry,
 ) error {
 	if partialSig.Value == nil || partialSig.Value.IsZero() {
 		return fmt.Errorf("invalid signature shard (nil or zero scalar): %v", partialSig.Value)
@@ -46,9 +54,32 @@ func (c *Configuration) VerifyPartialSignature(
 		return fmt.Errorf("identifier can't be zero or bigger than the max number of signers")
 	}
 
+	return c.verifyPartialSignature(
+		c.tweakedPublicShard(pks),
+		c.coefficient(lambdaRegistry, partialSig.SignerIdentifier),
+		commit,
+		bindingCoefficient,
+		finalNonce,
+		partialSig,
+		message,
+	)
+}
+
+// verifyPartialSignature checks a partial signature made with the secret of publicShard (already tweaked) times
+// lambda.
+func (c *Configuration) verifyPartialSignature(
+	publicShard *btcec.JacobianPoint,
+	lambda *btcec.ModNScalar,
+	commit BinoncePublic,
+	bindingCoefficient *btcec.ModNScalar,
+	finalNonce *btcec.JacobianPoint,
+	partialSig PartialSignature,
+	message []byte,
+) error {
+	signingKey, _ := c.TweakedPublicKey()
 	challenge := chainhash.TaggedHash(chainhash.TagBIP0340Challenge,
 		finalNonce.X.Bytes()[:],
-		c.PublicKey.X.Bytes()[:],
+		signingKey.X.Bytes()[:],
 		message,
 	)
 
@@ -66,7 +97,7 @@ func (c *Configuration) VerifyPartialSignature(
 	// (c * lambda)
 	sAux := new(btcec.ModNScalar)
 	sAux.SetBytes((*[32]byte)(challenge))
-	sAux.Mul(lambdaRegistry.getOrNew(c.Participants, partialSig.SignerIdentifier))
+	sAux.Mul(lambda)
 
 	// b * R2
 	leftSide := new(btcec.JacobianPoint)
@@ -77,7 +108,7 @@ func (c *Configuration) VerifyPartialSignature(
 
 	// (c * lambda) * X
 	aux := new(btcec.JacobianPoint)
-	btcec.ScalarMultNonConst(sAux, pks.PublicKey, aux)
+	btcec.ScalarMultNonConst(sAux, publicShard, aux)
 
 	// R1 + b * R2 + (c * lambda) * X
 	btcec.AddNonConst(leftSide, aux, leftSide)
@@ -96,29 +127,143 @@ func (c *Configuration) VerifyPartialSignature(
 	return nil
 }
 
+// PartialSignatureError says which signer has sent a bad partial signature.
+type PartialSignatureError struct {
+	SignerID int
+	Err      error
+}
+
+func (e PartialSignatureError) Error() string {
+	return fmt.Sprintf("invalid partial signature from signer %d: %s", e.SignerID, e.Err)
+}
+func (e PartialSignatureError) Unwrap() error { return e.Err }
+
+// VerifyPartialSignatures checks all the partial signatures of a session at once, which is much faster than calling
+// VerifyPartialSignature for each. pubShards and commits are the public key shard and the nonce commitment of the
+// signer of each partial signature, in the same order.
+//
+// instead of checking R1 + b * R2 + (c * lambda) * X == s * G for each signer we take a random z for each and check
+// that the sum of z * (R1 + b * R2 + (c * lambda) * X - s * G) is zero, which can't happen by chance if any of them
+// isn't, in a single multi-scalar multiplication. if it isn't zero each partial signature is checked on its own, so
+// we can tell which one is bad, and a PartialSignatureError is returned for the first bad one.
+func (c *Configuration) VerifyPartialSignatures(
+	pubShards []PublicKeyShard,
+	commits []BinoncePublic,
+	bindingCoefficient *btcec.ModNScalar,
+	finalNonce *btcec.JacobianPoint,
+	partialSigs []PartialSignature,
+	message []byte,
+	lambdaRegistry *LambdaRegistry,
+) error {
+	if len(pubShards) != len(partialSigs) || len(commits) != len(partialSigs) {
+		return fmt.Errorf("got %d partial signatures, %d public shards and %d commits",
+			len(partialSigs), len(pubShards), len(commits))
+	}
+
+	for _, partialSig := range partialSigs {
+		if partialSig.Value == nil || partialSig.Value.IsZero() {
+			return PartialSignatureError{partialSig.SignerIdentifier, errors.New("nil or zero scalar")}
+		}
+		if partialSig.SignerIdentifier == 0 || partialSig.SignerIdentifier > c.MaxSigners {
+			return PartialSignatureError{partialSig.SignerIdentifier, errors.New("invalid identifier")}
+		}
+	}
+
+	signingKey, _ := c.TweakedPublicKey()
+	challenge := chainhash.TaggedHash(chainhash.TagBIP0340Challenge,
+		finalNonce.X.Bytes()[:],
+		signingKey.X.Bytes()[:],
+		message,
+	)
+	e := new(btcec.ModNScalar)
+	e.SetBytes((*[32]byte)(challenge))
+
+	// z * R1, (z * b) * R2 and (z * c * lambda) * X for each, then -(sum of z * s) * G
+	scalars := make([]*btcec.ModNScalar, 0, 3*len(partialSigs))
+	points := make([]*btcec.JacobianPoint, 0, 3*len(partialSigs))
+	sumS := new(btcec.ModNScalar)
+	var random [16]byte
+	for i, partialSig := range partialSigs {
+		// 128 bits are enough for z, and make the multiplications by R1 shorter
+		z := new(btcec.ModNScalar).SetInt(1)
+		if i > 0 {
+			if _, err := rand.Read(random[:]); err != nil {
+				return fmt.Errorf("failed to read random bytes: %w", err)
+			}
+			z.SetByteSlice(random[:])
+		}
+
+		zb := new(btcec.ModNScalar).Mul2(z, bindingCoefficient)
+		zcl := new(btcec.ModNScalar).Mul2(z, e)
+		zcl.Mul(c.coefficient(lambdaRegistry, partialSig.SignerIdentifier))
+		scalars = append(scalars, z, zb, zcl)
+		points = append(points, commits[i][0], commits[i][1], c.tweakedPublicShard(pubShards[i]))
+
+		sumS.Add(new(btcec.ModNScalar).Mul2(z, partialSig.Value))
+	}
+
+	sum := new(btcec.JacobianPoint)
+	multiScalarMultNonConst(scalars, points, sum)
+	sG := new(btcec.JacobianPoint)
+	btcec.ScalarBaseMultNonConst(sumS.Negate(), sG)
+	btcec.AddNonConst(sum, sG, sum)
+
+	sum.ToAffine()
+	if sum.X.IsZero() && sum.Y.IsZero() {
+		return nil
+	}
+
+	// find out who it was
+	for i, partialSig := range partialSigs {
+		if err := c.VerifyPartialSignature(pubShards[i], commits[i], bindingCoefficient, finalNonce,
+			partialSig, message, lambdaRegistry,
+		); err != nil {
+			return PartialSignatureError{partialSig.SignerIdentifier, err}
+		}
+	}
+
+	// this should never happen
+	return errors.New("batch verification failed, but each partial signature is good")
+}
+
 type PartialSignature struct {
 	Value            *btcec.ModNScalar
 	SignerIdentifier int
 }
 
 func (s *PartialSignature) Encode() []byte {
-	out := make([]byte, 2+32)
+	out := make([]byte, wireHeaderSize+2+32)
+	putWireHeader(out, wirePartialSignature)
 
-	binary.LittleEndian.PutUint16(out[0:2], uint16(s.SignerIdentifier))
-	s.Value.PutBytesUnchecked(out[2 : 2+32])
+	binary.BigEndian.PutUint16(out[wireHeaderSize:wireHeaderSize+2], uint16(s.SignerIdentifier))
+	s.Value.PutBytesUnchecked(out[wireHeaderSize+2 : wireHeaderSize+2+32])
 
 	return out
 }
 
 func (s *PartialSignature) Decode(in []byte) error {
-	if len(in) < 32+2 {
-		return fmt.Errorf("too small")
+	versioned, err := readWireHeader(in, wirePartialSignature)
+	if err != nil {
+		return err
 	}
 
-	s.SignerIdentifier = int(binary.LittleEndian.Uint16(in[0:2]))
+	if versioned {
+		in = in[wireHeaderSize:]
+		if err := checkWireLength(in, 2+32); err != nil {
+			return err
+		}
+		s.SignerIdentifier = int(binary.BigEndian.Uint16(in[0:2]))
+	} else {
+		// the old encoding had the identifier in little-endian
+		if err := checkWireLength(in, 2+32); err != nil {
+			return err
+		}
+		s.SignerIdentifier = int(binary.LittleEndian.Uint16(in[0:2]))
+	}
 
-	s.Value = new(btcec.ModNScalar)
-	s.Value.SetBytes((*[32]byte)(in[2 : 2+32]))
+	if s.Value, err = readScalar(in[2 : 2+32]); err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
 
 	return nil
 }
@@ -19,21 +19,34 @@ type Signer struct {
 	// groups. Each group makes up a unique polynomial defined by the participants' identifiers. A value will be
 	// computed once for the first time a group is encountered, and kept across encodings and decodings of the signer,
 	// accelerating subsequent signatures within the same group of signers.
-	LambdaRegistry LambdaRegistry
+	LambdaRegistry *LambdaRegistry
 
 	// Configuration is the core FROST setup configuration.
 	Configuration *Configuration
 
 	// SecretNonces are our secret nonces for this session.
 	SecretNonces BinonceSecret
+
+	// only when we are a member of a sub-committee (see SubSigner): the identifier of the shard the committee holds
+	// and the configuration of the members that are signing for it
+	parentID  int
+	committee *Configuration
 }
 
-func (s *Signer) clearNonceCommitment() {
-	s.SecretNonces[0] = nil
-	s.SecretNonces[1] = nil
+// coefficient is our Lagrange coefficient λi, or, for a member of a sub-committee, the coefficient of the committee's
+// shard times our own coefficient among the members that are signing for it, since our shares of the committee's
+// shard add up to that shard just like the shards add up to the group key.
+func (s *Signer) coefficient() *btcec.ModNScalar {
+	if s.committee == nil {
+		return s.Configuration.coefficient(s.LambdaRegistry, s.KeyShard.ID)
+	}
+	return new(btcec.ModNScalar).Mul2(
+		s.Configuration.coefficient(s.LambdaRegistry, s.parentID),
+		s.committee.coefficient(s.LambdaRegistry, s.KeyShard.ID),
+	)
 }
 
-func (s *Signer) generateNonce(
+func generateNonce(
 	sessionId string,
 	secretShard *btcec.ModNScalar,
 	pubkey *btcec.JacobianPoint,
@@ -63,7 +76,7 @@ func (s *Signer) generateNonce(
 	k := new(btcec.ModNScalar)
 	k.SetBytes(&kH)
 	pt := new(btcec.JacobianPoint)
-	btcec.ScalarBaseMultNonConst(k, pt)
+	scalarBaseMultLadder(k, pt)
 	pt.ToAffine()
 
 	// zero stuff
@@ -83,8 +96,12 @@ func (s *Signer) generateNonce(
 // Commit generates a signer's nonces and commitment, to be used in the second FROST round. The internal nonce must
 // be kept secret, and the returned commitment sent to the signature aggregator.
 func (s *Signer) Commit(sessionId string) Commitment {
-	secHN, pubHN := s.generateNonce(sessionId+"h", s.KeyShard.Secret, s.Configuration.PublicKey)
-	secBN, pubBN := s.generateNonce(sessionId+"b", s.KeyShard.Secret, s.Configuration.PublicKey)
+	if s.Configuration.Ciphersuite == CiphersuiteRFC9591 {
+		return s.commitRFC9591(randomNonceBytes(), randomNonceBytes())
+	}
+
+	secHN, pubHN := generateNonce(sessionId+"h", s.KeyShard.Secret, s.Configuration.PublicKey)
+	secBN, pubBN := generateNonce(sessionId+"b", s.KeyShard.Secret, s.Configuration.PublicKey)
 
 	com := Commitment{
 		SignerID:      s.KeyShard.ID,
@@ -101,12 +118,25 @@ func (s *Signer) Sign(
 ) (PartialSignature, error) {
 	// SignRound(ski, pk, S, statei, ρ, m) -- from https://eprint.iacr.org/2023/899.pdf
 
+	if s.Configuration.Ciphersuite != CiphersuiteBIP340 {
+		return PartialSignature{}, fmt.Errorf("configuration uses the %s ciphersuite, use SignRFC9591",
+			s.Configuration.Ciphersuite)
+	}
+
+	// the nonces are cleared after each signature, signing twice with them would give away our shard
+	if s.SecretNonces[0] == nil || s.SecretNonces[1] == nil {
+		return PartialSignature{}, fmt.Errorf("no secret nonces, Commit or UsePreprocessed must be called first")
+	}
+
+	// with a tweak we sign for a different key
+	signingKey, negateKey := s.Configuration.TweakedPublicKey()
+
 	// 6 : b ← Hnon(X, S, ρ, m)
-	bindingCoefficient := computeBindingCoefficient(
-		s.Configuration.PublicKey, groupCommitment, message, s.Configuration.Participants)
+	bindingCoefficient := s.Configuration.bindingCoefficient(signingKey, groupCommitment, message)
 
 	// 7 : R ← DEb
-	finalNonce, negate := bindFinalNonce(groupCommitment, bindingCoefficient)
+	// (with an adaptor the final nonce is R + T, and that is what we sign for)
+	finalNonce, negate := bindFinalNonce(groupCommitment, bindingCoefficient, s.Configuration.Adaptor)
 
 	// BIP-340 special
 	if negate {
@@ -117,14 +147,23 @@ func (s *Signer) Sign(
 	// 8 : c ← Hsig(X, R, m)
 	challenge := chainhash.TaggedHash(chainhash.TagBIP0340Challenge,
 		finalNonce.X.Bytes()[:],
-		s.Configuration.PublicKey.X.Bytes()[:],
+		signingKey.X.Bytes()[:],
 		message,
 	)
 	challengeScalar := new(btcec.ModNScalar)
 	challengeScalar.SetBytes((*[32]byte)(challenge))
 
 	// 9 : Λi ← Lagrange(S, i)
-	lambda := s.LambdaRegistry.getOrNew(s.Configuration.Participants, s.KeyShard.ID) // Lagrange coefficient λi
+	lambda := s.coefficient() // Lagrange coefficient λi (1 with MuSig2)
+
+	// our shard of the tweaked key, which gets negated along with it
+	secret := new(btcec.ModNScalar).Set(s.KeyShard.Secret)
+	if tweak := s.Configuration.shardTweak(s.KeyShard.ID); tweak != nil {
+		secret.Add(tweak)
+	}
+	if negateKey {
+		secret.Negate()
+	}
 
 	// 10 : σi ← di + bei + cΛixi
 	z := new(btcec.ModNScalar).
@@ -139,10 +178,11 @@ func (s *Signer) Sign(
 					lambda,          // λi
 					challengeScalar, // c
 				).
-				Mul(s.KeyShard.Secret), // si
+				Mul(secret), // si
 		)
 
-	s.clearNonceCommitment()
+	s.SecretNonces.Zero()
+	secret.Zero()
 
 	// 11 : return σi
 	return PartialSignature{
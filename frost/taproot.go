@@ -0,0 +1,44 @@
+package frost
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// TaprootTweak gives the BIP-341 tweak that turns the group key, taken as the internal key, into the key of a
+// taproot output. merkleRoot is the root of the script tree, or nil if the output can only be spent by the key.
+func TaprootTweak(internalKey *btcec.JacobianPoint, merkleRoot []byte) (*btcec.ModNScalar, error) {
+	if len(merkleRoot) != 0 && len(merkleRoot) != 32 {
+		return nil, fmt.Errorf("merkle root must have 32 bytes, not %d", len(merkleRoot))
+	}
+
+	// BIP-341 takes the internal key as lift_x(x), which is what our group keys always are anyway
+	pt := *internalKey
+	pt.ToAffine()
+	if pt.Y.IsOdd() {
+		return nil, fmt.Errorf("internal key must have an even y")
+	}
+
+	hash := chainhash.TaggedHash(chainhash.TagTapTweak, pt.X.Bytes()[:], merkleRoot)
+	tweak := new(btcec.ModNScalar)
+	if overflow := tweak.SetBytes((*[32]byte)(hash)); overflow != 0 {
+		return nil, fmt.Errorf("taproot tweak is bigger than the curve order")
+	}
+
+	return tweak, nil
+}
+
+// UseTaproot makes the signers sign for the taproot output that has the group key as its internal key, such that
+// the signatures are BIP-341 key-path spends when the message is a 32-byte sighash. TweakedPublicKey gives the
+// output key.
+func (c *Configuration) UseTaproot(merkleRoot []byte) error {
+	tweak, err := TaprootTweak(c.PublicKey, merkleRoot)
+	if err != nil {
+		return err
+	}
+
+	c.Tweak = tweak
+	return nil
+}
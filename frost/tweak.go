@@ -0,0 +1,23 @@
+package frost
+
+import (
+	"encoding/binary"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// DeriveTweak gives the additive tweak for the child key at index, to be set as Configuration.Tweak. like in BIP-32
+// non-hardened derivation anyone that knows the group key can compute the child keys, but only the group can sign for
+// them.
+func DeriveTweak(pubkey *btcec.JacobianPoint, index uint32) *btcec.ModNScalar {
+	preimage := make([]byte, 33+4)
+	writePointTo(preimage[0:33], pubkey)
+	binary.BigEndian.PutUint32(preimage[33:33+4], index)
+
+	hash := chainhash.TaggedHash([]byte("promenade/derive"), preimage)
+	tweak := new(btcec.ModNScalar)
+	tweak.SetBytes((*[32]byte)(hash))
+
+	return tweak
+}
@@ -0,0 +1,144 @@
+package frost
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// Everything that goes through the wire (or to disk) is encoded as
+//
+//	[0x00] [0x00] [version: 1 byte] [type: 1 byte] [body]
+//
+// where all the integers in the body are big-endian, points are 33-byte compressed and scalars are 32 bytes, and
+// the body must have exactly the length implied by its contents. The bodies of version 1 are:
+//
+//	Configuration:    [threshold: 2] [max signers: 2] [n: 2] [flags: 1] [pubkey: 33] [n * participant: 2]
+//	                  [tweak: 32, only if flags & 1] [adaptor: 33, only if flags & 8]
+//	                  (flags & 2 means the RFC 9591 ciphersuite, flags & 4 means MuSig2)
+//	Commitment:       [signer id: 2] [hiding nonce: 33] [binding nonce: 33]
+//	BinoncePublic:    [hiding nonce: 33] [binding nonce: 33]
+//	PartialSignature: [signer id: 2] [value: 32]
+//	PublicKeyShard:   [id: 2] [n: 2] [pubkey: 33] [n * vss commitment: 33]
+//	KeyShard:         [id: 2] [n: 2] [pubkey shard: 33] [n * vss commitment: 33] [secret: 32] [group pubkey: 33]
+//	CommitmentList:   [n: 2] [n * ([signer id: 2] [hiding nonce: 33] [binding nonce: 33])]
+//	SubShard:         [key shard body] [parent public key shard body] [group pubkey: 33]
+//	                  (the bodies as above, without their headers; this one has no older encoding)
+//	DKGCommitment:    [signer id: 2] [n: 2] [proof nonce: 33] [proof scalar: 32] [n * vss commitment: 33]
+//	DKGShare:         [from: 2] [to: 2] [value: 32]
+//	RefreshCommitment, ReshareCommitment:
+//	                  [signer id: 2] [n: 2] [n * vss commitment: 33]
+//	ECDHShare:        [signer id: 2] [point: 33] [challenge: 32] [response: 32]
+//
+// The older encodings, which have no header, are still accepted by the decoders: none of them can start with two
+// zero bytes, as they start with an identifier or a threshold (little-endian), which are never zero, or with a point.
+//
+// There is also a JSON representation of each, see json.go.
+
+const wireVersion = 1
+
+type wireType byte
+
+const (
+	wireConfiguration    wireType = 1
+	wireCommitment       wireType = 2
+	wireBinoncePublic    wireType = 3
+	wirePartialSignature wireType = 4
+	wirePublicKeyShard   wireType = 5
+	wireKeyShard         wireType = 6
+	wireCommitmentList   wireType = 7
+	wireSubShard         wireType = 8
+	wireDKGCommitment    wireType = 9
+	wireDKGShare         wireType = 10
+	wireRefreshCommit    wireType = 11
+	wireReshareCommit    wireType = 12
+	wireECDHShare        wireType = 13
+)
+
+const wireHeaderSize = 4
+
+func (t wireType) String() string {
+	switch t {
+	case wireConfiguration:
+		return "configuration"
+	case wireCommitment:
+		return "commitment"
+	case wireBinoncePublic:
+		return "binonce"
+	case wirePartialSignature:
+		return "partial signature"
+	case wirePublicKeyShard:
+		return "public key shard"
+	case wireKeyShard:
+		return "key shard"
+	case wireCommitmentList:
+		return "commitment list"
+	case wireSubShard:
+		return "sub shard"
+	case wireDKGCommitment:
+		return "dkg commitment"
+	case wireDKGShare:
+		return "dkg share"
+	case wireRefreshCommit:
+		return "refresh commitment"
+	case wireReshareCommit:
+		return "reshare commitment"
+	case wireECDHShare:
+		return "ecdh share"
+	default:
+		return fmt.Sprintf("unknown type %d", byte(t))
+	}
+}
+
+func putWireHeader(out []byte, t wireType) {
+	out[0] = 0
+	out[1] = 0
+	out[2] = wireVersion
+	out[3] = byte(t)
+}
+
+// readWireHeader tells if in is in the versioned format, in which case it also checks that it is of the type we
+// expected and of a version we know.
+func readWireHeader(in []byte, t wireType) (versioned bool, err error) {
+	if len(in) < 2 || in[0] != 0 || in[1] != 0 {
+		return false, nil
+	}
+	if len(in) < wireHeaderSize {
+		return true, fmt.Errorf("too small for a header")
+	}
+	if in[2] != wireVersion {
+		return true, fmt.Errorf("unsupported version %d", in[2])
+	}
+	if wireType(in[3]) != t {
+		return true, fmt.Errorf("expected a %s, got a %s", t, wireType(in[3]))
+	}
+	return true, nil
+}
+
+func checkWireLength(in []byte, expected int) error {
+	if len(in) < expected {
+		return fmt.Errorf("too small (expected length %d, got %d)", expected, len(in))
+	}
+	if len(in) > expected {
+		return fmt.Errorf("too big (expected length %d, got %d)", expected, len(in))
+	}
+	return nil
+}
+
+func readPoint(in []byte) (*btcec.JacobianPoint, error) {
+	pk, err := btcec.ParsePubKey(in[0:33])
+	if err != nil {
+		return nil, err
+	}
+	pt := new(btcec.JacobianPoint)
+	pk.AsJacobian(pt)
+	return pt, nil
+}
+
+func readScalar(in []byte) (*btcec.ModNScalar, error) {
+	s := new(btcec.ModNScalar)
+	if overflow := s.SetByteSlice(in[0:32]); overflow {
+		return nil, fmt.Errorf("scalar is too big")
+	}
+	return s, nil
+}
@@ -37,6 +37,7 @@ export function trustedKeyDeal(
   }
 
   const polynomial = makePolynomial(secret, threshold);
+  const commits = vssCommit(polynomial);
 
   // evaluate the polynomial for each point x=1,...,n
   const shards: KeyShard[] = [];
@@ -50,14 +51,12 @@ export function trustedKeyDeal(
       pubkey: pubkey,
       pubShard: {
         pubkey: pksh,
-        vssCommit: [],
+        vssCommit: commits,
         id: id,
       },
     });
   }
 
-  const commits = vssCommit(polynomial);
-
   return { shards, pubkey, commits };
 }
 
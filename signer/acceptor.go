@@ -58,10 +58,11 @@ func runAcceptor(ctx context.Context, relayURLs []string, pow uint64, restartSig
 		ourInbox = relayURLs
 	}
 
-	// listen for incoming shards
+	// listen for incoming shards, invitations to generate new keys, requests to take part in a resharing and requests
+	// to give a shard back
 	log.Info().Msgf("[acceptor] listening for new shards at %v", ourInbox)
-	for shardEvt := range pool.SubscribeMany(ctx, ourInbox, nostr.Filter{
-		Kinds: []nostr.Kind{common.KindShard},
+	for ie := range pool.SubscribeMany(ctx, ourInbox, nostr.Filter{
+		Kinds: []nostr.Kind{common.KindShard, common.KindDKGInvite, common.KindReshareRequest, common.KindExportRequest},
 		Tags: nostr.TagMap{
 			"p": []string{ourPubkey.Hex()},
 		},
@@ -69,7 +70,16 @@ func runAcceptor(ctx context.Context, relayURLs []string, pow uint64, restartSig
 	}, nostr.SubscriptionOptions{
 		Label: "prom-shards",
 	}) {
-		go handleShard(ctx, shardEvt.Event, pow, restartSigner)
+		switch ie.Event.Kind {
+		case common.KindShard:
+			go handleShard(ctx, ie.Event, pow, restartSigner)
+		case common.KindDKGInvite:
+			go handleDKGInvite(ctx, ie.Event, pow, restartSigner)
+		case common.KindReshareRequest:
+			go handleReshareRequest(ctx, ie.Event, pow, restartSigner)
+		case common.KindExportRequest:
+			go handleExportRequest(ctx, ie.Event, pow)
+		}
 	}
 }
 
@@ -91,18 +101,58 @@ func handleShard(ctx context.Context, shardEvt nostr.Event, pow uint64, restartS
 		return
 	}
 
-	// get metadata and check validity
-	shard := frost.KeyShard{}
-
+	// get metadata and check validity -- there may be more than one shard if we are a weighted signer, or a
+	// sub-shard if we are a member of a sub-committee
 	plaintextShard, err := kr.Decrypt(ctx, shardEvt.Content, shardEvt.PubKey)
 	if err != nil {
 		log.Warn().Err(err).Msg("[acceptor] failed to decrypt shard")
 		return
 	}
-	if err := shard.DecodeHex(plaintextShard); err != nil {
-		log.Warn().Err(err).Msgf("[acceptor] got broken shard")
+	var sub *frost.SubShard
+	shards, err := common.DecodeShards(plaintextShard)
+	if err != nil {
+		sub = &frost.SubShard{}
+		if subErr := sub.DecodeHex(plaintextShard); subErr != nil {
+			log.Warn().Err(err).Msgf("[acceptor] got broken shard")
+			return
+		}
+		defer sub.Zero()
+		if err := validateSubShard(*sub); err != nil {
+			log.Warn().Err(err).Msgf("[acceptor] got invalid sub-shard")
+			return
+		}
+		if *sub.GroupPublicKey.X.Bytes() != shardEvt.PubKey {
+			log.Warn().Msg("[acceptor] got sub-shard for a different key")
+			return
+		}
+	}
+	defer func() {
+		for i := range shards {
+			shards[i].Zero()
+		}
+	}()
+	if len(shards) > 0 && *shards[0].PublicKey.X.Bytes() != shardEvt.PubKey {
+		log.Warn().Msg("[acceptor] got shard for a different key")
 		return
 	}
+
+	for _, shard := range shards {
+		// we don't know the threshold nor how many signers there are, so we take them from the commitments and
+		// from our own id -- the coordinator checks the registration against the same commitments we got here
+		cfg := frost.Configuration{
+			PublicKey:  shard.PublicKey,
+			Threshold:  len(shard.PublicKeyShard.VssCommitment),
+			MaxSigners: shard.ID,
+		}
+		if err := cfg.VerifySecretShare(shard.ID, shard.Secret, shard.PublicKeyShard.VssCommitment); err != nil {
+			log.Warn().Err(err).Msgf("[acceptor] got shard that doesn't match its commitments")
+			return
+		}
+		if err := cfg.ValidateKeyShard(shard); err != nil {
+			log.Warn().Err(err).Msgf("[acceptor] got invalid shard")
+			return
+		}
+	}
 	coordinator := shardEvt.Tags.Find("coordinator")
 	log = log.With().Str("coordinator", coordinator[1]).Logger()
 
@@ -147,43 +197,13 @@ func handleShard(ctx context.Context, shardEvt nostr.Event, pow uint64, restartS
 	}
 	kr.SignEvent(ctx, &ackEvt)
 
-	// first we need their read relays
-	theirInbox := make([]string, 0, 5)
-	for evt := range pool.FetchMany(ctx, common.IndexRelays, nostr.Filter{
-		Kinds:   []nostr.Kind{10002},
-		Authors: []nostr.PubKey{shardEvt.PubKey},
-	}, nostr.SubscriptionOptions{}) {
-		for tag := range evt.Tags.FindAll("r") {
-			if len(tag) == 2 || tag[2] == "read" {
-				theirInbox = append(theirInbox, tag[1])
-			}
-		}
-	}
-
-	// besides their read relays we'll also contact these relays they signaled
-	theirSignaledReplyTag := shardEvt.Tags.Find("reply")
-	theirSignaledReply := make([]string, 0, 5)
-	if theirSignaledReplyTag != nil {
-		theirSignaledReply = append(theirSignaledReply, theirSignaledReplyTag[1:]...)
-	}
-
 	// then we send the ack to them
-	success := false
-	errs := make(map[string]string, len(theirInbox))
-	log.Info().
-		Strs("relays", theirInbox).
-		Msg("[acceptor] sending ack")
-	for res := range pool.PublishMany(ctx, append(theirInbox, theirSignaledReply...), ackEvt) {
-		if res.Error == nil {
-			success = true
-		} else {
-			errs[res.RelayURL] = res.Error.Error()
-		}
+	var theirSignaledReply []string
+	if theirSignaledReplyTag := shardEvt.Tags.Find("reply"); theirSignaledReplyTag != nil {
+		theirSignaledReply = theirSignaledReplyTag[1:]
 	}
-	if !success {
-		log.Warn().
-			Interface("errors", errs).
-			Msg("[acceptor] failed to send ack back")
+	if err := sendToUser(ctx, shardEvt.PubKey, theirSignaledReply, ackEvt); err != nil {
+		log.Warn().Err(err).Msg("[acceptor] failed to send ack back")
 		return
 	}
 
@@ -206,15 +226,88 @@ func handleShard(ctx context.Context, shardEvt nostr.Event, pow uint64, restartS
 			shardEvt.Tags,
 			nostr.Tag{""},
 		),
-		Content: plaintextShard,
+	}
+	if sub != nil {
+		err = vault.sealSubShard(&storedShard, *sub)
+	} else {
+		err = vault.seal(&storedShard, shards...)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("[acceptor] failed to encrypt shard")
+		return
 	}
 	storedShard.ID = storedShard.GetID()
 	if err := store.ReplaceEvent(storedShard); err != nil {
-		panic(err)
+		log.Warn().Err(err).Msg("[acceptor] failed to store shard")
+		return
 	}
 
-	log.Info().Msgf("[acceptor] shard registered")
+	log.Info().Int("shards", len(shards)).Bool("sub-shard", sub != nil).Msgf("[acceptor] shard registered")
 
 	// restart signer process
 	restartSigner()
 }
+
+// validateSubShard checks a sub-shard like we check shards: against its own commitments, which must be to the shard
+// of the sub-committee, and that one against the commitments of the group.
+func validateSubShard(sub frost.SubShard) error {
+	if sub.GroupPublicKey == nil {
+		return fmt.Errorf("missing group key")
+	}
+	group := frost.Configuration{
+		PublicKey:  sub.GroupPublicKey,
+		Threshold:  len(sub.Parent.VssCommitment),
+		MaxSigners: sub.Parent.ID,
+	}
+	if len(sub.Parent.VssCommitment) == 0 {
+		return fmt.Errorf("sub-committee shard has no vss commitment")
+	}
+	if err := group.ValidatePublicKeyShard(sub.Parent); err != nil {
+		return fmt.Errorf("invalid sub-committee shard: %w", err)
+	}
+
+	committee := frost.Configuration{
+		PublicKey:  sub.Parent.PublicKey,
+		Threshold:  len(sub.VssCommitment),
+		MaxSigners: sub.ID,
+	}
+	if err := committee.VerifySecretShare(sub.ID, sub.Secret, sub.VssCommitment); err != nil {
+		return fmt.Errorf("sub-shard doesn't match its commitments: %w", err)
+	}
+	return committee.ValidateKeyShard(sub.KeyShard)
+}
+
+// sendToUser publishes evt to the read relays of user and to the relays they signaled they'd be listening on.
+func sendToUser(ctx context.Context, user nostr.PubKey, theirSignaledReply []string, evt nostr.Event) error {
+	// first we need their read relays
+	theirInbox := make([]string, 0, 5)
+	for evt := range pool.FetchMany(ctx, common.IndexRelays, nostr.Filter{
+		Kinds:   []nostr.Kind{10002},
+		Authors: []nostr.PubKey{user},
+	}, nostr.SubscriptionOptions{}) {
+		for tag := range evt.Tags.FindAll("r") {
+			if len(tag) == 2 || tag[2] == "read" {
+				theirInbox = append(theirInbox, tag[1])
+			}
+		}
+	}
+
+	// besides their read relays we'll also contact these relays they signaled
+	success := false
+	errs := make(map[string]string, len(theirInbox))
+	log.Info().
+		Strs("relays", theirInbox).
+		Msg("[acceptor] sending to user")
+	for res := range pool.PublishMany(ctx, append(theirInbox, theirSignaledReply...), evt) {
+		if res.Error == nil {
+			success = true
+		} else {
+			errs[res.RelayURL] = res.Error.Error()
+		}
+	}
+	if !success {
+		return fmt.Errorf("failed to publish anywhere: %v", errs)
+	}
+
+	return nil
+}
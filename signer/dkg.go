@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip11"
+	"fiatjaf.com/nostr/nip13"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/mailru/easyjson"
+)
+
+func handleDKGInvite(ctx context.Context, inviteEvt nostr.Event, pow uint64, restartSigner func()) {
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Minute*4, fmt.Errorf("distributed key generation took too long"))
+	defer cancel()
+
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+	log := log.With().
+		Str("requester", inviteEvt.PubKey.Hex()).
+		Str("evt", inviteEvt.ID.Hex()).
+		Logger()
+
+	log.Info().Msgf("[dkg] got invite")
+
+	// check proof-of-work
+	if work := nip13.CommittedDifficulty(inviteEvt); work < int(pow) {
+		log.Warn().Uint64("need", pow).Int("got", work).Msgf("[dkg] not enough work")
+		return
+	}
+
+	invite := common.DKGInvite{}
+	if err := invite.Decode(inviteEvt); err != nil {
+		log.Warn().Err(err).Msg("[dkg] got broken invite")
+		return
+	}
+	ourId := invite.ID(ourPubkey)
+	if ourId == 0 {
+		log.Warn().Msg("[dkg] we're not in the list of signers")
+		return
+	}
+	log = log.With().Str("coordinator", invite.Coordinator).Int("id", ourId).Logger()
+
+	// TOFU the coordinator's pubkey
+	info, err := nip11.Fetch(ctx, invite.Coordinator)
+	if err != nil || info.PubKey == nil {
+		log.Warn().Err(err).Msg("[dkg] error on nip11 request")
+		return
+	}
+	coordinatorPubKey := *info.PubKey
+
+	shard, err := runDKG(ctx, invite, ourId, coordinatorPubKey)
+	if err != nil {
+		log.Warn().Err(err).Msg("[dkg] failed")
+		return
+	}
+
+	// then we store this shard and will start listening to sign requests from it
+	storedShard := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindStoredShard,
+		PubKey:    nostr.PubKey(*shard.PublicKey.X.Bytes()),
+		Tags: nostr.Tags{
+			{"coordinator", invite.Coordinator, coordinatorPubKey.Hex()},
+			{"dkg", inviteEvt.ID.Hex()},
+			signersTag(invite.Signers),
+		},
+	}
+	if invite.Recovery != nostr.ZeroPK {
+		storedShard.Tags = append(storedShard.Tags, nostr.Tag{"recovery", invite.Recovery.Hex()})
+	}
+	if err := vault.seal(&storedShard, shard); err != nil {
+		log.Warn().Err(err).Msg("[dkg] failed to encrypt shard")
+		return
+	}
+	storedShard.ID = storedShard.GetID()
+	if err := store.ReplaceEvent(storedShard); err != nil {
+		log.Warn().Err(err).Msg("[dkg] failed to store shard")
+		return
+	}
+
+	log.Info().Str("user", storedShard.PubKey.Hex()).Msgf("[dkg] shard registered")
+
+	// restart signer process
+	restartSigner()
+}
+
+func runDKG(
+	ctx context.Context,
+	invite common.DKGInvite,
+	ourId int,
+	coordinatorPubKey nostr.PubKey,
+) (frost.KeyShard, error) {
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+	sessionId := invite.Event.ID
+
+	relay, err := pool.EnsureRelay(invite.Coordinator)
+	if err != nil {
+		return frost.KeyShard{}, fmt.Errorf("failed to connect to coordinator: %w", err)
+	}
+
+	sendToCoordinator := sessionPublisher(ctx, relay, sessionId)
+
+	// listen for everything related to this session before we say anything
+	events := pool.SubscribeMany(ctx, []string{invite.Coordinator}, nostr.Filter{
+		Kinds: []nostr.Kind{
+			common.KindDKGGroupCommit,
+			common.KindDKGShare,
+			common.KindGroupCommit,
+			common.KindEventToBeSigned,
+			common.KindShardACK,
+		},
+		Tags: nostr.TagMap{
+			"p": []string{ourPubkey.Hex()},
+		},
+	}, nostr.SubscriptionOptions{
+		Label: "prom-dkg",
+	})
+
+	if invite.Method == frost.MethodMuSig2 {
+		return runMuSig2KeyAggregation(ctx, invite, ourId, coordinatorPubKey, events, sendToCoordinator)
+	}
+
+	// step-1 (send): commit to our polynomial
+	participant, ourCommitment, err := frost.NewDKGParticipant(ourId, invite.Threshold, len(invite.Signers), sessionId[:])
+	if err != nil {
+		return frost.KeyShard{}, err
+	}
+	defer participant.Zero()
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindDKGCommit,
+		Content: ourCommitment.Hex(),
+	}); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-2 (receive): get everybody's commitments
+	var commitments []frost.DKGCommitment
+	shares := make([]frost.DKGShare, 0, len(invite.Signers)-1)
+	for commitments == nil {
+		ie, ok := <-events
+		if !ok {
+			return frost.KeyShard{}, fmt.Errorf("subscription closed: %w", context.Cause(ctx))
+		}
+		evt := ie.Event
+
+		switch {
+		case evt.Kind == common.KindDKGGroupCommit && evt.PubKey == coordinatorPubKey && isForSession(evt, sessionId):
+			commitments, err = decodeDKGCommitments(evt.Content)
+			if err != nil {
+				return frost.KeyShard{}, fmt.Errorf("failed to decode commitments: %w", err)
+			}
+			idx := slices.IndexFunc(commitments, func(com frost.DKGCommitment) bool { return com.SignerID == ourId })
+			if idx == -1 || commitments[idx].Hex() != ourCommitment.Hex() {
+				return frost.KeyShard{}, fmt.Errorf("coordinator didn't include our commitment correctly")
+			}
+		case evt.Kind == common.KindDKGShare && isForSession(evt, sessionId):
+			// shares may arrive before the commitments if some other signer is faster than us
+			share, err := decryptDKGShare(ctx, invite.ID(evt.PubKey), evt)
+			if err != nil {
+				return frost.KeyShard{}, err
+			}
+			shares = append(shares, share)
+		}
+	}
+
+	// step-3 (send): give everybody else their shares
+	for i, signer := range invite.Signers {
+		if signer == ourPubkey {
+			continue
+		}
+
+		ciphertext, err := kr.Encrypt(ctx, participant.Share(i+1).Hex(), signer)
+		if err != nil {
+			return frost.KeyShard{}, fmt.Errorf("failed to encrypt share to %s: %w", signer, err)
+		}
+		if err := sendToCoordinator(&nostr.Event{
+			Kind:    common.KindDKGShare,
+			Content: ciphertext,
+			Tags:    nostr.Tags{{"p", signer.Hex()}},
+		}); err != nil {
+			return frost.KeyShard{}, err
+		}
+	}
+
+	// step-4 (receive): get our shares from everybody else
+	for len(shares) < len(invite.Signers)-1 {
+		ie, ok := <-events
+		if !ok {
+			return frost.KeyShard{}, fmt.Errorf("subscription closed: %w", context.Cause(ctx))
+		}
+		evt := ie.Event
+
+		if evt.Kind == common.KindDKGShare && isForSession(evt, sessionId) {
+			share, err := decryptDKGShare(ctx, invite.ID(evt.PubKey), evt)
+			if err != nil {
+				return frost.KeyShard{}, err
+			}
+			if slices.ContainsFunc(shares, func(sh frost.DKGShare) bool { return sh.From == share.From }) {
+				return frost.KeyShard{}, fmt.Errorf("got repeated share from %d", share.From)
+			}
+			shares = append(shares, share)
+		}
+	}
+
+	shard, err := participant.Finalize(commitments, shares)
+	if err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-5 (send): tell the coordinator what we got
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindDKGResult,
+		Content: shard.PublicKeyShard.Hex(),
+	}); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-6: sign our first event, which is our own registration
+	_, vss, _ := frost.AggregateDKGCommitments(commitments)
+	signers := make(map[int]nostr.PubKey, len(invite.Signers))
+	expected := make([]frost.PublicKeyShard, len(invite.Signers))
+	for i, signer := range invite.Signers {
+		signers[i+1] = signer
+		expected[i] = vss.PublicKeyShard(i + 1)
+	}
+	if err := signOwnRegistration(ctx, events, coordinatorPubKey, sessionId, sendToCoordinator,
+		invite.Threshold, invite.Method, signers, expected, shard,
+	); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	return shard, nil
+}
+
+// runMuSig2KeyAggregation is runDKG for musig2: instead of exchanging shares we just make a key for ourselves, tell
+// it to everybody through the coordinator and get our shard of the aggregate of all of them.
+func runMuSig2KeyAggregation(
+	ctx context.Context,
+	invite common.DKGInvite,
+	ourId int,
+	coordinatorPubKey nostr.PubKey,
+	events chan nostr.RelayEvent,
+	sendToCoordinator func(evt *nostr.Event) error,
+) (frost.KeyShard, error) {
+	sessionId := invite.Event.ID
+
+	// step-1 (send): our own key, which nobody else will ever know the secret of
+	sk, err := btcec.NewPrivateKey()
+	if err != nil {
+		return frost.KeyShard{}, err
+	}
+	defer sk.Zero()
+	ourKey := frost.PublicKeyShard{ID: ourId, PublicKey: new(btcec.JacobianPoint)}
+	sk.PubKey().AsJacobian(ourKey.PublicKey)
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindDKGCommit,
+		Content: ourKey.Hex(),
+	}); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-2 (receive): get everybody's keys
+	var keys []frost.PublicKeyShard
+	for keys == nil {
+		ie, ok := <-events
+		if !ok {
+			return frost.KeyShard{}, fmt.Errorf("subscription closed: %w", context.Cause(ctx))
+		}
+		evt := ie.Event
+
+		if evt.Kind == common.KindDKGGroupCommit && evt.PubKey == coordinatorPubKey && isForSession(evt, sessionId) {
+			keys, err = decodeMuSig2Keys(evt.Content)
+			if err != nil {
+				return frost.KeyShard{}, fmt.Errorf("failed to decode keys: %w", err)
+			}
+		}
+	}
+	if len(keys) != len(invite.Signers) {
+		return frost.KeyShard{}, fmt.Errorf("got %d keys, expected %d", len(keys), len(invite.Signers))
+	}
+	points := make([]*btcec.JacobianPoint, len(keys))
+	for i, key := range keys {
+		if key.ID != i+1 {
+			return frost.KeyShard{}, fmt.Errorf("got key for %d at position %d", key.ID, i+1)
+		}
+		points[i] = key.PublicKey
+	}
+	if keys[ourId-1].Hex() != ourKey.Hex() {
+		return frost.KeyShard{}, fmt.Errorf("coordinator didn't include our key correctly")
+	}
+
+	shard, err := frost.MuSig2KeyShard(points, ourId, &sk.Key)
+	if err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-3 (send): tell the coordinator what we got
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindDKGResult,
+		Content: shard.PublicKeyShard.Hex(),
+	}); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-4: sign our first event, which is our own registration
+	_, expected, _ := frost.MuSig2AggregateKeys(points)
+	signers := make(map[int]nostr.PubKey, len(invite.Signers))
+	for i, signer := range invite.Signers {
+		signers[i+1] = signer
+	}
+	if err := signOwnRegistration(ctx, events, coordinatorPubKey, sessionId, sendToCoordinator,
+		invite.Threshold, invite.Method, signers, expected, shard,
+	); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	return shard, nil
+}
+
+// sessionPublisher returns a function that signs events and sends them to the coordinator tagged with sessionId.
+func sessionPublisher(ctx context.Context, relay *nostr.Relay, sessionId nostr.ID) func(evt *nostr.Event) error {
+	return func(evt *nostr.Event) error {
+		evt.CreatedAt = nostr.Now()
+		evt.Tags = append(evt.Tags, nostr.Tag{"e", sessionId.Hex()})
+		if err := kr.SignEvent(ctx, evt); err != nil {
+			return fmt.Errorf("failed to sign message k:%d: %w", evt.Kind, err)
+		}
+		if err := relay.Publish(ctx, *evt); err != nil {
+			return fmt.Errorf("failed to publish k:%d: %w", evt.Kind, err)
+		}
+		return nil
+	}
+}
+
+// signOwnRegistration is what a group of signers that has just got new shards does: it commits to nonces, signs
+// the account registration built by the coordinator after making sure it matches what was agreed on, then waits for
+// the coordinator to ack it. everybody in signers takes part.
+func signOwnRegistration(
+	ctx context.Context,
+	events chan nostr.RelayEvent,
+	coordinatorPubKey nostr.PubKey,
+	sessionId nostr.ID,
+	sendToCoordinator func(evt *nostr.Event) error,
+	threshold int,
+	method frost.Method,
+	signers map[int]nostr.PubKey,
+	expected []frost.PublicKeyShard,
+	shard frost.KeyShard,
+) error {
+	cfg := &frost.Configuration{
+		Threshold:    threshold,
+		MaxSigners:   len(signers),
+		Method:       method,
+		PublicKey:    shard.PublicKey,
+		Participants: slices.Sorted(maps.Keys(signers)),
+	}
+	signer, err := cfg.Signer(shard, lambdaRegistry)
+	if err != nil {
+		return err
+	}
+
+	// (send): commit to the nonces
+	ourNonceCommitment := signer.Commit(sessionId.Hex())
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindCommit,
+		Content: ourNonceCommitment.Hex(),
+		Tags:    nostr.Tags{{"p", cfg.PublicKey.X.String()}},
+	}); err != nil {
+		return err
+	}
+
+	// (receive): the account registration event and the group commitment
+	userPubKey := nostr.PubKey(*shard.PublicKey.X.Bytes())
+	var msg []byte
+	groupCommitment := frost.BinoncePublic{}
+	for len(msg) != 32 || groupCommitment[0] == nil {
+		ie, ok := <-events
+		if !ok {
+			return fmt.Errorf("subscription closed: %w", context.Cause(ctx))
+		}
+		evt := ie.Event
+		if evt.PubKey != coordinatorPubKey || !isForSession(evt, sessionId) {
+			continue
+		}
+
+		switch evt.Kind {
+		case common.KindEventToBeSigned:
+			var evtToSign nostr.Event
+			if err := easyjson.Unmarshal([]byte(evt.Content), &evtToSign); err != nil {
+				return fmt.Errorf("failed to decode event to be signed: %w", err)
+			}
+			if err := checkAccountRegistration(evtToSign, userPubKey, threshold, method, signers, expected); err != nil {
+				return err
+			}
+			msg = evtToSign.ID[:]
+		case common.KindGroupCommit:
+			if err := groupCommitment.DecodeHex(evt.Content); err != nil {
+				return fmt.Errorf("failed to decode received commitment: %w", err)
+			}
+		}
+	}
+
+	// (send): our partial signature
+	partialSig, err := signer.Sign(msg, groupCommitment)
+	if err != nil {
+		return err
+	}
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindPartialSignature,
+		Content: partialSig.Hex(),
+		Tags:    nostr.Tags{{"p", cfg.PublicKey.X.String()}},
+	}); err != nil {
+		return err
+	}
+
+	// (receive): the coordinator acks the registration just like when a shard is given to us
+	for {
+		ie, ok := <-events
+		if !ok {
+			return fmt.Errorf("failed to get ack from coordinator: %w", context.Cause(ctx))
+		}
+		evt := ie.Event
+		if evt.Kind != common.KindShardACK || evt.PubKey != coordinatorPubKey {
+			continue
+		}
+		if tag := evt.Tags.Find("P"); tag != nil && tag[1] == userPubKey.Hex() {
+			return nil
+		}
+	}
+}
+
+func isForSession(evt nostr.Event, sessionId nostr.ID) bool {
+	eTag := evt.Tags.Find("e")
+	return eTag != nil && eTag[1] == sessionId.Hex()
+}
+
+// decryptDKGShare reads a share sent to us by another signer, which must be the one identified by senderId
+// (0 if it isn't anyone we know).
+func decryptDKGShare(ctx context.Context, senderId int, evt nostr.Event) (frost.DKGShare, error) {
+	share := frost.DKGShare{}
+
+	if senderId == 0 {
+		return share, fmt.Errorf("got a share from unrelated %s", evt.PubKey)
+	}
+
+	plaintext, err := kr.Decrypt(ctx, evt.Content, evt.PubKey)
+	if err != nil {
+		return share, fmt.Errorf("failed to decrypt share from %s: %w", evt.PubKey, err)
+	}
+	if err := share.DecodeHex(plaintext); err != nil {
+		return share, fmt.Errorf("failed to decode share from %s: %w", evt.PubKey, err)
+	}
+	if share.From != senderId {
+		return share, fmt.Errorf("%s sent a share as %d, but it is %d", evt.PubKey, share.From, senderId)
+	}
+
+	return share, nil
+}
+
+func decodeDKGCommitments(content string) ([]frost.DKGCommitment, error) {
+	b, err := hex.DecodeString(content)
+	if err != nil {
+		return nil, err
+	}
+
+	commitments := make([]frost.DKGCommitment, 0, 5)
+	for len(b) > 0 {
+		com := frost.DKGCommitment{}
+		n, err := com.Decode(b)
+		if err != nil {
+			return nil, err
+		}
+		commitments = append(commitments, com)
+		b = b[n:]
+	}
+
+	return commitments, nil
+}
+
+func decodeMuSig2Keys(content string) ([]frost.PublicKeyShard, error) {
+	b, err := hex.DecodeString(content)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]frost.PublicKeyShard, 0, 5)
+	for len(b) > 0 {
+		key := frost.PublicKeyShard{}
+		n, err := key.Decode(b)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		b = b[n:]
+	}
+
+	return keys, nil
+}
+
+// checkAccountRegistration makes sure we will only ever sign an account registration that matches exactly what
+// we've just agreed on with the other signers, since in any other situation we refuse to sign these.
+func checkAccountRegistration(
+	evt nostr.Event,
+	pubkey nostr.PubKey,
+	threshold int,
+	method frost.Method,
+	signers map[int]nostr.PubKey,
+	expected []frost.PublicKeyShard,
+) error {
+	if !evt.CheckID() {
+		return fmt.Errorf("event to be signed has a broken id")
+	}
+	if evt.Kind != common.KindAccountRegistration {
+		return fmt.Errorf("expected an account registration, got kind %d", evt.Kind)
+	}
+	if evt.PubKey != pubkey {
+		return fmt.Errorf("account registration is for a different pubkey")
+	}
+
+	ar := common.AccountRegistration{}
+	if err := ar.Decode(evt); err != nil {
+		return fmt.Errorf("invalid account registration: %w", err)
+	}
+	if tag := evt.Tags.Find("threshold"); tag == nil || tag[1] != strconv.Itoa(threshold) {
+		return fmt.Errorf("account registration has the wrong threshold")
+	}
+	if ar.Method != method {
+		return fmt.Errorf("account registration is for %s, expected %s", ar.Method, method)
+	}
+	if len(ar.Signers) != len(signers) {
+		return fmt.Errorf("account registration has %d signers, expected %d", len(ar.Signers), len(signers))
+	}
+
+	for _, signer := range ar.Signers {
+		if signers[signer.Shard.ID] != signer.PeerPubKey {
+			return fmt.Errorf("account registration has the wrong signer for %d", signer.Shard.ID)
+		}
+	}
+	for _, pks := range expected {
+		idx := slices.IndexFunc(ar.Signers, func(signer common.Signer) bool { return signer.Shard.ID == pks.ID })
+		if idx == -1 || ar.Signers[idx].Shard.Hex() != pks.Hex() {
+			return fmt.Errorf("account registration has the wrong public shard for %d", pks.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// ecdhPolicy decides if we will help an account get the shared secret with counterparty, which is what lets whoever
+// holds the bunker read and write encrypted messages between them using scheme ("nip44" or "nip04"). it returns the
+// reason when we won't.
+type ecdhPolicy func(account nostr.PubKey, counterparty nostr.PubKey, scheme string) error
+
+// all of these must agree before we send an ecdh share
+var ecdhPolicies = []ecdhPolicy{
+	// shards (and exported shards) are encrypted between the user and us, so that's never allowed
+	func(account nostr.PubKey, counterparty nostr.PubKey, scheme string) error {
+		ourPubkey, _ := kr.GetPublicKey(context.Background())
+		if counterparty == ourPubkey {
+			return fmt.Errorf("counterparty is ourselves")
+		}
+		return nil
+	},
+
+	// the same goes for everybody else holding a part of this account and for its coordinator, as whoever has the
+	// bunker could otherwise read the shards and the signing traffic between them. we take their list from what we
+	// stored along with our shard, never from what the coordinator tells us now.
+	func(account nostr.PubKey, counterparty nostr.PubKey, scheme string) error {
+		shardEvt, err := storedShard(account)
+		if err != nil {
+			return err
+		}
+		if tag := shardEvt.Tags.Find("coordinator"); tag != nil && len(tag) >= 3 && tag[2] == counterparty.Hex() {
+			return fmt.Errorf("counterparty is the coordinator")
+		}
+		if tag := shardEvt.Tags.Find("signers"); tag != nil && slices.Contains(tag[1:], counterparty.Hex()) {
+			return fmt.Errorf("counterparty is a signer of this account")
+		}
+		return nil
+	},
+}
+
+// signersTag lists everybody holding a part of an account, to be kept with our shard for the ecdh policy above.
+func signersTag(peers []nostr.PubKey) nostr.Tag {
+	tag := make(nostr.Tag, 1, 1+len(peers))
+	tag[0] = "signers"
+	for _, peer := range peers {
+		tag = append(tag, peer.Hex())
+	}
+	return tag
+}
+
+// ecdhPolicyFromFlags turns the --ecdh-* flags into policies.
+func ecdhPolicyFromFlags(disabled bool, allowed []nostr.PubKey, denied []nostr.PubKey) []ecdhPolicy {
+	policies := make([]ecdhPolicy, 0, 3)
+
+	if disabled {
+		policies = append(policies, func(account nostr.PubKey, counterparty nostr.PubKey, scheme string) error {
+			return fmt.Errorf("ecdh is disabled")
+		})
+	}
+	if len(allowed) > 0 {
+		policies = append(policies, func(account nostr.PubKey, counterparty nostr.PubKey, scheme string) error {
+			if !slices.Contains(allowed, counterparty) {
+				return fmt.Errorf("counterparty is not allowed")
+			}
+			return nil
+		})
+	}
+	if len(denied) > 0 {
+		policies = append(policies, func(account nostr.PubKey, counterparty nostr.PubKey, scheme string) error {
+			if slices.Contains(denied, counterparty) {
+				return fmt.Errorf("counterparty is denied")
+			}
+			return nil
+		})
+	}
+
+	return policies
+}
+
+// nip04PolicyFromFlags turns the --*nip04* flags into a policy, for either all accounts or just some.
+func nip04PolicyFromFlags(disabled bool, deniedAccounts []nostr.PubKey) []ecdhPolicy {
+	if !disabled && len(deniedAccounts) == 0 {
+		return nil
+	}
+
+	return []ecdhPolicy{
+		func(account nostr.PubKey, counterparty nostr.PubKey, scheme string) error {
+			if scheme != "nip04" {
+				return nil
+			}
+			if disabled || slices.Contains(deniedAccounts, account) {
+				return fmt.Errorf("nip04 is disabled for this account")
+			}
+			return nil
+		},
+	}
+}
+
+// handleECDHConfiguration sends the coordinator our share of the shared secret between an account and someone else,
+// if our policies allow it, otherwise we tell it we won't so it can ask someone else.
+func handleECDHConfiguration(ctx context.Context, relay *nostr.Relay, evt nostr.Event) error {
+	cfg := frost.Configuration{}
+	if err := cfg.DecodeHex(evt.Content); err != nil {
+		return fmt.Errorf("error decoding config: %w", err)
+	}
+
+	counterpartyTag := evt.Tags.Find("counterparty")
+	if counterpartyTag == nil {
+		return fmt.Errorf("coordinator sent an ecdh configuration without a counterparty: %s", evt)
+	}
+	counterparty, err := nostr.PubKeyFromHex(counterpartyTag[1])
+	if err != nil {
+		return fmt.Errorf("invalid counterparty: %w", err)
+	}
+	theirs, err := btcec.ParsePubKey(append([]byte{2}, counterparty[:]...))
+	if err != nil {
+		return fmt.Errorf("invalid counterparty pubkey: %w", err)
+	}
+	target := new(btcec.JacobianPoint)
+	theirs.AsJacobian(target)
+
+	// before there was nip04 there was only nip44
+	scheme := "nip44"
+	if schemeTag := evt.Tags.Find("scheme"); schemeTag != nil {
+		scheme = schemeTag[1]
+	}
+	if scheme != "nip44" && scheme != "nip04" {
+		return fmt.Errorf("coordinator sent an ecdh configuration with an unknown scheme '%s'", scheme)
+	}
+
+	account := nostr.PubKey(*cfg.PublicKey.X.Bytes())
+	log := log.With().Str("user", account.Hex()).Str("counterparty", counterparty.Hex()).
+		Str("scheme", scheme).Str("coordinator", relay.URL).Logger()
+
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Second*10,
+		fmt.Errorf("sending ecdh share to coordinator took too long"))
+	defer cancel()
+	sendToCoordinator := sessionPublisher(ctx, relay, evt.ID)
+
+	for _, policy := range ecdhPolicies {
+		if err := policy(account, counterparty, scheme); err != nil {
+			log.Info().Err(err).Msg("[signer] refused to do ecdh")
+			return sendToCoordinator(&nostr.Event{
+				Kind: common.KindECDHShare,
+				Tags: nostr.Tags{{"p", account.Hex()}, {"refused", err.Error()}},
+			})
+		}
+	}
+
+	shards, err := openShards(account)
+	if err != nil {
+		return err
+	}
+	defer zeroShards(shards)
+
+	// when we are a weighted signer we send a share for each of our shards that is participating
+	sent := 0
+	for _, shard := range shards {
+		if _, err := cfg.Signer(shard, lambdaRegistry); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		if !slices.Contains(cfg.Participants, shard.ID) {
+			continue
+		}
+
+		share := cfg.CreateECDHShare(shard, target, lambdaRegistry)
+		if err := sendToCoordinator(&nostr.Event{
+			Kind:    common.KindECDHShare,
+			Content: share.Hex(),
+			Tags:    nostr.Tags{{"p", account.Hex()}},
+		}); err != nil {
+			return fmt.Errorf("failed to send ecdh share: %w", err)
+		}
+		sent++
+	}
+	if sent == 0 {
+		return fmt.Errorf("we are not a participant")
+	}
+
+	log.Info().Int("shards", sent).Msg("[signer] sent ecdh share")
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip13"
+	"fiatjaf.com/promenade/common"
+)
+
+// handleExportRequest is called when the user wants to take its key out of promenade. we give our shard (or all our
+// shards, if we are weighted) back, encrypted to the one-off key the user has chosen, as long as the request is
+// signed by the recovery key that was registered with our shard. the account key is never enough, as anyone with a
+// bunker url can get things signed with it.
+func handleExportRequest(ctx context.Context, requestEvt nostr.Event, pow uint64) {
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Minute*2, fmt.Errorf("exporting shard took too long"))
+	defer cancel()
+
+	log := log.With().
+		Str("user", requestEvt.PubKey.Hex()).
+		Str("evt", requestEvt.ID.Hex()).
+		Logger()
+
+	log.Info().Msgf("[export] got request")
+
+	// check proof-of-work
+	if work := nip13.CommittedDifficulty(requestEvt); work < int(pow) {
+		log.Warn().Uint64("need", pow).Int("got", work).Msgf("[export] not enough work")
+		return
+	}
+
+	// an old request may have been stored somewhere and replayed, the user must be around right now
+	if age := time.Since(requestEvt.CreatedAt.Time()); age > common.ExportRequestMaxAge || age < -time.Minute {
+		log.Warn().Dur("age", age).Msg("[export] request is too old")
+		return
+	}
+	if !requestEvt.VerifySignature() {
+		log.Warn().Msg("[export] request has an invalid signature")
+		return
+	}
+
+	request := common.ExportRequest{}
+	if err := request.Decode(requestEvt); err != nil {
+		log.Warn().Err(err).Msg("[export] got broken request")
+		return
+	}
+
+	var shardEvt nostr.Event
+	var ok bool
+	for evt := range store.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{common.KindStoredShard},
+		Authors: []nostr.PubKey{request.Account},
+	}, 1) {
+		shardEvt = evt
+		ok = true
+	}
+	if !ok {
+		log.Warn().Str("account", request.Account.Hex()).Msg("[export] we don't have a shard for this account")
+		return
+	}
+
+	if recovery, err := common.RecoveryKey(shardEvt.Tags); err != nil || recovery == nostr.ZeroPK {
+		log.Warn().Err(err).Msg("[export] no recovery key was registered for this account")
+		return
+	} else if recovery != requestEvt.PubKey {
+		log.Warn().Str("recovery", recovery.Hex()).Msg("[export] request not signed by the recovery key")
+		return
+	}
+
+	shards, err := vault.openAll(shardEvt)
+	if err != nil {
+		log.Warn().Err(err).Msg("[export] failed to open our shard")
+		return
+	}
+	defer func() {
+		for i := range shards {
+			shards[i].Zero()
+		}
+	}()
+
+	ciphertext, err := kr.Encrypt(ctx, common.EncodeShards(shards), request.To)
+	if err != nil {
+		log.Warn().Err(err).Msg("[export] failed to encrypt shard")
+		return
+	}
+	exportEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindExportShard,
+		Content:   ciphertext,
+		Tags: nostr.Tags{
+			{"p", request.To.Hex()},
+			{"P", request.Account.Hex()},
+			{"e", requestEvt.ID.Hex()},
+		},
+	}
+	if err := kr.SignEvent(ctx, &exportEvt); err != nil {
+		log.Warn().Err(err).Msg("[export] failed to sign")
+		return
+	}
+
+	if err := sendToUser(ctx, requestEvt.PubKey, request.Reply, exportEvt); err != nil {
+		log.Warn().Err(err).Msg("[export] failed to send shard back")
+		return
+	}
+
+	ids := make([]int, len(shards))
+	for i, shard := range shards {
+		ids[i] = shard.ID
+	}
+	log.Info().Ints("ids", ids).Msg("[export] shard sent")
+}
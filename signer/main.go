@@ -42,6 +42,11 @@ var app = &cli.Command{
 			Usage: "path to the eventstore directory",
 			Value: "./shardstore",
 		},
+		&cli.StringFlag{
+			Name:    "shards-passphrase",
+			Usage:   "passphrase the stored shards are encrypted with, if not given they are encrypted with our key",
+			Sources: cli.EnvVars("PROMENADE_SHARDS_PASSPHRASE"),
+		},
 		&cli.UintFlag{
 			Name:  "min-pow",
 			Usage: "how much proof-of-work to require in order to accept a shard",
@@ -51,17 +56,48 @@ var app = &cli.Command{
 			Name:  "accept-relay",
 			Usage: "specify one or more relay URLs to receive key shards from users that may want to use you as a signer",
 		},
+		&cli.BoolFlag{
+			Name:  "no-ecdh",
+			Usage: "never help decrypting or encrypting messages for the users",
+		},
+		&cli.StringSliceFlag{
+			Name:  "ecdh-allow",
+			Usage: "only help decrypting or encrypting messages between the users and these pubkeys",
+		},
+		&cli.StringSliceFlag{
+			Name:  "ecdh-deny",
+			Usage: "never help decrypting or encrypting messages between the users and these pubkeys",
+		},
+		&cli.BoolFlag{
+			Name:  "no-nip04",
+			Usage: "never help decrypting or encrypting messages with the legacy NIP-04 scheme",
+		},
+		&cli.StringSliceFlag{
+			Name:  "nip04-deny",
+			Usage: "never help these accounts decrypt or encrypt messages with the legacy NIP-04 scheme",
+		},
 	},
-	Action: func(ctx context.Context, c *cli.Command) error {
-		store = &boltdb.BoltBackend{Path: c.String("shards-db")}
-		err := store.Init()
+	Before: func(ctx context.Context, c *cli.Command) (context.Context, error) {
+		bolt := &boltdb.BoltBackend{Path: c.String("shards-db")}
+		err := bolt.Init()
 		if err != nil {
-			return fmt.Errorf("failed to open db at %s: %w", c.String("shards-db"), err)
+			return ctx, fmt.Errorf("failed to open db at %s: %w", c.String("shards-db"), err)
+		}
+		store = bolt
+
+		nonces, err = openNonceStore(bolt.DB)
+		if err != nil {
+			return ctx, err
 		}
 
 		kr, err = keyer.New(ctx, pool, c.String("sec"), nil)
 		if err != nil {
-			return fmt.Errorf("invalid secret key: %w", err)
+			return ctx, fmt.Errorf("invalid secret key: %w", err)
+		}
+
+		vault, err = unlockShardVault(ctx, bolt.DB, c.String("shards-passphrase"))
+		if err != nil {
+			return ctx, err
 		}
 
 		pool = nostr.NewPool(nostr.PoolOptions{
@@ -71,6 +107,42 @@ var app = &cli.Command{
 		publicKey, _ := kr.GetPublicKey(ctx)
 		log.Info().Msgf("[] running as %s", publicKey)
 
+		allowed := make([]nostr.PubKey, 0, len(c.StringSlice("ecdh-allow")))
+		for _, pkh := range c.StringSlice("ecdh-allow") {
+			pk, err := nostr.PubKeyFromHex(pkh)
+			if err != nil {
+				return ctx, fmt.Errorf("invalid pubkey '%s' in --ecdh-allow: %w", pkh, err)
+			}
+			allowed = append(allowed, pk)
+		}
+		denied := make([]nostr.PubKey, 0, len(c.StringSlice("ecdh-deny")))
+		for _, pkh := range c.StringSlice("ecdh-deny") {
+			pk, err := nostr.PubKeyFromHex(pkh)
+			if err != nil {
+				return ctx, fmt.Errorf("invalid pubkey '%s' in --ecdh-deny: %w", pkh, err)
+			}
+			denied = append(denied, pk)
+		}
+		ecdhPolicies = append(ecdhPolicies, ecdhPolicyFromFlags(c.Bool("no-ecdh"), allowed, denied)...)
+
+		nip04Denied := make([]nostr.PubKey, 0, len(c.StringSlice("nip04-deny")))
+		for _, pkh := range c.StringSlice("nip04-deny") {
+			pk, err := nostr.PubKeyFromHex(pkh)
+			if err != nil {
+				return ctx, fmt.Errorf("invalid pubkey '%s' in --nip04-deny: %w", pkh, err)
+			}
+			nip04Denied = append(nip04Denied, pk)
+		}
+		ecdhPolicies = append(ecdhPolicies, nip04PolicyFromFlags(c.Bool("no-nip04"), nip04Denied)...)
+
+		return ctx, nil
+	},
+	Commands: []*cli.Command{
+		repairCommand,
+		rekeyCommand,
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		var err error
 		signerCtx, cancelSigner := context.WithCancelCause(ctx)
 
 		restartSigner := func() {
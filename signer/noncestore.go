@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/frost"
+	"go.etcd.io/bbolt"
+)
+
+// nonceStore keeps the state of each signing session on disk, so a session can never be signed for twice, not even
+// after a crash or a restart or when the coordinator replays its events.
+//
+// only that state goes to the disk, the secret nonces themselves stay in memory until they are consumed and are
+// lost on restart (when all the pending sessions are marked as consumed), so there is nothing secret in the bucket.
+// the nonces we generate ahead of time are not here either, as those only live in memory too.
+type nonceStore struct {
+	db *bbolt.DB
+
+	mu        sync.Mutex
+	secrets   map[nostr.ID]pendingNonces
+	lastPrune time.Time
+}
+
+type pendingNonces struct {
+	secret    frost.BinonceSecret
+	createdAt nostr.Timestamp
+}
+
+var nonceBucket = []byte("promenade-nonces")
+
+const (
+	// the secret nonces are in memory
+	noncePending byte = 1
+
+	// the nonces are gone, nothing else will ever be signed in this session
+	nonceConsumed byte = 2
+)
+
+// sessions older than this are refused, so after this long their state can be pruned from the disk
+const nonceRetention = time.Hour * 24
+
+var nonces *nonceStore
+
+// openNonceStore uses a bucket of its own in the same database as the shards. sessions that were pending when we
+// stopped are marked as consumed, as their nonces are gone and they can't be resumed anyway.
+func openNonceStore(db *bbolt.DB) (*nonceStore, error) {
+	var wiped int
+	err := db.Update(func(txn *bbolt.Tx) error {
+		bucket, err := txn.CreateBucketIfNotExists(nonceBucket)
+		if err != nil {
+			return err
+		}
+
+		rewrite := make(map[string]nostr.Timestamp)
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if len(v) > 0 && v[0] == noncePending {
+				wiped++
+			}
+			if len(v) != 9 {
+				// older versions kept the secret nonces here and no timestamp, so these get one now
+				rewrite[string(k)] = nostr.Now()
+			} else if v[0] == noncePending {
+				rewrite[string(k)] = nostr.Timestamp(binary.BigEndian.Uint64(v[1:]))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for k, createdAt := range rewrite {
+			if err := bucket.Put([]byte(k), nonceState(nonceConsumed, createdAt)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open nonce store: %w", err)
+	}
+
+	if wiped > 0 {
+		log.Info().Int("sessions", wiped).Msg("[signer] discarded nonces of unfinished sessions")
+	}
+
+	ns := &nonceStore{db: db, secrets: make(map[nostr.ID]pendingNonces)}
+	ns.prune()
+	return ns, nil
+}
+
+func nonceState(state byte, createdAt nostr.Timestamp) []byte {
+	v := make([]byte, 9)
+	v[0] = state
+	binary.BigEndian.PutUint64(v[1:], uint64(createdAt))
+	return v
+}
+
+// save keeps the secret nonces for a session (createdAt being when it was started by the coordinator) until they are
+// consumed. it must be called before the commitment is sent out, and fails if anything was ever stored for the same
+// session.
+func (ns *nonceStore) save(sessionId nostr.ID, createdAt nostr.Timestamp, secret frost.BinonceSecret) error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if err := ns.mark(sessionId, createdAt, noncePending); err != nil {
+		return err
+	}
+	ns.secrets[sessionId] = pendingNonces{secret, createdAt}
+	return nil
+}
+
+// consume takes the secret nonces of a session out, marking it as consumed on disk at the same time, so the nonces
+// are given out only once, even after a restart.
+func (ns *nonceStore) consume(sessionId nostr.ID) (frost.BinonceSecret, error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	pending, ok := ns.secrets[sessionId]
+	if !ok {
+		return frost.BinonceSecret{}, fmt.Errorf("no nonces for session %s", sessionId)
+	}
+
+	err := ns.db.Update(func(txn *bbolt.Tx) error {
+		bucket := txn.Bucket(nonceBucket)
+		v := bucket.Get(sessionId[:])
+		if v == nil || v[0] != noncePending {
+			return fmt.Errorf("already signed for session %s", sessionId)
+		}
+		return bucket.Put(sessionId[:], nonceState(nonceConsumed, pending.createdAt))
+	})
+	delete(ns.secrets, sessionId)
+	if err != nil {
+		return frost.BinonceSecret{}, err
+	}
+
+	return pending.secret, nil
+}
+
+// claim marks a session as consumed right away, for when the nonces come from somewhere else. it fails if anything
+// was ever stored for the same session.
+func (ns *nonceStore) claim(sessionId nostr.ID, createdAt nostr.Timestamp) error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	return ns.mark(sessionId, createdAt, nonceConsumed)
+}
+
+// mark stores the state of a new session, refusing the ones we may have forgotten about already.
+func (ns *nonceStore) mark(sessionId nostr.ID, createdAt nostr.Timestamp, state byte) error {
+	if time.Since(createdAt.Time()) > nonceRetention {
+		return fmt.Errorf("session %s is too old", sessionId)
+	}
+	if time.Since(ns.lastPrune) > time.Hour {
+		ns.prune()
+	}
+
+	return ns.db.Update(func(txn *bbolt.Tx) error {
+		bucket := txn.Bucket(nonceBucket)
+		if bucket.Get(sessionId[:]) != nil {
+			return fmt.Errorf("already signed for session %s", sessionId)
+		}
+		return bucket.Put(sessionId[:], nonceState(state, createdAt))
+	})
+}
+
+// prune forgets about the consumed sessions that are too old to be accepted again and about the nonces of the ones
+// that never finished.
+func (ns *nonceStore) prune() {
+	ns.lastPrune = time.Now()
+	cutoff := nostr.Timestamp(time.Now().Add(-nonceRetention).Unix())
+
+	for sessionId, pending := range ns.secrets {
+		if pending.createdAt < cutoff {
+			delete(ns.secrets, sessionId)
+		}
+	}
+
+	var pruned int
+	err := ns.db.Update(func(txn *bbolt.Tx) error {
+		bucket := txn.Bucket(nonceBucket)
+		old := make([][]byte, 0)
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if len(v) == 9 && nostr.Timestamp(binary.BigEndian.Uint64(v[1:])) < cutoff {
+				old = append(old, append([]byte{}, k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range old {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(old)
+		return nil
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("[signer] failed to prune old sessions")
+	} else if pruned > 0 {
+		log.Debug().Int("sessions", pruned).Msg("[signer] pruned old sessions")
+	}
+}
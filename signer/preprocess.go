@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// how many nonce commitments we send to the coordinator at once
+const nonceBatchSize = 20
+
+type preprocessedNonces struct {
+	// we keep the secrets of the last two batches, so the coordinator can still use the previous one while the
+	// latest is on its way
+	pool *frost.NoncePool
+
+	// commitments we send one at a time during ROAST runs
+	fresh *frost.NoncePool
+
+	// how many of the commitments from the latest batch the coordinator still has, more or less
+	unused atomic.Int32
+
+	sending atomic.Bool
+}
+
+// nonces we have generated ahead of time, indexed by account
+var noncePools = xsync.NewMapOf[nostr.PubKey, *preprocessedNonces]()
+
+// getNonces gives the nonces for an account, for which we hold weight shards.
+func getNonces(account nostr.PubKey, weight int) *preprocessedNonces {
+	pn, _ := noncePools.LoadOrCompute(account, func() *preprocessedNonces {
+		return &preprocessedNonces{
+			pool:  frost.NewNoncePool(nonceBatchSize * 2 * weight),
+			fresh: frost.NewNoncePool(nonceBatchSize * weight),
+		}
+	})
+	return pn
+}
+
+// sendNonceBatch generates a batch of nonces for an account, with the same number for each of our shards, and sends
+// the commitments to the coordinator, which will replace whatever it had from us before. if this is an answer to a
+// session that wanted nonces we didn't have sessionId should be given so the coordinator knows.
+func sendNonceBatch(ctx context.Context, relay *nostr.Relay, shards []frost.KeyShard, sessionId *nostr.ID) error {
+	account := nostr.PubKey(*shards[0].PublicKey.X.Bytes())
+	pn := getNonces(account, len(shards))
+
+	// there is no point in sending two batches at the same time
+	if !pn.sending.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer pn.sending.Store(false)
+
+	batchId := strconv.FormatInt(time.Now().UnixNano(), 10)
+	batch := make(frost.CommitmentList, 0, nonceBatchSize*len(shards))
+	for _, shard := range shards {
+		batch = append(batch, pn.pool.Generate(shard, batchId, nonceBatchSize)...)
+	}
+	pn.unused.Store(nonceBatchSize)
+
+	evt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindNonceBatch,
+		Content:   batch.Hex(),
+		Tags:      nostr.Tags{{"P", account.Hex()}},
+	}
+	if sessionId != nil {
+		evt.Tags = append(evt.Tags, nostr.Tag{"e", sessionId.Hex()})
+	}
+	if err := kr.SignEvent(ctx, &evt); err != nil {
+		return fmt.Errorf("failed to sign nonce batch: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Second*10,
+		fmt.Errorf("sending nonce batch to coordinator took too long"))
+	defer cancel()
+	if err := relay.Publish(ctx, evt); err != nil {
+		return fmt.Errorf("failed to publish nonce batch: %w", err)
+	}
+
+	return nil
+}
+
+// sendFreshCommitments gives the coordinator a commitment for each of our shards, so it can put us in a ROAST
+// session with them.
+func sendFreshCommitments(ctx context.Context, relay *nostr.Relay, shards []frost.KeyShard, roastId nostr.ID) error {
+	account := nostr.PubKey(*shards[0].PublicKey.X.Bytes())
+	batchId := roastId.Hex() + "/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	pn := getNonces(account, len(shards))
+
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Second*10,
+		fmt.Errorf("sending commitment to coordinator took too long"))
+	defer cancel()
+
+	for _, shard := range shards {
+		commitment := pn.fresh.Generate(shard, batchId, 1)[0]
+		if err := sessionPublisher(ctx, relay, roastId)(&nostr.Event{
+			Kind:    common.KindCommit,
+			Content: commitment.Hex(),
+			Tags:    nostr.Tags{{"p", shard.PublicKey.X.String()}},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendMemberCommitment is like sendFreshCommitments, for when we are a member of a sub-committee.
+func sendMemberCommitment(ctx context.Context, relay *nostr.Relay, sub frost.SubShard, roastId nostr.ID) error {
+	account := nostr.PubKey(*sub.GroupPublicKey.X.Bytes())
+	batchId := roastId.Hex() + "/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	pn := getNonces(account, 1)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Second*10,
+		fmt.Errorf("sending commitment to coordinator took too long"))
+	defer cancel()
+
+	commitment := pn.fresh.Generate(sub.KeyShard, batchId, 1)[0]
+	return sessionPublisher(ctx, relay, roastId)(&nostr.Event{
+		Kind:    common.KindCommit,
+		Content: commitment.Hex(),
+		Tags:    nostr.Tags{{"p", sub.GroupPublicKey.X.String()}},
+	})
+}
+
+// openShards gets all our shards for an account (more than one only if we are a weighted signer).
+func openShards(account nostr.PubKey) ([]frost.KeyShard, error) {
+	res, err := storedShard(account)
+	if err != nil {
+		return nil, err
+	}
+
+	shards, err := vault.openAll(res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open our shard: %w", err)
+	}
+	return shards, nil
+}
+
+// openSubShard gets our sub-shard for an account, if what we are is a member of one of its sub-committees.
+func openSubShard(account nostr.PubKey) (frost.SubShard, bool) {
+	sub := frost.SubShard{}
+	res, err := storedShard(account)
+	if err != nil {
+		return sub, false
+	}
+	if err := vault.openSubShard(res, &sub); err != nil {
+		return sub, false
+	}
+	return sub, true
+}
+
+func storedShard(account nostr.PubKey) (nostr.Event, error) {
+	var res nostr.Event
+	var ok bool
+	for pk := range store.QueryEvents(nostr.Filter{Authors: []nostr.PubKey{account}}, 100) {
+		res = pk
+		ok = true
+	}
+	if !ok {
+		return res, fmt.Errorf("[signer] couldn't find a shard for %s", account)
+	}
+	return res, nil
+}
+
+func zeroShards(shards []frost.KeyShard) {
+	for i := range shards {
+		shards[i].Zero()
+	}
+}
+
+// handleRoastConfiguration is called when the coordinator doesn't have any commitments from us for an account, we
+// give it one for now and a batch for the next times.
+func handleRoastConfiguration(ctx context.Context, relay *nostr.Relay, evt nostr.Event) error {
+	cfg := frost.Configuration{}
+	if err := cfg.DecodeHex(evt.Content); err != nil {
+		return fmt.Errorf("error decoding config: %w", err)
+	}
+
+	account := nostr.PubKey(*cfg.PublicKey.X.Bytes())
+	log := log.With().Str("user", account.Hex()).Str("coordinator", relay.URL).Logger()
+	log.Info().Msgf("[signer] invited to sign")
+
+	if sub, isMember := openSubShard(account); isMember {
+		defer sub.Zero()
+		if !slices.Contains(cfg.Participants, sub.Parent.ID) {
+			return fmt.Errorf("invalid config: our sub-committee (%d) is not a participant", sub.Parent.ID)
+		}
+		if err := sendMemberCommitment(ctx, relay, sub, evt.ID); err != nil {
+			return fmt.Errorf("failed to send commitment: %w", err)
+		}
+		return nil
+	}
+
+	shards, err := openShards(account)
+	if err != nil {
+		return err
+	}
+	defer zeroShards(shards)
+	for _, shard := range shards {
+		if _, err := cfg.Signer(shard, lambdaRegistry); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	if err := sendFreshCommitments(ctx, relay, shards, evt.ID); err != nil {
+		return fmt.Errorf("failed to send commitment: %w", err)
+	}
+
+	if err := sendNonceBatch(ctx, relay, shards, nil); err != nil {
+		log.Warn().Err(err).Msg("failed to send nonce batch")
+	}
+
+	return nil
+}
+
+// startPreprocessedSession signs in a single round using one of the commitments we have sent to the coordinator
+// before. everything comes in a single event: the configuration, the commitments of all the signers and the event.
+// if it is part of a ROAST run we send a fresh commitment afterwards, so we can be put in the next session.
+func startPreprocessedSession(ctx context.Context, relay *nostr.Relay, evt nostr.Event) error {
+	cfgTag := evt.Tags.Find("config")
+	commitmentsTag := evt.Tags.Find("commitments")
+	if cfgTag == nil || commitmentsTag == nil {
+		return fmt.Errorf("coordinator sent a buggy one-round configuration: %s", evt)
+	}
+
+	cfg := frost.Configuration{}
+	if err := cfg.DecodeHex(cfgTag[1]); err != nil {
+		return fmt.Errorf("error decoding config: %w", err)
+	}
+	commitments := frost.CommitmentList{}
+	if err := commitments.DecodeHex(commitmentsTag[1]); err != nil {
+		return fmt.Errorf("error decoding commitments: %w", err)
+	}
+
+	account := nostr.PubKey(*cfg.PublicKey.X.Bytes())
+	log := log.With().Str("user", account.Hex()).Str("coordinator", relay.URL).Logger()
+	log.Info().Msgf("[signer] one-round sign session started")
+
+	// the commitments must be exactly those of the participants
+	if err := cfg.ValidateCommitmentList(commitments); err != nil {
+		return fmt.Errorf("invalid commitments: %w", err)
+	}
+	if len(commitments) != len(cfg.Participants) {
+		return fmt.Errorf("got %d commitments for %d participants", len(commitments), len(cfg.Participants))
+	}
+	for _, commitment := range commitments {
+		if !slices.Contains(cfg.Participants, commitment.SignerID) {
+			return fmt.Errorf("got a commitment from %d, which is not a participant", commitment.SignerID)
+		}
+	}
+
+	if sub, isMember := openSubShard(account); isMember {
+		defer sub.Zero()
+		return signAsMember(ctx, relay, evt, &cfg, commitments, sub)
+	}
+
+	allShards, err := openShards(account)
+	if err != nil {
+		return err
+	}
+	defer zeroShards(allShards)
+
+	// when we are a weighted signer we may be in with all our shards or only with some of them
+	shards := make([]frost.KeyShard, 0, len(allShards))
+	idxs := make([]int, 0, len(allShards))
+	for _, shard := range allShards {
+		if idx := slices.IndexFunc(commitments, func(c frost.Commitment) bool { return c.SignerID == shard.ID }); idx != -1 {
+			shards = append(shards, shard)
+			idxs = append(idxs, idx)
+		}
+	}
+	if len(shards) == 0 {
+		return fmt.Errorf("our commitment is not in the list")
+	}
+
+	signers := make([]*frost.Signer, len(shards))
+	for i, shard := range shards {
+		signer, err := cfg.Signer(shard, lambdaRegistry)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		signers[i] = signer
+	}
+
+	// take our secret nonces out before anything else, they will never be used again even if this fails later
+	pn := getNonces(account, len(allShards))
+	fromBatch := true
+	for i, signer := range signers {
+		err := signer.UsePreprocessed(pn.pool, commitments[idxs[i]])
+		if err != nil {
+			fromBatch = false
+			err = signer.UsePreprocessed(pn.fresh, commitments[idxs[i]])
+		}
+		if err != nil {
+			// we have probably restarted, tell the coordinator about our new nonces right away
+			if err := sendNonceBatch(ctx, relay, allShards, &evt.ID); err != nil {
+				log.Warn().Err(err).Msg("failed to send nonce batch")
+			}
+			return err
+		}
+	}
+
+	// and never sign twice in the same session, even with other nonces
+	if err := nonces.claim(evt.ID, evt.CreatedAt); err != nil {
+		return err
+	}
+
+	evtToSign, err := checkEventToBeSigned(relay, &cfg, evt.Content)
+	if err != nil {
+		return err
+	}
+	log = log.With().Str("id", evtToSign.ID.Hex()).Logger()
+	msg := evtToSign.ID[:]
+
+	groupCommitment, _, _ := cfg.ComputeGroupCommitment(commitments, msg)
+	for _, signer := range signers {
+		partialSig, err := signer.Sign(msg, groupCommitment)
+		if err != nil {
+			return err
+		}
+
+		if err := sessionPublisher(ctx, relay, evt.ID)(&nostr.Event{
+			Kind:    common.KindPartialSignature,
+			Content: partialSig.Hex(),
+			Tags:    nostr.Tags{{"p", cfg.PublicKey.X.String()}},
+		}); err != nil {
+			log.Warn().Err(err).Msg("failed to send partial signature to coordinator")
+			return nil
+		}
+	}
+
+	log.Info().Int("shards", len(signers)).Msgf("[signer] signed %x for %x in one round", msg, account)
+
+	if eTag := evt.Tags.Find("e"); eTag != nil {
+		if roastId, err := nostr.IDFromHex(eTag[1]); err == nil {
+			if err := sendFreshCommitments(ctx, relay, allShards, roastId); err != nil {
+				log.Warn().Err(err).Msg("failed to send commitment")
+			}
+		}
+	}
+
+	// send more before the coordinator runs out
+	if fromBatch && pn.unused.Add(-1) <= nonceBatchSize/4 {
+		if err := sendNonceBatch(ctx, relay, allShards, nil); err != nil {
+			log.Warn().Err(err).Msg("failed to send nonce batch")
+		}
+	}
+
+	return nil
+}
+
+// signAsMember signs in the inner round of the sub-committee we are a member of, which the coordinator describes in
+// a ["committee", "<parent-id>", "<hex-encoded-config>", "<hex-encoded-commitments>"] tag: the configuration and the
+// commitments of the members taking part, which must add up to the commitment of the sub-committee in the session,
+// otherwise our partial signature could be used for something else.
+func signAsMember(
+	ctx context.Context,
+	relay *nostr.Relay,
+	evt nostr.Event,
+	cfg *frost.Configuration,
+	commitments frost.CommitmentList,
+	sub frost.SubShard,
+) error {
+	account := nostr.PubKey(*sub.GroupPublicKey.X.Bytes())
+	log := log.With().Str("user", account.Hex()).Str("coordinator", relay.URL).Int("committee", sub.Parent.ID).Logger()
+
+	tag := evt.Tags.FindWithValue("committee", strconv.Itoa(sub.Parent.ID))
+	if tag == nil || len(tag) != 4 {
+		return fmt.Errorf("coordinator sent a buggy sub-committee round: %s", evt)
+	}
+	committee := frost.Configuration{}
+	if err := committee.DecodeHex(tag[2]); err != nil {
+		return fmt.Errorf("error decoding sub-committee config: %w", err)
+	}
+	memberCommitments := frost.CommitmentList{}
+	if err := memberCommitments.DecodeHex(tag[3]); err != nil {
+		return fmt.Errorf("error decoding sub-committee commitments: %w", err)
+	}
+
+	if err := committee.ValidateCommitmentList(memberCommitments); err != nil {
+		return fmt.Errorf("invalid sub-committee commitments: %w", err)
+	}
+	if len(memberCommitments) != len(committee.Participants) {
+		return fmt.Errorf("got %d sub-committee commitments for %d members", len(memberCommitments),
+			len(committee.Participants))
+	}
+	idx := -1
+	for i, commitment := range memberCommitments {
+		if !slices.Contains(committee.Participants, commitment.SignerID) {
+			return fmt.Errorf("got a commitment from %d, which is not a member taking part", commitment.SignerID)
+		}
+		if commitment.SignerID == sub.ID {
+			idx = i
+		}
+	}
+	if idx == -1 {
+		return fmt.Errorf("our commitment is not in the sub-committee list")
+	}
+
+	parentIdx := slices.IndexFunc(commitments, func(c frost.Commitment) bool { return c.SignerID == sub.Parent.ID })
+	if parentIdx == -1 {
+		return fmt.Errorf("our sub-committee is not in the list")
+	}
+	if frost.AggregateSubCommitments(sub.Parent.ID, memberCommitments).Hex() != commitments[parentIdx].Hex() {
+		return fmt.Errorf("sub-committee commitments don't add up to its commitment in the session")
+	}
+
+	signer, err := cfg.SubSigner(sub, &committee, lambdaRegistry)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// our commitments are never sent ahead of time, so they are always fresh
+	pn := getNonces(account, 1)
+	if err := signer.UsePreprocessed(pn.fresh, memberCommitments[idx]); err != nil {
+		return err
+	}
+	if err := nonces.claim(evt.ID, evt.CreatedAt); err != nil {
+		return err
+	}
+
+	evtToSign, err := checkEventToBeSigned(relay, cfg, evt.Content)
+	if err != nil {
+		return err
+	}
+	log = log.With().Str("id", evtToSign.ID.Hex()).Logger()
+	msg := evtToSign.ID[:]
+
+	groupCommitment, _, _ := cfg.ComputeGroupCommitment(commitments, msg)
+	partialSig, err := signer.Sign(msg, groupCommitment)
+	if err != nil {
+		return err
+	}
+	if err := sessionPublisher(ctx, relay, evt.ID)(&nostr.Event{
+		Kind:    common.KindPartialSignature,
+		Content: partialSig.Hex(),
+		Tags:    nostr.Tags{{"p", sub.GroupPublicKey.X.String()}},
+	}); err != nil {
+		log.Warn().Err(err).Msg("failed to send partial signature to coordinator")
+		return nil
+	}
+
+	log.Info().Msgf("[signer] signed %x for %x as a member of a sub-committee", msg, account)
+
+	if eTag := evt.Tags.Find("e"); eTag != nil {
+		if roastId, err := nostr.IDFromHex(eTag[1]); err == nil {
+			if err := sendMemberCommitment(ctx, relay, sub, roastId); err != nil {
+				log.Warn().Err(err).Msg("failed to send commitment")
+			}
+		}
+	}
+
+	return nil
+}
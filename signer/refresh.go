@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/mailru/easyjson"
+)
+
+func startRefreshSession(ctx context.Context, relay *nostr.Relay, ch chan nostr.Event) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Minute*3, fmt.Errorf("share refresh took too long"))
+	defer cancel()
+
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+
+	// step-1 (receive): initialize ourselves
+	evt := <-ch
+	cfg := frost.Configuration{}
+	if err := cfg.DecodeHex(evt.Content); err != nil {
+		return fmt.Errorf("error decoding config: %w", err)
+	}
+	if cfg.Method != frost.MethodFROST {
+		return fmt.Errorf("can't refresh %s shards", cfg.Method)
+	}
+
+	sessionId := evt.ID
+
+	userPubKey := nostr.PubKey(*cfg.PublicKey.X.Bytes())
+	log := log.With().Str("user", userPubKey.Hex()).Str("coordinator", relay.URL).Logger()
+	log.Info().Msgf("[signer] refresh session started")
+
+	signers := make(map[int]nostr.PubKey, cfg.MaxSigners)
+	for tag := range evt.Tags.FindAll("p") {
+		if len(tag) < 3 {
+			return fmt.Errorf("refresh configuration has a signer without an id")
+		}
+		pk, err := nostr.PubKeyFromHex(tag[1])
+		if err != nil {
+			return fmt.Errorf("refresh configuration has an invalid signer %s", tag[1])
+		}
+		id, err := strconv.Atoi(tag[2])
+		if err != nil || id <= 0 || id > cfg.MaxSigners {
+			return fmt.Errorf("refresh configuration has an invalid id '%s'", tag[2])
+		}
+		signers[id] = pk
+	}
+	if len(signers) != cfg.MaxSigners {
+		return fmt.Errorf("refresh configuration has %d signers, expected %d", len(signers), cfg.MaxSigners)
+	}
+
+	sessions.Store(sessionId, session{ch: ch, peers: slices.Collect(maps.Values(signers))})
+	defer sessions.Delete(sessionId)
+
+	var shardEvt nostr.Event
+	var ok bool
+	for evt := range store.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{common.KindStoredShard},
+		Authors: []nostr.PubKey{userPubKey},
+	}, 1) {
+		shardEvt = evt
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("[signer] couldn't find a shard for %s", userPubKey)
+	}
+
+	shard := frost.KeyShard{}
+	if err := vault.open(shardEvt, &shard); err != nil {
+		return fmt.Errorf("failed to open our shard: %w", err)
+	}
+	defer shard.Zero()
+	if signers[shard.ID] != ourPubkey {
+		return fmt.Errorf("refresh configuration has someone else as %d", shard.ID)
+	}
+
+	sendToCoordinator := sessionPublisher(ctx, relay, sessionId)
+
+	// step-2 (send): commit to our zero polynomial
+	participant, ourCommitment, err := frost.NewRefreshParticipant(shard.ID, cfg.Threshold, cfg.MaxSigners)
+	if err != nil {
+		return err
+	}
+	defer participant.Zero()
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindRefreshCommit,
+		Content: ourCommitment.Hex(),
+	}); err != nil {
+		return err
+	}
+
+	receive := func() (nostr.Event, error) {
+		select {
+		case evt := <-ch:
+			return evt, nil
+		case <-ctx.Done():
+			return nostr.Event{}, context.Cause(ctx)
+		}
+	}
+	receiveShare := func(evt nostr.Event) (frost.DKGShare, error) {
+		for id, pk := range signers {
+			if pk == evt.PubKey {
+				return decryptDKGShare(ctx, id, evt)
+			}
+		}
+		return decryptDKGShare(ctx, 0, evt)
+	}
+
+	// step-3 (receive): get everybody's commitments
+	var commitments []frost.RefreshCommitment
+	shares := make([]frost.DKGShare, 0, cfg.MaxSigners-1)
+	for commitments == nil {
+		evt, err := receive()
+		if err != nil {
+			return err
+		}
+
+		switch evt.Kind {
+		case common.KindRefreshGroupCommit:
+			commitments, err = decodeRefreshCommitments(evt.Content)
+			if err != nil {
+				return fmt.Errorf("failed to decode commitments: %w", err)
+			}
+			idx := slices.IndexFunc(commitments, func(com frost.RefreshCommitment) bool { return com.SignerID == shard.ID })
+			if idx == -1 || commitments[idx].Hex() != ourCommitment.Hex() {
+				return fmt.Errorf("coordinator didn't include our commitment correctly")
+			}
+		case common.KindDKGShare:
+			// shares may arrive before the commitments if some other signer is faster than us
+			share, err := receiveShare(evt)
+			if err != nil {
+				return err
+			}
+			shares = append(shares, share)
+		}
+	}
+
+	// step-4 (send): give everybody else their shares
+	for id, signer := range signers {
+		if signer == ourPubkey {
+			continue
+		}
+
+		ciphertext, err := kr.Encrypt(ctx, participant.Share(id).Hex(), signer)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt share to %s: %w", signer, err)
+		}
+		if err := sendToCoordinator(&nostr.Event{
+			Kind:    common.KindDKGShare,
+			Content: ciphertext,
+			Tags:    nostr.Tags{{"p", signer.Hex()}},
+		}); err != nil {
+			return err
+		}
+	}
+
+	// step-5 (receive): get our shares from everybody else
+	for len(shares) < cfg.MaxSigners-1 {
+		evt, err := receive()
+		if err != nil {
+			return err
+		}
+
+		if evt.Kind == common.KindDKGShare {
+			share, err := receiveShare(evt)
+			if err != nil {
+				return err
+			}
+			if slices.ContainsFunc(shares, func(sh frost.DKGShare) bool { return sh.From == share.From }) {
+				return fmt.Errorf("got repeated share from %d", share.From)
+			}
+			shares = append(shares, share)
+		}
+	}
+
+	newShard, err := participant.Refresh(shard, commitments, shares)
+	if err != nil {
+		return err
+	}
+
+	// step-6 (send): tell the coordinator what we got and commit to the nonces for signing the updated registration
+	signer, err := cfg.Signer(newShard, lambdaRegistry)
+	if err != nil {
+		return err
+	}
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindRefreshResult,
+		Content: newShard.PublicKeyShard.Hex(),
+	}); err != nil {
+		return err
+	}
+	ourNonceCommitment := signer.Commit(sessionId.Hex())
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindCommit,
+		Content: ourNonceCommitment.Hex(),
+		Tags:    nostr.Tags{{"p", cfg.PublicKey.X.String()}},
+	}); err != nil {
+		return err
+	}
+
+	// step-7 (receive): the account registration event and the group commitment
+	var msg []byte
+	groupCommitment := frost.BinoncePublic{}
+	for len(msg) != 32 || groupCommitment[0] == nil {
+		evt, err := receive()
+		if err != nil {
+			return err
+		}
+
+		switch evt.Kind {
+		case common.KindEventToBeSigned:
+			var evtToSign nostr.Event
+			if err := easyjson.Unmarshal([]byte(evt.Content), &evtToSign); err != nil {
+				return fmt.Errorf("failed to decode event to be signed: %w", err)
+			}
+			if err := checkAccountRegistration(
+				evtToSign,
+				userPubKey,
+				cfg.Threshold,
+				cfg.Method,
+				signers,
+				[]frost.PublicKeyShard{newShard.PublicKeyShard},
+			); err != nil {
+				return err
+			}
+			msg = evtToSign.ID[:]
+		case common.KindGroupCommit:
+			if err := groupCommitment.DecodeHex(evt.Content); err != nil {
+				return fmt.Errorf("failed to decode received commitment: %w", err)
+			}
+		}
+	}
+
+	// step-8 (send): our partial signature
+	partialSig, err := signer.Sign(msg, groupCommitment)
+	if err != nil {
+		return err
+	}
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindPartialSignature,
+		Content: partialSig.Hex(),
+		Tags:    nostr.Tags{{"p", cfg.PublicKey.X.String()}},
+	}); err != nil {
+		return err
+	}
+
+	// step-9 (receive): once the coordinator has the updated registration we can forget the old shard
+	for {
+		evt, err := receive()
+		if err != nil {
+			return fmt.Errorf("failed to get ack from coordinator, keeping the old shard: %w", err)
+		}
+		if evt.Kind == common.KindShardACK {
+			break
+		}
+	}
+
+	storedShard := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindStoredShard,
+		PubKey:    userPubKey,
+		Tags:      shardEvt.Tags,
+	}
+	if err := vault.seal(&storedShard, newShard); err != nil {
+		return err
+	}
+	storedShard.ID = storedShard.GetID()
+	if err := store.ReplaceEvent(storedShard); err != nil {
+		return fmt.Errorf("failed to store refreshed shard: %w", err)
+	}
+
+	log.Info().Msgf("[signer] shard refreshed")
+	return nil
+}
+
+func decodeRefreshCommitments(content string) ([]frost.RefreshCommitment, error) {
+	b, err := hex.DecodeString(content)
+	if err != nil {
+		return nil, err
+	}
+
+	commitments := make([]frost.RefreshCommitment, 0, 5)
+	for len(b) > 0 {
+		com := frost.RefreshCommitment{}
+		n, err := com.Decode(b)
+		if err != nil {
+			return nil, err
+		}
+		commitments = append(commitments, com)
+		b = b[n:]
+	}
+
+	return commitments, nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip11"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/mailru/easyjson"
+	"github.com/urfave/cli/v3"
+)
+
+var repairCommand = &cli.Command{
+	Name:  "repair",
+	Usage: "recovers a shard we have lost with help from the other signers of the account",
+	Description: `the other signers never learn our shard and the coordinator doesn't learn anything. this must be run
+while the signer itself is stopped, as they both use the same shards db.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "coordinator",
+			Usage:    "the coordinator relay where the account is registered",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "account",
+			Usage:    "the pubkey of the account we've lost the shard for",
+			Required: true,
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		ctx, cancel := context.WithTimeoutCause(ctx, time.Minute*4, fmt.Errorf("share repair took too long"))
+		defer cancel()
+
+		account, err := nostr.PubKeyFromHex(c.String("account"))
+		if err != nil {
+			return fmt.Errorf("invalid account pubkey: %w", err)
+		}
+		coordinator := nostr.NormalizeURL(c.String("coordinator"))
+
+		for range store.QueryEvents(nostr.Filter{
+			Kinds:   []nostr.Kind{common.KindStoredShard},
+			Authors: []nostr.PubKey{account},
+		}, 1) {
+			return fmt.Errorf("we already have a shard for %s", account)
+		}
+
+		// TOFU the coordinator's pubkey
+		info, err := nip11.Fetch(ctx, coordinator)
+		if err != nil || info.PubKey == nil {
+			return fmt.Errorf("error on nip11 request to %s: %w", coordinator, err)
+		}
+		coordinatorPubKey := *info.PubKey
+
+		shard, peers, err := runRepair(ctx, coordinator, coordinatorPubKey, account)
+		if err != nil {
+			return err
+		}
+
+		// then we store this shard and will be able to sign with it again once the signer is started
+		storedShard := nostr.Event{
+			CreatedAt: nostr.Now(),
+			Kind:      common.KindStoredShard,
+			PubKey:    account,
+			Tags: nostr.Tags{
+				{"coordinator", coordinator, coordinatorPubKey.Hex()},
+				signersTag(peers),
+			},
+		}
+		if err := vault.seal(&storedShard, shard); err != nil {
+			return err
+		}
+		storedShard.ID = storedShard.GetID()
+		if err := store.ReplaceEvent(storedShard); err != nil {
+			return fmt.Errorf("failed to store repaired shard: %w", err)
+		}
+
+		log.Info().Str("user", account.Hex()).Int("id", shard.ID).Msg("[repair] shard registered")
+		return nil
+	},
+}
+
+// runRepair asks the coordinator to have the other signers recover our shard for us, also giving back everybody
+// holding a part of the account as registered by the user.
+func runRepair(
+	ctx context.Context,
+	coordinator string,
+	coordinatorPubKey nostr.PubKey,
+	account nostr.PubKey,
+) (frost.KeyShard, []nostr.PubKey, error) {
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+
+	relay, err := pool.EnsureRelay(coordinator)
+	if err != nil {
+		return frost.KeyShard{}, nil, fmt.Errorf("failed to connect to coordinator: %w", err)
+	}
+
+	requestEvt := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindRepairRequest,
+		Tags:      nostr.Tags{{"P", account.Hex()}},
+	}
+	if err := kr.SignEvent(ctx, &requestEvt); err != nil {
+		return frost.KeyShard{}, nil, fmt.Errorf("failed to sign repair request: %w", err)
+	}
+	sessionId := requestEvt.ID
+
+	// listen for everything related to this session, the coordinator will start talking as soon as it gets the request
+	eosed := make(chan struct{})
+	events := pool.SubscribeManyNotifyEOSE(ctx, []string{coordinator}, nostr.Filter{
+		Kinds: []nostr.Kind{
+			common.KindRepairConfiguration,
+			common.KindDKGShare,
+			common.KindShardACK,
+		},
+		Tags: nostr.TagMap{
+			"p": []string{ourPubkey.Hex()},
+		},
+	}, eosed, nostr.SubscriptionOptions{
+		Label: "prom-repair",
+	})
+	select {
+	case <-eosed:
+	case <-ctx.Done():
+		return frost.KeyShard{}, nil, fmt.Errorf("failed to subscribe to coordinator: %w", context.Cause(ctx))
+	}
+
+	// step-1 (send): ask for the repair
+	log.Info().Str("user", account.Hex()).Str("coordinator", coordinator).Msg("[repair] requesting")
+	if err := relay.Publish(ctx, requestEvt); err != nil {
+		return frost.KeyShard{}, nil, fmt.Errorf("failed to publish repair request: %w", err)
+	}
+
+	// step-2 (receive): who is helping us and one repair share from each of them, which may arrive in any order
+	var ar common.AccountRegistration
+	var helpers map[int]nostr.PubKey
+	var ourId int
+	shareEvts := make(map[nostr.PubKey]nostr.Event)
+	gotAllShares := func() bool {
+		for _, pk := range helpers {
+			if _, ok := shareEvts[pk]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	for helpers == nil || !gotAllShares() {
+		ie, ok := <-events
+		if !ok {
+			return frost.KeyShard{}, nil, fmt.Errorf("subscription closed: %w", context.Cause(ctx))
+		}
+		evt := ie.Event
+		if !isForSession(evt, sessionId) {
+			continue
+		}
+
+		switch {
+		case evt.Kind == common.KindRepairConfiguration && evt.PubKey == coordinatorPubKey:
+			var lost nostr.PubKey
+			ar, helpers, lost, ourId, err = decodeRepairConfiguration(evt, account)
+			if err != nil {
+				return frost.KeyShard{}, nil, err
+			}
+			if lost != ourPubkey {
+				return frost.KeyShard{}, nil, fmt.Errorf("coordinator is repairing someone else")
+			}
+		case evt.Kind == common.KindDKGShare:
+			// we can only tell who these are from after we get the configuration
+			shareEvts[evt.PubKey] = evt
+		}
+	}
+
+	sigmas := make([]frost.DKGShare, 0, len(helpers))
+	for id, pk := range helpers {
+		sigma, err := decryptDKGShare(ctx, id, shareEvts[pk])
+		if err != nil {
+			return frost.KeyShard{}, nil, err
+		}
+		sigmas = append(sigmas, sigma)
+	}
+
+	ipk := make([]byte, 33)
+	ipk[0] = 2
+	copy(ipk[1:], account[:])
+	pubkey, err := btcec.ParseJacobian(ipk)
+	if err != nil {
+		return frost.KeyShard{}, nil, fmt.Errorf("invalid account pubkey: %w", err)
+	}
+
+	idx := slices.IndexFunc(ar.Signers, func(signer common.Signer) bool { return signer.Shard.ID == ourId })
+	shard, err := frost.RepairShareStep3(ar.Signers[idx].Shard, &pubkey, slices.Sorted(maps.Keys(helpers)), sigmas)
+	if err != nil {
+		return frost.KeyShard{}, nil, err
+	}
+
+	// step-3 (send): tell the coordinator we're good
+	if err := sessionPublisher(ctx, relay, sessionId)(&nostr.Event{
+		Kind:    common.KindRepairResult,
+		Content: shard.PublicKeyShard.Hex(),
+	}); err != nil {
+		return frost.KeyShard{}, nil, err
+	}
+
+	// step-4 (receive): the coordinator acks it
+	for {
+		ie, ok := <-events
+		if !ok {
+			return frost.KeyShard{}, nil, fmt.Errorf("failed to get ack from coordinator: %w", context.Cause(ctx))
+		}
+		evt := ie.Event
+		if evt.Kind == common.KindShardACK && evt.PubKey == coordinatorPubKey && isForSession(evt, sessionId) {
+			return shard, ar.Peers(), nil
+		}
+	}
+}
+
+// startRepairSession is called when we are one of the signers the coordinator has picked to help repairing the
+// lost shard of another signer of the same account.
+func startRepairSession(ctx context.Context, relay *nostr.Relay, ch chan nostr.Event) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Minute*3, fmt.Errorf("share repair took too long"))
+	defer cancel()
+
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+
+	// step-1 (receive): initialize ourselves
+	evt := <-ch
+	eTag := evt.Tags.Find("e")
+	if eTag == nil {
+		return fmt.Errorf("repair configuration has no session")
+	}
+	sessionId, err := nostr.IDFromHex(eTag[1])
+	if err != nil {
+		return fmt.Errorf("repair configuration has an invalid session: %w", err)
+	}
+	pTag := evt.Tags.Find("P")
+	if pTag == nil {
+		return fmt.Errorf("repair configuration has no account")
+	}
+	userPubKey, err := nostr.PubKeyFromHex(pTag[1])
+	if err != nil {
+		return fmt.Errorf("repair configuration has an invalid account: %w", err)
+	}
+
+	log := log.With().Str("user", userPubKey.Hex()).Str("coordinator", relay.URL).Logger()
+	log.Info().Msgf("[signer] repair session started")
+
+	ar, helpers, lost, lostId, err := decodeRepairConfiguration(evt, userPubKey)
+	if err != nil {
+		return err
+	}
+
+	sessions.Store(sessionId, session{ch: ch, peers: slices.Collect(maps.Values(helpers))})
+	defer sessions.Delete(sessionId)
+
+	var shardEvt nostr.Event
+	var ok bool
+	for evt := range store.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{common.KindStoredShard},
+		Authors: []nostr.PubKey{userPubKey},
+	}, 1) {
+		shardEvt = evt
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("[signer] couldn't find a shard for %s", userPubKey)
+	}
+
+	shard := frost.KeyShard{}
+	if err := vault.open(shardEvt, &shard); err != nil {
+		return fmt.Errorf("failed to open our shard: %w", err)
+	}
+	defer shard.Zero()
+	if helpers[shard.ID] != ourPubkey {
+		return fmt.Errorf("repair configuration has someone else as %d", shard.ID)
+	}
+
+	// the registration must be the current one, otherwise we could be recovering a shard for a signer that
+	// was since removed
+	idx := slices.IndexFunc(ar.Signers, func(signer common.Signer) bool { return signer.Shard.ID == shard.ID })
+	if ar.Signers[idx].Shard.Hex() != shard.PublicKeyShard.Hex() {
+		return fmt.Errorf("repair configuration has an outdated account registration")
+	}
+
+	sendToCoordinator := sessionPublisher(ctx, relay, sessionId)
+
+	// step-2 (send): give each helper a random piece of what we know about the lost shard
+	participants := slices.Sorted(maps.Keys(helpers))
+	pieces, err := frost.RepairShareStep1(shard, participants, lostId)
+	if err != nil {
+		return err
+	}
+	deltas := make([]frost.DKGShare, 0, len(helpers))
+	for _, piece := range pieces {
+		if piece.To == shard.ID {
+			deltas = append(deltas, piece)
+			continue
+		}
+
+		ciphertext, err := kr.Encrypt(ctx, piece.Hex(), helpers[piece.To])
+		if err != nil {
+			return fmt.Errorf("failed to encrypt piece to %s: %w", helpers[piece.To], err)
+		}
+		if err := sendToCoordinator(&nostr.Event{
+			Kind:    common.KindDKGShare,
+			Content: ciphertext,
+			Tags:    nostr.Tags{{"p", helpers[piece.To].Hex()}},
+		}); err != nil {
+			return err
+		}
+	}
+
+	// step-3 (receive): get a piece from each of the other helpers
+	for len(deltas) < len(helpers) {
+		var evt nostr.Event
+		select {
+		case evt = <-ch:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+		if evt.Kind != common.KindDKGShare {
+			continue
+		}
+
+		senderId := 0
+		for id, pk := range helpers {
+			if pk == evt.PubKey {
+				senderId = id
+			}
+		}
+		delta, err := decryptDKGShare(ctx, senderId, evt)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(deltas, func(d frost.DKGShare) bool { return d.From == delta.From }) {
+			return fmt.Errorf("got repeated piece from %d", delta.From)
+		}
+		deltas = append(deltas, delta)
+	}
+
+	// step-4 (send): sum them all and give the result to the lost signer
+	sigma, err := frost.RepairShareStep2(shard.ID, participants, lostId, deltas)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := kr.Encrypt(ctx, sigma.Hex(), lost)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt repair share to %s: %w", lost, err)
+	}
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindDKGShare,
+		Content: ciphertext,
+		Tags:    nostr.Tags{{"p", lost.Hex()}},
+	}); err != nil {
+		return err
+	}
+
+	log.Info().Int("lost", lostId).Msgf("[signer] helped repairing shard")
+	return nil
+}
+
+// decodeRepairConfiguration reads the account registration the coordinator sent along with the repair configuration
+// and checks the helpers and the lost signer against it, so the coordinator can't make anyone be anyone else.
+func decodeRepairConfiguration(evt nostr.Event, account nostr.PubKey) (
+	ar common.AccountRegistration,
+	helpers map[int]nostr.PubKey,
+	lost nostr.PubKey,
+	lostId int,
+	err error,
+) {
+	var regEvt nostr.Event
+	if err := easyjson.Unmarshal([]byte(evt.Content), &regEvt); err != nil {
+		return ar, nil, lost, 0, fmt.Errorf("failed to decode account registration: %w", err)
+	}
+	if regEvt.PubKey != account || !regEvt.VerifySignature() {
+		return ar, nil, lost, 0, fmt.Errorf("account registration isn't signed by %s", account)
+	}
+	if err := ar.Decode(regEvt); err != nil {
+		return ar, nil, lost, 0, fmt.Errorf("invalid account registration: %w", err)
+	}
+
+	registered := func(pk nostr.PubKey, id int) bool {
+		return slices.ContainsFunc(ar.Signers, func(signer common.Signer) bool {
+			return signer.PeerPubKey == pk && signer.Shard.ID == id
+		})
+	}
+
+	lostTag := evt.Tags.Find("lost")
+	if lostTag == nil || len(lostTag) < 3 {
+		return ar, nil, lost, 0, fmt.Errorf("repair configuration has no lost signer")
+	}
+	lost, err = nostr.PubKeyFromHex(lostTag[1])
+	if err != nil {
+		return ar, nil, lost, 0, fmt.Errorf("repair configuration has an invalid lost signer %s", lostTag[1])
+	}
+	lostId, err = strconv.Atoi(lostTag[2])
+	if err != nil || !registered(lost, lostId) {
+		return ar, nil, lost, 0, fmt.Errorf("lost signer %s isn't registered as %s", lostTag[1], lostTag[2])
+	}
+
+	helpers = make(map[int]nostr.PubKey, ar.Threshold)
+	for tag := range evt.Tags.FindAll("p") {
+		if len(tag) < 3 {
+			// this is the lost signer
+			continue
+		}
+		pk, err := nostr.PubKeyFromHex(tag[1])
+		if err != nil {
+			return ar, nil, lost, 0, fmt.Errorf("repair configuration has an invalid helper %s", tag[1])
+		}
+		id, err := strconv.Atoi(tag[2])
+		if err != nil || id == lostId || !registered(pk, id) {
+			return ar, nil, lost, 0, fmt.Errorf("helper %s isn't registered as %s", tag[1], tag[2])
+		}
+		helpers[id] = pk
+	}
+	if len(helpers) != ar.Threshold {
+		return ar, nil, lost, 0, fmt.Errorf("repair configuration has %d helpers, expected %d", len(helpers), ar.Threshold)
+	}
+
+	return ar, helpers, lost, lostId, nil
+}
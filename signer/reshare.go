@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip11"
+	"fiatjaf.com/nostr/nip13"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/mailru/easyjson"
+)
+
+// handleReshareRequest is called when we are one of the new signers an account is moving to.
+func handleReshareRequest(ctx context.Context, requestEvt nostr.Event, pow uint64, restartSigner func()) {
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Minute*4, fmt.Errorf("resharing took too long"))
+	defer cancel()
+
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+	log := log.With().
+		Str("user", requestEvt.PubKey.Hex()).
+		Str("evt", requestEvt.ID.Hex()).
+		Logger()
+
+	log.Info().Msgf("[reshare] got request")
+
+	// check proof-of-work
+	if work := nip13.CommittedDifficulty(requestEvt); work < int(pow) {
+		log.Warn().Uint64("need", pow).Int("got", work).Msgf("[reshare] not enough work")
+		return
+	}
+
+	request := common.ReshareRequest{}
+	if err := request.Decode(requestEvt); err != nil {
+		log.Warn().Err(err).Msg("[reshare] got broken request")
+		return
+	}
+	ourId := request.ID(ourPubkey)
+	if ourId == 0 {
+		log.Warn().Msg("[reshare] we're not in the list of signers")
+		return
+	}
+	log = log.With().Str("coordinator", request.Coordinator).Int("id", ourId).Logger()
+
+	// TOFU the coordinator's pubkey
+	info, err := nip11.Fetch(ctx, request.Coordinator)
+	if err != nil || info.PubKey == nil {
+		log.Warn().Err(err).Msg("[reshare] error on nip11 request")
+		return
+	}
+	coordinatorPubKey := *info.PubKey
+
+	shard, err := runReshare(ctx, request, ourId, coordinatorPubKey)
+	if err != nil {
+		log.Warn().Err(err).Msg("[reshare] failed")
+		return
+	}
+
+	// then we store this shard and will start listening to sign requests from it
+	storedShard := nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      common.KindStoredShard,
+		PubKey:    requestEvt.PubKey,
+		Tags: nostr.Tags{
+			{"coordinator", request.Coordinator, coordinatorPubKey.Hex()},
+			{"reshare", requestEvt.ID.Hex()},
+			signersTag(request.Signers),
+		},
+	}
+	if request.Recovery != nostr.ZeroPK {
+		storedShard.Tags = append(storedShard.Tags, nostr.Tag{"recovery", request.Recovery.Hex()})
+	}
+	if err := vault.seal(&storedShard, shard); err != nil {
+		log.Warn().Err(err).Msg("[reshare] failed to encrypt shard")
+		return
+	}
+	storedShard.ID = storedShard.GetID()
+	if err := store.ReplaceEvent(storedShard); err != nil {
+		log.Warn().Err(err).Msg("[reshare] failed to store shard")
+		return
+	}
+
+	log.Info().Msgf("[reshare] shard registered")
+
+	// restart signer process
+	restartSigner()
+}
+
+func runReshare(
+	ctx context.Context,
+	request common.ReshareRequest,
+	ourId int,
+	coordinatorPubKey nostr.PubKey,
+) (frost.KeyShard, error) {
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+	sessionId := request.Event.ID
+
+	relay, err := pool.EnsureRelay(request.Coordinator)
+	if err != nil {
+		return frost.KeyShard{}, fmt.Errorf("failed to connect to coordinator: %w", err)
+	}
+	sendToCoordinator := sessionPublisher(ctx, relay, sessionId)
+
+	ipk := make([]byte, 33)
+	ipk[0] = 2
+	copy(ipk[1:], request.Event.PubKey[:])
+	pubkey, err := btcec.ParseJacobian(ipk)
+	if err != nil {
+		return frost.KeyShard{}, fmt.Errorf("invalid account pubkey: %w", err)
+	}
+
+	// listen for everything related to this session
+	events := pool.SubscribeMany(ctx, []string{request.Coordinator}, nostr.Filter{
+		Kinds: []nostr.Kind{
+			common.KindReshareGroupCommit,
+			common.KindDKGShare,
+			common.KindGroupCommit,
+			common.KindEventToBeSigned,
+			common.KindShardACK,
+		},
+		Tags: nostr.TagMap{
+			"p": []string{ourPubkey.Hex()},
+		},
+	}, nostr.SubscriptionOptions{
+		Label: "prom-reshare",
+	})
+
+	// step-1 (send): let the coordinator know we're listening
+	if err := sendToCoordinator(&nostr.Event{Kind: common.KindReshareReady}); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-2 (receive): the dealers' commitments and one share from each dealer, which may arrive in any order
+	var commitments []frost.ReshareCommitment
+	var dealers map[nostr.PubKey]int
+	shareEvts := make(map[nostr.PubKey]nostr.Event, request.Threshold)
+	gotAllShares := func() bool {
+		for pk := range dealers {
+			if _, ok := shareEvts[pk]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	for commitments == nil || !gotAllShares() {
+		ie, ok := <-events
+		if !ok {
+			return frost.KeyShard{}, fmt.Errorf("subscription closed: %w", context.Cause(ctx))
+		}
+		evt := ie.Event
+		if !isForSession(evt, sessionId) {
+			continue
+		}
+
+		switch {
+		case evt.Kind == common.KindReshareGroupCommit && evt.PubKey == coordinatorPubKey:
+			commitments, err = decodeReshareCommitments(evt.Content)
+			if err != nil {
+				return frost.KeyShard{}, fmt.Errorf("failed to decode commitments: %w", err)
+			}
+			dealers = make(map[nostr.PubKey]int, len(commitments))
+			for tag := range evt.Tags.FindAll("dealer") {
+				if len(tag) != 3 {
+					return frost.KeyShard{}, fmt.Errorf("invalid dealer tag %v", tag)
+				}
+				pk, err := nostr.PubKeyFromHex(tag[1])
+				if err != nil {
+					return frost.KeyShard{}, fmt.Errorf("invalid dealer tag %v", tag)
+				}
+				id, err := strconv.Atoi(tag[2])
+				if err != nil || !slices.ContainsFunc(commitments, func(com frost.ReshareCommitment) bool {
+					return com.SignerID == id
+				}) {
+					return frost.KeyShard{}, fmt.Errorf("dealer tag %v doesn't match any commitment", tag)
+				}
+				dealers[pk] = id
+			}
+			if len(dealers) != len(commitments) {
+				return frost.KeyShard{}, fmt.Errorf("got %d dealers for %d commitments", len(dealers), len(commitments))
+			}
+		case evt.Kind == common.KindDKGShare:
+			// we can only tell who these are from after we get the commitments
+			shareEvts[evt.PubKey] = evt
+		}
+	}
+
+	shares := make([]frost.DKGShare, 0, len(dealers))
+	for pk, id := range dealers {
+		share, err := decryptDKGShare(ctx, id, shareEvts[pk])
+		if err != nil {
+			return frost.KeyShard{}, err
+		}
+		shares = append(shares, share)
+	}
+
+	shard, err := frost.FinalizeReshare(ourId, &pubkey, commitments, shares)
+	if err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-3 (send): tell the coordinator what we got
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindReshareResult,
+		Content: shard.PublicKeyShard.Hex(),
+	}); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	// step-4: the new group signs its registration, which will replace the old one
+	vss := frost.AggregateReshareCommitments(commitments)
+	signers := make(map[int]nostr.PubKey, len(request.Signers))
+	expected := make([]frost.PublicKeyShard, len(request.Signers))
+	for i, signer := range request.Signers {
+		signers[i+1] = signer
+		expected[i] = vss.PublicKeyShard(i + 1)
+	}
+	if err := signOwnRegistration(ctx, events, coordinatorPubKey, sessionId, sendToCoordinator,
+		request.Threshold, frost.MethodFROST, signers, expected, shard,
+	); err != nil {
+		return frost.KeyShard{}, err
+	}
+
+	return shard, nil
+}
+
+// startReshareSession is called when we are one of the current signers the coordinator has picked to deal our shard
+// to the new signers.
+func startReshareSession(ctx context.Context, relay *nostr.Relay, ch chan nostr.Event) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Minute*3, fmt.Errorf("resharing took too long"))
+	defer cancel()
+
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+
+	// step-1 (receive): the request as signed by the user and who the other dealers are
+	evt := <-ch
+	var requestEvt nostr.Event
+	if err := easyjson.Unmarshal([]byte(evt.Content), &requestEvt); err != nil {
+		return fmt.Errorf("failed to decode reshare request: %w", err)
+	}
+	if !requestEvt.CheckID() || !requestEvt.VerifySignature() {
+		return fmt.Errorf("reshare request has a bad signature")
+	}
+	request := common.ReshareRequest{}
+	if err := request.Decode(requestEvt); err != nil {
+		return fmt.Errorf("invalid reshare request: %w", err)
+	}
+	if request.Coordinator != relay.URL {
+		return fmt.Errorf("reshare request is for coordinator %s, not %s", request.Coordinator, relay.URL)
+	}
+	if !isForSession(evt, requestEvt.ID) {
+		return fmt.Errorf("reshare configuration doesn't match the request")
+	}
+
+	sessionId := requestEvt.ID
+	// as a dealer we only send shares, so only the coordinator's ack comes here
+	sessions.Store(sessionId, session{ch: ch})
+	defer sessions.Delete(sessionId)
+
+	log := log.With().Str("user", requestEvt.PubKey.Hex()).Str("coordinator", relay.URL).Logger()
+	log.Info().Msgf("[signer] reshare session started")
+
+	participants := make([]int, 0, 5)
+	var ourId int
+	for tag := range evt.Tags.FindAll("p") {
+		if len(tag) < 3 {
+			return fmt.Errorf("reshare configuration has a dealer without an id")
+		}
+		id, err := strconv.Atoi(tag[2])
+		if err != nil || id <= 0 || slices.Contains(participants, id) {
+			return fmt.Errorf("reshare configuration has an invalid id '%s'", tag[2])
+		}
+		participants = append(participants, id)
+		if tag[1] == ourPubkey.Hex() {
+			ourId = id
+		}
+	}
+
+	var shardEvt nostr.Event
+	var ok bool
+	for evt := range store.QueryEvents(nostr.Filter{
+		Kinds:   []nostr.Kind{common.KindStoredShard},
+		Authors: []nostr.PubKey{requestEvt.PubKey},
+	}, 1) {
+		shardEvt = evt
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("[signer] couldn't find a shard for %s", requestEvt.PubKey)
+	}
+
+	shard := frost.KeyShard{}
+	if err := vault.open(shardEvt, &shard); err != nil {
+		return fmt.Errorf("failed to open our shard: %w", err)
+	}
+	defer shard.Zero()
+	if shard.ID != ourId {
+		return fmt.Errorf("reshare configuration has us as %d, but we are %d", ourId, shard.ID)
+	}
+
+	sendToCoordinator := sessionPublisher(ctx, relay, sessionId)
+
+	// step-2 (send): commit to our polynomial
+	dealer, ourCommitment, err := frost.NewReshareDealer(shard, participants, request.Threshold)
+	if err != nil {
+		return err
+	}
+	defer dealer.Zero()
+	if err := sendToCoordinator(&nostr.Event{
+		Kind:    common.KindReshareCommit,
+		Content: ourCommitment.Hex(),
+	}); err != nil {
+		return err
+	}
+
+	// step-3 (send): give each new signer its share
+	for i, signer := range request.Signers {
+		ciphertext, err := kr.Encrypt(ctx, dealer.Share(i+1).Hex(), signer)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt share to %s: %w", signer, err)
+		}
+		if err := sendToCoordinator(&nostr.Event{
+			Kind:    common.KindDKGShare,
+			Content: ciphertext,
+			Tags:    nostr.Tags{{"p", signer.Hex()}},
+		}); err != nil {
+			return err
+		}
+	}
+
+	// step-4 (receive): once the new signers are registered our old shard is useless
+	for acked := false; !acked; {
+		select {
+		case evt := <-ch:
+			acked = evt.Kind == common.KindShardACK
+		case <-ctx.Done():
+			return fmt.Errorf("failed to get ack from coordinator, keeping the old shard: %w", context.Cause(ctx))
+		}
+	}
+
+	if request.ID(ourPubkey) == 0 {
+		if err := store.DeleteEvent(shardEvt.ID); err != nil {
+			return fmt.Errorf("failed to delete old shard: %w", err)
+		}
+		log.Info().Msgf("[signer] old shard deleted after resharing")
+	} else {
+		log.Info().Msgf("[signer] resharing done, we're also in the new group")
+	}
+
+	return nil
+}
+
+func decodeReshareCommitments(content string) ([]frost.ReshareCommitment, error) {
+	b, err := hex.DecodeString(content)
+	if err != nil {
+		return nil, err
+	}
+
+	commitments := make([]frost.ReshareCommitment, 0, 5)
+	for len(b) > 0 {
+		com := frost.ReshareCommitment{}
+		n, err := com.Decode(b)
+		if err != nil {
+			return nil, err
+		}
+		commitments = append(commitments, com)
+		b = b[n:]
+	}
+
+	return commitments, nil
+}
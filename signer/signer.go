@@ -15,9 +15,19 @@ import (
 )
 
 // signing sessions are indexed by the id of the first event that triggered them
-var sessions = xsync.NewMapOf[nostr.ID, chan nostr.Event]()
+var sessions = xsync.NewMapOf[nostr.ID, session]()
 
-var lambdaRegistry = make(frost.LambdaRegistry)
+// session is where the events of an ongoing session go. besides the coordinator, only the other signers taking part
+// in it (as told by its configuration) can send it anything.
+type session struct {
+	ch    chan nostr.Event
+	peers []nostr.PubKey
+}
+
+// events are dropped when a session is this far behind, so a stuck one can never hold up all the others
+const sessionBacklog = 32
+
+var lambdaRegistry = frost.NewLambdaRegistry(frost.DefaultLambdaRegistrySize)
 
 var signerEndedEarly = fmt.Errorf("signer ended early")
 
@@ -25,13 +35,33 @@ func runSigner(ctx context.Context) error {
 	ourPubkey, _ := kr.GetPublicKey(ctx)
 
 	filter := nostr.Filter{
-		Kinds: []nostr.Kind{common.KindConfiguration, common.KindGroupCommit, common.KindEventToBeSigned},
+		Kinds: []nostr.Kind{
+			common.KindConfiguration,
+			common.KindGroupCommit,
+			common.KindEventToBeSigned,
+			common.KindRefreshConfiguration,
+			common.KindRefreshGroupCommit,
+			common.KindReshareConfiguration,
+			common.KindRepairConfiguration,
+			common.KindPreprocessedConfiguration,
+			common.KindRoastConfiguration,
+			common.KindECDHConfiguration,
+			common.KindShardACK,
+		},
+		Tags: nostr.TagMap{
+			"p": []string{ourPubkey.Hex()},
+		},
+	}
+
+	// shares for a refresh or a repair come straight from the other signers
+	sharesFilter := nostr.Filter{
+		Kinds: []nostr.Kind{common.KindDKGShare},
 		Tags: nostr.TagMap{
 			"p": []string{ourPubkey.Hex()},
 		},
 	}
 
-	dfs := make([]nostr.DirectedFilter, 0, 2)
+	dfs := make([]nostr.DirectedFilter, 0, 4)
 
 	ngroups := 0
 	for shardEvt := range store.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{common.KindStoredShard}}, 500) {
@@ -42,7 +72,8 @@ func runSigner(ctx context.Context) error {
 		}
 
 		idx := slices.IndexFunc(dfs, func(df nostr.DirectedFilter) bool {
-			return df.Relay == nostr.NormalizeURL(coordinator[1]) && df.Filter.Authors[0] == coordinatorPubKey
+			return df.Relay == nostr.NormalizeURL(coordinator[1]) &&
+				len(df.Filter.Authors) == 1 && df.Filter.Authors[0] == coordinatorPubKey
 		})
 		if idx == -1 {
 			// use the pubkey the coordinator had at the time of shard creation
@@ -52,6 +83,15 @@ func runSigner(ctx context.Context) error {
 				Relay:  nostr.NormalizeURL(coordinator[1]),
 				Filter: filter,
 			})
+
+			if !slices.ContainsFunc(dfs, func(df nostr.DirectedFilter) bool {
+				return df.Relay == nostr.NormalizeURL(coordinator[1]) && len(df.Filter.Authors) == 0
+			}) {
+				dfs = append(dfs, nostr.DirectedFilter{
+					Relay:  nostr.NormalizeURL(coordinator[1]),
+					Filter: sharesFilter,
+				})
+			}
 		}
 
 		ngroups++
@@ -67,7 +107,7 @@ func runSigner(ctx context.Context) error {
 
 		switch evt.Kind {
 		case common.KindConfiguration:
-			ch := make(chan nostr.Event)
+			ch := make(chan nostr.Event, sessionBacklog)
 
 			go func() {
 				err := startSession(ctx, ie.Relay, ch)
@@ -77,7 +117,84 @@ func runSigner(ctx context.Context) error {
 			}()
 
 			ch <- evt
-		case common.KindGroupCommit, common.KindEventToBeSigned:
+		case common.KindRefreshConfiguration:
+			ch := make(chan nostr.Event, sessionBacklog)
+
+			go func() {
+				err := startRefreshSession(ctx, ie.Relay, ch)
+				if err != nil {
+					log.Warn().Err(err).Msg("[signer] refresh session failed")
+				}
+			}()
+
+			ch <- evt
+		case common.KindReshareConfiguration:
+			ch := make(chan nostr.Event, sessionBacklog)
+
+			go func() {
+				err := startReshareSession(ctx, ie.Relay, ch)
+				if err != nil {
+					log.Warn().Err(err).Msg("[signer] reshare session failed")
+				}
+			}()
+
+			ch <- evt
+		case common.KindRepairConfiguration:
+			ch := make(chan nostr.Event, sessionBacklog)
+
+			go func() {
+				err := startRepairSession(ctx, ie.Relay, ch)
+				if err != nil {
+					log.Warn().Err(err).Msg("[signer] repair session failed")
+				}
+			}()
+
+			ch <- evt
+		case common.KindPreprocessedConfiguration:
+			go func() {
+				err := startPreprocessedSession(ctx, ie.Relay, evt)
+				if err != nil {
+					log.Warn().Err(err).Msg("[signer] one-round signing session failed")
+				}
+			}()
+		case common.KindRoastConfiguration:
+			go func() {
+				err := handleRoastConfiguration(ctx, ie.Relay, evt)
+				if err != nil {
+					log.Warn().Err(err).Msg("[signer] failed to join signing")
+				}
+			}()
+		case common.KindECDHConfiguration:
+			go func() {
+				err := handleECDHConfiguration(ctx, ie.Relay, evt)
+				if err != nil {
+					log.Warn().Err(err).Msg("[signer] ecdh failed")
+				}
+			}()
+		case common.KindDKGShare, common.KindShardACK:
+			// these may come from anyone or not be related to any session at all, and shares are only taken from
+			// the signers the session is expecting them from
+			eTag := evt.Tags.Find("e")
+			if eTag == nil {
+				continue
+			}
+
+			id, err := nostr.IDFromHex(eTag[1])
+			if err != nil {
+				continue
+			}
+
+			if session, ok := sessions.Load(id); ok {
+				if evt.Kind == common.KindDKGShare && !slices.Contains(session.peers, evt.PubKey) {
+					continue
+				}
+				select {
+				case session.ch <- evt:
+				case <-ctx.Done():
+				default:
+				}
+			}
+		case common.KindGroupCommit, common.KindEventToBeSigned, common.KindRefreshGroupCommit:
 			eTag := evt.Tags.Find("e")
 			if eTag == nil {
 				return fmt.Errorf("coordinator sent a buggy event without \"e\": %s", evt)
@@ -88,8 +205,12 @@ func runSigner(ctx context.Context) error {
 				return fmt.Errorf("coordinator sent an event with an invalid \"e\": %s", evt)
 			}
 
-			if ch, ok := sessions.Load(id); ok {
-				ch <- evt
+			if session, ok := sessions.Load(id); ok {
+				select {
+				case session.ch <- evt:
+				case <-ctx.Done():
+				default:
+				}
 			}
 		}
 	}
@@ -137,20 +258,27 @@ func startSession(ctx context.Context, relay *nostr.Relay, ch chan nostr.Event)
 	}
 
 	sessionId := evt.ID
-	sessions.Store(sessionId, ch)
+	sessions.Store(sessionId, session{ch: ch})
+	defer sessions.Delete(sessionId)
 
 	shard := frost.KeyShard{}
-	if err := shard.DecodeHex(res.Content); err != nil {
-		return fmt.Errorf("failed to decode our shard: %w", err)
+	if err := vault.open(res, &shard); err != nil {
+		return fmt.Errorf("failed to open our shard: %w", err)
 	}
+	defer shard.Zero()
 
 	signer, err := cfg.Signer(shard, lambdaRegistry)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("invalid config: %w", err)
 	}
 
 	// step-2 (send): send our pre-commit to coordinator
 	ourCommitment := signer.Commit(sessionId.Hex() /* use the event id as the session id */)
+
+	// the session must be marked on disk before the commitment goes out, and we never commit twice for the same one
+	if err := nonces.save(sessionId, evt.CreatedAt, signer.SecretNonces); err != nil {
+		return err
+	}
 	commitments := make([]frost.Commitment, 0, cfg.Threshold)
 	commitments = append(commitments, ourCommitment)
 	if err := sendToCoordinator(&nostr.Event{
@@ -170,37 +298,12 @@ func startSession(ctx context.Context, relay *nostr.Relay, ch chan nostr.Event)
 		evt := <-ch
 		switch evt.Kind {
 		case common.KindEventToBeSigned:
-			var evtToSign nostr.Event
-			if err := easyjson.Unmarshal([]byte(evt.Content), &evtToSign); err != nil {
-				return fmt.Errorf("failed to decode event to be signed: %w", err)
-			}
-			if !evtToSign.CheckID() {
-				return fmt.Errorf("event to be signed has a broken id")
+			evtToSign, err := checkEventToBeSigned(relay, &cfg, evt.Content)
+			if err != nil {
+				return err
 			}
 			log = log.With().Str("id", evtToSign.ID.Hex()).Logger()
 
-			// prevent someone with the bunker url from breaking everything
-			if slices.Contains(common.ForbiddenKinds, evtToSign.Kind) {
-				return fmt.Errorf("event has a forbidden kind")
-			}
-			if evtToSign.Kind == nostr.KindClientAuthentication {
-				if tag := evtToSign.Tags.Find("challenge"); tag != nil && strings.HasPrefix(tag[1], "frostbunker:") {
-					return fmt.Errorf("can't sign a frost bunker coordinator AUTH")
-				}
-				if tag := evtToSign.Tags.Find("relay"); tag != nil && nostr.NormalizeURL(tag[1]) == relay.URL {
-					return fmt.Errorf("can't sign an AUTH for this same coordinator")
-				}
-			}
-
-			// disallow events signed for the future and the past
-			now := nostr.Now()
-			if evtToSign.CreatedAt < now-80 {
-				return fmt.Errorf("can't sign event in the past")
-			}
-			if evtToSign.CreatedAt > now+80 {
-				return fmt.Errorf("can't sign event in the future")
-			}
-
 			msg = evtToSign.ID[:]
 		case common.KindGroupCommit:
 			if err := groupCommitment.DecodeHex(evt.Content); err != nil {
@@ -213,10 +316,15 @@ func startSession(ctx context.Context, relay *nostr.Relay, ch chan nostr.Event)
 		}
 	}
 
-	// step-4 (send): sign and shard our partial signature
+	// step-4 (send): sign and shard our partial signature, but only once the session is marked as consumed on disk,
+	// so even if the coordinator replays these events or we crash and restart the nonces can't be used again
+	signer.SecretNonces, err = nonces.consume(sessionId)
+	if err != nil {
+		return err
+	}
 	partialSig, err := signer.Sign(msg, groupCommitment)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	if err := sendToCoordinator(&nostr.Event{
@@ -230,5 +338,52 @@ func startSession(ctx context.Context, relay *nostr.Relay, ch chan nostr.Event)
 	}
 
 	log.Info().Msgf("[signer] signed %x for %x", msg[:], *cfg.PublicKey.X.Bytes())
+
+	// the coordinator had to ask for our commitment, so it may be missing our preprocessed ones
+	if err := sendNonceBatch(ctx, relay, []frost.KeyShard{shard}, nil); err != nil {
+		log.Warn().Err(err).Msg("failed to send nonce batch")
+	}
+
 	return nil
 }
+
+// checkEventToBeSigned decodes the event the coordinator wants us to sign and refuses it if it's something we
+// shouldn't be signing.
+func checkEventToBeSigned(relay *nostr.Relay, cfg *frost.Configuration, content string) (nostr.Event, error) {
+	var evtToSign nostr.Event
+	if err := easyjson.Unmarshal([]byte(content), &evtToSign); err != nil {
+		return evtToSign, fmt.Errorf("failed to decode event to be signed: %w", err)
+	}
+	if !evtToSign.CheckID() {
+		return evtToSign, fmt.Errorf("event to be signed has a broken id")
+	}
+
+	// the event must be for the key we are signing for, i.e. the group key or one derived from it
+	if pubkey, _ := cfg.TweakedPublicKey(); evtToSign.PubKey != nostr.PubKey(*pubkey.X.Bytes()) {
+		return evtToSign, fmt.Errorf("event to be signed is for %s, not for the key in the config", evtToSign.PubKey)
+	}
+
+	// prevent someone with the bunker url from breaking everything
+	if slices.Contains(common.ForbiddenKinds, evtToSign.Kind) {
+		return evtToSign, fmt.Errorf("event has a forbidden kind")
+	}
+	if evtToSign.Kind == nostr.KindClientAuthentication {
+		if tag := evtToSign.Tags.Find("challenge"); tag != nil && strings.HasPrefix(tag[1], "frostbunker:") {
+			return evtToSign, fmt.Errorf("can't sign a frost bunker coordinator AUTH")
+		}
+		if tag := evtToSign.Tags.Find("relay"); tag != nil && nostr.NormalizeURL(tag[1]) == relay.URL {
+			return evtToSign, fmt.Errorf("can't sign an AUTH for this same coordinator")
+		}
+	}
+
+	// disallow events signed for the future and the past
+	now := nostr.Now()
+	if evtToSign.CreatedAt < now-80 {
+		return evtToSign, fmt.Errorf("can't sign event in the past")
+	}
+	if evtToSign.CreatedAt > now+80 {
+		return evtToSign, fmt.Errorf("can't sign event in the future")
+	}
+
+	return evtToSign, nil
+}
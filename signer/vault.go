@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"fiatjaf.com/nostr"
+	"fiatjaf.com/nostr/nip44"
+	"fiatjaf.com/nostr/nip49"
+	"fiatjaf.com/promenade/common"
+	"fiatjaf.com/promenade/frost"
+	"github.com/urfave/cli/v3"
+	"go.etcd.io/bbolt"
+)
+
+// shardVault encrypts the shards we store, so whoever gets a copy of the database doesn't get them too.
+//
+// shards are encrypted with nip44 using a random key of their own, the store key, which is kept in the database
+// encrypted either with a passphrase (as an ncryptsec, see nip49) or with our own key (nip44 to ourselves). each
+// stored shard says which store key it was encrypted with in an ["encrypted", "<key-id>"] tag, and shards without
+// that tag are from before we encrypted anything. when we are a weighted signer of an account all our shards for it
+// are stored together, as in common.EncodeShards, and when we are a member of a sub-committee what we store is our
+// frost.SubShard instead.
+//
+// only the shards are encrypted: their tags (the account, the coordinator, the other signers, the recovery key) and
+// the nonce bucket are not, which is why the nonce store never puts a secret there (see nonceStore).
+type shardVault struct {
+	db *bbolt.DB
+
+	// the current store key and, while re-keying, the previous one, by id
+	keys    map[string][32]byte
+	current string
+}
+
+var vaultBucket = []byte("promenade-vault")
+
+var (
+	vaultCurrentKey  = []byte("current")
+	vaultPreviousKey = []byte("previous")
+)
+
+const (
+	// an ncryptsec follows
+	vaultWithPassphrase byte = 1
+
+	// a nip44 payload encrypted by our keyer to ourselves follows
+	vaultWithKeyer byte = 2
+)
+
+var vault *shardVault
+
+// unlockShardVault gets the store key with passphrase, or with our keyer if passphrase is empty, creating a new one
+// if there is none yet, then encrypts every shard that isn't encrypted with it yet: the ones from before there was
+// encryption and the ones left behind by a re-keying that was interrupted.
+func unlockShardVault(ctx context.Context, db *bbolt.DB, passphrase string) (*shardVault, error) {
+	var current, previous []byte
+	if err := db.Update(func(txn *bbolt.Tx) error {
+		bucket, err := txn.CreateBucketIfNotExists(vaultBucket)
+		if err != nil {
+			return err
+		}
+		current = slices.Clone(bucket.Get(vaultCurrentKey))
+		previous = slices.Clone(bucket.Get(vaultPreviousKey))
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to open vault: %w", err)
+	}
+
+	v := &shardVault{db: db, keys: make(map[string][32]byte, 2)}
+	if current == nil {
+		if err := v.setKeys(ctx, passphrase, nil); err != nil {
+			return nil, err
+		}
+		log.Info().Msg("[vault] created a new store key")
+	} else {
+		key, err := unwrapStoreKey(ctx, current, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		v.current = storeKeyID(key)
+		v.keys[v.current] = key
+
+		if previous != nil {
+			key, err := unwrapStoreKey(ctx, previous, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get previous store key: %w", err)
+			}
+			v.keys[storeKeyID(key)] = key
+		}
+	}
+
+	if err := v.reencrypt(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// rekey replaces the store key with a new one, encrypted with newPassphrase or with our keyer if it's empty, then
+// encrypts all the shards again with it.
+func (v *shardVault) rekey(ctx context.Context, newPassphrase string) error {
+	previous := v.keys[v.current]
+	if err := v.setKeys(ctx, newPassphrase, &previous); err != nil {
+		return err
+	}
+	return v.reencrypt()
+}
+
+// setKeys makes a new store key the current one, keeping previous around (if given) until all the shards are
+// encrypted with the new one.
+func (v *shardVault) setKeys(ctx context.Context, passphrase string, previous *[32]byte) error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+
+	wrappedCurrent, err := wrapStoreKey(ctx, key, passphrase)
+	if err != nil {
+		return err
+	}
+	var wrappedPrevious []byte
+	if previous != nil {
+		// the previous key is encrypted just like the new one, so we can still get it if we stop before the end
+		if wrappedPrevious, err = wrapStoreKey(ctx, *previous, passphrase); err != nil {
+			return err
+		}
+	}
+
+	if err := v.db.Update(func(txn *bbolt.Tx) error {
+		bucket := txn.Bucket(vaultBucket)
+		if wrappedPrevious != nil {
+			if err := bucket.Put(vaultPreviousKey, wrappedPrevious); err != nil {
+				return err
+			}
+		}
+		return bucket.Put(vaultCurrentKey, wrappedCurrent)
+	}); err != nil {
+		return fmt.Errorf("failed to save store key: %w", err)
+	}
+
+	v.current = storeKeyID(key)
+	v.keys[v.current] = key
+	return nil
+}
+
+// reencrypt encrypts every shard that isn't encrypted with the current store key with it, then forgets the others.
+func (v *shardVault) reencrypt() error {
+	pending := make([]nostr.Event, 0, 10)
+	for evt := range store.QueryEvents(nostr.Filter{Kinds: []nostr.Kind{common.KindStoredShard}}, 100_000) {
+		if tag := evt.Tags.Find("encrypted"); tag == nil || tag[1] != v.current {
+			pending = append(pending, evt)
+		}
+	}
+
+	for _, evt := range pending {
+		// whatever is in there, shards or a sub-shard, goes back as it was
+		plaintext, err := v.openPlaintext(evt)
+		if err != nil {
+			return fmt.Errorf("failed to open shard for %s: %w", evt.PubKey, err)
+		}
+		if err := v.sealPlaintext(&evt, plaintext); err != nil {
+			return err
+		}
+		// it must be newer or it won't replace the old one
+		evt.CreatedAt = max(nostr.Now(), evt.CreatedAt+1)
+		evt.ID = evt.GetID()
+		if err := store.ReplaceEvent(evt); err != nil {
+			return fmt.Errorf("failed to store shard for %s: %w", evt.PubKey, err)
+		}
+	}
+	if len(pending) > 0 {
+		log.Info().Int("shards", len(pending)).Msg("[vault] encrypted shards with the current store key")
+	}
+
+	if err := v.db.Update(func(txn *bbolt.Tx) error {
+		return txn.Bucket(vaultBucket).Delete(vaultPreviousKey)
+	}); err != nil {
+		return fmt.Errorf("failed to delete previous store key: %w", err)
+	}
+	for id := range v.keys {
+		if id != v.current {
+			delete(v.keys, id)
+		}
+	}
+
+	return nil
+}
+
+// seal sets the content of evt, a stored shard event, to shards (usually just one) encrypted with the current
+// store key.
+func (v *shardVault) seal(evt *nostr.Event, shards ...frost.KeyShard) error {
+	return v.sealPlaintext(evt, common.EncodeShards(shards))
+}
+
+// sealSubShard is like seal, for when we are a member of a sub-committee.
+func (v *shardVault) sealSubShard(evt *nostr.Event, sub frost.SubShard) error {
+	return v.sealPlaintext(evt, sub.Hex())
+}
+
+func (v *shardVault) sealPlaintext(evt *nostr.Event, plaintext string) error {
+	ciphertext, err := nip44.Encrypt(plaintext, v.keys[v.current])
+	if err != nil {
+		return fmt.Errorf("failed to encrypt shard: %w", err)
+	}
+
+	evt.Content = ciphertext
+	evt.Tags = slices.DeleteFunc(slices.Clone(evt.Tags), func(tag nostr.Tag) bool {
+		return len(tag) > 0 && tag[0] == "encrypted"
+	})
+	evt.Tags = append(evt.Tags, nostr.Tag{"encrypted", v.current})
+	return nil
+}
+
+// open reads the shard in evt, a stored shard event, which must be the only one: everything but signing and ecdh
+// must refuse to go on when we are a weighted signer.
+func (v *shardVault) open(evt nostr.Event, shard *frost.KeyShard) error {
+	shards, err := v.openAll(evt)
+	if err != nil {
+		return err
+	}
+	if len(shards) != 1 {
+		for i := range shards {
+			shards[i].Zero()
+		}
+		return fmt.Errorf("we hold %d shards for this account, which is only supported for signing and ecdh",
+			len(shards))
+	}
+	*shard = shards[0]
+	return nil
+}
+
+// openAll reads all the shards in evt, a stored shard event.
+func (v *shardVault) openAll(evt nostr.Event) ([]frost.KeyShard, error) {
+	plaintext, err := v.openPlaintext(evt)
+	if err != nil {
+		return nil, err
+	}
+	return common.DecodeShards(plaintext)
+}
+
+// openSubShard reads the sub-shard in evt, a stored shard event, which only exists when we are a member of a
+// sub-committee of the account.
+func (v *shardVault) openSubShard(evt nostr.Event, sub *frost.SubShard) error {
+	plaintext, err := v.openPlaintext(evt)
+	if err != nil {
+		return err
+	}
+	return sub.DecodeHex(plaintext)
+}
+
+func (v *shardVault) openPlaintext(evt nostr.Event) (string, error) {
+	tag := evt.Tags.Find("encrypted")
+	if tag == nil {
+		// from before there was encryption
+		return evt.Content, nil
+	}
+
+	key, ok := v.keys[tag[1]]
+	if !ok {
+		return "", fmt.Errorf("shard is encrypted with unknown store key %s", tag[1])
+	}
+	plaintext, err := nip44.Decrypt(evt.Content, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt shard: %w", err)
+	}
+	return plaintext, nil
+}
+
+func storeKeyID(key [32]byte) string {
+	hash := sha256.Sum256(key[:])
+	return hex.EncodeToString(hash[0:4])
+}
+
+func wrapStoreKey(ctx context.Context, key [32]byte, passphrase string) ([]byte, error) {
+	if passphrase != "" {
+		ncryptsec, err := nip49.Encrypt(key, passphrase, 16, nip49.ClientDoesNotTrackThisData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt store key with passphrase: %w", err)
+		}
+		return append([]byte{vaultWithPassphrase}, ncryptsec...), nil
+	}
+
+	ourPubkey, _ := kr.GetPublicKey(ctx)
+	ciphertext, err := kr.Encrypt(ctx, hex.EncodeToString(key[:]), ourPubkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt store key to ourselves: %w", err)
+	}
+	return append([]byte{vaultWithKeyer}, ciphertext...), nil
+}
+
+func unwrapStoreKey(ctx context.Context, wrapped []byte, passphrase string) ([32]byte, error) {
+	var key [32]byte
+	if len(wrapped) == 0 {
+		return key, fmt.Errorf("empty store key")
+	}
+
+	switch wrapped[0] {
+	case vaultWithPassphrase:
+		if passphrase == "" {
+			return key, fmt.Errorf("shards are encrypted with a passphrase, which must be given with --shards-passphrase")
+		}
+		b, err := nip49.DecryptToBytes(string(wrapped[1:]), passphrase)
+		if err != nil {
+			return key, fmt.Errorf("failed to decrypt store key (wrong passphrase?): %w", err)
+		}
+		if len(b) != 32 {
+			return key, fmt.Errorf("store key has %d bytes", len(b))
+		}
+		copy(key[:], b)
+	case vaultWithKeyer:
+		if passphrase != "" {
+			return key, fmt.Errorf("shards are encrypted with our key, not with a passphrase (see the 'rekey' command)")
+		}
+		ourPubkey, _ := kr.GetPublicKey(ctx)
+		plaintext, err := kr.Decrypt(ctx, string(wrapped[1:]), ourPubkey)
+		if err != nil {
+			return key, fmt.Errorf("failed to decrypt store key with our key: %w", err)
+		}
+		if _, err := hex.Decode(key[:], []byte(plaintext)); err != nil || len(plaintext) != 64 {
+			return key, fmt.Errorf("invalid store key")
+		}
+	default:
+		return key, fmt.Errorf("unknown store key encryption %d", wrapped[0])
+	}
+
+	return key, nil
+}
+
+var rekeyCommand = &cli.Command{
+	Name:  "rekey",
+	Usage: "encrypts all the stored shards again with a new key, which is itself encrypted with a new passphrase or with our key",
+	Description: `the current passphrase, if any, is given with --shards-passphrase as usual. this must be run while the
+signer itself is stopped, as they both use the same shards db. if it is interrupted it will be finished the next time
+the shards db is opened, with the new passphrase.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "new-passphrase",
+			Usage:   "passphrase to encrypt the new key with, if not given it will be encrypted with our key",
+			Sources: cli.EnvVars("PROMENADE_NEW_SHARDS_PASSPHRASE"),
+		},
+	},
+	Action: func(ctx context.Context, c *cli.Command) error {
+		if err := vault.rekey(ctx, c.String("new-passphrase")); err != nil {
+			return fmt.Errorf("failed to rekey: %w", err)
+		}
+
+		log.Info().Str("key", vault.current).Msg("[vault] shards encrypted with a new key")
+		return nil
+	},
+}